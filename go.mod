@@ -3,8 +3,15 @@ module github.com/m1kkY8/ctfserver
 go 1.24.1
 
 require (
+	github.com/BurntSushi/toml v1.6.0
+	github.com/geoffgarside/ber v1.1.0 // indirect
 	github.com/gorilla/mux v1.8.1
+	github.com/hirochachacha/go-smb2 v1.1.0
+	github.com/kr/fs v0.1.0 // indirect
+	github.com/pkg/sftp v1.13.9
 	github.com/sirupsen/logrus v1.9.3
+	golang.org/x/crypto v0.40.0
+	golang.org/x/net v0.42.0
+	golang.org/x/sys v0.34.0
+	gopkg.in/yaml.v3 v3.0.1
 )
-
-require golang.org/x/sys v0.34.0 // indirect
@@ -9,10 +9,13 @@ import (
 
 func main() {
 	// Load configuration
-	cfg := config.LoadConfig()
+	cfg, loader := config.LoadConfig()
 
 	// Create and start server
-	srv := server.NewServer(cfg)
+	srv, err := server.NewServer(cfg, loader.Load)
+	if err != nil {
+		log.Fatal("Failed to create server:", err)
+	}
 	if err := srv.Start(); err != nil {
 		log.Fatal("Failed to start server:", err)
 	}
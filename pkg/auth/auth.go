@@ -0,0 +1,194 @@
+// Package auth authenticates requests with basic auth users and bearer/API-key
+// tokens and checks the roles they were granted.
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/m1kkY8/ctfserver/pkg/config"
+)
+
+// TokenQueryParam is the query parameter accepted for clients that can't set headers
+const TokenQueryParam = "token"
+
+// Identity is who a request was authenticated as
+type Identity struct {
+	Name      string // User name, token number or "anonymous"
+	Anonymous bool   // No credentials were given
+	roles     map[string]bool
+}
+
+// Has reports whether the identity was granted role; admin implies every role
+func (id *Identity) Has(role string) bool {
+	return id.roles[role] || id.roles[config.RoleAdmin]
+}
+
+// Authenticator checks request credentials against the configured users and tokens
+type Authenticator struct {
+	mu        sync.RWMutex
+	users     map[string]config.UserConfig
+	tokens    []config.TokenConfig
+	anonymous *Identity
+}
+
+// New creates an authenticator for the users and tokens in cfg
+func New(cfg *config.Config) *Authenticator {
+	a := &Authenticator{}
+	a.Configure(cfg)
+	return a
+}
+
+// Configure replaces the users, tokens and anonymous roles, e.g. on reload
+func (a *Authenticator) Configure(cfg *config.Config) {
+	users := make(map[string]config.UserConfig, len(cfg.Users))
+	for _, user := range cfg.Users {
+		users[user.Name] = user
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.users = users
+	a.tokens = cfg.Tokens
+	a.anonymous = newIdentity("anonymous", cfg.Anonymous)
+	a.anonymous.Anonymous = true
+}
+
+// Enabled reports whether any credentials are configured; without them every
+// request is allowed
+func (a *Authenticator) Enabled() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.users) > 0 || len(a.tokens) > 0
+}
+
+// Authenticate returns the identity of the request. ok is false when the
+// request carried credentials that are not valid.
+func (a *Authenticator) Authenticate(r *http.Request) (id *Identity, ok bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if name, password, hasBasic := r.BasicAuth(); hasBasic {
+		return a.passwordIdentity(name, password)
+	}
+
+	if secret := requestToken(r); secret != "" {
+		if id := a.tokenIdentity(secret); id != nil {
+			return id, true
+		}
+		return nil, false
+	}
+
+	return a.anonymous, true
+}
+
+// AuthenticatePassword returns the identity of user name with password, as
+// for basic auth, for protocols that log on with a user name and password
+func (a *Authenticator) AuthenticatePassword(name, password string) (*Identity, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.passwordIdentity(name, password)
+}
+
+// AuthenticateSecret returns the identity of user name when verify accepts
+// its password, or of a token verify accepts in place of the password. It
+// serves protocols like NTLM that prove knowledge of the password without
+// sending it.
+func (a *Authenticator) AuthenticateSecret(name string, verify func(secret string) bool) (*Identity, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if user, exists := a.users[name]; exists && verify(user.Password) {
+		return newIdentity(user.Name, user.Roles), true
+	}
+	for i, token := range a.tokens {
+		if verify(token.Token) {
+			return newIdentity(fmt.Sprintf("token#%d", i+1), token.Roles), true
+		}
+	}
+	return nil, false
+}
+
+// KeyIdentity returns the identity of a key the operator authorized for user
+// name, like an SSH authorized key: the configured user of that name, or the
+// anonymous roles if there is none, so a key never grants more than the user
+// it was issued to
+func (a *Authenticator) KeyIdentity(name string) *Identity {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if user, exists := a.users[name]; exists {
+		return newIdentity(user.Name, user.Roles)
+	}
+	return &Identity{Name: name, Anonymous: true, roles: a.anonymous.roles}
+}
+
+// Permits reports whether identity may act in role: anyone may while
+// authentication is disabled, otherwise only identities granted role. A nil
+// identity hasn't logged on and is granted nothing.
+func (a *Authenticator) Permits(identity *Identity, role string) bool {
+	return !a.Enabled() || identity != nil && identity.Has(role)
+}
+
+// Anonymous returns the identity of clients without credentials
+func (a *Authenticator) Anonymous() *Identity {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.anonymous
+}
+
+// passwordIdentity returns the identity of user name with password
+func (a *Authenticator) passwordIdentity(name, password string) (*Identity, bool) {
+	if user, exists := a.users[name]; exists && secretEqual(user.Password, password) {
+		return newIdentity(user.Name, user.Roles), true
+	}
+	// Tools that only speak basic auth can pass a token as the password
+	if id := a.tokenIdentity(password); id != nil {
+		return id, true
+	}
+	return nil, false
+}
+
+// tokenIdentity returns the identity of the configured token matching secret.
+// Tokens are named by their position so logs never contain them.
+func (a *Authenticator) tokenIdentity(secret string) *Identity {
+	var found *Identity
+	for i, token := range a.tokens {
+		// Compare against every token so timing doesn't reveal which one matched
+		if secretEqual(token.Token, secret) && found == nil {
+			found = newIdentity(fmt.Sprintf("token#%d", i+1), token.Roles)
+		}
+	}
+	return found
+}
+
+// requestToken extracts a token from the Authorization or X-API-Key header or the query
+func requestToken(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		if scheme, token, found := strings.Cut(header, " "); found && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+	if token := r.Header.Get("X-API-Key"); token != "" {
+		return token
+	}
+	return r.URL.Query().Get(TokenQueryParam)
+}
+
+// secretEqual compares secrets in constant time regardless of their length
+func secretEqual(expected, given string) bool {
+	a, b := sha256.Sum256([]byte(expected)), sha256.Sum256([]byte(given))
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
+
+func newIdentity(name string, roles []string) *Identity {
+	id := &Identity{Name: name, roles: make(map[string]bool, len(roles))}
+	for _, role := range roles {
+		id.roles[role] = true
+	}
+	return id
+}
@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/m1kkY8/ctfserver/pkg/config"
+)
+
+func testAuthenticator() *Authenticator {
+	return New(&config.Config{
+		Users: []config.UserConfig{
+			{Name: "alice", Password: "secret", Roles: []string{config.RoleUpload}},
+			{Name: "root", Password: "toor", Roles: []string{config.RoleAdmin}},
+		},
+		Tokens: []config.TokenConfig{
+			{Token: "loot-token", Roles: []string{config.RoleLoot}},
+		},
+		Anonymous: []string{config.RoleDownload},
+	})
+}
+
+func TestAuthenticate(t *testing.T) {
+	a := testAuthenticator()
+
+	tests := []struct {
+		name      string
+		setup     func(r *http.Request)
+		query     string
+		wantOK    bool
+		wantName  string
+		wantRoles []string
+		denied    []string
+	}{
+		{
+			name:      "anonymous",
+			setup:     func(r *http.Request) {},
+			wantOK:    true,
+			wantName:  "anonymous",
+			wantRoles: []string{config.RoleDownload},
+			denied:    []string{config.RoleUpload, config.RoleLoot, config.RoleAdmin},
+		},
+		{
+			name:      "basic auth",
+			setup:     func(r *http.Request) { r.SetBasicAuth("alice", "secret") },
+			wantOK:    true,
+			wantName:  "alice",
+			wantRoles: []string{config.RoleUpload},
+			denied:    []string{config.RoleDownload, config.RoleLoot, config.RoleAdmin},
+		},
+		{
+			name:   "basic auth wrong password",
+			setup:  func(r *http.Request) { r.SetBasicAuth("alice", "wrong") },
+			wantOK: false,
+		},
+		{
+			name:   "basic auth unknown user",
+			setup:  func(r *http.Request) { r.SetBasicAuth("mallory", "secret") },
+			wantOK: false,
+		},
+		{
+			name:      "token as basic auth password",
+			setup:     func(r *http.Request) { r.SetBasicAuth("anything", "loot-token") },
+			wantOK:    true,
+			wantName:  "token#1",
+			wantRoles: []string{config.RoleLoot},
+			denied:    []string{config.RoleUpload},
+		},
+		{
+			name:      "bearer",
+			setup:     func(r *http.Request) { r.Header.Set("Authorization", "bearer loot-token") },
+			wantOK:    true,
+			wantName:  "token#1",
+			wantRoles: []string{config.RoleLoot},
+			denied:    []string{config.RoleDownload, config.RoleAdmin},
+		},
+		{
+			name:      "api key header",
+			setup:     func(r *http.Request) { r.Header.Set("X-API-Key", "loot-token") },
+			wantOK:    true,
+			wantName:  "token#1",
+			wantRoles: []string{config.RoleLoot},
+		},
+		{
+			name:      "query token",
+			setup:     func(r *http.Request) {},
+			query:     "?token=loot-token",
+			wantOK:    true,
+			wantName:  "token#1",
+			wantRoles: []string{config.RoleLoot},
+		},
+		{
+			name:   "invalid token",
+			setup:  func(r *http.Request) { r.Header.Set("Authorization", "Bearer nope") },
+			wantOK: false,
+		},
+		{
+			name:      "admin implies every role",
+			setup:     func(r *http.Request) { r.SetBasicAuth("root", "toor") },
+			wantOK:    true,
+			wantName:  "root",
+			wantRoles: []string{config.RoleDownload, config.RoleUpload, config.RoleLoot, config.RoleAdmin},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/files/"+tt.query, nil)
+			tt.setup(r)
+
+			id, ok := a.Authenticate(r)
+			if ok != tt.wantOK {
+				t.Fatalf("Authenticate() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if id.Name != tt.wantName {
+				t.Errorf("Name = %q, want %q", id.Name, tt.wantName)
+			}
+			for _, role := range tt.wantRoles {
+				if !id.Has(role) {
+					t.Errorf("Has(%q) = false, want true", role)
+				}
+			}
+			for _, role := range tt.denied {
+				if id.Has(role) {
+					t.Errorf("Has(%q) = true, want false", role)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyIdentity(t *testing.T) {
+	a := testAuthenticator()
+
+	tests := []struct {
+		name          string
+		wantAnonymous bool
+		wantRoles     []string
+		denied        []string
+	}{
+		{"alice", false, []string{config.RoleUpload}, []string{config.RoleAdmin, config.RoleLoot}},
+		{"root", false, []string{config.RoleAdmin, config.RoleLoot}, nil},
+		// Keys of users that aren't configured get no more than anonymous clients
+		{"mallory", true, []string{config.RoleDownload}, []string{config.RoleAdmin, config.RoleUpload, config.RoleLoot}},
+		{"", true, []string{config.RoleDownload}, []string{config.RoleAdmin}},
+	}
+	for _, tt := range tests {
+		id := a.KeyIdentity(tt.name)
+		if id.Anonymous != tt.wantAnonymous {
+			t.Errorf("KeyIdentity(%q).Anonymous = %v, want %v", tt.name, id.Anonymous, tt.wantAnonymous)
+		}
+		for _, role := range tt.wantRoles {
+			if !id.Has(role) {
+				t.Errorf("KeyIdentity(%q).Has(%q) = false, want true", tt.name, role)
+			}
+		}
+		for _, role := range tt.denied {
+			if id.Has(role) {
+				t.Errorf("KeyIdentity(%q).Has(%q) = true, want false", tt.name, role)
+			}
+		}
+	}
+}
+
+func TestAuthenticateSecret(t *testing.T) {
+	a := testAuthenticator()
+
+	if id, ok := a.AuthenticateSecret("alice", func(secret string) bool { return secret == "secret" }); !ok || id.Name != "alice" {
+		t.Errorf("AuthenticateSecret(alice) = %v, %v", id, ok)
+	}
+	if id, ok := a.AuthenticateSecret("nobody", func(secret string) bool { return secret == "loot-token" }); !ok || !id.Has(config.RoleLoot) {
+		t.Errorf("AuthenticateSecret(token) = %v, %v", id, ok)
+	}
+	if _, ok := a.AuthenticateSecret("alice", func(string) bool { return false }); ok {
+		t.Error("AuthenticateSecret accepted a wrong secret")
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	if New(&config.Config{}).Enabled() {
+		t.Error("Enabled() = true without users or tokens")
+	}
+	if !testAuthenticator().Enabled() {
+		t.Error("Enabled() = false with users")
+	}
+}
+
+func TestPermits(t *testing.T) {
+	enabled := testAuthenticator()
+	disabled := New(&config.Config{})
+	alice, _ := enabled.AuthenticatePassword("alice", "secret")
+
+	tests := []struct {
+		name     string
+		a        *Authenticator
+		identity *Identity
+		role     string
+		want     bool
+	}{
+		{"disabled lets anyone in", disabled, nil, config.RoleUpload, true},
+		{"no logon", enabled, nil, config.RoleDownload, false},
+		{"anonymous role", enabled, enabled.Anonymous(), config.RoleDownload, true},
+		{"anonymous lacking role", enabled, enabled.Anonymous(), config.RoleUpload, false},
+		{"user role", enabled, alice, config.RoleUpload, true},
+		{"user lacking role", enabled, alice, config.RoleLoot, false},
+	}
+	for _, tt := range tests {
+		if got := tt.a.Permits(tt.identity, tt.role); got != tt.want {
+			t.Errorf("%s: Permits() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Package builtin exposes the toolkit compiled into the binary. Build with
+// "-tags builtin" after copying the toolkit to pkg/builtin/toolkit (see the
+// build-builtin Makefile target); otherwise the bundle is empty.
+package builtin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/m1kkY8/ctfserver/pkg/models"
+	"github.com/m1kkY8/ctfserver/pkg/util"
+)
+
+// ManifestFile is an optional file in the toolkit root mapping paths to tool versions
+const ManifestFile = "manifest.json"
+
+// Version identifies the bundle, set at build time with -ldflags "-X .../builtin.Version=..."
+var Version = "dev"
+
+// bundle holds the embedded toolkit, nil when built without the builtin tag
+var bundle fs.FS
+
+var (
+	manifestOnce sync.Once
+	manifest     *models.BuiltinManifest
+)
+
+// FS returns the embedded toolkit, or nil if none was compiled in
+func FS() fs.FS {
+	return bundle
+}
+
+// Manifest lists the bundled files with their hashes and declared versions
+func Manifest() *models.BuiltinManifest {
+	manifestOnce.Do(func() {
+		manifest = buildManifest()
+	})
+	return manifest
+}
+
+func buildManifest() *models.BuiltinManifest {
+	result := &models.BuiltinManifest{
+		Success: true,
+		Enabled: bundle != nil,
+		Version: Version,
+	}
+	if bundle == nil {
+		return result
+	}
+
+	// Tool versions declared by the bundle author, keyed by path
+	versions := make(map[string]string)
+	if data, err := fs.ReadFile(bundle, ManifestFile); err == nil {
+		json.Unmarshal(data, &versions)
+	}
+
+	fs.WalkDir(bundle, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || name == ManifestFile {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		sums, err := util.HashFile(bundle, name, []string{"sha256"})
+		if err != nil {
+			return nil
+		}
+		result.Files = append(result.Files, models.BuiltinFile{
+			Path:    name,
+			Size:    info.Size(),
+			SHA256:  sums["sha256"],
+			Version: versions[name],
+		})
+		return nil
+	})
+
+	sort.Slice(result.Files, func(i, j int) bool {
+		return result.Files[i].Path < result.Files[j].Path
+	})
+	result.Count = len(result.Files)
+	return result
+}
+
+// PrettyManifest renders the manifest as a human-readable listing
+func PrettyManifest(m *models.BuiltinManifest) string {
+	if !m.Enabled {
+		return "No builtin toolkit compiled into this binary.\n"
+	}
+
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("Builtin toolkit %s (%d files):\n", m.Version, m.Count))
+	for i, file := range m.Files {
+		connector := "├── "
+		if i == len(m.Files)-1 {
+			connector = "└── "
+		}
+		builder.WriteString(fmt.Sprintf("%s%s (%s)", connector, file.Path, util.FormatFileSize(file.Size)))
+		if file.Version != "" {
+			builder.WriteString(" " + file.Version)
+		}
+		builder.WriteString(" sha256:" + file.SHA256 + "\n")
+	}
+	return builder.String()
+}
@@ -0,0 +1,50 @@
+package builtin
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestBuildManifest(t *testing.T) {
+	saved := bundle
+	defer func() { bundle = saved }()
+
+	bundle = nil
+	disabled := buildManifest()
+	if disabled.Enabled || disabled.Count != 0 {
+		t.Errorf("manifest without bundle: %+v", disabled)
+	}
+	if got := PrettyManifest(disabled); got != "No builtin toolkit compiled into this binary.\n" {
+		t.Errorf("pretty manifest without bundle: %q", got)
+	}
+
+	bundle = fstest.MapFS{
+		"linux/pspy64":   {Data: []byte("pspy")},
+		"windows/nc.exe": {Data: []byte("nc")},
+		ManifestFile:     {Data: []byte(`{"linux/pspy64": "v1.2.1", "missing": "v0"}`)},
+	}
+	m := buildManifest()
+	if !m.Enabled || m.Count != 2 || len(m.Files) != 2 {
+		t.Fatalf("manifest: %+v", m)
+	}
+	// Sorted by path, the version manifest itself left out
+	pspy, nc := m.Files[0], m.Files[1]
+	if pspy.Path != "linux/pspy64" || pspy.Version != "v1.2.1" || pspy.Size != 4 {
+		t.Errorf("pspy entry: %+v", pspy)
+	}
+	if nc.Path != "windows/nc.exe" || nc.Version != "" {
+		t.Errorf("nc entry: %+v", nc)
+	}
+	// sha256 of "nc"
+	if nc.SHA256 != "0be477336d3e1a8d45d820aa54755092e2ec5e0751ccdf93a8fa8fa1e10bd753" {
+		t.Errorf("nc hash %q", nc.SHA256)
+	}
+
+	pretty := PrettyManifest(m)
+	for _, want := range []string{"Builtin toolkit " + Version + " (2 files):\n", "├── linux/pspy64 (4 B) v1.2.1 sha256:", "└── windows/nc.exe (2 B) sha256:" + nc.SHA256 + "\n"} {
+		if !strings.Contains(pretty, want) {
+			t.Errorf("pretty manifest lacks %q:\n%s", want, pretty)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+//go:build builtin
+
+package builtin
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed all:toolkit
+var toolkit embed.FS
+
+func init() {
+	sub, err := fs.Sub(toolkit, "toolkit")
+	if err != nil {
+		panic(err)
+	}
+	bundle = sub
+}
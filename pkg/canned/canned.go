@@ -0,0 +1,168 @@
+// Package canned keeps the table of canned responses served for SSRF and
+// open redirect testing: redirects to any scheme, chosen status codes,
+// headers, bodies and delays for requests matching a method and a path.
+// Routes come from the config file and from /api/v1/routes at runtime.
+package canned
+
+import (
+	"errors"
+	"maps"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/m1kkY8/ctfserver/pkg/config"
+)
+
+// Where routes come from
+const (
+	SourceConfig = "config"
+	SourceAPI    = "api"
+)
+
+// ErrNotFound is returned for route ids not in the table
+var ErrNotFound = errors.New("route not found")
+
+// Route is a canned response rule of the table
+type Route struct {
+	ID     int64
+	Source string // SourceConfig or SourceAPI
+	config.ResponseRule
+}
+
+// Table is the ordered list of canned responses. Routes added at runtime
+// are tried before those of the config file, so they can override them.
+type Table struct {
+	mu     sync.RWMutex
+	routes []Route
+	lastID int64
+}
+
+// New creates a table with the routes of the config file
+func New(rules []config.ResponseRule) *Table {
+	t := &Table{}
+	t.Configure(rules)
+	return t
+}
+
+// Configure replaces the routes of the config file with rules, keeping the
+// routes added at runtime
+func (t *Table) Configure(rules []config.ResponseRule) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	routes := make([]Route, 0, len(t.routes)+len(rules))
+	for _, route := range t.routes {
+		if route.Source == SourceAPI {
+			routes = append(routes, route)
+		}
+	}
+	for _, rule := range rules {
+		routes = append(routes, t.newRoute(SourceConfig, rule))
+	}
+	t.routes = routes
+}
+
+// newRoute gives rule the next id. The caller holds t.mu.
+func (t *Table) newRoute(source string, rule config.ResponseRule) Route {
+	t.lastID++
+	rule.Headers = maps.Clone(rule.Headers)
+	return Route{ID: t.lastID, Source: source, ResponseRule: rule}
+}
+
+// Routes returns the routes in the order they are tried
+func (t *Table) Routes() []Route {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	routes := make([]Route, len(t.routes))
+	copy(routes, t.routes)
+	return routes
+}
+
+// Add validates rule and adds it after the other routes added at runtime
+func (t *Table) Add(rule config.ResponseRule) (Route, error) {
+	if err := rule.Validate(); err != nil {
+		return Route{}, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	route := t.newRoute(SourceAPI, rule)
+	position := 0
+	for position < len(t.routes) && t.routes[position].Source == SourceAPI {
+		position++
+	}
+	t.routes = append(t.routes[:position], append([]Route{route}, t.routes[position:]...)...)
+	return route, nil
+}
+
+// Update validates rule and replaces the route with id by it, in place.
+// Routes of the config file get theirs back on the next reload.
+func (t *Table) Update(id int64, rule config.ResponseRule) (Route, error) {
+	if err := rule.Validate(); err != nil {
+		return Route{}, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, route := range t.routes {
+		if route.ID == id {
+			rule.Headers = maps.Clone(rule.Headers)
+			t.routes[i].ResponseRule = rule
+			return t.routes[i], nil
+		}
+	}
+	return Route{}, ErrNotFound
+}
+
+// Delete removes the route with id
+func (t *Table) Delete(id int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, route := range t.routes {
+		if route.ID == id {
+			t.routes = append(t.routes[:i], t.routes[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// Match returns the first route matching a request for urlPath with method
+func (t *Table) Match(method, urlPath string) (Route, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, route := range t.routes {
+		if matchMethod(route.Method, method) && matchPath(route.Path, urlPath) {
+			return route, true
+		}
+	}
+	return Route{}, false
+}
+
+// matchMethod reports whether method is allowed by pattern: any method when
+// empty or *, HEAD wherever GET is
+func matchMethod(pattern, method string) bool {
+	if pattern == "" || pattern == "*" || strings.EqualFold(pattern, method) {
+		return true
+	}
+	return method == "HEAD" && strings.EqualFold(pattern, "GET")
+}
+
+// matchPath reports whether urlPath matches pattern. A trailing /** matches
+// the path before it and everything below.
+func matchPath(pattern, urlPath string) bool {
+	base, below := strings.CutSuffix(pattern, "/**")
+	if !below {
+		matched, _ := path.Match(pattern, urlPath)
+		return matched
+	}
+	if base == "" {
+		return true
+	}
+	for p := urlPath; ; p = path.Dir(p) {
+		if matched, _ := path.Match(base, p); matched {
+			return true
+		}
+		if p == "/" {
+			return false
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package canned
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/m1kkY8/ctfserver/pkg/config"
+)
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		pattern, urlPath string
+		want             bool
+	}{
+		{"/ssrf", "/ssrf", true},
+		{"/ssrf", "/ssrf/", false},
+		{"/ssrf", "/ssrf/a", false},
+		{"/ssrf", "/SSRF", false},
+		{"/*", "/anything", true},
+		{"/*", "/a/b", false},
+		{"/a/*/c", "/a/b/c", true},
+		{"/a/*/c", "/a/b/x/c", false},
+		{"/file?.txt", "/file1.txt", true},
+		{"/file?.txt", "/file10.txt", false},
+		{"/[a-c]x", "/bx", true},
+		{"/[a-c]x", "/dx", false},
+		{"/a/**", "/a", true},
+		{"/a/**", "/a/", true},
+		{"/a/**", "/a/b/c", true},
+		{"/a/**", "/ab", false},
+		{"/a/**", "/b/a", false},
+		{"/*/**", "/x/y/z", true},
+		{"/**", "/", true},
+		{"/**", "/any/thing", true},
+		{"/[a", "/[a", false},
+	}
+	for _, tt := range tests {
+		if got := matchPath(tt.pattern, tt.urlPath); got != tt.want {
+			t.Errorf("matchPath(%q, %q) = %v, want %v", tt.pattern, tt.urlPath, got, tt.want)
+		}
+	}
+}
+
+func TestMatchMethod(t *testing.T) {
+	tests := []struct {
+		pattern, method string
+		want            bool
+	}{
+		{"", "DELETE", true},
+		{"*", "PROPFIND", true},
+		{"GET", "GET", true},
+		{"get", "GET", true},
+		{"GET", "HEAD", true},
+		{"GET", "POST", false},
+		{"HEAD", "GET", false},
+		{"POST", "HEAD", false},
+	}
+	for _, tt := range tests {
+		if got := matchMethod(tt.pattern, tt.method); got != tt.want {
+			t.Errorf("matchMethod(%q, %q) = %v, want %v", tt.pattern, tt.method, got, tt.want)
+		}
+	}
+}
+
+// paths returns the paths of the table's routes in order
+func paths(table *Table) []string {
+	var result []string
+	for _, route := range table.Routes() {
+		result = append(result, route.Path)
+	}
+	return result
+}
+
+func TestTable(t *testing.T) {
+	table := New([]config.ResponseRule{
+		{Path: "/config/**", Body: "config"},
+		{Path: "/shared", Body: "config"},
+	})
+
+	// Runtime routes go before the config file's, in the order they were added
+	first, err := table.Add(config.ResponseRule{Path: "/shared", Body: "api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := table.Add(config.ResponseRule{Method: "POST", Path: "/post", Status: 201})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/shared", "/post", "/config/**", "/shared"}; !slices.Equal(paths(table), want) {
+		t.Fatalf("routes %v, want %v", paths(table), want)
+	}
+	if route, ok := table.Match("GET", "/shared"); !ok || route.ID != first.ID || route.Source != SourceAPI {
+		t.Errorf("runtime route doesn't override the config file: %+v", route)
+	}
+	if _, ok := table.Match("GET", "/post"); ok {
+		t.Error("POST route matched GET")
+	}
+	if route, ok := table.Match("HEAD", "/config/a/b"); !ok || route.Source != SourceConfig {
+		t.Errorf("config route not matched: %+v, %v", route, ok)
+	}
+	if _, ok := table.Match("GET", "/other"); ok {
+		t.Error("unmatched path matched")
+	}
+
+	if _, err := table.Add(config.ResponseRule{Path: "/f", File: "../etc/passwd"}); err == nil {
+		t.Error("added an invalid route")
+	}
+
+	// Updates keep the id and position
+	updated, err := table.Update(first.ID, config.ResponseRule{Path: "/updated", Body: "new"})
+	if err != nil || updated.ID != first.ID || updated.Source != SourceAPI {
+		t.Fatalf("Update = %+v, %v", updated, err)
+	}
+	if want := []string{"/updated", "/post", "/config/**", "/shared"}; !slices.Equal(paths(table), want) {
+		t.Errorf("routes after update %v, want %v", paths(table), want)
+	}
+	if _, err := table.Update(999, config.ResponseRule{Path: "/x"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Update of an unknown id = %v", err)
+	}
+	if _, err := table.Update(first.ID, config.ResponseRule{Path: "x"}); err == nil {
+		t.Error("updated to an invalid route")
+	}
+
+	// A reload replaces the config file's routes with new ids and keeps the runtime ones
+	table.Configure([]config.ResponseRule{{Path: "/reloaded"}})
+	if want := []string{"/updated", "/post", "/reloaded"}; !slices.Equal(paths(table), want) {
+		t.Errorf("routes after reload %v, want %v", paths(table), want)
+	}
+	if routes := table.Routes(); routes[2].ID <= second.ID {
+		t.Errorf("reloaded route reused id %d", routes[2].ID)
+	}
+
+	if err := table.Delete(second.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Delete(second.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Delete = %v", err)
+	}
+	if want := []string{"/updated", "/reloaded"}; !slices.Equal(paths(table), want) {
+		t.Errorf("routes after delete %v, want %v", paths(table), want)
+	}
+}
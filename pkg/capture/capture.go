@@ -0,0 +1,290 @@
+// Package capture records requests that targets make back to the server,
+// for blind SSRF, XXE and XSS: every detail of the request is kept in memory
+// for /api/v1/hits and appended to a JSON lines log that outlives restarts.
+// The log is rotated at a size limit, keeping one previous log beside it.
+package capture
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/models"
+	"github.com/m1kkY8/ctfserver/pkg/util"
+)
+
+// Recorder keeps the latest captured requests in a ring buffer and appends
+// every one to its log file
+type Recorder struct {
+	logPath   string // Empty to keep hits in memory only
+	bodyLimit int64
+
+	mu      sync.Mutex
+	ring    []models.Hit
+	start   int // Index of the oldest hit
+	count   int
+	lastID  int64
+	log     *os.File // Opened on the first hit
+	logSize int64
+	maxSize int64 // Size the log is rotated at, 0 to never rotate
+	minFree int64 // Free space left on the log's file system, 0 to disable
+	full    bool  // Hits are only kept in memory for lack of space
+}
+
+// New creates a recorder keeping history hits in memory and bodyLimit bytes
+// of each body. The latest hits of an existing log at logPath and the log
+// rotated before it are loaded, so ids continue where the previous run
+// stopped.
+func New(logPath string, history int, bodyLimit int64) *Recorder {
+	r := &Recorder{
+		logPath:   logPath,
+		bodyLimit: bodyLimit,
+		ring:      make([]models.Hit, history),
+	}
+	if logPath != "" {
+		for _, path := range []string{rotatedPath(logPath), logPath} {
+			if err := r.load(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				logger.Logger.WithError(err).WithField("path", path).Warn("Failed to load capture log")
+			}
+		}
+	}
+	return r
+}
+
+// SetLogLimits rotates the log once it would grow past maxSize and stops
+// writing it while less than minFree bytes are free, keeping hits in memory
+// only. Zero disables either limit.
+func (r *Recorder) SetLogLimits(maxSize, minFree int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.maxSize = maxSize
+	r.minFree = minFree
+}
+
+// rotatedPath is where the log at path is moved when it is rotated
+func rotatedPath(path string) string {
+	return path + ".1"
+}
+
+// load fills the ring buffer from the log at path, stopping at the first
+// line that doesn't parse
+func (r *Recorder) load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	for {
+		var hit models.Hit
+		if err := decoder.Decode(&hit); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		r.add(hit)
+	}
+}
+
+// add stores hit in the ring buffer, replacing the oldest once it is full.
+// The caller holds r.mu or owns r.
+func (r *Recorder) add(hit models.Hit) {
+	if r.count < len(r.ring) {
+		r.ring[(r.start+r.count)%len(r.ring)] = hit
+		r.count++
+	} else {
+		r.ring[r.start] = hit
+		r.start = (r.start + 1) % len(r.ring)
+	}
+	if hit.ID > r.lastID {
+		r.lastID = hit.ID
+	}
+}
+
+// Record captures req, made from source, reading at most the body limit of
+// its body
+func (r *Recorder) Record(req *http.Request, source string) models.Hit {
+	hit := models.Hit{
+		Time:       time.Now().UTC(),
+		Source:     source,
+		RemoteAddr: req.RemoteAddr,
+		Method:     req.Method,
+		Host:       req.Host,
+		Path:       req.URL.Path,
+		RawQuery:   req.URL.RawQuery,
+		Proto:      req.Proto,
+		Headers:    req.Header.Clone(),
+		TLS:        tlsInfo(req.TLS),
+	}
+	if len(hit.Headers) == 0 {
+		hit.Headers = map[string][]string{}
+	}
+	if query := req.URL.Query(); len(query) > 0 {
+		hit.Query = query
+	}
+
+	if req.Body != nil && r.bodyLimit > 0 {
+		var body bytes.Buffer
+		n, err := io.Copy(&body, io.LimitReader(req.Body, r.bodyLimit+1))
+		if err != nil {
+			logger.Logger.WithError(err).WithField("source", source).Debug("Failed to read captured body")
+		}
+		if n > r.bodyLimit {
+			body.Truncate(int(r.bodyLimit))
+			hit.BodyTruncated = true
+		}
+		hit.BodySize = int64(body.Len())
+		if utf8.Valid(body.Bytes()) {
+			hit.Body = body.String()
+		} else {
+			hit.Body = base64.StdEncoding.EncodeToString(body.Bytes())
+			hit.BodyEncoding = "base64"
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	hit.ID = r.lastID + 1
+	r.add(hit)
+	r.write(hit)
+	return hit
+}
+
+// write appends hit to the log. The caller holds r.mu.
+func (r *Recorder) write(hit models.Hit) {
+	if r.logPath == "" {
+		return
+	}
+	line, err := json.Marshal(hit)
+	if err != nil {
+		logger.Logger.WithError(err).Error("Failed to encode captured request")
+		return
+	}
+	line = append(line, '\n')
+
+	if !r.hasSpace(int64(len(line))) {
+		return
+	}
+	if r.log == nil && !r.open() {
+		return
+	}
+	if r.maxSize > 0 && r.logSize > 0 && r.logSize+int64(len(line)) > r.maxSize && !r.rotate() {
+		return
+	}
+
+	n, err := r.log.Write(line)
+	r.logSize += int64(n)
+	if err != nil {
+		logger.Logger.WithError(err).WithField("path", r.logPath).Error("Failed to write capture log")
+	}
+}
+
+// hasSpace reports whether size bytes can be written to the log while
+// keeping the minimum free space, warning once when it runs out. The caller
+// holds r.mu.
+func (r *Recorder) hasSpace(size int64) bool {
+	if r.minFree <= 0 {
+		return true
+	}
+	space, err := util.GetDiskSpace(filepath.Dir(r.logPath))
+	if err != nil {
+		return true
+	}
+	full := space.Free-size < r.minFree
+	if full && !r.full {
+		logger.Logger.WithFields(map[string]interface{}{
+			"path":     r.logPath,
+			"min_free": util.FormatFileSize(r.minFree),
+		}).Warn("Disk almost full, keeping captured requests in memory only")
+	}
+	r.full = full
+	return !full
+}
+
+// open opens the log for appending, reporting whether it succeeded. The
+// caller holds r.mu.
+func (r *Recorder) open() bool {
+	if dir := filepath.Dir(r.logPath); dir != "." {
+		os.MkdirAll(dir, 0755)
+	}
+	file, err := os.OpenFile(r.logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		logger.Logger.WithError(err).WithField("path", r.logPath).Error("Failed to open capture log")
+		return false
+	}
+	r.log = file
+	r.logSize = 0
+	if info, err := file.Stat(); err == nil {
+		r.logSize = info.Size()
+	}
+	return true
+}
+
+// rotate moves the log aside, replacing the previously rotated one, and
+// opens a new one, reporting whether there is a log to write to. The caller
+// holds r.mu.
+func (r *Recorder) rotate() bool {
+	r.log.Close()
+	r.log = nil
+	if err := os.Rename(r.logPath, rotatedPath(r.logPath)); err != nil {
+		// Keep appending rather than losing hits
+		logger.Logger.WithError(err).WithField("path", r.logPath).Error("Failed to rotate capture log")
+	} else {
+		logger.Logger.WithField("path", r.logPath).Info("Rotated capture log")
+	}
+	return r.open()
+}
+
+// Hits returns the captured requests with an id above since, oldest first,
+// at most the latest limit of them when limit is positive
+func (r *Recorder) Hits(since int64, limit int) []models.Hit {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	hits := make([]models.Hit, 0, r.count)
+	for i := 0; i < r.count; i++ {
+		if hit := r.ring[(r.start+i)%len(r.ring)]; hit.ID > since {
+			hits = append(hits, hit)
+		}
+	}
+	if limit > 0 && len(hits) > limit {
+		hits = hits[len(hits)-limit:]
+	}
+	return hits
+}
+
+// Close closes the log
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.log == nil {
+		return nil
+	}
+	err := r.log.Close()
+	r.log = nil
+	return err
+}
+
+// tlsInfo describes the TLS connection of a request, nil for plain HTTP
+func tlsInfo(state *tls.ConnectionState) *models.HitTLS {
+	if state == nil {
+		return nil
+	}
+	return &models.HitTLS{
+		Version:     tls.VersionName(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+		ServerName:  state.ServerName,
+		ALPN:        state.NegotiatedProtocol,
+	}
+}
@@ -0,0 +1,77 @@
+package capture
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+)
+
+func TestLogRotation(t *testing.T) {
+	logger.InitLogger("error")
+	logPath := filepath.Join(t.TempDir(), ".hits.jsonl")
+	const maxSize = 2048
+
+	r := New(logPath, 100, 1024)
+	r.SetLogLimits(maxSize, 0)
+	for range 20 {
+		r.Record(httptest.NewRequest("POST", "/c/probe", strings.NewReader(strings.Repeat("x", 200))), "test")
+	}
+	r.Close()
+
+	for _, path := range []string{logPath, rotatedPath(logPath)} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() == 0 || info.Size() > maxSize {
+			t.Errorf("%s is %d bytes, want 1-%d", filepath.Base(path), info.Size(), maxSize)
+		}
+	}
+
+	// Only the hits of the two logs survive a restart, and ids continue
+	restarted := New(logPath, 100, 1024)
+	defer restarted.Close()
+	hits := restarted.Hits(0, 0)
+	if len(hits) == 0 || len(hits) >= 20 || hits[len(hits)-1].ID != 20 {
+		t.Fatalf("loaded %d hits, want fewer than 20 up to id 20", len(hits))
+	}
+	for i := 1; i < len(hits); i++ {
+		if hits[i].ID != hits[i-1].ID+1 {
+			t.Errorf("loaded id %d after %d", hits[i].ID, hits[i-1].ID)
+		}
+	}
+	if hit := restarted.Record(httptest.NewRequest("GET", "/c/next", nil), "test"); hit.ID != 21 {
+		t.Errorf("next id %d, want 21", hit.ID)
+	}
+}
+
+func TestLogMinFreeSpace(t *testing.T) {
+	logger.InitLogger("error")
+	logPath := filepath.Join(t.TempDir(), ".hits.jsonl")
+
+	r := New(logPath, 10, 1024)
+	defer r.Close()
+	r.SetLogLimits(0, 1<<62)
+	r.Record(httptest.NewRequest("GET", "/c/full", nil), "test")
+	if _, err := os.Stat(logPath); !os.IsNotExist(err) {
+		t.Errorf("log written without free space: %v", err)
+	}
+	// Hits are still kept in memory
+	if hits := r.Hits(0, 0); len(hits) != 1 {
+		t.Errorf("kept %d hits, want 1", len(hits))
+	}
+
+	r.SetLogLimits(0, 0)
+	r.Record(httptest.NewRequest("GET", "/c/space", nil), "test")
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "/c/space") || strings.Contains(string(data), "/c/full") {
+		t.Errorf("log holds %q, want only the hit with space", data)
+	}
+}
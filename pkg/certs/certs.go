@@ -0,0 +1,156 @@
+// Package certs loads and generates the TLS certificates used by the server.
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// File names used when persisting a generated certificate
+const (
+	certFileName = "cert.pem"
+	keyFileName  = "key.pem"
+)
+
+// validity is how long generated certificates are valid for
+const validity = 365 * 24 * time.Hour
+
+// Load loads a user-provided certificate and key
+func Load(certFile, keyFile string) (tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to load certificate: %w", err)
+	}
+	return cert, nil
+}
+
+// SelfSigned returns a self-signed ECDSA certificate covering every local
+// interface address. If persistDir is set, a certificate saved there by an
+// earlier run is reused, and a newly generated one is saved for later runs.
+func SelfSigned(persistDir string) (tls.Certificate, error) {
+	if persistDir != "" {
+		certFile := filepath.Join(persistDir, certFileName)
+		keyFile := filepath.Join(persistDir, keyFileName)
+		if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil {
+			return cert, nil
+		}
+	}
+
+	certPEM, keyPEM, err := generate()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	if persistDir != "" {
+		if err := os.MkdirAll(persistDir, 0700); err != nil {
+			return tls.Certificate{}, fmt.Errorf("failed to create certificate directory: %w", err)
+		}
+		if err := os.WriteFile(filepath.Join(persistDir, certFileName), certPEM, 0644); err != nil {
+			return tls.Certificate{}, fmt.Errorf("failed to save certificate: %w", err)
+		}
+		if err := os.WriteFile(filepath.Join(persistDir, keyFileName), keyPEM, 0600); err != nil {
+			return tls.Certificate{}, fmt.Errorf("failed to save key: %w", err)
+		}
+	}
+
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// generate creates a PEM encoded self-signed certificate and key
+func generate() ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	hostname, _ := os.Hostname()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hostname},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           LocalIPs(),
+	}
+	if hostname != "" {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode key: %w", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// LocalIPs returns the addresses of all local interfaces
+func LocalIPs() []net.IP {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	}
+
+	var ips []net.IP
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+	return ips
+}
+
+// Fingerprint returns the colon separated SHA-256 fingerprint of the leaf certificate
+func Fingerprint(cert tls.Certificate) string {
+	if len(cert.Certificate) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(cert.Certificate[0])
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// PublicKeyPin returns the curl --pinnedpubkey value for the leaf certificate
+func PublicKeyPin(cert tls.Certificate) (string, error) {
+	if len(cert.Certificate) == 0 {
+		return "", fmt.Errorf("empty certificate chain")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+	return "sha256//" + base64.StdEncoding.EncodeToString(sum[:]), nil
+}
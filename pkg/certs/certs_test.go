@@ -0,0 +1,120 @@
+package certs
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSelfSignedPersists(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "tls")
+	first, err := SelfSigned(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(dir, keyFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("key saved with mode %v", info.Mode().Perm())
+	}
+
+	// A later run reuses the saved certificate, so pins stay valid
+	second, err := SelfSigned(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Fingerprint(first) != Fingerprint(second) {
+		t.Error("saved certificate was not reused")
+	}
+
+	// Without a directory every call generates a new one
+	a, _ := SelfSigned("")
+	b, _ := SelfSigned("")
+	if Fingerprint(a) == Fingerprint(b) {
+		t.Error("generated the same certificate twice")
+	}
+
+	loaded, err := Load(filepath.Join(dir, certFileName), filepath.Join(dir, keyFileName))
+	if err != nil || Fingerprint(loaded) != Fingerprint(first) {
+		t.Errorf("Load: %v", err)
+	}
+	if _, err := Load(filepath.Join(dir, "missing.pem"), filepath.Join(dir, keyFileName)); err == nil {
+		t.Error("loaded a missing certificate")
+	}
+}
+
+func TestSelfSignedCoversLocalAddresses(t *testing.T) {
+	cert, err := SelfSigned("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leaf.VerifyHostname("localhost"); err != nil {
+		t.Error(err)
+	}
+	if err := leaf.VerifyHostname("127.0.0.1"); err != nil {
+		t.Error(err)
+	}
+	if leaf.NotAfter.Sub(leaf.NotBefore) < validity {
+		t.Errorf("valid from %v to %v", leaf.NotBefore, leaf.NotAfter)
+	}
+}
+
+func TestFingerprintAndPin(t *testing.T) {
+	cert, err := SelfSigned("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+
+	sum := sha256.Sum256(cert.Certificate[0])
+	fingerprint := Fingerprint(cert)
+	if len(fingerprint) != 95 || strings.ReplaceAll(fingerprint, ":", "") != strings.ToUpper(fmt.Sprintf("%x", sum)) {
+		t.Errorf("fingerprint %s", fingerprint)
+	}
+	if Fingerprint(tls.Certificate{}) != "" {
+		t.Error("fingerprint of an empty chain")
+	}
+
+	pin, err := PublicKeyPin(cert)
+	keySum := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+	if err != nil || pin != "sha256//"+base64.StdEncoding.EncodeToString(keySum[:]) {
+		t.Errorf("pin %s, %v", pin, err)
+	}
+	if _, err := PublicKeyPin(tls.Certificate{}); err == nil {
+		t.Error("pinned an empty chain")
+	}
+
+	// A client pinning the fingerprint accepts the server without a CA
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.StartTLS()
+	defer server.Close()
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			if got := Fingerprint(tls.Certificate{Certificate: [][]byte{state.PeerCertificates[0].Raw}}); got != fingerprint {
+				return fmt.Errorf("fingerprint %s", got)
+			}
+			return nil
+		},
+	}}}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,160 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Roles that credentials can be granted
+const (
+	RoleDownload = "download" // Downloads below /files/ and the informational API
+	RoleUpload   = "upload"   // File uploads
+	RoleLoot     = "loot"     // Listing uploaded files
+	RoleAdmin    = "admin"    // Server administration, implies every other role
+)
+
+// Roles lists every known role
+var Roles = []string{RoleDownload, RoleUpload, RoleLoot, RoleAdmin}
+
+// UserConfig is a basic auth user
+type UserConfig struct {
+	Name     string
+	Password string
+	Roles    []string
+}
+
+// TokenConfig is a bearer/API-key token
+type TokenConfig struct {
+	Token string
+	Roles []string
+}
+
+// ParseUser parses a user definition of the form NAME:PASSWORD:ROLE+ROLE.
+// The password may contain colons, the roles follow the last one.
+func ParseUser(spec string) (UserConfig, error) {
+	first, last := strings.Index(spec, ":"), strings.LastIndex(spec, ":")
+	if first < 0 || first == last {
+		return UserConfig{}, fmt.Errorf("invalid user %q (expected NAME:PASSWORD:ROLES)", maskSecret(spec))
+	}
+
+	user := UserConfig{Name: spec[:first], Password: spec[first+1 : last]}
+	if user.Name == "" || user.Password == "" {
+		return user, fmt.Errorf("invalid user %q: name and password must not be empty", user.Name)
+	}
+
+	roles, err := parseRoles(spec[last+1:])
+	if err != nil {
+		return user, fmt.Errorf("user %q: %w", user.Name, err)
+	}
+	user.Roles = roles
+	return user, nil
+}
+
+// ParseToken parses a token definition of the form TOKEN:ROLE+ROLE
+func ParseToken(spec string) (TokenConfig, error) {
+	sep := strings.LastIndex(spec, ":")
+	if sep <= 0 {
+		return TokenConfig{}, fmt.Errorf("invalid token %q (expected TOKEN:ROLES)", maskSecret(spec))
+	}
+
+	token := TokenConfig{Token: spec[:sep]}
+	roles, err := parseRoles(spec[sep+1:])
+	if err != nil {
+		return token, fmt.Errorf("token %s: %w", maskSecret(token.Token), err)
+	}
+	token.Roles = roles
+	return token, nil
+}
+
+// UnmarshalText parses a user definition from a config file
+func (u *UserConfig) UnmarshalText(text []byte) error {
+	user, err := ParseUser(string(text))
+	if err != nil {
+		return err
+	}
+	*u = user
+	return nil
+}
+
+// UnmarshalText parses a token definition from a config file
+func (t *TokenConfig) UnmarshalText(text []byte) error {
+	token, err := ParseToken(string(text))
+	if err != nil {
+		return err
+	}
+	*t = token
+	return nil
+}
+
+// parseRoles parses a list of roles separated by "+" or ","
+func parseRoles(list string) ([]string, error) {
+	var roles []string
+	for _, role := range strings.FieldsFunc(list, func(r rune) bool { return r == '+' || r == ',' }) {
+		role = strings.TrimSpace(role)
+		if !isRole(role) {
+			return nil, fmt.Errorf("unknown role %q (expected one of %s)", role, strings.Join(Roles, ", "))
+		}
+		roles = append(roles, role)
+	}
+	if len(roles) == 0 {
+		return nil, fmt.Errorf("no roles given")
+	}
+	return roles, nil
+}
+
+func isRole(role string) bool {
+	for _, known := range Roles {
+		if role == known {
+			return true
+		}
+	}
+	return false
+}
+
+// maskSecret hides all but the first characters of a secret for error messages
+func maskSecret(secret string) string {
+	if len(secret) <= 4 {
+		return "****"
+	}
+	return secret[:4] + "****"
+}
+
+// userList is a repeatable -user flag
+type userList []UserConfig
+
+func (l *userList) String() string {
+	names := make([]string, len(*l))
+	for i, user := range *l {
+		names[i] = user.Name
+	}
+	return strings.Join(names, ";")
+}
+
+func (l *userList) Set(value string) error {
+	user, err := ParseUser(value)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, user)
+	return nil
+}
+
+// tokenList is a repeatable -token flag
+type tokenList []TokenConfig
+
+func (l *tokenList) String() string {
+	masked := make([]string, len(*l))
+	for i, token := range *l {
+		masked[i] = maskSecret(token.Token)
+	}
+	return strings.Join(masked, ";")
+}
+
+func (l *tokenList) Set(value string) error {
+	token, err := ParseToken(value)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, token)
+	return nil
+}
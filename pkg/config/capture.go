@@ -0,0 +1,9 @@
+package config
+
+// Responses of the /x exfiltration endpoint, small enough to embed anywhere
+const (
+	ExfilGIF       = "gif" // Transparent 1x1 GIF, for <img> tags
+	ExfilJS        = "js"  // Empty script, for <script> tags
+	ExfilCSS       = "css" // Empty stylesheet, for <link> tags
+	ExfilNoContent = "204" // No body at all
+)
@@ -1,72 +1,513 @@
 package config
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"io"
+	"net"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
-// Config holds the application configuration
+// Config holds the application configuration. The yaml/toml keys match the
+// command line flag names.
 type Config struct {
-	Host          string
-	Port          int
-	RootDir       string
-	UploadDir     string
-	MaxUploadSize int64
-	LogLevel      string
-}
-
-// LoadConfig loads configuration from environment variables and command line flags
-func LoadConfig() *Config {
-	cfg := &Config{
-		Host:          getEnvOrDefault("CTF_HOST", "0.0.0.0"),
-		Port:          getEnvOrDefaultInt("CTF_PORT", 8080),
-		RootDir:       getEnvOrDefault("CTF_ROOT_DIR", "."),
-		UploadDir:     getEnvOrDefault("CTF_UPLOAD_DIR", "./uploads"),
-		MaxUploadSize: getEnvOrDefaultInt64("CTF_MAX_UPLOAD_SIZE", 200*1024*1024), // 200MB
-		LogLevel:      getEnvOrDefault("CTF_LOG_LEVEL", "info"),
-	}
-
-	// Command line flags override environment variables
-	host := flag.String("host", cfg.Host, "Host to bind to")
-	port := flag.Int("port", cfg.Port, "Port to listen on")
-	rootDir := flag.String("root", cfg.RootDir, "Root directory to serve")
-	uploadDir := flag.String("upload-dir", cfg.UploadDir, "Directory for uploaded files")
-	maxUpload := flag.Int64("max-upload", cfg.MaxUploadSize, "Maximum upload size in bytes")
-	logLevel := flag.String("log-level", cfg.LogLevel, "Log level (debug, info, warn, error)")
-	flag.Parse()
-
-	cfg.Host = *host
-	cfg.Port = *port
-	cfg.RootDir = *rootDir
-	cfg.UploadDir = *uploadDir
-	cfg.MaxUploadSize = *maxUpload
-	cfg.LogLevel = *logLevel
-
-	return cfg
-}
-
-func getEnvOrDefault(key, defaultValue string) string {
+	Host          string           `yaml:"host" toml:"host"`
+	Port          int              `yaml:"port" toml:"port"`
+	RootDirs      []string         `yaml:"root" toml:"root"` // Directories and archives overlaid as the served root, highest precedence first
+	UploadDir     string           `yaml:"upload-dir" toml:"upload-dir"`
+	MaxUploadSize int64            `yaml:"max-upload" toml:"max-upload"`
+	LogLevel      string           `yaml:"log-level" toml:"log-level"`
+	HashHeader    bool             `yaml:"hash-header" toml:"hash-header"`
+	Builtin       bool             `yaml:"builtin" toml:"builtin"` // Merge the toolkit compiled into the binary below the root
+	TLS           bool             `yaml:"tls" toml:"tls"`
+	TLSCertFile   string           `yaml:"tls-cert" toml:"tls-cert"`
+	TLSKeyFile    string           `yaml:"tls-key" toml:"tls-key"`
+	TLSCertDir    string           `yaml:"tls-dir" toml:"tls-dir"` // Directory where a generated self-signed certificate is persisted
+	Listeners     []ListenerConfig `yaml:"listen" toml:"listen"`
+	SMBListen     string           `yaml:"smb-listen" toml:"smb-listen"` // Address of the SMB share server, empty to disable it
+
+	// FTP server for targets with only ftp.exe or busybox
+	FTPListen       string `yaml:"ftp-listen" toml:"ftp-listen"`               // Address of the FTP server, empty to disable it
+	FTPPassivePorts string `yaml:"ftp-passive-ports" toml:"ftp-passive-ports"` // MIN-MAX range for passive data connections, any port when empty
+
+	TFTPListen string `yaml:"tftp-listen" toml:"tftp-listen"` // UDP address of the TFTP server, empty to disable it
+
+	// SSH server offering only SFTP and scp
+	SSHListen         string `yaml:"ssh-listen" toml:"ssh-listen"`                   // Address of the SSH server, empty to disable it
+	SSHHostKey        string `yaml:"ssh-host-key" toml:"ssh-host-key"`               // Host key file, generated there if missing; a key for this run only when empty
+	SSHAuthorizedKeys string `yaml:"ssh-authorized-keys" toml:"ssh-authorized-keys"` // authorized_keys file of keys that may log on
+
+	// Authoritative DNS server of a delegated zone, reassembling files leaked through queries
+	DNSListen string `yaml:"dns-listen" toml:"dns-listen"` // UDP address of the DNS server, empty to disable it
+	DNSZone   string `yaml:"dns-zone" toml:"dns-zone"`     // Zone delegated to the server, e.g. x.example.com
+
+	Drops []DropConfig `yaml:"drop" toml:"drop"` // Raw TCP listeners for netcat uploads and downloads
+
+	// Callback capture of requests below /c/, for blind SSRF, XXE and XSS
+	CaptureUnmatched bool   `yaml:"capture-unmatched" toml:"capture-unmatched"` // Also capture requests no route matches
+	CaptureLog       string `yaml:"capture-log" toml:"capture-log"`             // JSON lines file of captured requests, relative to the upload directory; none when empty
+	CaptureLogSize   int64  `yaml:"capture-log-size" toml:"capture-log-size"`   // Size the capture log is rotated at, keeping one previous log
+	CaptureBody      int64  `yaml:"capture-body" toml:"capture-body"`           // Bytes of each request body kept
+	CaptureHistory   int    `yaml:"capture-history" toml:"capture-history"`     // Captured requests kept in memory for /api/v1/hits
+	ExfilResponse    string `yaml:"exfil-response" toml:"exfil-response"`       // Answer of /x: gif, js, css or 204
+
+	Responses []ResponseRule `yaml:"responses" toml:"responses"` // Canned responses, config file only; more are added at /api/v1/routes
+
+	// Authentication, enabled as soon as any user or token is configured
+	Users     []UserConfig  `yaml:"users" toml:"users"`
+	Tokens    []TokenConfig `yaml:"tokens" toml:"tokens"`
+	Anonymous []string      `yaml:"anonymous" toml:"anonymous"` // Roles granted to requests without credentials
+
+	// Source address filtering
+	Allow          []IPRule   `yaml:"allow" toml:"allow"`
+	Deny           []IPRule   `yaml:"deny" toml:"deny"`
+	TrustedProxies []IPPrefix `yaml:"trusted-proxies" toml:"trusted-proxies"` // Proxies whose X-Forwarded-For is believed
+	IPReject       string     `yaml:"ip-reject" toml:"ip-reject"`             // Response to rejected addresses: drop, 404 or 403
+
+	// Rate and concurrency limits, zero disables a limit
+	RateLimits           []RateRule `yaml:"rate-limit" toml:"rate-limit"`
+	MaxConcurrentUploads int        `yaml:"max-concurrent-uploads" toml:"max-concurrent-uploads"`
+	DownloadRate         int64      `yaml:"download-rate" toml:"download-rate"` // Bytes per second for each download below /files/
+
+	// Upload storage guards in bytes, zero disables a guard
+	MinFreeSpace int64 `yaml:"min-free-space" toml:"min-free-space"` // Free space kept on the upload file system
+	UploadQuota  int64 `yaml:"upload-quota" toml:"upload-quota"`     // Total size of the upload directory
+	SourceQuota  int64 `yaml:"source-quota" toml:"source-quota"`     // Size of the uploads of each source address
+
+	// Upload retention, zero keeps uploads forever
+	RetentionMaxAge  time.Duration `yaml:"retention-max-age" toml:"retention-max-age"`   // Delete uploads older than this
+	RetentionMaxSize int64         `yaml:"retention-max-size" toml:"retention-max-size"` // Delete the oldest uploads above this total size
+
+	// Server-wide timeouts, zero disables a timeout
+	ReadHeaderTimeout time.Duration `yaml:"read-header-timeout" toml:"read-header-timeout"`
+	ReadTimeout       time.Duration `yaml:"read-timeout" toml:"read-timeout"`
+	WriteTimeout      time.Duration `yaml:"write-timeout" toml:"write-timeout"`
+	IdleTimeout       time.Duration `yaml:"idle-timeout" toml:"idle-timeout"`
+
+	// Per-route timeouts
+	APITimeout          time.Duration `yaml:"api-timeout" toml:"api-timeout"`                     // Absolute deadline for JSON/text API requests
+	TransferIdleTimeout time.Duration `yaml:"transfer-idle-timeout" toml:"transfer-idle-timeout"` // Deadline reset on every read/write for downloads and uploads
+}
+
+// Default returns the configuration used when no source sets a value
+func Default() *Config {
+	return &Config{
+		Host:          "0.0.0.0",
+		Port:          8080,
+		RootDirs:      []string{"."},
+		UploadDir:     "./uploads",
+		MaxUploadSize: 200 * 1024 * 1024, // 200MB
+		LogLevel:      "info",
+		Builtin:       true,
+		IPReject:      IPRejectForbidden,
+		MinFreeSpace:  100 * 1024 * 1024, // 100MB
+
+		CaptureLog:     ".hits.jsonl",    // Hidden from the uploads like their index
+		CaptureLogSize: 64 * 1024 * 1024, // 64MB
+		CaptureBody:    64 * 1024,        // 64KB
+		CaptureHistory: 1000,
+		ExfilResponse:  ExfilGIF,
+
+		ReadHeaderTimeout:   10 * time.Second,
+		IdleTimeout:         60 * time.Second,
+		APITimeout:          15 * time.Second,
+		TransferIdleTimeout: 60 * time.Second,
+	}
+}
+
+// Loader builds the configuration from its sources in order of precedence:
+// defaults, the config file, environment variables and command line flags.
+// Load can be called again at any time to pick up changes for a reload.
+type Loader struct {
+	args       []string
+	configPath string
+}
+
+// NewLoader parses the command line, exiting with usage on invalid flags
+func NewLoader(args []string) *Loader {
+	loader := &Loader{args: args}
+
+	// Parse once up front so bad flags fail early and -config is known
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	var lists flagLists
+	bindFlags(flags, Default(), &lists, &loader.configPath)
+	flags.Parse(args)
+
+	if loader.configPath == "" {
+		loader.configPath = os.Getenv("CTF_CONFIG")
+	}
+	return loader
+}
+
+// ConfigPath returns the config file in use, empty when there is none
+func (l *Loader) ConfigPath() string {
+	return l.configPath
+}
+
+// Load reads all configuration sources and validates the result
+func (l *Loader) Load() (*Config, error) {
+	cfg := Default()
+
+	if l.configPath != "" {
+		if err := loadFile(l.configPath, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := applyEnv(cfg); err != nil {
+		return nil, err
+	}
+
+	// Re-parsing the command line on top of file and environment values only
+	// overwrites what was actually given as a flag
+	flags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	flags.SetOutput(io.Discard) // Already reported by NewLoader
+	var lists flagLists
+	var configPath string
+	bindFlags(flags, cfg, &lists, &configPath)
+	if err := flags.Parse(l.args); err != nil {
+		return nil, err
+	}
+
+	// Repeatable flags replace the lists from other sources, and without any
+	// listeners the server listens on -host/-port only
+	if len(lists.listeners) > 0 {
+		cfg.Listeners = lists.listeners
+	}
+	if len(lists.drops) > 0 {
+		cfg.Drops = lists.drops
+	}
+	if len(lists.users) > 0 {
+		cfg.Users = lists.users
+	}
+	if len(lists.tokens) > 0 {
+		cfg.Tokens = lists.tokens
+	}
+	if len(lists.allow) > 0 {
+		cfg.Allow = lists.allow
+	}
+	if len(lists.deny) > 0 {
+		cfg.Deny = lists.deny
+	}
+	if len(lists.rateLimits) > 0 {
+		cfg.RateLimits = lists.rateLimits
+	}
+	cfg.TLS = cfg.TLS || (cfg.TLSCertFile != "" && cfg.TLSKeyFile != "")
+	if len(cfg.Listeners) == 0 {
+		cfg.Listeners = []ListenerConfig{{
+			Addr: net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+			TLS:  cfg.TLS,
+		}}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// LoadConfig loads configuration from the config file, environment variables
+// and command line flags, exiting on invalid configuration
+func LoadConfig() (*Config, *Loader) {
+	loader := NewLoader(os.Args[1:])
+	cfg, err := loader.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration: %v\n", err)
+		os.Exit(2)
+	}
+	return cfg, loader
+}
+
+// flagLists collects repeatable flags, which replace rather than extend the
+// lists from the config file and environment
+type flagLists struct {
+	listeners  listenerList
+	users      userList
+	tokens     tokenList
+	allow      ipRuleList
+	deny       ipRuleList
+	rateLimits rateRuleList
+	drops      dropList
+}
+
+// bindFlags defines the command line flags on flags, defaulting to and
+// writing into cfg
+func bindFlags(flags *flag.FlagSet, cfg *Config, lists *flagLists, configPath *string) {
+	flags.StringVar(configPath, "config", "", "Config file (.yaml, .yml or .toml), also CTF_CONFIG")
+	flags.StringVar(&cfg.Host, "host", cfg.Host, "Host or interface name (e.g. tun0) to bind to")
+	flags.IntVar(&cfg.Port, "port", cfg.Port, "Port to listen on")
+	flags.Var((*commaList)(&cfg.RootDirs), "root", "Comma-separated root directories or archives (.zip, .tar, .tar.gz) to serve, first wins")
+	flags.StringVar(&cfg.UploadDir, "upload-dir", cfg.UploadDir, "Directory for uploaded files")
+	flags.Int64Var(&cfg.MaxUploadSize, "max-upload", cfg.MaxUploadSize, "Maximum upload size in bytes")
+	flags.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "Log level (debug, info, warn, error)")
+	flags.BoolVar(&cfg.HashHeader, "hash-header", cfg.HashHeader, "Add X-Content-SHA256 header to file downloads")
+	flags.BoolVar(&cfg.Builtin, "builtin", cfg.Builtin, "Serve the toolkit compiled into the binary below the root")
+	flags.BoolVar(&cfg.TLS, "tls", cfg.TLS, "Serve HTTPS (self-signed certificate unless -tls-cert/-tls-key are given)")
+	flags.StringVar(&cfg.TLSCertFile, "tls-cert", cfg.TLSCertFile, "TLS certificate file (PEM)")
+	flags.StringVar(&cfg.TLSKeyFile, "tls-key", cfg.TLSKeyFile, "TLS private key file (PEM)")
+	flags.StringVar(&cfg.TLSCertDir, "tls-dir", cfg.TLSCertDir, "Directory to persist the generated self-signed certificate in")
+	flags.DurationVar(&cfg.ReadHeaderTimeout, "read-header-timeout", cfg.ReadHeaderTimeout, "Maximum time to read request headers")
+	flags.DurationVar(&cfg.ReadTimeout, "read-timeout", cfg.ReadTimeout, "Absolute limit for reading a whole request, 0 for none")
+	flags.DurationVar(&cfg.WriteTimeout, "write-timeout", cfg.WriteTimeout, "Absolute limit for writing a whole response, 0 for none")
+	flags.DurationVar(&cfg.IdleTimeout, "idle-timeout", cfg.IdleTimeout, "Keep-alive idle timeout between requests")
+	flags.DurationVar(&cfg.APITimeout, "api-timeout", cfg.APITimeout, "Deadline for API requests, 0 for none")
+	flags.DurationVar(&cfg.TransferIdleTimeout, "transfer-idle-timeout", cfg.TransferIdleTimeout, "Abort downloads and uploads making no progress for this long, 0 for never")
+	flags.StringVar(&cfg.SMBListen, "smb-listen", cfg.SMBListen, "Serve the root and upload directory as SMB shares on HOST:PORT (e.g. 0.0.0.0:445)")
+	flags.StringVar(&cfg.FTPListen, "ftp-listen", cfg.FTPListen, "Serve the root and upload directory over FTP on HOST:PORT (e.g. 0.0.0.0:21)")
+	flags.StringVar(&cfg.FTPPassivePorts, "ftp-passive-ports", cfg.FTPPassivePorts, "Port range MIN-MAX for passive FTP data connections (default any port)")
+	flags.StringVar(&cfg.TFTPListen, "tftp-listen", cfg.TFTPListen, "Serve the root and accept uploads over TFTP on UDP HOST:PORT (e.g. 0.0.0.0:69)")
+	flags.StringVar(&cfg.SSHListen, "ssh-listen", cfg.SSHListen, "Serve the root and upload directory over SFTP and scp on HOST:PORT (e.g. 0.0.0.0:2222)")
+	flags.StringVar(&cfg.SSHHostKey, "ssh-host-key", cfg.SSHHostKey, "SSH host key file, generated if missing (default a new key every run)")
+	flags.StringVar(&cfg.SSHAuthorizedKeys, "ssh-authorized-keys", cfg.SSHAuthorizedKeys, "authorized_keys file of public keys that may log on over SSH")
+	flags.StringVar(&cfg.DNSListen, "dns-listen", cfg.DNSListen, "Receive files leaked through DNS queries on UDP HOST:PORT (e.g. 0.0.0.0:53)")
+	flags.StringVar(&cfg.DNSZone, "dns-zone", cfg.DNSZone, "Zone delegated to the DNS server (e.g. x.example.com)")
+	flags.Var(&lists.listeners, "listen", "Listener ADDR[,tls][,routes=files+upload+loot+api+admin] (repeatable, replaces -host/-port)")
+	flags.Var(&lists.drops, "drop", "Raw TCP listener ADDR,upload[,header] or ADDR,serve=FILE for netcat transfers (repeatable)")
+	flags.Var(&lists.users, "user", "Basic auth user NAME:PASSWORD:ROLE+ROLE with roles download, upload, loot, admin (repeatable)")
+	flags.Var(&lists.tokens, "token", "Bearer/API-key token TOKEN:ROLE+ROLE (repeatable)")
+	flags.Var((*commaList)(&cfg.Anonymous), "anonymous", "Comma-separated roles granted without credentials when auth is enabled (e.g. download)")
+	flags.Var(&lists.allow, "allow", "Allowed sources [GROUP+GROUP=]CIDR[,CIDR] (repeatable)")
+	flags.Var(&lists.deny, "deny", "Denied sources [GROUP+GROUP=]CIDR[,CIDR] (repeatable)")
+	flags.Var((*ipPrefixList)(&cfg.TrustedProxies), "trusted-proxy", "Comma-separated proxy addresses whose X-Forwarded-For is trusted")
+	flags.StringVar(&cfg.IPReject, "ip-reject", cfg.IPReject, "Response to rejected source addresses (drop, 404, 403)")
+	flags.Var(&lists.rateLimits, "rate-limit", "Requests per source address [GROUP+GROUP=]COUNT/s|m|h[,burst=N] (repeatable)")
+	flags.IntVar(&cfg.MaxConcurrentUploads, "max-concurrent-uploads", cfg.MaxConcurrentUploads, "Maximum uploads in progress at once, 0 for unlimited")
+	flags.Int64Var(&cfg.DownloadRate, "download-rate", cfg.DownloadRate, "Bandwidth limit for each download in bytes per second, 0 for unlimited")
+	flags.Int64Var(&cfg.MinFreeSpace, "min-free-space", cfg.MinFreeSpace, "Bytes to keep free on the upload file system, 0 to disable")
+	flags.Int64Var(&cfg.UploadQuota, "upload-quota", cfg.UploadQuota, "Maximum total size of the upload directory in bytes, 0 for unlimited")
+	flags.Int64Var(&cfg.SourceQuota, "source-quota", cfg.SourceQuota, "Maximum size of the uploads of each source address in bytes, 0 for unlimited")
+	flags.BoolVar(&cfg.CaptureUnmatched, "capture-unmatched", cfg.CaptureUnmatched, "Capture requests to paths no route matches, like those below /c/")
+	flags.StringVar(&cfg.CaptureLog, "capture-log", cfg.CaptureLog, "JSON lines file of captured requests, relative to the upload directory, empty for none")
+	flags.Int64Var(&cfg.CaptureLogSize, "capture-log-size", cfg.CaptureLogSize, "Bytes at which the capture log is rotated, 0 to never rotate")
+	flags.Int64Var(&cfg.CaptureBody, "capture-body", cfg.CaptureBody, "Bytes of each captured request body to keep")
+	flags.IntVar(&cfg.CaptureHistory, "capture-history", cfg.CaptureHistory, "Captured requests kept in memory for /api/v1/hits")
+	flags.StringVar(&cfg.ExfilResponse, "exfil-response", cfg.ExfilResponse, "Answer of the /x exfiltration endpoint (gif, js, css, 204)")
+	flags.DurationVar(&cfg.RetentionMaxAge, "retention-max-age", cfg.RetentionMaxAge, "Delete uploads older than this, 0 to keep them")
+	flags.Int64Var(&cfg.RetentionMaxSize, "retention-max-size", cfg.RetentionMaxSize, "Delete the oldest uploads while the upload directory is larger than this many bytes, 0 for unlimited")
+}
+
+// applyEnv overrides cfg with the CTF_* environment variables that are set
+func applyEnv(cfg *Config) error {
+	env := &envReader{}
+	env.string("CTF_HOST", &cfg.Host)
+	env.int("CTF_PORT", &cfg.Port)
+	if value := os.Getenv("CTF_ROOT_DIR"); value != "" {
+		cfg.RootDirs = splitList(value)
+	}
+	env.string("CTF_UPLOAD_DIR", &cfg.UploadDir)
+	env.int64("CTF_MAX_UPLOAD_SIZE", &cfg.MaxUploadSize)
+	env.string("CTF_LOG_LEVEL", &cfg.LogLevel)
+	env.bool("CTF_HASH_HEADER", &cfg.HashHeader)
+	env.bool("CTF_BUILTIN", &cfg.Builtin)
+	env.bool("CTF_TLS", &cfg.TLS)
+	env.string("CTF_TLS_CERT", &cfg.TLSCertFile)
+	env.string("CTF_TLS_KEY", &cfg.TLSKeyFile)
+	env.string("CTF_TLS_DIR", &cfg.TLSCertDir)
+	env.string("CTF_SMB_LISTEN", &cfg.SMBListen)
+	env.string("CTF_FTP_LISTEN", &cfg.FTPListen)
+	env.string("CTF_FTP_PASSIVE_PORTS", &cfg.FTPPassivePorts)
+	env.string("CTF_TFTP_LISTEN", &cfg.TFTPListen)
+	env.string("CTF_SSH_LISTEN", &cfg.SSHListen)
+	env.string("CTF_SSH_HOST_KEY", &cfg.SSHHostKey)
+	env.string("CTF_SSH_AUTHORIZED_KEYS", &cfg.SSHAuthorizedKeys)
+	env.string("CTF_DNS_LISTEN", &cfg.DNSListen)
+	env.string("CTF_DNS_ZONE", &cfg.DNSZone)
+	env.duration("CTF_READ_HEADER_TIMEOUT", &cfg.ReadHeaderTimeout)
+	env.duration("CTF_READ_TIMEOUT", &cfg.ReadTimeout)
+	env.duration("CTF_WRITE_TIMEOUT", &cfg.WriteTimeout)
+	env.duration("CTF_IDLE_TIMEOUT", &cfg.IdleTimeout)
+	env.duration("CTF_API_TIMEOUT", &cfg.APITimeout)
+	env.duration("CTF_TRANSFER_IDLE_TIMEOUT", &cfg.TransferIdleTimeout)
+
+	// Listeners from the environment, separated by semicolons
+	var envListeners listenerList
+	for _, spec := range splitEnvList("CTF_LISTEN") {
+		if err := envListeners.Set(spec); err != nil {
+			env.errs = append(env.errs, fmt.Errorf("CTF_LISTEN: %w", err))
+		}
+	}
+	if len(envListeners) > 0 {
+		cfg.Listeners = envListeners
+	}
+
+	// Drop listeners, separated by semicolons
+	var envDrops dropList
+	for _, spec := range splitEnvList("CTF_DROP") {
+		if err := envDrops.Set(spec); err != nil {
+			env.errs = append(env.errs, fmt.Errorf("CTF_DROP: %w", err))
+		}
+	}
+	if len(envDrops) > 0 {
+		cfg.Drops = envDrops
+	}
+
+	// Users and tokens, separated by semicolons
+	var envUsers userList
+	for _, spec := range splitEnvList("CTF_USERS") {
+		if err := envUsers.Set(spec); err != nil {
+			env.errs = append(env.errs, fmt.Errorf("CTF_USERS: %w", err))
+		}
+	}
+	if len(envUsers) > 0 {
+		cfg.Users = envUsers
+	}
+	var envTokens tokenList
+	for _, spec := range splitEnvList("CTF_TOKENS") {
+		if err := envTokens.Set(spec); err != nil {
+			env.errs = append(env.errs, fmt.Errorf("CTF_TOKENS: %w", err))
+		}
+	}
+	if len(envTokens) > 0 {
+		cfg.Tokens = envTokens
+	}
+	if value := os.Getenv("CTF_ANONYMOUS"); value != "" {
+		cfg.Anonymous = splitList(value)
+	}
+
+	// Source address rules, separated by semicolons
+	ruleVars := []struct {
+		key    string
+		target *[]IPRule
+	}{
+		{"CTF_ALLOW", &cfg.Allow},
+		{"CTF_DENY", &cfg.Deny},
+	}
+	for _, ruleVar := range ruleVars {
+		var rules ipRuleList
+		for _, spec := range splitEnvList(ruleVar.key) {
+			if err := rules.Set(spec); err != nil {
+				env.errs = append(env.errs, fmt.Errorf("%s: %w", ruleVar.key, err))
+			}
+		}
+		if len(rules) > 0 {
+			*ruleVar.target = rules
+		}
+	}
+	if value := os.Getenv("CTF_TRUSTED_PROXIES"); value != "" {
+		if err := (*ipPrefixList)(&cfg.TrustedProxies).Set(value); err != nil {
+			env.errs = append(env.errs, fmt.Errorf("CTF_TRUSTED_PROXIES: %w", err))
+		}
+	}
+	env.string("CTF_IP_REJECT", &cfg.IPReject)
+
+	var envRateLimits rateRuleList
+	for _, spec := range splitEnvList("CTF_RATE_LIMITS") {
+		if err := envRateLimits.Set(spec); err != nil {
+			env.errs = append(env.errs, fmt.Errorf("CTF_RATE_LIMITS: %w", err))
+		}
+	}
+	if len(envRateLimits) > 0 {
+		cfg.RateLimits = envRateLimits
+	}
+	env.int("CTF_MAX_CONCURRENT_UPLOADS", &cfg.MaxConcurrentUploads)
+	env.int64("CTF_DOWNLOAD_RATE", &cfg.DownloadRate)
+	env.int64("CTF_MIN_FREE_SPACE", &cfg.MinFreeSpace)
+	env.int64("CTF_UPLOAD_QUOTA", &cfg.UploadQuota)
+	env.int64("CTF_SOURCE_QUOTA", &cfg.SourceQuota)
+	env.duration("CTF_RETENTION_MAX_AGE", &cfg.RetentionMaxAge)
+	env.int64("CTF_RETENTION_MAX_SIZE", &cfg.RetentionMaxSize)
+	env.bool("CTF_CAPTURE_UNMATCHED", &cfg.CaptureUnmatched)
+	env.string("CTF_CAPTURE_LOG", &cfg.CaptureLog)
+	env.int64("CTF_CAPTURE_LOG_SIZE", &cfg.CaptureLogSize)
+	env.int64("CTF_CAPTURE_BODY", &cfg.CaptureBody)
+	env.int("CTF_CAPTURE_HISTORY", &cfg.CaptureHistory)
+	env.string("CTF_EXFIL_RESPONSE", &cfg.ExfilResponse)
+
+	return errors.Join(env.errs...)
+}
+
+// splitList splits a comma-separated list, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// splitEnvList splits a semicolon-separated environment variable, dropping empty items
+func splitEnvList(key string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(key), ";") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// commaList is a flag holding a comma-separated list
+type commaList []string
+
+func (l *commaList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *commaList) Set(value string) error {
+	*l = splitList(value)
+	return nil
+}
+
+// envReader reads typed environment variables, collecting invalid values
+type envReader struct {
+	errs []error
+}
+
+func (e *envReader) string(key string, target *string) {
+	if value := os.Getenv(key); value != "" {
+		*target = value
+	}
+}
+
+func (e *envReader) int(key string, target *int) {
 	if value := os.Getenv(key); value != "" {
-		return value
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: invalid integer %q", key, value))
+			return
+		}
+		*target = parsed
+	}
+}
+
+func (e *envReader) int64(key string, target *int64) {
+	if value := os.Getenv(key); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: invalid integer %q", key, value))
+			return
+		}
+		*target = parsed
 	}
-	return defaultValue
 }
 
-func getEnvOrDefaultInt(key string, defaultValue int) int {
+func (e *envReader) bool(key string, target *bool) {
 	if value := os.Getenv(key); value != "" {
-		if parsed, err := strconv.Atoi(value); err == nil {
-			return parsed
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: invalid boolean %q", key, value))
+			return
 		}
+		*target = parsed
 	}
-	return defaultValue
 }
 
-func getEnvOrDefaultInt64(key string, defaultValue int64) int64 {
+func (e *envReader) duration(key string, target *time.Duration) {
 	if value := os.Getenv(key); value != "" {
-		if parsed, err := strconv.ParseInt(value, 10, 64); err == nil {
-			return parsed
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: invalid duration %q", key, value))
+			return
 		}
+		*target = parsed
 	}
-	return defaultValue
 }
@@ -0,0 +1,244 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// logLevels are the accepted log-level values
+var logLevels = []string{"trace", "debug", "info", "warn", "warning", "error", "fatal", "panic"}
+
+// loadFile decodes the YAML or TOML config file at path over cfg. Keys
+// missing from the file keep their current value, unknown keys are an error.
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	case ".toml":
+		meta, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			keys := make([]string, len(undecoded))
+			for i, key := range undecoded {
+				keys[i] = key.String()
+			}
+			return fmt.Errorf("%s: unknown keys: %s", path, strings.Join(keys, ", "))
+		}
+	default:
+		return fmt.Errorf("%s: unsupported config file type (expected .yaml, .yml or .toml)", path)
+	}
+
+	return nil
+}
+
+// Validate reports every invalid value in the configuration
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Port < 0 || c.Port > 65535 {
+		invalid("port: %d is out of range", c.Port)
+	}
+	if len(c.RootDirs) == 0 {
+		invalid("root: at least one directory or archive is required")
+	}
+	if c.UploadDir == "" {
+		invalid("upload-dir: must not be empty")
+	}
+	if c.MaxUploadSize <= 0 {
+		invalid("max-upload: must be positive")
+	}
+	if !isLogLevel(c.LogLevel) {
+		invalid("log-level: unknown level %q (expected one of %s)", c.LogLevel, strings.Join(logLevels, ", "))
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		invalid("tls-cert and tls-key must be given together")
+	}
+	if c.SMBListen != "" {
+		if _, _, err := net.SplitHostPort(c.SMBListen); err != nil {
+			invalid("smb-listen: %v", err)
+		}
+	}
+	if c.FTPListen != "" {
+		if _, _, err := net.SplitHostPort(c.FTPListen); err != nil {
+			invalid("ftp-listen: %v", err)
+		}
+	}
+	if c.TFTPListen != "" {
+		if _, _, err := net.SplitHostPort(c.TFTPListen); err != nil {
+			invalid("tftp-listen: %v", err)
+		}
+	}
+	if c.SSHListen != "" {
+		if _, _, err := net.SplitHostPort(c.SSHListen); err != nil {
+			invalid("ssh-listen: %v", err)
+		}
+	}
+	if c.DNSListen != "" {
+		if _, _, err := net.SplitHostPort(c.DNSListen); err != nil {
+			invalid("dns-listen: %v", err)
+		}
+		if c.DNSZone == "" {
+			invalid("dns-zone: required with dns-listen")
+		}
+	}
+	if c.FTPPassivePorts != "" {
+		if _, _, err := ParsePortRange(c.FTPPassivePorts); err != nil {
+			invalid("ftp-passive-ports: %v", err)
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, user := range c.Users {
+		if names[user.Name] {
+			invalid("users: duplicate user %q", user.Name)
+		}
+		names[user.Name] = true
+	}
+	for _, role := range c.Anonymous {
+		if !isRole(role) {
+			invalid("anonymous: unknown role %q (expected one of %s)", role, strings.Join(Roles, ", "))
+		}
+	}
+
+	switch c.IPReject {
+	case IPRejectDrop, IPRejectNotFound, IPRejectForbidden:
+	default:
+		invalid("ip-reject: unknown response %q (expected %s, %s or %s)", c.IPReject, IPRejectDrop, IPRejectNotFound, IPRejectForbidden)
+	}
+
+	if c.MaxConcurrentUploads < 0 {
+		invalid("max-concurrent-uploads: must not be negative")
+	}
+	if c.DownloadRate < 0 {
+		invalid("download-rate: must not be negative")
+	}
+	if c.MinFreeSpace < 0 {
+		invalid("min-free-space: must not be negative")
+	}
+	if c.UploadQuota < 0 {
+		invalid("upload-quota: must not be negative")
+	}
+	if c.SourceQuota < 0 {
+		invalid("source-quota: must not be negative")
+	}
+	if c.RetentionMaxAge < 0 {
+		invalid("retention-max-age: must not be negative")
+	}
+	if c.RetentionMaxSize < 0 {
+		invalid("retention-max-size: must not be negative")
+	}
+	if c.CaptureLogSize < 0 {
+		invalid("capture-log-size: must not be negative")
+	}
+	if c.CaptureBody < 0 {
+		invalid("capture-body: must not be negative")
+	}
+	if c.CaptureHistory < 1 {
+		invalid("capture-history: must be at least 1")
+	}
+	for i, rule := range c.Responses {
+		if err := rule.Validate(); err != nil {
+			invalid("responses[%d]: %v", i, err)
+		}
+	}
+	switch c.ExfilResponse {
+	case ExfilGIF, ExfilJS, ExfilCSS, ExfilNoContent:
+	default:
+		invalid("exfil-response: unknown response %q (expected %s, %s, %s or %s)", c.ExfilResponse, ExfilGIF, ExfilJS, ExfilCSS, ExfilNoContent)
+	}
+
+	timeouts := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"read-header-timeout", c.ReadHeaderTimeout},
+		{"read-timeout", c.ReadTimeout},
+		{"write-timeout", c.WriteTimeout},
+		{"idle-timeout", c.IdleTimeout},
+		{"api-timeout", c.APITimeout},
+		{"transfer-idle-timeout", c.TransferIdleTimeout},
+	}
+	for _, timeout := range timeouts {
+		if timeout.value < 0 {
+			invalid("%s: must not be negative", timeout.name)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// RestartRequired lists the settings that differ between c and next but only
+// take effect after a restart
+func (c *Config) RestartRequired(next *Config) []string {
+	var changed []string
+	check := func(name string, same bool) {
+		if !same {
+			changed = append(changed, name)
+		}
+	}
+
+	current, updated := listenerList(c.Listeners), listenerList(next.Listeners)
+	check("listen", current.String() == updated.String())
+	currentDrops, updatedDrops := dropList(c.Drops), dropList(next.Drops)
+	check("drop", currentDrops.String() == updatedDrops.String())
+	check("tls-cert", c.TLSCertFile == next.TLSCertFile)
+	check("tls-key", c.TLSKeyFile == next.TLSKeyFile)
+	check("tls-dir", c.TLSCertDir == next.TLSCertDir)
+	check("smb-listen", c.SMBListen == next.SMBListen)
+	check("ftp-listen", c.FTPListen == next.FTPListen)
+	check("ftp-passive-ports", c.FTPPassivePorts == next.FTPPassivePorts)
+	check("tftp-listen", c.TFTPListen == next.TFTPListen)
+	check("ssh-listen", c.SSHListen == next.SSHListen)
+	check("ssh-host-key", c.SSHHostKey == next.SSHHostKey)
+	check("ssh-authorized-keys", c.SSHAuthorizedKeys == next.SSHAuthorizedKeys)
+	check("dns-listen", c.DNSListen == next.DNSListen)
+	check("dns-zone", c.DNSZone == next.DNSZone)
+	check("capture-unmatched", c.CaptureUnmatched == next.CaptureUnmatched)
+	// Relative capture logs move with the upload directory
+	check("capture-log", c.CaptureLog == next.CaptureLog && (c.CaptureLog == "" || filepath.IsAbs(c.CaptureLog) || c.UploadDir == next.UploadDir))
+	check("capture-body", c.CaptureBody == next.CaptureBody)
+	check("capture-history", c.CaptureHistory == next.CaptureHistory)
+	check("exfil-response", c.ExfilResponse == next.ExfilResponse)
+	check("hash-header", c.HashHeader == next.HashHeader)
+	check("read-header-timeout", c.ReadHeaderTimeout == next.ReadHeaderTimeout)
+	check("read-timeout", c.ReadTimeout == next.ReadTimeout)
+	check("write-timeout", c.WriteTimeout == next.WriteTimeout)
+	check("idle-timeout", c.IdleTimeout == next.IdleTimeout)
+	check("api-timeout", c.APITimeout == next.APITimeout)
+	check("transfer-idle-timeout", c.TransferIdleTimeout == next.TransferIdleTimeout)
+
+	return changed
+}
+
+func isLogLevel(level string) bool {
+	for _, known := range logLevels {
+		if strings.EqualFold(level, known) {
+			return true
+		}
+	}
+	return false
+}
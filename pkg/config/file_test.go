@@ -0,0 +1,27 @@
+package config
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRestartRequiredCaptureLog(t *testing.T) {
+	tests := []struct {
+		name       string
+		captureLog string
+		want       []string
+	}{
+		{"relative log moves with the upload directory", ".hits.jsonl", []string{"capture-log"}},
+		{"absolute log stays", "/var/log/hits.jsonl", nil},
+		{"no log", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current := &Config{UploadDir: "/a", CaptureLog: tt.captureLog}
+			next := &Config{UploadDir: "/b", CaptureLog: tt.captureLog}
+			if got := current.RestartRequired(next); !slices.Equal(got, tt.want) {
+				t.Errorf("RestartRequired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
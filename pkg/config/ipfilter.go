@@ -0,0 +1,175 @@
+package config
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Responses to requests from addresses that aren't allowed
+const (
+	IPRejectDrop      = "drop" // Close the connection without a response
+	IPRejectNotFound  = "404"
+	IPRejectForbidden = "403"
+)
+
+// IPPrefix is an address range, written as a CIDR or a single address
+type IPPrefix struct {
+	netip.Prefix
+}
+
+// ParseIPPrefix parses a CIDR such as 10.10.0.0/16 or a single address
+func ParseIPPrefix(value string) (IPPrefix, error) {
+	value = strings.TrimSpace(value)
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return IPPrefix{}, fmt.Errorf("invalid CIDR %q", value)
+		}
+		return IPPrefix{prefix.Masked()}, nil
+	}
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return IPPrefix{}, fmt.Errorf("invalid address %q", value)
+	}
+	addr = addr.Unmap()
+	return IPPrefix{netip.PrefixFrom(addr, addr.BitLen())}, nil
+}
+
+// UnmarshalText parses an address range from a config file
+func (p *IPPrefix) UnmarshalText(text []byte) error {
+	prefix, err := ParseIPPrefix(string(text))
+	if err != nil {
+		return err
+	}
+	*p = prefix
+	return nil
+}
+
+// IPRule is a set of address ranges, optionally scoped to route groups
+type IPRule struct {
+	Groups   []string // Route groups the rule applies to, all when empty
+	Prefixes []IPPrefix
+}
+
+// AppliesTo reports whether the rule covers the given route group
+func (r IPRule) AppliesTo(group string) bool {
+	if len(r.Groups) == 0 {
+		return true
+	}
+	for _, scoped := range r.Groups {
+		if scoped == group {
+			return true
+		}
+	}
+	return false
+}
+
+// Contains reports whether addr falls within any of the rule's ranges
+func (r IPRule) Contains(addr netip.Addr) bool {
+	for _, prefix := range r.Prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseIPRule parses a rule of the form [GROUP+GROUP=]CIDR[,CIDR], e.g.
+// "loot+admin=10.8.0.0/24,10.8.1.5"
+func ParseIPRule(spec string) (IPRule, error) {
+	var rule IPRule
+	ranges := spec
+	if groups, rest, found := strings.Cut(spec, "="); found {
+		for _, group := range strings.Split(groups, "+") {
+			group = strings.TrimSpace(group)
+			if !isRouteGroup(group) {
+				return rule, fmt.Errorf("unknown route group %q (expected one of %s)", group, strings.Join(RouteGroups, ", "))
+			}
+			rule.Groups = append(rule.Groups, group)
+		}
+		ranges = rest
+	}
+
+	for _, value := range splitList(ranges) {
+		prefix, err := ParseIPPrefix(value)
+		if err != nil {
+			return rule, err
+		}
+		rule.Prefixes = append(rule.Prefixes, prefix)
+	}
+	if len(rule.Prefixes) == 0 {
+		return rule, fmt.Errorf("no addresses in rule %q", spec)
+	}
+	return rule, nil
+}
+
+// String formats the rule in the same syntax accepted by ParseIPRule
+func (r IPRule) String() string {
+	ranges := make([]string, len(r.Prefixes))
+	for i, prefix := range r.Prefixes {
+		ranges[i] = prefix.String()
+	}
+	if len(r.Groups) == 0 {
+		return strings.Join(ranges, ",")
+	}
+	return strings.Join(r.Groups, "+") + "=" + strings.Join(ranges, ",")
+}
+
+// UnmarshalText parses a rule from a config file
+func (r *IPRule) UnmarshalText(text []byte) error {
+	rule, err := ParseIPRule(string(text))
+	if err != nil {
+		return err
+	}
+	*r = rule
+	return nil
+}
+
+// ipRuleList is a repeatable -allow/-deny flag
+type ipRuleList []IPRule
+
+func (l *ipRuleList) String() string {
+	specs := make([]string, len(*l))
+	for i, rule := range *l {
+		specs[i] = rule.String()
+	}
+	return strings.Join(specs, ";")
+}
+
+func (l *ipRuleList) Set(value string) error {
+	rule, err := ParseIPRule(value)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, rule)
+	return nil
+}
+
+// ipPrefixList is a comma-separated list of address ranges
+type ipPrefixList []IPPrefix
+
+func (l *ipPrefixList) String() string {
+	if l == nil {
+		return ""
+	}
+	ranges := make([]string, len(*l))
+	for i, prefix := range *l {
+		ranges[i] = prefix.String()
+	}
+	return strings.Join(ranges, ",")
+}
+
+func (l *ipPrefixList) Set(value string) error {
+	var prefixes []IPPrefix
+	for _, item := range splitList(value) {
+		prefix, err := ParseIPPrefix(item)
+		if err != nil {
+			return err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	*l = prefixes
+	return nil
+}
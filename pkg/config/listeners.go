@@ -0,0 +1,229 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Route groups that listeners can be restricted to
+const (
+	RouteFiles   = "files"   // Downloads below /files/
+	RouteUpload  = "upload"  // File uploads
+	RouteLoot    = "loot"    // Listing uploaded files
+	RouteAPI     = "api"     // Informational API endpoints (tree, hashes, health, ...)
+	RouteAdmin   = "admin"   // Server administration (reload, ...)
+	RouteCapture = "capture" // Captured callbacks below /c/ and exfiltrated data below /x/, public
+)
+
+// RouteGroups lists every known route group
+var RouteGroups = []string{RouteFiles, RouteUpload, RouteLoot, RouteAPI, RouteAdmin, RouteCapture}
+
+// ListenerConfig describes one address the server listens on
+type ListenerConfig struct {
+	Addr   string
+	TLS    bool
+	Routes []string // Route groups served on this listener, all when empty
+}
+
+// Serves reports whether the listener serves the given route group
+func (l ListenerConfig) Serves(group string) bool {
+	if len(l.Routes) == 0 {
+		return true
+	}
+	for _, route := range l.Routes {
+		if route == group {
+			return true
+		}
+	}
+	return false
+}
+
+// String formats the listener in the same syntax accepted by ParseListener
+func (l ListenerConfig) String() string {
+	spec := l.Addr
+	if l.TLS {
+		spec += ",tls"
+	}
+	if len(l.Routes) > 0 {
+		spec += ",routes=" + strings.Join(l.Routes, "+")
+	}
+	return spec
+}
+
+// UnmarshalText parses a listener definition from a config file
+func (l *ListenerConfig) UnmarshalText(text []byte) error {
+	listener, err := ParseListener(string(text))
+	if err != nil {
+		return err
+	}
+	*l = listener
+	return nil
+}
+
+// ParseListener parses a listener definition of the form
+// ADDR[,tls][,routes=GROUP+GROUP], e.g. ":8443,tls,routes=upload+loot"
+func ParseListener(spec string) (ListenerConfig, error) {
+	parts := strings.Split(spec, ",")
+	listener := ListenerConfig{Addr: strings.TrimSpace(parts[0])}
+
+	if _, _, err := net.SplitHostPort(listener.Addr); err != nil {
+		return listener, fmt.Errorf("invalid listener address %q: %w", listener.Addr, err)
+	}
+
+	for _, option := range parts[1:] {
+		option = strings.TrimSpace(option)
+		switch {
+		case option == "tls":
+			listener.TLS = true
+		case strings.HasPrefix(option, "routes="):
+			for _, group := range strings.Split(strings.TrimPrefix(option, "routes="), "+") {
+				if !isRouteGroup(group) {
+					return listener, fmt.Errorf("unknown route group %q (expected one of %s)", group, strings.Join(RouteGroups, ", "))
+				}
+				listener.Routes = append(listener.Routes, group)
+			}
+		default:
+			return listener, fmt.Errorf("unknown listener option %q", option)
+		}
+	}
+
+	return listener, nil
+}
+
+// DropConfig describes a raw TCP listener for targets that only have netcat
+// or bash's /dev/tcp: an upload port saves every connection's stream in the
+// upload directory, a download port sends a file of the root and closes
+type DropConfig struct {
+	Addr   string
+	Serve  string // File of the root sent on every connection, empty for an upload port
+	Header bool   // The first line of an upload names the file
+}
+
+// String formats the drop listener in the same syntax accepted by ParseDrop
+func (d DropConfig) String() string {
+	if d.Serve != "" {
+		return d.Addr + ",serve=" + d.Serve
+	}
+	spec := d.Addr + ",upload"
+	if d.Header {
+		spec += ",header"
+	}
+	return spec
+}
+
+// UnmarshalText parses a drop listener definition from a config file
+func (d *DropConfig) UnmarshalText(text []byte) error {
+	drop, err := ParseDrop(string(text))
+	if err != nil {
+		return err
+	}
+	*d = drop
+	return nil
+}
+
+// ParseDrop parses a drop listener definition of the form
+// ADDR,upload[,header] or ADDR,serve=PATH, e.g. ":9001,upload" or
+// ":9002,serve=tools/linpeas.sh"
+func ParseDrop(spec string) (DropConfig, error) {
+	parts := strings.Split(spec, ",")
+	drop := DropConfig{Addr: strings.TrimSpace(parts[0])}
+
+	if _, _, err := net.SplitHostPort(drop.Addr); err != nil {
+		return drop, fmt.Errorf("invalid drop listener address %q: %w", drop.Addr, err)
+	}
+
+	upload := false
+	for _, option := range parts[1:] {
+		option = strings.TrimSpace(option)
+		switch {
+		case option == "upload":
+			upload = true
+		case option == "header":
+			drop.Header = true
+		case strings.HasPrefix(option, "serve="):
+			drop.Serve = strings.TrimPrefix(option, "serve=")
+			if drop.Serve == "" {
+				return drop, fmt.Errorf("drop listener %q: serve needs a file", spec)
+			}
+		default:
+			return drop, fmt.Errorf("unknown drop listener option %q", option)
+		}
+	}
+
+	if upload == (drop.Serve != "") {
+		return drop, fmt.Errorf("drop listener %q: expected either upload or serve=FILE", spec)
+	}
+	if drop.Header && !upload {
+		return drop, fmt.Errorf("drop listener %q: header only applies to upload ports", spec)
+	}
+	return drop, nil
+}
+
+// ParsePortRange parses a port range of the form MIN-MAX, e.g. "30000-30100"
+func ParsePortRange(spec string) (first, last int, err error) {
+	low, high, found := strings.Cut(spec, "-")
+	if !found {
+		return 0, 0, fmt.Errorf("invalid port range %q (expected MIN-MAX)", spec)
+	}
+	if first, err = strconv.Atoi(strings.TrimSpace(low)); err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q: %w", spec, err)
+	}
+	if last, err = strconv.Atoi(strings.TrimSpace(high)); err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q: %w", spec, err)
+	}
+	if first < 1 || last > 65535 || first > last {
+		return 0, 0, fmt.Errorf("invalid port range %q", spec)
+	}
+	return first, last, nil
+}
+
+func isRouteGroup(group string) bool {
+	for _, known := range RouteGroups {
+		if group == known {
+			return true
+		}
+	}
+	return false
+}
+
+// listenerList is a repeatable -listen flag
+type listenerList []ListenerConfig
+
+func (l *listenerList) String() string {
+	specs := make([]string, len(*l))
+	for i, listener := range *l {
+		specs[i] = listener.String()
+	}
+	return strings.Join(specs, ";")
+}
+
+func (l *listenerList) Set(value string) error {
+	listener, err := ParseListener(value)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, listener)
+	return nil
+}
+
+// dropList is a repeatable -drop flag
+type dropList []DropConfig
+
+func (l *dropList) String() string {
+	specs := make([]string, len(*l))
+	for i, drop := range *l {
+		specs[i] = drop.String()
+	}
+	return strings.Join(specs, ";")
+}
+
+func (l *dropList) Set(value string) error {
+	drop, err := ParseDrop(value)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, drop)
+	return nil
+}
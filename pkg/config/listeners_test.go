@@ -0,0 +1,91 @@
+package config
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseListener(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    ListenerConfig
+		wantErr bool
+	}{
+		{spec: ":8080", want: ListenerConfig{Addr: ":8080"}},
+		{spec: "0.0.0.0:8443,tls", want: ListenerConfig{Addr: "0.0.0.0:8443", TLS: true}},
+		{spec: ":8443,tls,routes=upload+loot", want: ListenerConfig{Addr: ":8443", TLS: true, Routes: []string{RouteUpload, RouteLoot}}},
+		{spec: "[::1]:80, routes=files", want: ListenerConfig{Addr: "[::1]:80", Routes: []string{RouteFiles}}},
+		{spec: "8080", wantErr: true},
+		{spec: ":80,routes=files+nope", wantErr: true},
+		{spec: ":80,routes=", wantErr: true},
+		{spec: ":80,http2", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseListener(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseListener() = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Addr != tt.want.Addr || got.TLS != tt.want.TLS || !slices.Equal(got.Routes, tt.want.Routes) {
+				t.Errorf("ParseListener() = %+v, want %+v", got, tt.want)
+			}
+			// String gives a spec parsing to the same listener
+			again, err := ParseListener(got.String())
+			if err != nil || again.String() != got.String() {
+				t.Errorf("String() = %q does not round trip: %v", got.String(), err)
+			}
+		})
+	}
+}
+
+func TestListenerServes(t *testing.T) {
+	all := ListenerConfig{Addr: ":80"}
+	some := ListenerConfig{Addr: ":80", Routes: []string{RouteUpload, RouteCapture}}
+	for _, group := range RouteGroups {
+		if !all.Serves(group) {
+			t.Errorf("listener without routes doesn't serve %s", group)
+		}
+		want := group == RouteUpload || group == RouteCapture
+		if some.Serves(group) != want {
+			t.Errorf("Serves(%s) = %v, want %v", group, !want, want)
+		}
+	}
+}
+
+func TestParseDrop(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    DropConfig
+		wantErr bool
+	}{
+		{spec: ":9001,upload", want: DropConfig{Addr: ":9001"}},
+		{spec: ":9001,upload,header", want: DropConfig{Addr: ":9001", Header: true}},
+		{spec: "10.0.0.1:9002,serve=tools/linpeas.sh", want: DropConfig{Addr: "10.0.0.1:9002", Serve: "tools/linpeas.sh"}},
+		{spec: ":9001", wantErr: true},
+		{spec: "9001,upload", wantErr: true},
+		{spec: ":9002,serve=", wantErr: true},
+		{spec: ":9002,upload,serve=a", wantErr: true},
+		{spec: ":9002,serve=a,header", wantErr: true},
+		{spec: ":9001,upload,gzip", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseDrop(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseDrop() = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseDrop() = %+v, want %+v", got, tt.want)
+			}
+			if again, err := ParseDrop(got.String()); err != nil || again != got {
+				t.Errorf("String() = %q does not round trip: %+v, %v", got.String(), again, err)
+			}
+		})
+	}
+}
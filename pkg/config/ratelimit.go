@@ -0,0 +1,134 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateRule limits how many requests each source address may make to route
+// groups, as a token bucket refilled at Count per Per
+type RateRule struct {
+	Groups []string // Route groups the rule applies to, all when empty
+	Count  int
+	Per    time.Duration
+	Burst  int // Requests allowed at once, Count when not given
+}
+
+// PerSecond returns the refill rate of the bucket
+func (r RateRule) PerSecond() float64 {
+	return float64(r.Count) / r.Per.Seconds()
+}
+
+// AppliesTo reports whether the rule covers the given route group
+func (r RateRule) AppliesTo(group string) bool {
+	if len(r.Groups) == 0 {
+		return true
+	}
+	for _, scoped := range r.Groups {
+		if scoped == group {
+			return true
+		}
+	}
+	return false
+}
+
+// rateUnits are the accepted rate limit periods
+var rateUnits = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+}
+
+// ParseRateRule parses a rule of the form [GROUP+GROUP=]COUNT/UNIT[,burst=N]
+// with UNIT one of s, m or h, e.g. "upload=10/m,burst=3"
+func ParseRateRule(spec string) (RateRule, error) {
+	var rule RateRule
+	rest := spec
+	if groups, limit, found := strings.Cut(spec, "="); found && !strings.ContainsAny(groups, "/,") {
+		for _, group := range strings.Split(groups, "+") {
+			group = strings.TrimSpace(group)
+			if !isRouteGroup(group) {
+				return rule, fmt.Errorf("unknown route group %q (expected one of %s)", group, strings.Join(RouteGroups, ", "))
+			}
+			rule.Groups = append(rule.Groups, group)
+		}
+		rest = limit
+	}
+
+	parts := strings.Split(rest, ",")
+	count, unit, found := strings.Cut(strings.TrimSpace(parts[0]), "/")
+	per, known := rateUnits[unit]
+	if !found || !known {
+		return rule, fmt.Errorf("invalid rate %q (expected COUNT/s, COUNT/m or COUNT/h)", parts[0])
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n <= 0 {
+		return rule, fmt.Errorf("invalid rate %q: count must be a positive integer", parts[0])
+	}
+	rule.Count, rule.Per, rule.Burst = n, per, n
+
+	for _, option := range parts[1:] {
+		option = strings.TrimSpace(option)
+		value, ok := strings.CutPrefix(option, "burst=")
+		if !ok {
+			return rule, fmt.Errorf("unknown rate limit option %q", option)
+		}
+		burst, err := strconv.Atoi(value)
+		if err != nil || burst <= 0 {
+			return rule, fmt.Errorf("invalid burst %q: must be a positive integer", value)
+		}
+		rule.Burst = burst
+	}
+
+	return rule, nil
+}
+
+// String formats the rule in the same syntax accepted by ParseRateRule
+func (r RateRule) String() string {
+	unit := r.Per.String()
+	for name, per := range rateUnits {
+		if per == r.Per {
+			unit = name
+		}
+	}
+	spec := fmt.Sprintf("%d/%s", r.Count, unit)
+	if r.Burst != r.Count {
+		spec += fmt.Sprintf(",burst=%d", r.Burst)
+	}
+	if len(r.Groups) > 0 {
+		spec = strings.Join(r.Groups, "+") + "=" + spec
+	}
+	return spec
+}
+
+// UnmarshalText parses a rule from a config file
+func (r *RateRule) UnmarshalText(text []byte) error {
+	rule, err := ParseRateRule(string(text))
+	if err != nil {
+		return err
+	}
+	*r = rule
+	return nil
+}
+
+// rateRuleList is a repeatable -rate-limit flag
+type rateRuleList []RateRule
+
+func (l *rateRuleList) String() string {
+	specs := make([]string, len(*l))
+	for i, rule := range *l {
+		specs[i] = rule.String()
+	}
+	return strings.Join(specs, ";")
+}
+
+func (l *rateRuleList) Set(value string) error {
+	rule, err := ParseRateRule(value)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, rule)
+	return nil
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// MaxResponseDelay bounds the delay of canned responses
+const MaxResponseDelay = 10 * time.Minute
+
+// ResponseRule is a canned response for requests matching a method and a
+// path pattern, for SSRF and open redirect testing
+type ResponseRule struct {
+	Method   string            `yaml:"method" toml:"method"`     // Any method when empty or *
+	Path     string            `yaml:"path" toml:"path"`         // path.Match pattern, a trailing /** matches everything below
+	Status   int               `yaml:"status" toml:"status"`     // 302 with a redirect, 200 otherwise, when zero
+	Redirect string            `yaml:"redirect" toml:"redirect"` // Location, any scheme (http, gopher, file, ...)
+	Headers  map[string]string `yaml:"headers" toml:"headers"`
+	Body     string            `yaml:"body" toml:"body"` // Inline body
+	File     string            `yaml:"file" toml:"file"` // File of the root sent as the body
+	Delay    time.Duration     `yaml:"delay" toml:"delay"`
+}
+
+// Validate reports what is wrong with the rule
+func (r ResponseRule) Validate() error {
+	if r.Method != "" && r.Method != "*" && strings.ContainsAny(r.Method, " \t\r\n()<>@,;:\\\"/[]?={}") {
+		return fmt.Errorf("invalid method %q", r.Method)
+	}
+	if !strings.HasPrefix(r.Path, "/") {
+		return fmt.Errorf("path %q must start with /", r.Path)
+	}
+	if _, err := path.Match(strings.TrimSuffix(r.Path, "/**"), "/"); err != nil {
+		return fmt.Errorf("invalid path pattern %q", r.Path)
+	}
+	if r.Status != 0 && (r.Status < 100 || r.Status > 599) {
+		return fmt.Errorf("status %d is out of range", r.Status)
+	}
+	if r.Body != "" && r.File != "" {
+		return errors.New("body and file are exclusive")
+	}
+	if r.File != "" && !fs.ValidPath(strings.TrimPrefix(r.File, "/")) {
+		return fmt.Errorf("invalid file %q", r.File)
+	}
+	for name, value := range r.Headers {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") || strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("invalid header %q", name)
+		}
+	}
+	if strings.ContainsAny(r.Redirect, "\r\n") {
+		return errors.New("invalid redirect")
+	}
+	if r.Delay < 0 || r.Delay > MaxResponseDelay {
+		return fmt.Errorf("delay must be between 0 and %s", MaxResponseDelay)
+	}
+	return nil
+}
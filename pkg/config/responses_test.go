@@ -0,0 +1,42 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResponseRuleValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    ResponseRule
+		wantErr bool
+	}{
+		{"minimal", ResponseRule{Path: "/ssrf"}, false},
+		{"everything", ResponseRule{Method: "POST", Path: "/a/**", Status: 307, Redirect: "gopher://127.0.0.1:6379/_INFO", Headers: map[string]string{"X-A": "b"}, Delay: time.Second}, false},
+		{"any method", ResponseRule{Method: "*", Path: "/*"}, false},
+		{"file", ResponseRule{Path: "/dtd", File: "/payloads/xxe.dtd"}, false},
+		{"file without slash", ResponseRule{Path: "/dtd", File: "payloads/xxe.dtd"}, false},
+		{"file escaping the root", ResponseRule{Path: "/dtd", File: "/../etc/passwd"}, true},
+		{"file with dot dot inside", ResponseRule{Path: "/dtd", File: "/payloads/../../etc/passwd"}, true},
+		{"file with empty segment", ResponseRule{Path: "/dtd", File: "/payloads//xxe.dtd"}, true},
+		{"file of the root", ResponseRule{Path: "/dtd", File: "/"}, true},
+		{"file with trailing slash", ResponseRule{Path: "/dtd", File: "/payloads/"}, true},
+		{"body and file", ResponseRule{Path: "/dtd", Body: "x", File: "/a"}, true},
+		{"relative path", ResponseRule{Path: "ssrf"}, true},
+		{"bad pattern", ResponseRule{Path: "/[a"}, true},
+		{"bad method", ResponseRule{Method: "GE T", Path: "/"}, true},
+		{"status out of range", ResponseRule{Path: "/", Status: 600}, true},
+		{"header injection", ResponseRule{Path: "/", Headers: map[string]string{"X-A": "b\r\nX-B: c"}}, true},
+		{"bad header name", ResponseRule{Path: "/", Headers: map[string]string{"X A": "b"}}, true},
+		{"redirect injection", ResponseRule{Path: "/", Redirect: "http://a\r\nX-B: c"}, true},
+		{"negative delay", ResponseRule{Path: "/", Delay: -time.Second}, true},
+		{"long delay", ResponseRule{Path: "/", Delay: MaxResponseDelay + time.Second}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.rule.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
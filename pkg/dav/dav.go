@@ -0,0 +1,312 @@
+// Package dav exposes the root directory read-only and the upload directory
+// writable as a WebDAV file system.
+package dav
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	iofs "io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/m1kkY8/ctfserver/pkg/ipfilter"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+	"github.com/m1kkY8/ctfserver/pkg/util"
+	"golang.org/x/net/webdav"
+)
+
+// UploadsDir is the writable directory at the top of the share, backed by the
+// upload directory. It hides a root entry of the same name.
+const UploadsDir = "uploads"
+
+// UploadName returns the upload file name of a share path, ok is false for
+// paths outside the uploads directory
+func UploadName(name string) (upload string, ok bool) {
+	name = path.Clean("/" + name)
+	if name == "/"+UploadsDir {
+		return "", true
+	}
+	upload, ok = strings.CutPrefix(name, "/"+UploadsDir+"/")
+	return upload, ok
+}
+
+// FileSystem is the WebDAV view of a file service: the root at the top of
+// the share and the upload directory below UploadsDir
+type FileSystem struct {
+	fileService *service.FileService
+}
+
+// NewFileSystem creates a WebDAV file system for fileService
+func NewFileSystem(fileService *service.FileService) *FileSystem {
+	return &FileSystem{
+		fileService: fileService,
+	}
+}
+
+// Mkdir always fails: the root is read-only and the upload directory is flat
+func (f *FileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return os.ErrPermission
+}
+
+// OpenFile opens a file or directory. Only the lock-null request of WebDAV
+// clients may create a file, empty, in the uploads directory; contents are
+// written with PUT through the file service.
+func (f *FileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	writing := flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+	upload, inUploads := UploadName(name)
+
+	switch {
+	case !inUploads:
+		if writing {
+			return nil, os.ErrPermission
+		}
+		return f.openRoot(name)
+
+	case upload == "":
+		if writing {
+			return nil, os.ErrPermission
+		}
+		uploadDir := f.fileService.UploadDir()
+		if err := util.EnsureDir(uploadDir); err != nil {
+			return nil, err
+		}
+		dir, err := os.Open(uploadDir)
+		if err != nil {
+			return nil, err
+		}
+		return &uploadsDir{readOnlyFile{dir}}, nil
+	}
+
+	filePath, err := f.fileService.UploadPath(upload)
+	if err != nil {
+		if writing {
+			return nil, os.ErrPermission
+		}
+		return nil, os.ErrNotExist
+	}
+
+	if flag&os.O_CREATE != 0 {
+		if _, err := os.Stat(filePath); errors.Is(err, os.ErrNotExist) {
+			result, err := f.fileService.SaveUpload(upload, strings.NewReader(""), 0, sourceAddress(ctx), 0)
+			if err != nil {
+				return nil, err
+			}
+			if !result.Success {
+				return nil, os.ErrPermission
+			}
+		}
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return &uploadFile{readOnlyFile{file}}, nil
+}
+
+// RemoveAll deletes an uploaded file; nothing else can be deleted
+func (f *FileSystem) RemoveAll(ctx context.Context, name string) error {
+	upload, inUploads := UploadName(name)
+	if !inUploads || upload == "" {
+		return os.ErrPermission
+	}
+
+	if err := f.fileService.DeleteUpload(upload); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if errors.Is(err, service.ErrInvalidFilename) {
+			return os.ErrPermission
+		}
+		return err
+	}
+
+	logger.Logger.WithFields(map[string]interface{}{
+		"filename": upload,
+		"source":   sourceAddress(ctx),
+	}).Info("Deleted upload over WebDAV")
+	return nil
+}
+
+// Rename renames an uploaded file within the uploads directory
+func (f *FileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	oldUpload, oldInUploads := UploadName(oldName)
+	newUpload, newInUploads := UploadName(newName)
+	if !oldInUploads || !newInUploads || oldUpload == "" || newUpload == "" {
+		return os.ErrPermission
+	}
+
+	if err := f.fileService.RenameUpload(oldUpload, newUpload); err != nil {
+		if errors.Is(err, service.ErrInvalidFilename) {
+			return os.ErrPermission
+		}
+		return err
+	}
+
+	logger.Logger.WithFields(map[string]interface{}{
+		"filename": newUpload,
+		"from":     oldUpload,
+		"source":   sourceAddress(ctx),
+	}).Info("Renamed upload over WebDAV")
+	return nil
+}
+
+// Stat returns the file info of a share path
+func (f *FileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	upload, inUploads := UploadName(name)
+	switch {
+	case !inUploads:
+		return iofs.Stat(f.fileService.RootFS(), util.CleanPath(name))
+
+	case upload == "":
+		uploadDir := f.fileService.UploadDir()
+		if err := util.EnsureDir(uploadDir); err != nil {
+			return nil, err
+		}
+		return uploadsDirInfo(uploadDir)
+	}
+
+	filePath, err := f.fileService.UploadPath(upload)
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	return os.Stat(filePath)
+}
+
+// openRoot opens a read-only file or directory of the root
+func (f *FileSystem) openRoot(name string) (webdav.File, error) {
+	relPath := util.CleanPath(name)
+	file, err := f.fileService.RootFS().Open(relPath)
+	if err != nil {
+		return nil, err
+	}
+	root := &rootFile{File: file}
+	if relPath == "." {
+		root.uploadDir = f.fileService.UploadDir()
+	}
+	return root, nil
+}
+
+// sourceAddress returns the client address stored in the request context
+func sourceAddress(ctx context.Context) string {
+	if addr, ok := ipfilter.FromContext(ctx); ok {
+		return addr.String()
+	}
+	return ""
+}
+
+// rootFile is a read-only file or directory of the root. The top directory
+// lists the uploads directory in place of any root entry of that name.
+type rootFile struct {
+	iofs.File
+	uploadDir string // Set for the top directory only
+}
+
+func (f *rootFile) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := f.File.(io.Seeker)
+	if !ok {
+		return 0, errors.New("file does not support seeking")
+	}
+	return seeker.Seek(offset, whence)
+}
+
+func (f *rootFile) Readdir(count int) ([]iofs.FileInfo, error) {
+	dir, ok := f.File.(iofs.ReadDirFile)
+	if !ok {
+		return nil, errors.New("not a directory")
+	}
+	entries, err := dir.ReadDir(count)
+
+	infos := make([]iofs.FileInfo, 0, len(entries)+1)
+	for _, entry := range entries {
+		if f.uploadDir != "" && entry.Name() == UploadsDir {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			infos = append(infos, info)
+		}
+	}
+	if f.uploadDir != "" && count <= 0 {
+		if info, err := uploadsDirInfo(f.uploadDir); err == nil {
+			infos = append(infos, info)
+		}
+	}
+	return infos, err
+}
+
+func (f *rootFile) Write(p []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+// readOnlyFile is a file of the upload directory opened for reading; writes
+// go through PUT
+type readOnlyFile struct {
+	*os.File
+}
+
+func (f readOnlyFile) Write(p []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+// uploadsDir is the upload directory, hiding the files reserved by the file service
+type uploadsDir struct {
+	readOnlyFile
+}
+
+func (d *uploadsDir) Readdir(count int) ([]iofs.FileInfo, error) {
+	infos, err := d.File.Readdir(count)
+	visible := infos[:0]
+	for _, info := range infos {
+		if service.IsValidUploadName(info.Name()) {
+			visible = append(visible, info)
+		}
+	}
+	return visible, err
+}
+
+func (d *uploadsDir) Stat() (iofs.FileInfo, error) {
+	return uploadsDirInfo(d.Name())
+}
+
+// uploadFile is an uploaded file. It accepts and discards property changes,
+// which Windows sends after every copy to set file times and attributes.
+type uploadFile struct {
+	readOnlyFile
+}
+
+func (f *uploadFile) DeadProps() (map[xml.Name]webdav.Property, error) {
+	return map[xml.Name]webdav.Property{}, nil
+}
+
+func (f *uploadFile) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	accepted := webdav.Propstat{Status: http.StatusOK}
+	for _, patch := range patches {
+		for _, prop := range patch.Props {
+			accepted.Props = append(accepted.Props, webdav.Property{XMLName: prop.XMLName})
+		}
+	}
+	return []webdav.Propstat{accepted}, nil
+}
+
+// uploadsDirInfo returns the info of the upload directory, named UploadsDir
+func uploadsDirInfo(uploadDir string) (iofs.FileInfo, error) {
+	info, err := os.Stat(uploadDir)
+	if err != nil {
+		return nil, err
+	}
+	return namedInfo{info}, nil
+}
+
+// namedInfo is the upload directory's info under its name in the share
+type namedInfo struct {
+	iofs.FileInfo
+}
+
+func (i namedInfo) Name() string {
+	return UploadsDir
+}
@@ -0,0 +1,149 @@
+package dav
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+)
+
+// newTestFS returns a file system over a root holding tools/nc and an upload
+// directory holding loot.txt
+func newTestFS(t *testing.T) (*FileSystem, string) {
+	t.Helper()
+	logger.InitLogger("error")
+	root := fstest.MapFS{
+		"tools/nc":        {Data: []byte("nc")},
+		"uploads/old.txt": {Data: []byte("hidden by the uploads directory")},
+	}
+	uploadDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(uploadDir, "loot.txt"), []byte("loot"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return NewFileSystem(service.NewFileService(root, "root", uploadDir, 1<<20)), uploadDir
+}
+
+func TestUploadName(t *testing.T) {
+	tests := []struct {
+		name   string
+		upload string
+		ok     bool
+	}{
+		{"/uploads", "", true},
+		{"/uploads/", "", true},
+		{"uploads/loot.txt", "loot.txt", true},
+		{"/uploads/../uploads/loot.txt", "loot.txt", true},
+		{"/uploads/a/b", "a/b", true},
+		{"/uploads/../tools/nc", "", false},
+		{"/tools/nc", "", false},
+		{"/uploadsx/a", "", false},
+		{"/", "", false},
+	}
+	for _, tt := range tests {
+		upload, ok := UploadName(tt.name)
+		if ok != tt.ok || ok && upload != tt.upload {
+			t.Errorf("UploadName(%q) = %q, %v, want %q, %v", tt.name, upload, ok, tt.upload, tt.ok)
+		}
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	f, uploadDir := newTestFS(t)
+	ctx := context.Background()
+
+	// Nothing outside the uploads directory, nor the directory itself, opens for writing
+	for _, name := range []string{"/tools/nc", "/tools/new", "/uploads", "/uploads/../etc"} {
+		if _, err := f.OpenFile(ctx, name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0); !errors.Is(err, os.ErrPermission) {
+			t.Errorf("OpenFile(%q) for writing = %v, want permission denied", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(uploadDir, "new")); !os.IsNotExist(err) {
+		t.Errorf("refused write created a file: %v", err)
+	}
+
+	// Reads of the root still work, and uploads hides the root entry of that name
+	file, err := f.OpenFile(ctx, "/tools/nc", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(file); string(data) != "nc" {
+		t.Errorf("read %q, want nc", data)
+	}
+	file.Close()
+	if _, err := f.OpenFile(ctx, "/uploads/old.txt", os.O_RDONLY, 0); !os.IsNotExist(err) {
+		t.Errorf("root entry below uploads opened: %v", err)
+	}
+
+	// Files opened in uploads can't be written, only created empty
+	file, err = f.OpenFile(ctx, "/uploads/loot.txt", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte("x")); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("Write() = %v, want permission denied", err)
+	}
+	file.Close()
+
+	file, err = f.OpenFile(ctx, "/uploads/locked.txt", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	if info, err := os.Stat(filepath.Join(uploadDir, "locked.txt")); err != nil || info.Size() != 0 {
+		t.Errorf("lock-null file not created empty: %v", err)
+	}
+	// Creating an existing upload keeps its content
+	file, err = f.OpenFile(ctx, "/uploads/loot.txt", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	if data, _ := os.ReadFile(filepath.Join(uploadDir, "loot.txt")); string(data) != "loot" {
+		t.Errorf("existing upload holds %q", data)
+	}
+}
+
+func TestRenameAndRemove(t *testing.T) {
+	f, uploadDir := newTestFS(t)
+	ctx := context.Background()
+
+	for _, tt := range []struct{ from, to string }{
+		{"/tools/nc", "/uploads/nc"},
+		{"/uploads/loot.txt", "/tools/loot.txt"},
+		{"/uploads", "/uploads2"},
+		{"/uploads/loot.txt", "/uploads"},
+		{"/uploads/loot.txt", "/uploads/.uploads.json"},
+	} {
+		if err := f.Rename(ctx, tt.from, tt.to); !errors.Is(err, os.ErrPermission) {
+			t.Errorf("Rename(%q, %q) = %v, want permission denied", tt.from, tt.to, err)
+		}
+	}
+	if err := f.Rename(ctx, "/uploads/loot.txt", "/uploads/creds.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(uploadDir, "creds.txt")); string(data) != "loot" {
+		t.Errorf("renamed upload holds %q", data)
+	}
+
+	for _, name := range []string{"/", "/tools", "/tools/nc", "/uploads", "/uploads/.uploads.json"} {
+		if err := f.RemoveAll(ctx, name); !errors.Is(err, os.ErrPermission) {
+			t.Errorf("RemoveAll(%q) = %v, want permission denied", name, err)
+		}
+	}
+	if err := f.RemoveAll(ctx, "/uploads/creds.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(uploadDir, "creds.txt")); !os.IsNotExist(err) {
+		t.Errorf("upload not deleted: %v", err)
+	}
+	// Deleting what is already gone succeeds
+	if err := f.RemoveAll(ctx, "/uploads/creds.txt"); err != nil {
+		t.Errorf("RemoveAll of a missing upload = %v", err)
+	}
+}
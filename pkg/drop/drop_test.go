@@ -0,0 +1,140 @@
+package drop
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/m1kkY8/ctfserver/pkg/auth"
+	"github.com/m1kkY8/ctfserver/pkg/config"
+	"github.com/m1kkY8/ctfserver/pkg/ipfilter"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+)
+
+func TestParallelUploadsKeepEveryFile(t *testing.T) {
+	logger.InitLogger("error")
+	uploadDir := t.TempDir()
+	cfg := &config.Config{}
+	fileService := service.NewFileService(fstest.MapFS{}, "root", uploadDir, 1<<20)
+	server := New(fileService, auth.New(cfg), ipfilter.New(cfg), time.Minute)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(l, config.DropConfig{})
+
+	// Every connection comes from 127.0.0.1 within a few milliseconds, so
+	// the generated names collide
+	const uploads = 40
+	var wg sync.WaitGroup
+	for i := range uploads {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			fmt.Fprintf(conn, "upload %d\n", i)
+			conn.Close()
+		}()
+	}
+	wg.Wait()
+	defer server.Close()
+
+	// Uploads are saved once the server reads the end of their connections
+	seen := make(map[string]bool)
+	for deadline := time.Now().Add(5 * time.Second); len(seen) != uploads && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		uploaded, err := fileService.ListUploads()
+		if err != nil {
+			t.Fatal(err)
+		}
+		clear(seen)
+		for _, file := range uploaded.Files {
+			data, err := os.ReadFile(filepath.Join(uploadDir, file.Name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			seen[string(data)] = true
+		}
+	}
+	if len(seen) != uploads {
+		t.Errorf("%d distinct uploads saved, want %d", len(seen), uploads)
+	}
+}
+
+func TestAccess(t *testing.T) {
+	logger.InitLogger("error")
+	users := []config.UserConfig{{Name: "admin", Password: "secret", Roles: []string{config.RoleAdmin}}}
+	deny, err := config.ParseIPRule("upload=127.0.0.1/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		cfg      *config.Config
+		download bool
+		upload   bool
+	}{
+		{"no authentication", &config.Config{}, true, true},
+		{"anonymous download", &config.Config{Users: users, Anonymous: []string{config.RoleDownload}}, true, false},
+		{"anonymous upload", &config.Config{Users: users, Anonymous: []string{config.RoleUpload}}, false, true},
+		{"denied address", &config.Config{Deny: []config.IPRule{deny}}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uploadDir := t.TempDir()
+			fileService := service.NewFileService(fstest.MapFS{"tools/nc": {Data: []byte("nc")}}, "root", uploadDir, 1<<20)
+			server := New(fileService, auth.New(tt.cfg), ipfilter.New(tt.cfg), time.Minute)
+			defer server.Close()
+
+			download, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			go server.Serve(download, config.DropConfig{Serve: "tools/nc"})
+			upload, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			go server.Serve(upload, config.DropConfig{Header: true})
+
+			conn, err := net.Dial("tcp", download.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			data, _ := io.ReadAll(conn)
+			conn.Close()
+			if (string(data) == "nc") != tt.download {
+				t.Errorf("download sent %q, want allowed %v", data, tt.download)
+			}
+
+			conn, err = net.Dial("tcp", upload.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			fmt.Fprint(conn, "loot.txt\nloot")
+			conn.(*net.TCPConn).CloseWrite()
+			// The server closes the connection once the upload is saved or refused
+			io.Copy(io.Discard, conn)
+			conn.Close()
+			_, err = os.Stat(filepath.Join(uploadDir, "loot.txt"))
+			if (err == nil) != tt.upload {
+				t.Errorf("upload saved: %v, want allowed %v", err, tt.upload)
+			}
+		})
+	}
+}
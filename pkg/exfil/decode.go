@@ -0,0 +1,119 @@
+package exfil
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Encodings Decode recognizes
+const (
+	EncodingURL       = "url"
+	EncodingHex       = "hex"
+	EncodingBase64    = "base64"
+	EncodingBase64URL = "base64url"
+)
+
+// maxLayers bounds how many encodings Decode peels off, as in
+// encodeURIComponent(btoa(...))
+const maxLayers = 4
+
+// minHexLength keeps numbers from being read as hex
+const minHexLength = 8
+
+// blobLength is the length from which base64 values are decoded even when
+// the result isn't text: plain text that long without a single space or
+// punctuation is unlikely
+const blobLength = 64
+
+// Decode removes the encodings of value, outermost first, returning the
+// content and the encodings found joined with "+". A layer that only
+// decodes to binary is kept unless value is long base64, so words, numbers
+// and hex tokens that happen to be valid encodings stay as they are. Values
+// of only hex digits are never taken for base64.
+func Decode(value string) (content []byte, encoding string) {
+	content = []byte(value)
+	var layers []string
+	for len(layers) < maxLayers {
+		decoded, layer, ok := decodeLayer(string(content))
+		if !ok {
+			break
+		}
+		content = decoded
+		layers = append(layers, layer)
+	}
+	return content, strings.Join(layers, "+")
+}
+
+// decodeLayer removes one encoding from value
+func decodeLayer(value string) ([]byte, string, bool) {
+	if strings.Contains(value, "%") {
+		if decoded, err := url.QueryUnescape(value); err == nil && decoded != value {
+			return []byte(decoded), EncodingURL, true
+		}
+	}
+	if len(value) < 4 {
+		return nil, "", false
+	}
+
+	// Hex first: every hex string is also in the base64 alphabet, and one
+	// that isn't hex encoded text is a token, digest or number
+	if isHex(value) {
+		if len(value) >= minHexLength && len(value)%2 == 0 {
+			if decoded, err := hex.DecodeString(value); err == nil && isText(decoded) {
+				return decoded, EncodingHex, true
+			}
+		}
+		return nil, "", false
+	}
+
+	// Unencoded base64 in a query string has its plus signs turned into spaces
+	candidate := strings.ReplaceAll(strings.TrimSpace(value), " ", "+")
+	if strings.ContainsAny(candidate, "-_") {
+		if decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(candidate, "=")); err == nil && acceptable(decoded, value) {
+			return decoded, EncodingBase64URL, true
+		}
+		return nil, "", false
+	}
+	if decoded, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(candidate, "=")); err == nil && acceptable(decoded, value) {
+		return decoded, EncodingBase64, true
+	}
+	return nil, "", false
+}
+
+// acceptable reports whether decoded is a plausible base64 decoding of
+// value: text, or anything for long values without spaces
+func acceptable(decoded []byte, value string) bool {
+	if len(decoded) == 0 {
+		return false
+	}
+	blob := len(value) >= blobLength && !strings.Contains(value, " ")
+	return blob || isText(decoded)
+}
+
+// isHex reports whether s has only hex digits
+func isHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// isText reports whether b is UTF-8 text without control characters other
+// than whitespace
+func isText(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if unicode.IsControl(r) && r != '\n' && r != '\r' && r != '\t' {
+			return false
+		}
+	}
+	return true
+}
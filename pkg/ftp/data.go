@@ -0,0 +1,222 @@
+package ftp
+
+import (
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dataTimeout bounds waiting for a data connection and the time a transfer
+// may make no progress
+const dataTimeout = time.Minute
+
+// handlePasv opens a passive data port on the address the client connected to
+func (c *conn) handlePasv(arg string) {
+	ip := c.localIP().To4()
+	if ip == nil {
+		c.reply(425, "PASV needs IPv4, use EPSV")
+		return
+	}
+	port, err := c.listenPassive()
+	if err != nil {
+		c.reply(425, "Can't open passive data port")
+		return
+	}
+	c.reply(227, "Entering Passive Mode (%d,%d,%d,%d,%d,%d)", ip[0], ip[1], ip[2], ip[3], port>>8, port&0xFF)
+}
+
+// handleEpsv opens a passive data port for IPv4 or IPv6 clients
+func (c *conn) handleEpsv(arg string) {
+	if strings.EqualFold(arg, "ALL") {
+		c.reply(200, "EPSV ALL ok")
+		return
+	}
+	port, err := c.listenPassive()
+	if err != nil {
+		c.reply(425, "Can't open passive data port")
+		return
+	}
+	c.reply(229, "Entering Extended Passive Mode (|||%d|)", port)
+}
+
+// handlePort sets the client's address for an active data connection
+func (c *conn) handlePort(arg string) {
+	parts := strings.Split(arg, ",")
+	if len(parts) != 6 {
+		c.reply(501, "Invalid PORT argument")
+		return
+	}
+	var values [6]byte
+	for i, part := range parts {
+		value, err := strconv.ParseUint(strings.TrimSpace(part), 10, 8)
+		if err != nil {
+			c.reply(501, "Invalid PORT argument")
+			return
+		}
+		values[i] = byte(value)
+	}
+	ip := netip.AddrFrom4([4]byte(values[:4]))
+	c.setActive(ip, int(values[4])<<8|int(values[5]))
+}
+
+// handleEprt sets the client's address for an active data connection, e.g.
+// "|2|::1|6275|"
+func (c *conn) handleEprt(arg string) {
+	if len(arg) < 2 {
+		c.reply(501, "Invalid EPRT argument")
+		return
+	}
+	parts := strings.Split(arg, arg[:1])
+	if len(parts) != 5 {
+		c.reply(501, "Invalid EPRT argument")
+		return
+	}
+	ip, err := netip.ParseAddr(parts[2])
+	port, portErr := strconv.Atoi(parts[3])
+	if err != nil || portErr != nil || (parts[1] != "1" && parts[1] != "2") {
+		c.reply(501, "Invalid EPRT argument")
+		return
+	}
+	c.setActive(ip, port)
+}
+
+// setActive switches to active data connections to ip and port. Only the
+// client's own address is accepted, so the server can't be used to connect
+// elsewhere.
+func (c *conn) setActive(ip netip.Addr, port int) {
+	if ip.Unmap() != c.addr || port < 1 || port > 65535 {
+		c.reply(500, "Data connections must go to the client's address")
+		return
+	}
+
+	c.mu.Lock()
+	c.closePassive()
+	c.active = netip.AddrPortFrom(ip.Unmap(), uint16(port)).String()
+	c.mu.Unlock()
+	c.reply(200, "PORT command successful")
+}
+
+// listenPassive listens for the next data connection, returning the port
+func (c *conn) listenPassive() (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closePassive()
+	c.active = ""
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+
+	ip := c.localIP()
+	first, last := c.server.minPassivePort, c.server.maxPassivePort
+	// Start at a random port of the range so parallel clients rarely collide
+	offset := 0
+	if last > first {
+		offset = rand.IntN(last - first + 1)
+	}
+	var err error
+	for i := 0; i <= last-first; i++ {
+		port := first + (offset+i)%(last-first+1)
+		var l net.Listener
+		if l, err = net.Listen("tcp", net.JoinHostPort(ip.String(), strconv.Itoa(port))); err == nil {
+			c.passive = l
+			return l.Addr().(*net.TCPAddr).Port, nil
+		}
+	}
+	return 0, err
+}
+
+// closePassive closes an unused passive listener; c.mu must be held
+func (c *conn) closePassive() {
+	if c.passive != nil {
+		c.passive.Close()
+		c.passive = nil
+	}
+}
+
+// localIP returns the address the client connected to
+func (c *conn) localIP() net.IP {
+	if addr, ok := c.netConn.LocalAddr().(*net.TCPAddr); ok {
+		return addr.IP
+	}
+	return net.IPv4zero
+}
+
+// openData opens the data connection set up by the last PASV, EPSV, PORT
+// or EPRT. Passive connections are only accepted from the client's address.
+func (c *conn) openData() (net.Conn, error) {
+	c.mu.Lock()
+	passive, active := c.passive, c.active
+	c.active = ""
+	c.mu.Unlock()
+
+	// The passive listener stays where abort can close it until the client connected
+	defer func() {
+		c.mu.Lock()
+		c.closePassive()
+		c.mu.Unlock()
+	}()
+
+	var dataConn net.Conn
+	var err error
+	switch {
+	case passive != nil:
+		if l, ok := passive.(*net.TCPListener); ok {
+			l.SetDeadline(time.Now().Add(dataTimeout))
+		}
+		for dataConn == nil {
+			accepted, acceptErr := passive.Accept()
+			if acceptErr != nil {
+				return nil, acceptErr
+			}
+			if addrPort, err := netip.ParseAddrPort(accepted.RemoteAddr().String()); err != nil || addrPort.Addr().Unmap() != c.addr {
+				accepted.Close()
+				continue
+			}
+			dataConn = accepted
+		}
+	case active != "":
+		if dataConn, err = net.DialTimeout("tcp", active, dataTimeout); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("no data connection set up, use PASV or PORT first")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		dataConn.Close()
+		return nil, net.ErrClosed
+	}
+	c.data = dataConn
+	return &idleConn{dataConn}, nil
+}
+
+// closeData closes the data connection of a finished transfer
+func (c *conn) closeData() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.data != nil {
+		c.data.Close()
+		c.data = nil
+	}
+}
+
+// idleConn is a data connection that fails once it makes no progress for dataTimeout
+type idleConn struct {
+	net.Conn
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	c.Conn.SetReadDeadline(time.Now().Add(dataTimeout))
+	return c.Conn.Read(p)
+}
+
+func (c *idleConn) Write(p []byte) (int, error) {
+	c.Conn.SetWriteDeadline(time.Now().Add(dataTimeout))
+	return c.Conn.Write(p)
+}
@@ -0,0 +1,515 @@
+package ftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/m1kkY8/ctfserver/pkg/config"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+	"github.com/m1kkY8/ctfserver/pkg/util"
+)
+
+// resolve returns the absolute path of name relative to the current
+// directory. Backslashes are separators, as typed on Windows targets.
+func (c *conn) resolve(name string) string {
+	name = strings.ReplaceAll(name, `\`, "/")
+	if !strings.HasPrefix(name, "/") {
+		name = c.cwd + "/" + name
+	}
+	return path.Clean(name)
+}
+
+// uploadName returns the upload file name of an absolute path, ok is false
+// for paths outside the uploads directory
+func uploadName(name string) (upload string, ok bool) {
+	if name == "/"+UploadsDir {
+		return "", true
+	}
+	return strings.CutPrefix(name, "/"+UploadsDir+"/")
+}
+
+// canRead reports whether the client may read or list a path: the root
+// needs the download role, the uploads directory the loot role
+func (c *conn) canRead(name string) bool {
+	if _, ok := uploadName(name); ok {
+		return c.allowed(config.RouteLoot, config.RoleLoot)
+	}
+	return c.allowed(config.RouteFiles, config.RoleDownload)
+}
+
+// canWrite reports whether the client may store, rename and delete uploads
+func (c *conn) canWrite() bool {
+	return c.allowed(config.RouteUpload, config.RoleUpload)
+}
+
+// stat returns the info of an absolute path
+func (c *conn) stat(name string) (iofs.FileInfo, error) {
+	upload, inUploads := uploadName(name)
+	switch {
+	case !inUploads:
+		return iofs.Stat(c.server.fileService.RootFS(), util.CleanPath(name))
+	case upload == "":
+		return c.uploadsDirInfo()
+	}
+
+	filePath, err := c.server.fileService.UploadPath(upload)
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	return os.Stat(filePath)
+}
+
+// uploadsDirInfo returns the info of the upload directory, named UploadsDir
+func (c *conn) uploadsDirInfo() (iofs.FileInfo, error) {
+	uploadDir := c.server.fileService.UploadDir()
+	if err := util.EnsureDir(uploadDir); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(uploadDir)
+	if err != nil {
+		return nil, err
+	}
+	return namedInfo{info, UploadsDir}, nil
+}
+
+// readDir lists a directory. The uploads directory hides the files
+// reserved by the file service, the top directory lists it in place of any
+// root entry of that name.
+func (c *conn) readDir(name string) ([]iofs.FileInfo, error) {
+	var infos []iofs.FileInfo
+	if _, inUploads := uploadName(name); inUploads {
+		entries, err := os.ReadDir(c.server.fileService.UploadDir())
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !service.IsValidUploadName(entry.Name()) {
+				continue
+			}
+			if info, err := entry.Info(); err == nil {
+				infos = append(infos, info)
+			}
+		}
+		return infos, nil
+	}
+
+	entries, err := iofs.ReadDir(c.server.fileService.RootFS(), util.CleanPath(name))
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if name == "/" && entry.Name() == UploadsDir {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			infos = append(infos, info)
+		}
+	}
+	if name == "/" {
+		if info, err := c.uploadsDirInfo(); err == nil {
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+func (c *conn) handlePwd(arg string) {
+	c.reply(257, "%s is the current directory", quotePath(c.cwd))
+}
+
+func (c *conn) handleCwd(arg string) {
+	name := c.resolve(arg)
+	info, err := c.stat(name)
+	if err != nil || !info.IsDir() {
+		c.reply(550, "No such directory")
+		return
+	}
+	c.cwd = name
+	c.reply(250, "Directory changed to %s", name)
+}
+
+func (c *conn) handleCdup(arg string) {
+	c.handleCwd("..")
+}
+
+// handleMkd refuses directory changes: the root is read-only and the upload
+// directory is flat
+func (c *conn) handleMkd(arg string) {
+	c.reply(550, "Directories can't be created or removed")
+}
+
+func (c *conn) handleList(arg string) {
+	c.list(arg, listLine)
+}
+
+func (c *conn) handleNlst(arg string) {
+	c.list(arg, func(info iofs.FileInfo, now time.Time) string {
+		return info.Name()
+	})
+}
+
+func (c *conn) handleMlsd(arg string) {
+	c.list(arg, func(info iofs.FileInfo, now time.Time) string {
+		return facts(info) + " " + info.Name()
+	})
+}
+
+// handleMlst describes a single file or directory on the control connection
+func (c *conn) handleMlst(arg string) {
+	name := c.cwd
+	if arg != "" {
+		name = c.resolve(arg)
+	}
+	if !c.canRead(name) {
+		c.reply(550, "Permission denied")
+		return
+	}
+	info, err := c.stat(name)
+	if err != nil {
+		c.reply(550, "No such file or directory")
+		return
+	}
+	c.replyLines(250, "Listing "+name, []string{facts(info) + " " + name}, "End")
+}
+
+// list sends the entries of a directory, or a file itself, over a data
+// connection, formatted by format. Options of ls some clients add to LIST,
+// like "-la", are ignored.
+func (c *conn) list(arg string, format func(info iofs.FileInfo, now time.Time) string) {
+	for strings.HasPrefix(arg, "-") {
+		_, arg, _ = strings.Cut(arg, " ")
+	}
+	name := c.cwd
+	if arg != "" {
+		name = c.resolve(arg)
+	}
+	if !c.canRead(name) {
+		c.reply(550, "Permission denied")
+		return
+	}
+	info, err := c.stat(name)
+	if err != nil {
+		c.reply(550, "No such file or directory")
+		return
+	}
+	infos := []iofs.FileInfo{info}
+	if info.IsDir() {
+		if infos, err = c.readDir(name); err != nil {
+			c.reply(550, "Failed to read directory")
+			return
+		}
+	}
+
+	var b strings.Builder
+	now := time.Now()
+	for _, info := range infos {
+		b.WriteString(format(info, now))
+		b.WriteString("\r\n")
+	}
+
+	data, ok := c.startTransfer("Here comes the directory listing")
+	if !ok {
+		return
+	}
+	_, err = io.WriteString(data, b.String())
+	c.finishTransfer(err)
+}
+
+// listLine formats info like ls -l
+func listLine(info iofs.FileInfo, now time.Time) string {
+	mode := "-rw-r--r--"
+	if info.IsDir() {
+		mode = "drwxr-xr-x"
+	}
+	modified := info.ModTime()
+	stamp := modified.Format("Jan _2 15:04")
+	if modified.Before(now.AddDate(0, -6, 0)) || modified.After(now.Add(time.Hour)) {
+		stamp = modified.Format("Jan _2  2006")
+	}
+	return fmt.Sprintf("%s 1 ftp ftp %12d %s %s", mode, max(info.Size(), 0), stamp, info.Name())
+}
+
+// facts formats the machine-readable facts of info for MLSD and MLST
+func facts(info iofs.FileInfo) string {
+	modify := info.ModTime().UTC().Format("20060102150405")
+	if info.IsDir() {
+		return fmt.Sprintf("type=dir;modify=%s;perm=el;", modify)
+	}
+	return fmt.Sprintf("type=file;size=%d;modify=%s;perm=r;", max(info.Size(), 0), modify)
+}
+
+func (c *conn) handleSize(arg string) {
+	info, ok := c.statFile(arg)
+	if !ok {
+		return
+	}
+	c.reply(213, "%d", max(info.Size(), 0))
+}
+
+func (c *conn) handleMdtm(arg string) {
+	info, ok := c.statFile(arg)
+	if !ok {
+		return
+	}
+	c.reply(213, "%s", info.ModTime().UTC().Format("20060102150405"))
+}
+
+// statFile returns the info of a readable regular file, replying with an
+// error if there is none
+func (c *conn) statFile(arg string) (iofs.FileInfo, bool) {
+	name := c.resolve(arg)
+	if !c.canRead(name) {
+		c.reply(550, "Permission denied")
+		return nil, false
+	}
+	info, err := c.stat(name)
+	if err != nil || info.IsDir() {
+		c.reply(550, "No such file")
+		return nil, false
+	}
+	return info, true
+}
+
+// handleRetr sends a file of the root or the uploads directory, from the
+// offset set by REST
+func (c *conn) handleRetr(arg string) {
+	name := c.resolve(arg)
+	if !c.canRead(name) {
+		c.reply(550, "Permission denied")
+		return
+	}
+	file, err := c.open(name)
+	if err != nil {
+		c.reply(550, "No such file")
+		return
+	}
+	defer file.Close()
+	if info, err := file.Stat(); err != nil || info.IsDir() {
+		c.reply(550, "Not a regular file")
+		return
+	}
+
+	var content io.Reader = file
+	if c.restart > 0 {
+		if seeker, ok := file.(io.Seeker); ok {
+			_, err = seeker.Seek(c.restart, io.SeekStart)
+		} else {
+			_, err = io.CopyN(io.Discard, file, c.restart)
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			c.reply(554, "Invalid restart offset")
+			return
+		}
+	}
+
+	data, ok := c.startTransfer("Opening data connection for " + path.Base(name))
+	if !ok {
+		return
+	}
+	served, err := io.Copy(data, content)
+	c.finishTransfer(err)
+
+	fields := map[string]interface{}{
+		"filename": strings.TrimPrefix(name, "/"),
+		"bytes":    served,
+		"source":   c.source(),
+		"user":     c.user,
+	}
+	if c.restart > 0 {
+		fields["offset"] = c.restart
+	}
+	logger.Logger.WithFields(fields).Info("Served file over FTP")
+}
+
+// open opens a file of the root or the uploads directory for reading
+func (c *conn) open(name string) (iofs.File, error) {
+	upload, inUploads := uploadName(name)
+	if !inUploads {
+		return c.server.fileService.RootFS().Open(util.CleanPath(name))
+	}
+	filePath, err := c.server.fileService.UploadPath(upload)
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	return os.Open(filePath)
+}
+
+// handleStor writes an upload through the file service. The file is named
+// after the last element of the path, whatever the directory.
+func (c *conn) handleStor(arg string) {
+	if !c.canWrite() {
+		c.reply(550, "Permission denied")
+		return
+	}
+	if c.restart > 0 {
+		c.reply(554, "Resuming uploads is not supported")
+		return
+	}
+	filename := path.Base(c.resolve(arg))
+	if !service.IsValidUploadName(filename) {
+		c.reply(553, "Invalid filename")
+		return
+	}
+
+	data, ok := c.startTransfer("Ok to send data")
+	if !ok {
+		return
+	}
+	result, err := c.server.fileService.SaveUpload(filename, data, -1, c.source(), 0)
+	c.closeData()
+
+	fields := map[string]interface{}{
+		"filename": filename,
+		"source":   c.source(),
+		"user":     c.user,
+	}
+	switch {
+	case errors.Is(err, service.ErrInsufficientStorage):
+		logger.Logger.WithError(err).WithFields(fields).Warn("Rejected upload over storage limits")
+		c.reply(452, "%v", err)
+	case err != nil:
+		logger.Logger.WithError(err).WithFields(fields).Error("Failed to save upload")
+		c.reply(451, "Failed to save file")
+	case !result.Success:
+		logger.Logger.WithFields(fields).WithField("error", result.Error).Warn("Rejected FTP upload")
+		c.reply(552, "%s", result.Error)
+	default:
+		logger.Logger.WithFields(map[string]interface{}{
+			"filename": result.Filename,
+			"size":     result.Size,
+			"path":     result.Path,
+			"source":   c.source(),
+			"user":     c.user,
+			"via":      "ftp",
+		}).Info("File uploaded successfully")
+		c.reply(226, "Transfer complete")
+	}
+}
+
+func (c *conn) handleDele(arg string) {
+	upload, ok := c.writableUpload(arg)
+	if !ok {
+		return
+	}
+	if err := c.server.fileService.DeleteUpload(upload); err != nil {
+		c.reply(550, "No such file")
+		return
+	}
+
+	logger.Logger.WithFields(map[string]interface{}{
+		"filename": upload,
+		"source":   c.source(),
+		"user":     c.user,
+	}).Info("Deleted upload over FTP")
+	c.reply(250, "File deleted")
+}
+
+func (c *conn) handleRnfr(arg string) {
+	upload, ok := c.writableUpload(arg)
+	if !ok {
+		return
+	}
+	if _, err := c.stat("/" + UploadsDir + "/" + upload); err != nil {
+		c.reply(550, "No such file")
+		return
+	}
+	c.renameFrom = upload
+	c.reply(350, "Ready for RNTO")
+}
+
+func (c *conn) handleRnto(arg string) {
+	from := c.renameFrom
+	c.renameFrom = ""
+	if from == "" {
+		c.reply(503, "Use RNFR first")
+		return
+	}
+	upload, ok := c.writableUpload(arg)
+	if !ok {
+		return
+	}
+	if err := c.server.fileService.RenameUpload(from, upload); err != nil {
+		if errors.Is(err, service.ErrInvalidFilename) {
+			c.reply(553, "Invalid filename")
+			return
+		}
+		c.reply(550, "Rename failed")
+		return
+	}
+
+	logger.Logger.WithFields(map[string]interface{}{
+		"filename": upload,
+		"from":     from,
+		"source":   c.source(),
+		"user":     c.user,
+	}).Info("Renamed upload over FTP")
+	c.reply(250, "File renamed")
+}
+
+// writableUpload returns the upload a path names if the client may change
+// it, replying with an error otherwise
+func (c *conn) writableUpload(arg string) (string, bool) {
+	upload, inUploads := uploadName(c.resolve(arg))
+	if !inUploads || upload == "" || strings.Contains(upload, "/") || !c.canWrite() {
+		c.reply(550, "Permission denied")
+		return "", false
+	}
+	return upload, true
+}
+
+// startTransfer opens the data connection, replying with what is about to
+// be sent or an error
+func (c *conn) startTransfer(message string) (net.Conn, bool) {
+	c.mu.Lock()
+	ready := c.passive != nil || c.active != ""
+	c.mu.Unlock()
+	if !ready {
+		c.reply(425, "Use PASV or PORT first")
+		return nil, false
+	}
+
+	c.reply(150, "%s", message)
+	data, err := c.openData()
+	if err != nil {
+		logger.Logger.WithError(err).WithField("remote_addr", c.netConn.RemoteAddr().String()).Debug("FTP data connection failed")
+		c.reply(425, "Can't open data connection")
+		return nil, false
+	}
+	return data, true
+}
+
+// finishTransfer closes the data connection and replies with the outcome
+func (c *conn) finishTransfer(err error) {
+	c.closeData()
+	if err != nil {
+		logger.Logger.WithError(err).WithField("remote_addr", c.netConn.RemoteAddr().String()).Debug("FTP transfer failed")
+		c.reply(426, "Transfer aborted")
+		return
+	}
+	c.reply(226, "Transfer complete")
+}
+
+// quotePath quotes a path for a 257 reply, doubling quotes within it
+func quotePath(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// namedInfo is a directory's info under another name
+type namedInfo struct {
+	iofs.FileInfo
+	name string
+}
+
+func (i namedInfo) Name() string {
+	return i.name
+}
@@ -0,0 +1,138 @@
+// Package ftp serves the root directory and the upload directory over FTP,
+// for legacy targets that only have ftp.exe or busybox ftpget and ftpput.
+//
+// The root is served read-only at the top of the server, with the upload
+// directory below UploadsDir as over WebDAV. Every STOR writes into the
+// upload directory, whatever the current directory, so a bare "put FILE"
+// works. Logons are checked against the configured users and tokens, with
+// "anonymous" getting the anonymous roles; without any configured, every
+// logon is accepted.
+package ftp
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/m1kkY8/ctfserver/pkg/auth"
+	"github.com/m1kkY8/ctfserver/pkg/ipfilter"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+)
+
+// UploadsDir is the directory at the top of the server backed by the upload
+// directory. It hides a root entry of the same name.
+const UploadsDir = "uploads"
+
+// ErrServerClosed is returned by Serve after Close
+var ErrServerClosed = errors.New("ftp: server closed")
+
+// Server is an FTP server
+type Server struct {
+	fileService *service.FileService
+	auth        *auth.Authenticator
+	ipFilter    *ipfilter.Filter
+
+	// Range of ports for passive data connections, any port when zero
+	minPassivePort int
+	maxPassivePort int
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[*conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// New creates an FTP server for fileService, checking logons with
+// authenticator and client addresses with filter. Passive data connections
+// listen on a port between minPassivePort and maxPassivePort, or on any port
+// if they are zero.
+func New(fileService *service.FileService, authenticator *auth.Authenticator, filter *ipfilter.Filter, minPassivePort, maxPassivePort int) *Server {
+	return &Server{
+		fileService:    fileService,
+		auth:           authenticator,
+		ipFilter:       filter,
+		minPassivePort: minPassivePort,
+		maxPassivePort: maxPassivePort,
+		conns:          make(map[*conn]struct{}),
+	}
+}
+
+// ListenAndServe listens on the TCP address addr and serves connections
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve serves connections accepted from l until Close
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listener = l
+	s.mu.Unlock()
+
+	for {
+		netConn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		c := newConn(s, netConn)
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			netConn.Close()
+			return ErrServerClosed
+		}
+		s.conns[c] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+			c.serve()
+			s.mu.Lock()
+			delete(s.conns, c)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// Close stops accepting connections and closes the open ones, waiting for
+// their transfers to end
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for c := range s.conns {
+		c.abort()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	logger.Logger.Debug("FTP server closed")
+	return err
+}
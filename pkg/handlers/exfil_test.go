@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/m1kkY8/ctfserver/pkg/config"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+)
+
+func TestExfilHandlerKeepsBursts(t *testing.T) {
+	logger.InitLogger("error")
+	uploadDir := t.TempDir()
+	fileService := service.NewFileService(fstest.MapFS{}, "root", uploadDir, 1<<20)
+	handler := NewExfilHandler(fileService, "/x", config.ExfilGIF)
+
+	// Callbacks of one source within a millisecond get the same name
+	const requests = 30
+	var wg sync.WaitGroup
+	for i := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/x/cookies?d=value-%d", i), nil)
+			r.RemoteAddr = "10.10.11.5:40000"
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != http.StatusOK {
+				t.Errorf("status %d", w.Code)
+			}
+		}()
+	}
+	wg.Wait()
+
+	entries, err := os.ReadDir(uploadDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if !strings.HasPrefix(entry.Name(), "cookies-10.10.11.5-") || !strings.Contains(entry.Name(), ".txt") {
+			t.Errorf("unexpected name %s", entry.Name())
+		}
+		data, err := os.ReadFile(filepath.Join(uploadDir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[string(data)] = true
+	}
+	if len(seen) != requests {
+		t.Errorf("kept %d of %d callbacks", len(seen), requests)
+	}
+}
+
+func TestExfilName(t *testing.T) {
+	received := time.Date(2024, 1, 2, 15, 4, 5, 123e6, time.FixedZone("CET", 3600))
+	tests := []struct {
+		label, source, extension string
+		want                     string
+	}{
+		{"cookies", "10.10.11.5", ".txt", "cookies-10.10.11.5-20240102T140405.123Z.txt"},
+		{"x", "::1", ".bin", "x-__1-20240102T140405.123Z.bin"},
+		{"x", "dead:beef::1", ".txt", "x-dead_beef__1-20240102T140405.123Z.txt"},
+		{"x", "unknown", ".txt", "x-unknown-20240102T140405.123Z.txt"},
+	}
+	for _, tt := range tests {
+		got := exfilName(tt.label, tt.source, received, tt.extension)
+		if got != tt.want {
+			t.Errorf("exfilName(%q, %q) = %q, want %q", tt.label, tt.source, got, tt.want)
+		}
+		if !service.IsValidUploadName(got) {
+			t.Errorf("exfilName(%q, %q) = %q is not a valid upload name", tt.label, tt.source, got)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"embed"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/models"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+	"github.com/m1kkY8/ctfserver/pkg/util"
+)
+
+//go:embed templates/index.html
+var templateFS embed.FS
+
+// indexTemplate renders directory listings below /files/
+var indexTemplate = template.Must(template.ParseFS(templateFS, "templates/index.html"))
+
+// indexPage is the data passed to the directory index template
+type indexPage struct {
+	Path        string
+	Parent      string
+	Breadcrumbs []indexLink
+	Entries     []indexEntry
+}
+
+// indexLink is a named link in the breadcrumb trail
+type indexLink struct {
+	Name string
+	Href string
+}
+
+// indexEntry is a single row of the directory index
+type indexEntry struct {
+	Name     string
+	Href     string
+	IsDir    bool
+	Size     string
+	ModTime  string
+	Type     string
+	Commands []indexCommand
+}
+
+// indexCommand is a copyable download one-liner
+type indexCommand struct {
+	Label   string
+	Command string
+}
+
+// FilesHandler serves static files and directory indexes from the root directory
+type FilesHandler struct {
+	fileService *service.FileService
+	fileServer  http.Handler
+	hashHeader  bool
+}
+
+// NewFilesHandler creates a new static file handler
+func NewFilesHandler(fileService *service.FileService, hashHeader bool) *FilesHandler {
+	return &FilesHandler{
+		fileService: fileService,
+		fileServer:  http.FileServer(http.FS(fileService.RootFS())),
+		hashHeader:  hashHeader,
+	}
+}
+
+// ServeHTTP handles the file download request
+func (h *FilesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	isRead := r.Method == http.MethodGet || r.Method == http.MethodHead
+
+	// Render our own index for directories without an index.html
+	if isRead && (r.URL.Path == "" || strings.HasSuffix(r.URL.Path, "/")) {
+		listing, err := h.fileService.ListDirectory(r.URL.Path)
+		if err == nil && !hasIndexFile(listing) {
+			h.renderIndex(w, r, listing)
+			return
+		}
+	}
+
+	// Advertise the content hash so clients can verify the transfer
+	if h.hashHeader && isRead && !strings.HasSuffix(r.URL.Path, "/") {
+		if sum, ok := h.fileService.FileSHA256(r.URL.Path); ok {
+			w.Header().Set("X-Content-SHA256", sum)
+		}
+	}
+
+	h.fileServer.ServeHTTP(w, r)
+}
+
+func (h *FilesHandler) renderIndex(w http.ResponseWriter, r *http.Request, listing *models.DirectoryListing) {
+	baseURL := requestBaseURL(r)
+	page := indexPage{
+		Path: "/files" + strings.TrimSuffix(listing.Path, "/") + "/",
+	}
+
+	// Breadcrumbs for every path segment below /files/
+	href := "/files/"
+	for _, segment := range strings.Split(strings.Trim(listing.Path, "/"), "/") {
+		if segment == "" {
+			continue
+		}
+		href += url.PathEscape(segment) + "/"
+		page.Breadcrumbs = append(page.Breadcrumbs, indexLink{Name: segment, Href: href})
+	}
+	if listing.Path != "/" {
+		page.Parent = "/files/"
+		if parent := path.Dir(listing.Path); parent != "/" {
+			page.Parent = escapeFilesPath(parent) + "/"
+		}
+	}
+
+	for _, entry := range listing.Entries {
+		item := indexEntry{
+			Name:    entry.Name,
+			Href:    escapeFilesPath(entry.Path),
+			IsDir:   entry.IsDir,
+			Size:    "-",
+			ModTime: entry.ModTime.Format("2006-01-02 15:04:05"),
+			Type:    entry.MimeType,
+		}
+		if entry.IsDir {
+			item.Href += "/"
+			item.Type = "directory"
+		} else {
+			item.Size = util.FormatFileSize(entry.Size)
+			item.Commands = downloadCommands(baseURL+item.Href, entry.Name)
+		}
+		page.Entries = append(page.Entries, item)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	if err := indexTemplate.Execute(w, page); err != nil {
+		logger.Logger.WithError(err).Error("Failed to render directory index")
+	}
+}
+
+// downloadCommands returns curl, wget and PowerShell one-liners for a file
+func downloadCommands(fileURL, name string) []indexCommand {
+	return []indexCommand{
+		{Label: "curl", Command: "curl -o " + shellQuote(name) + " " + shellQuote(fileURL)},
+		{Label: "wget", Command: "wget -O " + shellQuote(name) + " " + shellQuote(fileURL)},
+		{Label: "powershell", Command: "iwr -UseBasicParsing -Uri " + psQuote(fileURL) + " -OutFile " + psQuote(name)},
+	}
+}
+
+// hasIndexFile reports whether the directory should be served by its own index.html
+func hasIndexFile(listing *models.DirectoryListing) bool {
+	for _, entry := range listing.Entries {
+		if !entry.IsDir && entry.Name == "index.html" {
+			return true
+		}
+	}
+	return false
+}
+
+// escapeFilesPath returns the escaped /files/ URL path for a path below the root directory
+func escapeFilesPath(p string) string {
+	return (&url.URL{Path: "/files" + p}).EscapedPath()
+}
+
+// requestBaseURL reconstructs the scheme and host the client used to reach us
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// shellQuote quotes s for POSIX shells
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// psQuote quotes s as a PowerShell literal string
+func psQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"html"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+)
+
+func TestFilesIndex(t *testing.T) {
+	logger.InitLogger("error")
+	root := fstest.MapFS{
+		"tools/win/nc.exe":            {Data: []byte("MZ")},
+		"tools/it's <b>.sh":           {Data: []byte("#!/bin/sh\n")},
+		"tools/with space/linpeas.sh": {Data: []byte("#!/bin/sh\n")},
+		"site/index.html":             {Data: []byte("<p>own index</p>")},
+	}
+	fileService := service.NewFileService(root, "root", t.TempDir(), 1<<20)
+	handler := http.StripPrefix("/files/", NewFilesHandler(fileService, false))
+
+	get := func(method, target string) (*httptest.ResponseRecorder, string) {
+		r := httptest.NewRequest(method, target, nil)
+		r.Host = "10.10.14.7:8080"
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		body, _ := io.ReadAll(w.Body)
+		return w, string(body)
+	}
+
+	tests := []struct {
+		name    string
+		target  string
+		want    []string
+		notWant []string
+	}{
+		{
+			name:   "root",
+			target: "/files/",
+			want:   []string{"<title>Index of /files/</title>", `<a href="/files/tools/">tools/</a>`, `<a href="/files/site/">site/</a>`},
+			// The root has no parent
+			notWant: []string{">../<"},
+		},
+		{
+			name:   "subdirectory",
+			target: "/files/tools/",
+			want: []string{
+				`<a href="/files/">files</a> / <a href="/files/tools/">tools</a>`,
+				`<a href="/files/">../</a>`,
+				`<a href="/files/tools/with%20space/">with space/</a>`,
+				`<a href="/files/tools/win/">win/</a>`,
+			},
+		},
+		{
+			name:   "commands are quoted and escaped",
+			target: "/files/tools/",
+			want: []string{
+				html.EscapeString(`curl -o 'it'\''s <b>.sh' 'http://10.10.14.7:8080/files/tools/it%27s%20%3Cb%3E.sh'`),
+				html.EscapeString(`iwr -UseBasicParsing -Uri 'http://10.10.14.7:8080/files/tools/it%27s%20%3Cb%3E.sh' -OutFile 'it''s <b>.sh'`),
+			},
+			notWant: []string{"<b>.sh"},
+		},
+		{
+			name:   "nested parent and sizes",
+			target: "/files/tools/win/",
+			want:   []string{`<a href="/files/tools/">../</a>`, `<td class="size">2 B</td>`, "wget -O &#39;nc.exe&#39;"},
+		},
+		{
+			name:   "own index.html wins",
+			target: "/files/site/",
+			want:   []string{"<p>own index</p>"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, body := get(http.MethodGet, tt.target)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d", w.Code)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(body, want) {
+					t.Errorf("page lacks %s\n%s", want, body)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(body, notWant) {
+					t.Errorf("page contains %s", notWant)
+				}
+			}
+		})
+	}
+
+	w, body := get(http.MethodHead, "/files/tools/")
+	if w.Code != http.StatusOK || body != "" || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("HEAD answered %d, %q, %q", w.Code, w.Header().Get("Content-Type"), body)
+	}
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/models"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+	"github.com/m1kkY8/ctfserver/pkg/util"
+)
+
+// HashesHandler handles requests for file hash manifests
+type HashesHandler struct {
+	fileService *service.FileService
+}
+
+// NewHashesHandler creates a new file hashes handler
+func NewHashesHandler(fileService *service.FileService) *HashesHandler {
+	return &HashesHandler{
+		fileService: fileService,
+	}
+}
+
+// ServeHTTP handles the file hashes request
+func (h *HashesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Check if client wants JSON response (default is sha256sum -c compatible text)
+	acceptHeader := r.Header.Get("Accept")
+	wantsJSON := acceptHeader == "application/json" || r.URL.Query().Get("format") == "json"
+
+	algos, err := util.ParseHashAlgorithms(r.URL.Query().Get("algo"))
+	if err != nil {
+		h.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	manifest, result, err := h.fileService.GetPrettyFileHashes(r.URL.Query().Get("path"), algos)
+	if err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			h.writeErrorResponse(w, "Path not found", http.StatusNotFound)
+			return
+		}
+		logger.Logger.WithError(err).Error("Failed to hash files")
+		h.writeErrorResponse(w, "Failed to hash files", http.StatusInternalServerError)
+		return
+	}
+
+	// Return JSON if specifically requested
+	if wantsJSON {
+		h.writeJSONResponse(w, result, http.StatusOK)
+		return
+	}
+
+	// Return plain text by default
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(manifest))
+}
+
+func (h *HashesHandler) writeJSONResponse(w http.ResponseWriter, data interface{}, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		logger.Logger.WithError(err).Error("Failed to encode JSON response")
+	}
+}
+
+func (h *HashesHandler) writeErrorResponse(w http.ResponseWriter, message string, statusCode int) {
+	response := &models.ErrorResponse{
+		Success: false,
+		Error:   message,
+	}
+	h.writeJSONResponse(w, response, statusCode)
+}
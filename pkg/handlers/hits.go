@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/m1kkY8/ctfserver/pkg/capture"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/models"
+)
+
+// HitsHandler handles requests for the captured requests
+type HitsHandler struct {
+	recorder *capture.Recorder
+}
+
+// NewHitsHandler creates a new captured requests handler
+func NewHitsHandler(recorder *capture.Recorder) *HitsHandler {
+	return &HitsHandler{
+		recorder: recorder,
+	}
+}
+
+// ServeHTTP handles the captured requests request
+func (h *HitsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Check if client wants JSON response (default is plain text)
+	acceptHeader := r.Header.Get("Accept")
+	wantsJSON := acceptHeader == "application/json" || r.URL.Query().Get("format") == "json"
+
+	// Pollers pass the last id they saw as since
+	var since int64
+	var limit int
+	var err error
+	if value := r.URL.Query().Get("since"); value != "" {
+		if since, err = strconv.ParseInt(value, 10, 64); err != nil {
+			h.writeErrorResponse(w, "Invalid since", http.StatusBadRequest)
+			return
+		}
+	}
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 0 {
+			h.writeErrorResponse(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	hits := h.recorder.Hits(since, limit)
+
+	// Return JSON if specifically requested
+	if wantsJSON {
+		h.writeJSONResponse(w, &models.HitsResponse{
+			Success: true,
+			Hits:    hits,
+			Count:   len(hits),
+		}, http.StatusOK)
+		return
+	}
+
+	// Return plain text by default
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(prettyHits(hits)))
+}
+
+// prettyHits formats hits one per line, with the body on the following line
+func prettyHits(hits []models.Hit) string {
+	if len(hits) == 0 {
+		return "No captured requests\n"
+	}
+	var b strings.Builder
+	for _, hit := range hits {
+		target := hit.Host + hit.Path
+		if hit.RawQuery != "" {
+			target += "?" + hit.RawQuery
+		}
+		scheme := "http"
+		if hit.TLS != nil {
+			scheme = "https"
+		}
+		fmt.Fprintf(&b, "#%d  %s  %-15s  %s %s://%s", hit.ID, hit.Time.Local().Format(time.DateTime), hit.Source, hit.Method, scheme, target)
+		if agent := strings.Join(hit.Headers["User-Agent"], ", "); agent != "" {
+			fmt.Fprintf(&b, "  %q", agent)
+		}
+		b.WriteString("\n")
+		if hit.BodySize > 0 {
+			body := hit.Body
+			if hit.BodyEncoding != "" {
+				body = hit.BodyEncoding + ":" + body
+			}
+			if len(body) > 200 {
+				body = body[:200] + "..."
+			}
+			size := fmt.Sprintf("%d bytes", hit.BodySize)
+			if hit.BodyTruncated {
+				size += ", truncated"
+			}
+			fmt.Fprintf(&b, "    body (%s): %q\n", size, body)
+		}
+	}
+	return b.String()
+}
+
+func (h *HitsHandler) writeJSONResponse(w http.ResponseWriter, data interface{}, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		logger.Logger.WithError(err).Error("Failed to encode JSON response")
+	}
+}
+
+func (h *HitsHandler) writeErrorResponse(w http.ResponseWriter, message string, statusCode int) {
+	response := &models.ErrorResponse{
+		Success: false,
+		Error:   message,
+	}
+	h.writeJSONResponse(w, response, statusCode)
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/models"
+)
+
+// InfoHandler handles requests for server and listener information
+type InfoHandler struct {
+	version   string
+	listeners func() []models.ListenerInfo
+}
+
+// NewInfoHandler creates a new server info handler
+func NewInfoHandler(version string, listeners func() []models.ListenerInfo) *InfoHandler {
+	return &InfoHandler{
+		version:   version,
+		listeners: listeners,
+	}
+}
+
+// ServeHTTP handles the server info request
+func (h *InfoHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	hostname, _ := os.Hostname()
+	response := &models.InfoResponse{
+		Success:   true,
+		Version:   h.version,
+		Hostname:  hostname,
+		LHOST:     localHost(r),
+		Listeners: h.listeners(),
+	}
+
+	h.writeJSONResponse(w, response, http.StatusOK)
+}
+
+// localHost returns the local address the request arrived on, the LHOST the client can reach
+func localHost(r *http.Request) string {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if !ok {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+func (h *InfoHandler) writeJSONResponse(w http.ResponseWriter, data interface{}, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		logger.Logger.WithError(err).Error("Failed to encode JSON response")
+	}
+}
+
+func (h *InfoHandler) writeErrorResponse(w http.ResponseWriter, message string, statusCode int) {
+	response := &models.ErrorResponse{
+		Success: false,
+		Error:   message,
+	}
+	h.writeJSONResponse(w, response, statusCode)
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/m1kkY8/ctfserver/pkg/dnsexfil"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/models"
+)
+
+// OnelinerHandler handles requests for sender one-liners of the DNS
+// exfiltration zone
+type OnelinerHandler struct {
+	zone string // Empty when the DNS server is disabled
+}
+
+// NewOnelinerHandler creates a new one-liner handler for zone
+func NewOnelinerHandler(zone string) *OnelinerHandler {
+	return &OnelinerHandler{
+		zone: zone,
+	}
+}
+
+// ServeHTTP handles the DNS sender one-liner request
+func (h *OnelinerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.zone == "" {
+		h.writeErrorResponse(w, "DNS exfiltration is not enabled", http.StatusNotFound)
+		return
+	}
+
+	// Check if client wants JSON response (default is the bare script)
+	acceptHeader := r.Header.Get("Accept")
+	wantsJSON := acceptHeader == "application/json" || r.URL.Query().Get("format") == "json"
+
+	query := r.URL.Query()
+	client := query.Get("client")
+	if client == "" {
+		client = dnsexfil.ClientNslookup
+	}
+	script, err := dnsexfil.Sender(client, h.zone, query.Get("file"), query.Get("server"))
+	if err != nil {
+		h.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Return JSON if specifically requested
+	if wantsJSON {
+		h.writeJSONResponse(w, &models.OnelinerResponse{
+			Success: true,
+			Client:  client,
+			Zone:    h.zone,
+			Script:  script,
+		}, http.StatusOK)
+		return
+	}
+
+	// Return plain text by default
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(script + "\n"))
+}
+
+func (h *OnelinerHandler) writeJSONResponse(w http.ResponseWriter, data interface{}, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		logger.Logger.WithError(err).Error("Failed to encode JSON response")
+	}
+}
+
+func (h *OnelinerHandler) writeErrorResponse(w http.ResponseWriter, message string, statusCode int) {
+	response := &models.ErrorResponse{
+		Success: false,
+		Error:   message,
+	}
+	h.writeJSONResponse(w, response, statusCode)
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/models"
+)
+
+// ReloadHandler handles configuration reload requests
+type ReloadHandler struct {
+	reload func() ([]string, error)
+}
+
+// NewReloadHandler creates a new reload handler; reload returns the changed
+// settings that need a restart to apply
+func NewReloadHandler(reload func() ([]string, error)) *ReloadHandler {
+	return &ReloadHandler{
+		reload: reload,
+	}
+}
+
+// ServeHTTP handles the reload request
+func (h *ReloadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Check if client wants JSON response
+	acceptHeader := r.Header.Get("Accept")
+	wantsJSON := acceptHeader == "application/json" || r.URL.Query().Get("format") == "json"
+
+	restart, err := h.reload()
+	if err != nil {
+		// The previous configuration stays active
+		h.writeErrorResponse(w, "Reload failed: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := &models.ReloadResponse{
+		Success:         true,
+		Message:         "Configuration reloaded",
+		RestartRequired: restart,
+	}
+
+	// Return JSON if specifically requested
+	if wantsJSON {
+		h.writeJSONResponse(w, response, http.StatusOK)
+		return
+	}
+
+	// Return plain text by default
+	text := response.Message + "\n"
+	if len(restart) > 0 {
+		text += fmt.Sprintf("Restart required to apply: %s\n", strings.Join(restart, ", "))
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(text))
+}
+
+func (h *ReloadHandler) writeJSONResponse(w http.ResponseWriter, data interface{}, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		logger.Logger.WithError(err).Error("Failed to encode JSON response")
+	}
+}
+
+func (h *ReloadHandler) writeErrorResponse(w http.ResponseWriter, message string, statusCode int) {
+	response := &models.ErrorResponse{
+		Success: false,
+		Error:   message,
+	}
+	h.writeJSONResponse(w, response, statusCode)
+}
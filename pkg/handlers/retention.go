@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/models"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+)
+
+// RetentionPreviewHandler shows which uploads the retention policy would delete
+type RetentionPreviewHandler struct {
+	fileService *service.FileService
+}
+
+// NewRetentionPreviewHandler creates a new retention preview handler
+func NewRetentionPreviewHandler(fileService *service.FileService) *RetentionPreviewHandler {
+	return &RetentionPreviewHandler{
+		fileService: fileService,
+	}
+}
+
+// ServeHTTP handles the retention preview request, deleting nothing
+func (h *RetentionPreviewHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Check if client wants JSON response
+	acceptHeader := r.Header.Get("Accept")
+	wantsJSON := acceptHeader == "application/json" || r.URL.Query().Get("format") == "json"
+
+	prettyPreview, result, err := h.fileService.GetPrettyRetentionPreview()
+	if err != nil {
+		logger.Logger.WithError(err).Error("Failed to preview retention")
+		h.writeErrorResponse(w, "Failed to preview retention", http.StatusInternalServerError)
+		return
+	}
+
+	// Return JSON if specifically requested
+	if wantsJSON {
+		h.writeJSONResponse(w, result, http.StatusOK)
+		return
+	}
+
+	// Return plain text by default
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(prettyPreview))
+}
+
+func (h *RetentionPreviewHandler) writeJSONResponse(w http.ResponseWriter, data interface{}, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		logger.Logger.WithError(err).Error("Failed to encode JSON response")
+	}
+}
+
+func (h *RetentionPreviewHandler) writeErrorResponse(w http.ResponseWriter, message string, statusCode int) {
+	response := &models.ErrorResponse{
+		Success: false,
+		Error:   message,
+	}
+	h.writeJSONResponse(w, response, statusCode)
+}
@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/m1kkY8/ctfserver/pkg/canned"
+	"github.com/m1kkY8/ctfserver/pkg/config"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/models"
+)
+
+// maxRouteBody bounds the JSON body of route changes
+const maxRouteBody = 1 << 20
+
+// RoutesHandler handles requests listing and changing the canned responses
+type RoutesHandler struct {
+	table *canned.Table
+}
+
+// NewRoutesHandler creates a new canned responses handler
+func NewRoutesHandler(table *canned.Table) *RoutesHandler {
+	return &RoutesHandler{
+		table: table,
+	}
+}
+
+// ServeHTTP lists the routes or creates one at /routes, and changes or
+// deletes the route of the id variable at /routes/{id}
+func (h *RoutesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Check if client wants JSON response (default is plain text)
+	acceptHeader := r.Header.Get("Accept")
+	wantsJSON := acceptHeader == "application/json" || r.URL.Query().Get("format") == "json"
+
+	value, withID := mux.Vars(r)["id"]
+	var id int64
+	if withID {
+		var err error
+		if id, err = strconv.ParseInt(value, 10, 64); err != nil {
+			h.writeErrorResponse(w, "Invalid route id", http.StatusBadRequest)
+			return
+		}
+	}
+
+	switch {
+	case !withID && r.Method == http.MethodGet:
+		routes := h.table.Routes()
+		response := &models.CannedRoutesResponse{
+			Success: true,
+			Routes:  make([]models.CannedRoute, len(routes)),
+			Count:   len(routes),
+		}
+		for i, route := range routes {
+			response.Routes[i] = cannedRouteModel(route)
+		}
+		if wantsJSON {
+			h.writeJSONResponse(w, response, http.StatusOK)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(prettyRoutes(routes)))
+
+	case !withID && r.Method == http.MethodPost:
+		rule, ok := h.readRule(w, r)
+		if !ok {
+			return
+		}
+		route, err := h.table.Add(rule)
+		if err != nil {
+			h.writeErrorResponse(w, "Invalid route: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.logChange(r, route, "Canned response added")
+		h.writeRouteResponse(w, wantsJSON, "Route added", route, http.StatusCreated)
+
+	case withID && r.Method == http.MethodPut:
+		rule, ok := h.readRule(w, r)
+		if !ok {
+			return
+		}
+		route, err := h.table.Update(id, rule)
+		if errors.Is(err, canned.ErrNotFound) {
+			h.writeErrorResponse(w, "Route not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			h.writeErrorResponse(w, "Invalid route: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.logChange(r, route, "Canned response changed")
+		h.writeRouteResponse(w, wantsJSON, "Route changed", route, http.StatusOK)
+
+	case withID && r.Method == http.MethodDelete:
+		if err := h.table.Delete(id); err != nil {
+			h.writeErrorResponse(w, "Route not found", http.StatusNotFound)
+			return
+		}
+		h.logChange(r, canned.Route{ID: id}, "Canned response deleted")
+		h.writeRouteResponse(w, wantsJSON, "Route deleted", canned.Route{}, http.StatusOK)
+
+	default:
+		h.writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// readRule decodes the route in the body of r, answering with an error when
+// it doesn't parse
+func (h *RoutesHandler) readRule(w http.ResponseWriter, r *http.Request) (config.ResponseRule, bool) {
+	var input models.CannedRoute
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRouteBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&input); err != nil {
+		h.writeErrorResponse(w, "Invalid route: "+err.Error(), http.StatusBadRequest)
+		return config.ResponseRule{}, false
+	}
+
+	rule := config.ResponseRule{
+		Method:   strings.ToUpper(input.Method),
+		Path:     input.Path,
+		Status:   input.Status,
+		Redirect: input.Redirect,
+		Headers:  input.Headers,
+		Body:     input.Body,
+		File:     input.File,
+	}
+	if input.Delay != "" {
+		delay, err := time.ParseDuration(input.Delay)
+		if err != nil {
+			h.writeErrorResponse(w, "Invalid route: invalid delay "+strconv.Quote(input.Delay), http.StatusBadRequest)
+			return config.ResponseRule{}, false
+		}
+		rule.Delay = delay
+	}
+	return rule, true
+}
+
+// logChange logs a change of the table made through the API
+func (h *RoutesHandler) logChange(r *http.Request, route canned.Route, message string) {
+	fields := map[string]interface{}{
+		"id":     route.ID,
+		"source": sourceAddress(r),
+	}
+	if route.Path != "" {
+		fields["method"] = route.Method
+		fields["path"] = route.Path
+	}
+	logger.Logger.WithFields(fields).Info(message)
+}
+
+// writeRouteResponse answers a change with message and the route, if any
+func (h *RoutesHandler) writeRouteResponse(w http.ResponseWriter, wantsJSON bool, message string, route canned.Route, statusCode int) {
+	response := &models.CannedRouteResponse{
+		Success: true,
+		Message: message,
+	}
+	if route.ID != 0 {
+		model := cannedRouteModel(route)
+		response.Route = &model
+	}
+
+	// Return JSON if specifically requested
+	if wantsJSON {
+		h.writeJSONResponse(w, response, statusCode)
+		return
+	}
+
+	// Return plain text by default
+	text := message + "\n"
+	if route.ID != 0 {
+		text += prettyRoutes([]canned.Route{route})
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(statusCode)
+	w.Write([]byte(text))
+}
+
+// cannedRouteModel converts a route of the table for the API
+func cannedRouteModel(route canned.Route) models.CannedRoute {
+	model := models.CannedRoute{
+		ID:       route.ID,
+		Source:   route.Source,
+		Method:   route.Method,
+		Path:     route.Path,
+		Status:   route.Status,
+		Redirect: route.Redirect,
+		Headers:  route.Headers,
+		Body:     route.Body,
+		File:     route.File,
+	}
+	if route.Delay > 0 {
+		model.Delay = route.Delay.String()
+	}
+	return model
+}
+
+// prettyRoutes formats routes one per line in the order they are tried,
+// e.g. "#3  api     GET  /redirect  -> 302 gopher://127.0.0.1:6379/_INFO"
+func prettyRoutes(routes []canned.Route) string {
+	if len(routes) == 0 {
+		return "No canned responses\n"
+	}
+	var b strings.Builder
+	for _, route := range routes {
+		method := route.Method
+		if method == "" {
+			method = "*"
+		}
+		fmt.Fprintf(&b, "#%d  %-6s  %-6s  %s  -> %d", route.ID, route.Source, method, route.Path, cannedStatus(route.ResponseRule))
+		switch {
+		case route.Redirect != "":
+			fmt.Fprintf(&b, " %s", route.Redirect)
+		case route.File != "":
+			fmt.Fprintf(&b, " file %s", route.File)
+		case route.Body != "":
+			fmt.Fprintf(&b, " body %d bytes", len(route.Body))
+		}
+		if len(route.Headers) > 0 {
+			fmt.Fprintf(&b, ", %d headers", len(route.Headers))
+		}
+		if route.Delay > 0 {
+			fmt.Fprintf(&b, ", after %s", route.Delay)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func (h *RoutesHandler) writeJSONResponse(w http.ResponseWriter, data interface{}, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		logger.Logger.WithError(err).Error("Failed to encode JSON response")
+	}
+}
+
+func (h *RoutesHandler) writeErrorResponse(w http.ResponseWriter, message string, statusCode int) {
+	response := &models.ErrorResponse{
+		Success: false,
+		Error:   message,
+	}
+	h.writeJSONResponse(w, response, statusCode)
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/models"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+)
+
+// StatsHandler handles requests for upload storage usage and quotas
+type StatsHandler struct {
+	fileService *service.FileService
+}
+
+// NewStatsHandler creates a new storage stats handler
+func NewStatsHandler(fileService *service.FileService) *StatsHandler {
+	return &StatsHandler{
+		fileService: fileService,
+	}
+}
+
+// ServeHTTP handles the storage stats request
+func (h *StatsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Check if client wants JSON response
+	acceptHeader := r.Header.Get("Accept")
+	wantsJSON := acceptHeader == "application/json" || r.URL.Query().Get("format") == "json"
+
+	prettyStats, result, err := h.fileService.GetPrettyStats(sourceAddress(r))
+	if err != nil {
+		logger.Logger.WithError(err).Error("Failed to get storage stats")
+		h.writeErrorResponse(w, "Failed to get storage stats", http.StatusInternalServerError)
+		return
+	}
+
+	// Return JSON if specifically requested
+	if wantsJSON {
+		h.writeJSONResponse(w, result, http.StatusOK)
+		return
+	}
+
+	// Return plain text by default
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(prettyStats))
+}
+
+func (h *StatsHandler) writeJSONResponse(w http.ResponseWriter, data interface{}, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		logger.Logger.WithError(err).Error("Failed to encode JSON response")
+	}
+}
+
+func (h *StatsHandler) writeErrorResponse(w http.ResponseWriter, message string, statusCode int) {
+	response := &models.ErrorResponse{
+		Success: false,
+		Error:   message,
+	}
+	h.writeJSONResponse(w, response, statusCode)
+}
@@ -2,13 +2,25 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net"
 	"net/http"
+	"time"
 
+	"github.com/m1kkY8/ctfserver/pkg/ipfilter"
 	"github.com/m1kkY8/ctfserver/pkg/logger"
 	"github.com/m1kkY8/ctfserver/pkg/models"
 	"github.com/m1kkY8/ctfserver/pkg/service"
 )
 
+// ExpireAfterHeader sets how long an upload is kept before it is deleted
+const ExpireAfterHeader = "X-Expire-After"
+
+// maxFormOverhead bounds the multipart headers and fields around the file of
+// an upload
+const maxFormOverhead = 1 << 20
+
 // UploadHandler handles file upload requests
 type UploadHandler struct {
 	fileService *service.FileService
@@ -28,27 +40,46 @@ func (h *UploadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse multipart form
-	if err := r.ParseMultipartForm(h.fileService.MaxSize()); err != nil {
-		logger.Logger.WithError(err).Error("Failed to parse multipart form")
-		h.writeErrorResponse(w, "Failed to parse form data", http.StatusBadRequest)
+	// Optional lifetime of the upload, e.g. "X-Expire-After: 24h"
+	expireAfter, err := parseExpireAfter(r)
+	if err != nil {
+		h.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Get file from form data
-	file, header, err := r.FormFile("file")
+	// Refuse uploads that can't fit before reading them
+	source := sourceAddress(r)
+	if r.ContentLength > 0 {
+		if err := h.fileService.CheckUpload(source, r.ContentLength); err != nil {
+			h.writeStorageError(w, err)
+			return
+		}
+	}
+
+	// Stream the file part to disk instead of buffering the form; the body
+	// may only exceed the maximum upload size by the other fields
+	r.Body = http.MaxBytesReader(w, r.Body, h.fileService.MaxSize()+maxFormOverhead)
+	part, err := filePart(r)
 	if err != nil {
 		logger.Logger.WithError(err).Error("Failed to get file from form")
 		h.writeErrorResponse(w, "No file provided", http.StatusBadRequest)
 		return
 	}
-	defer file.Close()
+	defer part.Close()
 
 	// Upload the file
-	result, err := h.fileService.UploadFile(header, file)
+	result, err := h.fileService.SaveUpload(part.FileName(), part, -1, source, expireAfter)
 	if err != nil {
-		logger.Logger.WithError(err).Error("Failed to upload file")
-		h.writeErrorResponse(w, "Failed to save file", http.StatusInternalServerError)
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.Is(err, service.ErrInsufficientStorage):
+			h.writeStorageError(w, err)
+		case errors.As(err, &tooLarge):
+			h.writeErrorResponse(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		default:
+			logger.Logger.WithError(err).Error("Failed to upload file")
+			h.writeErrorResponse(w, "Failed to save file", http.StatusInternalServerError)
+		}
 		return
 	}
 
@@ -63,11 +94,61 @@ func (h *UploadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		"filename": result.Filename,
 		"size":     result.Size,
 		"path":     result.Path,
+		"source":   source,
 	}).Info("File uploaded successfully")
 
 	h.writeJSONResponse(w, result, http.StatusCreated)
 }
 
+// filePart skips to the "file" part of the multipart form of r
+func filePart(r *http.Request) (*multipart.Part, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "file" && part.FileName() != "" {
+			return part, nil
+		}
+		part.Close()
+	}
+}
+
+// writeStorageError rejects an upload that doesn't fit the storage limits
+func (h *UploadHandler) writeStorageError(w http.ResponseWriter, err error) {
+	logger.Logger.WithError(err).Warn("Rejected upload over storage limits")
+	h.writeErrorResponse(w, err.Error(), http.StatusInsufficientStorage)
+}
+
+// parseExpireAfter parses the optional ExpireAfterHeader of an upload request
+func parseExpireAfter(r *http.Request) (time.Duration, error) {
+	value := r.Header.Get(ExpireAfterHeader)
+	if value == "" {
+		return 0, nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl <= 0 {
+		return 0, errors.New("Invalid " + ExpireAfterHeader + " header, expected a positive duration such as 24h")
+	}
+	return ttl, nil
+}
+
+// sourceAddress returns the client address of the request as resolved by the
+// server middleware, falling back to the connection's remote address
+func sourceAddress(r *http.Request) string {
+	if addr, ok := ipfilter.FromContext(r.Context()); ok {
+		return addr.String()
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
 func (h *UploadHandler) writeJSONResponse(w http.ResponseWriter, data interface{}, statusCode int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
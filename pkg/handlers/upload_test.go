@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+)
+
+// multipartBody builds a form with a field before the file part
+func multipartBody(t *testing.T, filename string, content []byte) (io.Reader, string) {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("note", "before the file")
+	part, err := form.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(content)
+	form.Close()
+	return &body, form.FormDataContentType()
+}
+
+func TestUploadHandler(t *testing.T) {
+	logger.InitLogger("error")
+
+	tests := []struct {
+		name       string
+		size       int
+		chunked    bool // No Content-Length, so only the quota check while streaming applies
+		quota      int64
+		wantStatus int
+	}{
+		{"small", 1000, false, 0, http.StatusCreated},
+		{"streamed", 1000, true, 2000, http.StatusCreated},
+		{"over max size", 5000, true, 0, http.StatusBadRequest},
+		{"over quota up front", 3000, false, 2000, http.StatusInsufficientStorage},
+		{"over quota while streaming", 3000, true, 2000, http.StatusInsufficientStorage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uploadDir := t.TempDir()
+			fileService := service.NewFileService(fstest.MapFS{}, "root", uploadDir, 4000)
+			fileService.SetStorageLimits(service.StorageLimits{UploadQuota: tt.quota})
+
+			body, contentType := multipartBody(t, "loot.bin", bytes.Repeat([]byte("x"), tt.size))
+			if tt.chunked {
+				body = struct{ io.Reader }{body}
+			}
+			r := httptest.NewRequest(http.MethodPost, "/upload", body)
+			r.Header.Set("Content-Type", contentType)
+			if tt.chunked {
+				r.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			NewUploadHandler(fileService).ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			data, err := os.ReadFile(filepath.Join(uploadDir, "loot.bin"))
+			if tt.wantStatus != http.StatusCreated {
+				if err == nil {
+					t.Error("refused upload left a file behind")
+				}
+				return
+			}
+			if err != nil || len(data) != tt.size {
+				t.Errorf("saved %d bytes (%v), want %d", len(data), err, tt.size)
+			}
+		})
+	}
+}
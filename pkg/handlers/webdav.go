@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/m1kkY8/ctfserver/pkg/dav"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+	"golang.org/x/net/webdav"
+)
+
+// WebDAVHandler serves the root read-only and the upload directory writable
+// over WebDAV, e.g. for the Windows WebClient service
+type WebDAVHandler struct {
+	fileService *service.FileService
+	prefix      string
+	dav         *webdav.Handler
+}
+
+// NewWebDAVHandler creates a new WebDAV handler for the share at prefix
+func NewWebDAVHandler(fileService *service.FileService, prefix string) *WebDAVHandler {
+	return &WebDAVHandler{
+		fileService: fileService,
+		prefix:      prefix,
+		dav: &webdav.Handler{
+			Prefix:     prefix,
+			FileSystem: dav.NewFileSystem(fileService),
+			LockSystem: webdav.NewMemLS(),
+			Logger: func(r *http.Request, err error) {
+				if err != nil {
+					logger.Logger.WithError(err).WithFields(map[string]interface{}{
+						"method": r.Method,
+						"path":   r.URL.Path,
+					}).Debug("WebDAV request failed")
+				}
+			},
+		},
+	}
+}
+
+// ServeHTTP handles a WebDAV request
+func (h *WebDAVHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// The Windows mini-redirector probes the server root before mounting the share
+	if r.URL.Path != h.prefix && !strings.HasPrefix(r.URL.Path, h.prefix+"/") {
+		if r.Method != http.MethodOptions {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("DAV", "1, 2")
+		w.Header().Set("MS-Author-Via", "DAV")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		h.put(w, r)
+	case "COPY":
+		// Copies are written through the file system, which only creates empty files
+		http.Error(w, "Copying is not supported, download and PUT the file instead", http.StatusForbidden)
+	case "MOVE":
+		// A move over an existing upload deletes it first, so refuse moves that can't succeed
+		if !h.movable(r) {
+			http.Error(w, "Only files in the "+dav.UploadsDir+" directory can be moved", http.StatusForbidden)
+			return
+		}
+		h.dav.ServeHTTP(w, r)
+	default:
+		h.dav.ServeHTTP(w, r)
+	}
+}
+
+// movable reports whether the source of a move is an existing upload
+func (h *WebDAVHandler) movable(r *http.Request) bool {
+	upload, ok := dav.UploadName(strings.TrimPrefix(r.URL.Path, h.prefix))
+	if !ok || upload == "" {
+		return false
+	}
+	filePath, err := h.fileService.UploadPath(upload)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(filePath)
+	return err == nil
+}
+
+// put stores a file in the uploads directory the same way as the upload API
+func (h *WebDAVHandler) put(w http.ResponseWriter, r *http.Request) {
+	filename, ok := dav.UploadName(strings.TrimPrefix(r.URL.Path, h.prefix))
+	if !ok || filename == "" {
+		http.Error(w, "Only the "+dav.UploadsDir+" directory is writable", http.StatusForbidden)
+		return
+	}
+
+	expireAfter, err := parseExpireAfter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Refuse uploads that can't fit before reading them
+	source := sourceAddress(r)
+	if r.ContentLength > 0 {
+		if err := h.fileService.CheckUpload(source, r.ContentLength); err != nil {
+			h.writeUploadError(w, err)
+			return
+		}
+	}
+
+	existed := false
+	if filePath, err := h.fileService.UploadPath(filename); err == nil {
+		_, err := os.Stat(filePath)
+		existed = err == nil
+	}
+
+	result, err := h.fileService.SaveUpload(filename, r.Body, r.ContentLength, source, expireAfter)
+	if err != nil {
+		h.writeUploadError(w, err)
+		return
+	}
+	if !result.Success {
+		http.Error(w, result.Error, http.StatusBadRequest)
+		return
+	}
+
+	// Log successful upload
+	logger.Logger.WithFields(map[string]interface{}{
+		"filename": result.Filename,
+		"size":     result.Size,
+		"path":     result.Path,
+		"source":   source,
+		"via":      "webdav",
+	}).Info("File uploaded successfully")
+
+	if existed {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *WebDAVHandler) writeUploadError(w http.ResponseWriter, err error) {
+	if errors.Is(err, service.ErrInsufficientStorage) {
+		logger.Logger.WithError(err).Warn("Rejected upload over storage limits")
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	}
+	logger.Logger.WithError(err).Error("Failed to upload file")
+	http.Error(w, "Failed to save file", http.StatusInternalServerError)
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+)
+
+func TestWebDAVHandler(t *testing.T) {
+	logger.InitLogger("error")
+	uploadDir := t.TempDir()
+	fileService := service.NewFileService(fstest.MapFS{"tools/nc": {Data: []byte("nc")}}, "root", uploadDir, 1<<20)
+	handler := NewWebDAVHandler(fileService, "/dav")
+
+	tests := []struct {
+		method      string
+		path        string
+		body        string
+		destination string
+		wants       int
+	}{
+		{http.MethodPut, "/dav/uploads/loot.txt", "first", "", http.StatusCreated},
+		{http.MethodPut, "/dav/uploads/loot.txt", "loot", "", http.StatusNoContent},
+		{http.MethodPut, "/dav/tools/nc", "x", "", http.StatusForbidden},
+		{http.MethodPut, "/dav/uploads", "x", "", http.StatusForbidden},
+		{http.MethodPut, "/dav/uploads/.uploads.json", "{}", "", http.StatusBadRequest},
+		{http.MethodGet, "/dav/uploads/loot.txt", "", "", http.StatusOK},
+		{http.MethodGet, "/dav/tools/nc", "", "", http.StatusOK},
+		// Copies can't be written, and moves out of the root would delete the target first
+		{"COPY", "/dav/tools/nc", "", "/dav/uploads/nc", http.StatusForbidden},
+		{"COPY", "/dav/uploads/loot.txt", "", "/dav/uploads/copy.txt", http.StatusForbidden},
+		{"MOVE", "/dav/tools/nc", "", "/dav/uploads/loot.txt", http.StatusForbidden},
+		{"MOVE", "/dav/uploads/missing.txt", "", "/dav/uploads/loot.txt", http.StatusForbidden},
+		{"MOVE", "/dav/uploads/loot.txt", "", "/dav/uploads/creds.txt", http.StatusCreated},
+		{http.MethodDelete, "/dav/tools/nc", "", "", http.StatusMethodNotAllowed},
+		{"MKCOL", "/dav/uploads/dir", "", "", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		if tt.destination != "" {
+			r.Header.Set("Destination", "http://example.com"+tt.destination)
+			r.Header.Set("Overwrite", "T")
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.wants {
+			t.Errorf("%s %s = %d, want %d", tt.method, tt.path, w.Code, tt.wants)
+		}
+	}
+
+	entries, err := os.ReadDir(uploadDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if strings.Join(names, ",") != ".uploads.json,creds.txt" {
+		t.Errorf("upload directory holds %v, want only creds.txt", names)
+	}
+	if data, _ := os.ReadFile(filepath.Join(uploadDir, "creds.txt")); string(data) != "loot" {
+		t.Errorf("creds.txt holds %q, want loot", data)
+	}
+}
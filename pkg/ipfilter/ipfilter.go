@@ -0,0 +1,157 @@
+// Package ipfilter decides which source addresses may use each route group.
+package ipfilter
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"github.com/m1kkY8/ctfserver/pkg/config"
+)
+
+// Filter checks request source addresses against allow and deny rules.
+//
+// Deny rules always apply. Allow rules scoped to a route group replace the
+// unscoped ones for that group, so a group can be narrowed to fewer sources
+// than the rest of the server. Without any applicable allow rule every
+// address that isn't denied is allowed.
+type Filter struct {
+	mu             sync.RWMutex
+	allow          []config.IPRule
+	deny           []config.IPRule
+	trustedProxies []config.IPPrefix
+	reject         string
+}
+
+// New creates a filter for the rules in cfg
+func New(cfg *config.Config) *Filter {
+	f := &Filter{}
+	f.Configure(cfg)
+	return f
+}
+
+// Configure replaces the rules, e.g. on reload
+func (f *Filter) Configure(cfg *config.Config) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.allow = cfg.Allow
+	f.deny = cfg.Deny
+	f.trustedProxies = cfg.TrustedProxies
+	f.reject = cfg.IPReject
+}
+
+// Enabled reports whether any allow or deny rule is configured
+func (f *Filter) Enabled() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return len(f.allow) > 0 || len(f.deny) > 0
+}
+
+// Reject returns how rejected requests are answered (config.IPReject*)
+func (f *Filter) Reject() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.reject
+}
+
+// Allowed reports whether addr may use the route group
+func (f *Filter) Allowed(group string, addr netip.Addr) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	for _, rule := range f.deny {
+		if rule.AppliesTo(group) && rule.Contains(addr) {
+			return false
+		}
+	}
+
+	var global, scoped []config.IPRule
+	for _, rule := range f.allow {
+		switch {
+		case len(rule.Groups) == 0:
+			global = append(global, rule)
+		case rule.AppliesTo(group):
+			scoped = append(scoped, rule)
+		}
+	}
+	if len(scoped) > 0 {
+		global = scoped
+	}
+	if len(global) == 0 {
+		return true
+	}
+	for _, rule := range global {
+		if rule.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientAddr returns the address the request came from. Requests from trusted
+// proxies are attributed to the last untrusted hop in X-Forwarded-For.
+func (f *Filter) ClientAddr(r *http.Request) (netip.Addr, bool) {
+	addr, ok := remoteAddr(r.RemoteAddr)
+	if !ok {
+		return addr, false
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if !f.trusted(addr) {
+		return addr, true
+	}
+
+	// Walk the chain from the nearest hop, skipping our own proxies
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break // A malformed hop can't be trusted, stop at the last good one
+		}
+		addr = hop.Unmap().WithZone("")
+		if !f.trusted(addr) {
+			break
+		}
+	}
+	return addr, true
+}
+
+func (f *Filter) trusted(addr netip.Addr) bool {
+	for _, prefix := range f.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteAddr parses the host part of a request's RemoteAddr
+func remoteAddr(remote string) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(remote)
+	if err != nil {
+		host = remote
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+// clientAddrKey is the context key for the resolved client address
+type clientAddrKey struct{}
+
+// NewContext returns a copy of ctx carrying the client address of the request
+func NewContext(ctx context.Context, addr netip.Addr) context.Context {
+	return context.WithValue(ctx, clientAddrKey{}, addr)
+}
+
+// FromContext returns the client address stored by NewContext
+func FromContext(ctx context.Context) (netip.Addr, bool) {
+	addr, ok := ctx.Value(clientAddrKey{}).(netip.Addr)
+	return addr, ok
+}
@@ -0,0 +1,106 @@
+package ipfilter
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/m1kkY8/ctfserver/pkg/config"
+)
+
+func rules(t *testing.T, specs ...string) []config.IPRule {
+	t.Helper()
+	var parsed []config.IPRule
+	for _, spec := range specs {
+		rule, err := config.ParseIPRule(spec)
+		if err != nil {
+			t.Fatalf("ParseIPRule(%q): %v", spec, err)
+		}
+		parsed = append(parsed, rule)
+	}
+	return parsed
+}
+
+func TestAllowed(t *testing.T) {
+	tests := []struct {
+		name  string
+		allow []string
+		deny  []string
+		group string
+		addr  string
+		want  bool
+	}{
+		{"no rules", nil, nil, config.RouteFiles, "203.0.113.7", true},
+		{"global allow inside", []string{"10.10.0.0/16"}, nil, config.RouteFiles, "10.10.14.2", true},
+		{"global allow outside", []string{"10.10.0.0/16"}, nil, config.RouteFiles, "10.11.0.1", false},
+		{"single address", []string{"10.8.1.5"}, nil, config.RouteFiles, "10.8.1.5", true},
+		{"single address neighbour", []string{"10.8.1.5"}, nil, config.RouteFiles, "10.8.1.6", false},
+		{"deny wins over allow", []string{"10.0.0.0/8"}, []string{"10.10.14.0/24"}, config.RouteFiles, "10.10.14.2", false},
+		{"deny only", nil, []string{"10.10.14.0/24"}, config.RouteFiles, "10.10.15.2", true},
+		{"scoped deny other group", nil, []string{"admin=10.10.14.0/24"}, config.RouteFiles, "10.10.14.2", true},
+		{"scoped deny its group", nil, []string{"admin=10.10.14.0/24"}, config.RouteAdmin, "10.10.14.2", false},
+		// Scoped allow rules replace the global ones for their groups
+		{"scoped allow narrows", []string{"10.0.0.0/8", "loot+admin=10.8.0.0/24"}, nil, config.RouteLoot, "10.10.14.2", false},
+		{"scoped allow inside", []string{"10.0.0.0/8", "loot+admin=10.8.0.0/24"}, nil, config.RouteAdmin, "10.8.0.9", true},
+		{"scoped allow leaves other groups", []string{"10.0.0.0/8", "loot+admin=10.8.0.0/24"}, nil, config.RouteFiles, "10.10.14.2", true},
+		{"scoped allow widens", []string{"10.0.0.0/8", "files=0.0.0.0/0"}, nil, config.RouteFiles, "203.0.113.7", true},
+		{"only scoped allow", []string{"upload=10.8.0.0/24"}, nil, config.RouteFiles, "203.0.113.7", true},
+		{"only scoped allow outside", []string{"upload=10.8.0.0/24"}, nil, config.RouteUpload, "203.0.113.7", false},
+		{"ipv6", []string{"fd00::/8"}, nil, config.RouteFiles, "fd00::1", true},
+		{"ipv6 outside", []string{"fd00::/8"}, nil, config.RouteFiles, "2001:db8::1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := New(&config.Config{Allow: rules(t, tt.allow...), Deny: rules(t, tt.deny...)})
+			if got := f.Allowed(tt.group, netip.MustParseAddr(tt.addr)); got != tt.want {
+				t.Errorf("Allowed(%s, %s) = %v, want %v", tt.group, tt.addr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientAddr(t *testing.T) {
+	proxy, err := config.ParseIPPrefix("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	internal, err := config.ParseIPPrefix("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := New(&config.Config{TrustedProxies: []config.IPPrefix{proxy, internal}})
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{"direct", "203.0.113.7:4444", nil, "203.0.113.7"},
+		{"untrusted forwarder ignored", "203.0.113.7:4444", []string{"10.10.14.2"}, "203.0.113.7"},
+		{"trusted proxy", "127.0.0.1:4444", []string{"203.0.113.7"}, "203.0.113.7"},
+		{"last untrusted hop", "127.0.0.1:4444", []string{"198.51.100.1, 203.0.113.7, 10.0.0.5"}, "203.0.113.7"},
+		{"several headers", "127.0.0.1:4444", []string{"198.51.100.1", "203.0.113.7"}, "203.0.113.7"},
+		{"malformed hop stops", "127.0.0.1:4444", []string{"203.0.113.7, junk"}, "127.0.0.1"},
+		{"only proxies", "127.0.0.1:4444", []string{"10.0.0.5"}, "10.0.0.5"},
+		{"mapped ipv4", "[::ffff:203.0.113.7]:4444", nil, "203.0.113.7"},
+		{"ipv6 zone", "[fe80::1%eth0]:4444", nil, "fe80::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			addr, ok := f.ClientAddr(r)
+			if !ok {
+				t.Fatalf("ClientAddr(%s) failed", tt.remote)
+			}
+			if addr.String() != tt.want {
+				t.Errorf("ClientAddr = %s, want %s", addr, tt.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package logger
 
 import (
+	"io"
 	"net/http"
 	"time"
 
@@ -24,6 +25,16 @@ func InitLogger(level string) {
 	Logger.SetLevel(logLevel)
 }
 
+// SetLevel changes the level of the global logger at runtime
+func SetLevel(level string) error {
+	logLevel, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	Logger.SetLevel(logLevel)
+	return nil
+}
+
 // responseWriter wraps http.ResponseWriter to capture status code
 type responseWriter struct {
 	http.ResponseWriter
@@ -42,6 +53,24 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	return size, err
 }
 
+// ReadFrom keeps the sendfile path of the underlying writer for downloads
+func (rw *responseWriter) ReadFrom(src io.Reader) (int64, error) {
+	var n int64
+	var err error
+	if rf, ok := rw.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(src)
+	} else {
+		n, err = io.Copy(struct{ io.Writer }{rw.ResponseWriter}, src)
+	}
+	rw.size += int(n)
+	return n, err
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 // LoggingMiddleware logs HTTP requests
 func LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -4,12 +4,14 @@ import "time"
 
 // FileInfo represents a file or directory in the file tree
 type FileInfo struct {
-	Name     string     `json:"name"`
-	Path     string     `json:"path"`
-	IsDir    bool       `json:"is_dir"`
-	Size     int64      `json:"size,omitempty"`
-	ModTime  time.Time  `json:"mod_time"`
-	Children []FileInfo `json:"children,omitempty"`
+	Name      string     `json:"name"`
+	Path      string     `json:"path"`
+	IsDir     bool       `json:"is_dir"`
+	Size      int64      `json:"size,omitempty"`
+	TotalSize int64      `json:"total_size,omitempty"` // Recursive size of all files below a directory
+	FileCount int        `json:"file_count,omitempty"` // Recursive number of files below a directory
+	ModTime   time.Time  `json:"mod_time"`
+	Children  []FileInfo `json:"children,omitempty"`
 }
 
 // FileTreeResponse represents the response for file tree API
@@ -29,11 +31,12 @@ type PrettyFileTreeResponse struct {
 
 // UploadResponse represents the response for upload API
 type UploadResponse struct {
-	Success  bool   `json:"success"`
-	Filename string `json:"filename,omitempty"`
-	Size     int64  `json:"size,omitempty"`
-	Path     string `json:"path,omitempty"`
-	Error    string `json:"error,omitempty"`
+	Success  bool       `json:"success"`
+	Filename string     `json:"filename,omitempty"`
+	Size     int64      `json:"size,omitempty"`
+	Path     string     `json:"path,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"` // When the file will be deleted, if X-Expire-After was given
+	Error    string     `json:"error,omitempty"`
 }
 
 // UploadedFileInfo represents information about an uploaded file
@@ -52,6 +55,237 @@ type UploadsListResponse struct {
 	Error   string             `json:"error,omitempty"`
 }
 
+// FileHash represents the hashes of a single served file
+type FileHash struct {
+	Path    string            `json:"path"`
+	Size    int64             `json:"size"`
+	ModTime time.Time         `json:"mod_time"`
+	Hashes  map[string]string `json:"hashes"`
+}
+
+// HashesResponse represents the response for file hashes API
+type HashesResponse struct {
+	Success    bool       `json:"success"`
+	Algorithms []string   `json:"algorithms,omitempty"`
+	Files      []FileHash `json:"files,omitempty"`
+	Count      int        `json:"count"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// DiskUsageEntry represents the disk usage of a single file or directory
+type DiskUsageEntry struct {
+	Path      string `json:"path"`
+	IsDir     bool   `json:"is_dir"`
+	Size      int64  `json:"size"`
+	SizeHuman string `json:"size_human"`
+	FileCount int    `json:"file_count"`
+}
+
+// DiskUsageResponse represents the response for disk usage API
+type DiskUsageResponse struct {
+	Success        bool             `json:"success"`
+	Dir            string           `json:"dir,omitempty"`
+	Path           string           `json:"path,omitempty"`
+	TotalSize      int64            `json:"total_size"`
+	TotalSizeHuman string           `json:"total_size_human,omitempty"`
+	FileCount      int              `json:"file_count"`
+	Entries        []DiskUsageEntry `json:"entries,omitempty"`
+	Error          string           `json:"error,omitempty"`
+}
+
+// DirectoryEntry represents a single entry of a browsable directory listing
+type DirectoryEntry struct {
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	IsDir    bool      `json:"is_dir"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	MimeType string    `json:"mime_type,omitempty"`
+}
+
+// DirectoryListing represents the contents of a directory below the root directory
+type DirectoryListing struct {
+	Path    string           `json:"path"`
+	Entries []DirectoryEntry `json:"entries"`
+}
+
+// BuiltinFile represents a file of the toolkit compiled into the binary
+type BuiltinFile struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256"`
+	Version string `json:"version,omitempty"`
+}
+
+// BuiltinManifest represents the response for the embedded toolkit API
+type BuiltinManifest struct {
+	Success bool          `json:"success"`
+	Enabled bool          `json:"enabled"`
+	Version string        `json:"version"`
+	Files   []BuiltinFile `json:"files,omitempty"`
+	Count   int           `json:"count"`
+}
+
+// ListenerURL is a URL a listener can be reached at
+type ListenerURL struct {
+	Interface string `json:"interface,omitempty"`
+	URL       string `json:"url"`
+}
+
+// ListenerInfo describes a configured listener and where it is reachable
+type ListenerInfo struct {
+	Addr      string        `json:"addr"`
+	Interface string        `json:"interface,omitempty"` // Set when bound by interface name
+	TLS       bool          `json:"tls"`
+	Routes    []string      `json:"routes,omitempty"`
+	URLs      []ListenerURL `json:"urls"`
+}
+
+// InfoResponse represents the response for server info API
+type InfoResponse struct {
+	Success   bool           `json:"success"`
+	Version   string         `json:"version"`
+	Hostname  string         `json:"hostname,omitempty"`
+	LHOST     string         `json:"lhost,omitempty"` // Local address the request arrived on
+	Listeners []ListenerInfo `json:"listeners"`
+}
+
+// DiskStats describes the file system holding the upload directory
+type DiskStats struct {
+	Total          int64  `json:"total"`
+	Free           int64  `json:"free"`
+	FreeHuman      string `json:"free_human"`
+	MinFree        int64  `json:"min_free"`
+	Available      int64  `json:"available"` // Bytes uploads may still use before the free space threshold
+	AvailableHuman string `json:"available_human"`
+}
+
+// QuotaUsage describes the usage of one upload quota
+type QuotaUsage struct {
+	Address   string `json:"address,omitempty"`
+	Files     int    `json:"files"`
+	Used      int64  `json:"used"`
+	UsedHuman string `json:"used_human"`
+	Quota     int64  `json:"quota"`               // 0 when unlimited
+	Remaining *int64 `json:"remaining,omitempty"` // Omitted when unlimited
+}
+
+// StatsResponse represents the response for upload storage stats API
+type StatsResponse struct {
+	Success   bool        `json:"success"`
+	UploadDir string      `json:"upload_dir,omitempty"`
+	Disk      *DiskStats  `json:"disk,omitempty"` // Omitted where free space can't be queried
+	Uploads   *QuotaUsage `json:"uploads,omitempty"`
+	Source    *QuotaUsage `json:"source,omitempty"` // Usage of the requesting source address
+	Error     string      `json:"error,omitempty"`
+}
+
+// RetentionEntry is an uploaded file deleted by the retention policies
+type RetentionEntry struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	SizeHuman string    `json:"size_human"`
+	ModTime   time.Time `json:"mod_time"`
+	Reason    string    `json:"reason"` // expired, max-age or max-size
+}
+
+// RetentionResponse represents the response for the retention preview API
+type RetentionResponse struct {
+	Success    bool             `json:"success"`
+	MaxAge     string           `json:"max_age,omitempty"`  // Omitted when files never age out
+	MaxSize    int64            `json:"max_size,omitempty"` // Omitted when the total size is unlimited
+	Files      []RetentionEntry `json:"files"`
+	Count      int              `json:"count"`
+	Freed      int64            `json:"freed"`
+	FreedHuman string           `json:"freed_human"`
+	Error      string           `json:"error,omitempty"`
+}
+
+// ReloadResponse represents the response for a configuration reload
+type ReloadResponse struct {
+	Success         bool     `json:"success"`
+	Message         string   `json:"message,omitempty"`
+	Error           string   `json:"error,omitempty"`
+	RestartRequired []string `json:"restart_required,omitempty"` // Changed settings that only apply after a restart
+}
+
+// OnelinerResponse represents the response for the DNS sender one-liner API
+type OnelinerResponse struct {
+	Success bool   `json:"success"`
+	Client  string `json:"client,omitempty"` // nslookup, dig or powershell
+	Zone    string `json:"zone,omitempty"`
+	Script  string `json:"script,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Hit represents a request recorded by the callback capture
+type Hit struct {
+	ID            int64               `json:"id"`
+	Time          time.Time           `json:"time"`
+	Source        string              `json:"source"`
+	RemoteAddr    string              `json:"remote_addr"`
+	Method        string              `json:"method"`
+	Host          string              `json:"host"`
+	Path          string              `json:"path"`
+	RawQuery      string              `json:"raw_query,omitempty"`
+	Query         map[string][]string `json:"query,omitempty"`
+	Proto         string              `json:"proto"`
+	Headers       map[string][]string `json:"headers"`
+	Body          string              `json:"body,omitempty"`
+	BodyEncoding  string              `json:"body_encoding,omitempty"` // base64 for bodies that aren't UTF-8 text
+	BodySize      int64               `json:"body_size"`               // Bytes of the body kept
+	BodyTruncated bool                `json:"body_truncated,omitempty"`
+	TLS           *HitTLS             `json:"tls,omitempty"`
+}
+
+// HitTLS represents the TLS connection a captured request came over
+type HitTLS struct {
+	Version     string `json:"version"`
+	CipherSuite string `json:"cipher_suite"`
+	ServerName  string `json:"server_name,omitempty"` // SNI
+	ALPN        string `json:"alpn,omitempty"`
+}
+
+// HitsResponse represents the response for the captured requests API
+type HitsResponse struct {
+	Success bool   `json:"success"`
+	Hits    []Hit  `json:"hits"`
+	Count   int    `json:"count"`
+	Error   string `json:"error,omitempty"`
+}
+
+// CannedRoute represents a canned response of the routes API, also the
+// body of requests creating or changing one
+type CannedRoute struct {
+	ID       int64             `json:"id,omitempty"`
+	Source   string            `json:"source,omitempty"` // config or api
+	Method   string            `json:"method,omitempty"`
+	Path     string            `json:"path"`
+	Status   int               `json:"status,omitempty"`
+	Redirect string            `json:"redirect,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Body     string            `json:"body,omitempty"`
+	File     string            `json:"file,omitempty"`
+	Delay    string            `json:"delay,omitempty"` // Duration, e.g. 5s
+}
+
+// CannedRoutesResponse represents the response for the routes list
+type CannedRoutesResponse struct {
+	Success bool          `json:"success"`
+	Routes  []CannedRoute `json:"routes"`
+	Count   int           `json:"count"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// CannedRouteResponse represents the response for a created, changed or
+// deleted route
+type CannedRouteResponse struct {
+	Success bool         `json:"success"`
+	Message string       `json:"message,omitempty"`
+	Route   *CannedRoute `json:"route,omitempty"`
+	Error   string       `json:"error,omitempty"`
+}
+
 // ErrorResponse represents a generic error response
 type ErrorResponse struct {
 	Success bool   `json:"success"`
@@ -0,0 +1,157 @@
+// Package ratelimit enforces per-source request rates, a cap on concurrent
+// uploads and per-download bandwidth.
+package ratelimit
+
+import (
+	"math"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/m1kkY8/ctfserver/pkg/config"
+)
+
+// sweepInterval is how often buckets that have refilled completely are dropped
+const sweepInterval = 10 * time.Minute
+
+// bucketKey identifies the token bucket of one source in one route group
+type bucketKey struct {
+	group string
+	addr  netip.Addr
+}
+
+// bucket is a token bucket holding up to burst tokens
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limits holds the configured limits and the state needed to enforce them
+type Limits struct {
+	mu           sync.Mutex
+	rules        []config.RateRule
+	buckets      map[bucketKey]*bucket
+	lastSweep    time.Time
+	maxUploads   int
+	uploads      int
+	downloadRate int64
+}
+
+// New creates limits from cfg
+func New(cfg *config.Config) *Limits {
+	l := &Limits{}
+	l.Configure(cfg)
+	return l
+}
+
+// Configure replaces the limits, e.g. on reload. Rate limit state starts
+// over; uploads in progress keep counting against the new cap.
+func (l *Limits) Configure(cfg *config.Config) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rules = cfg.RateLimits
+	l.buckets = make(map[bucketKey]*bucket)
+	l.maxUploads = cfg.MaxConcurrentUploads
+	l.downloadRate = cfg.DownloadRate
+}
+
+// rule returns the rate rule for a route group: the first rule scoped to it,
+// otherwise the first unscoped rule
+func (l *Limits) rule(group string) (config.RateRule, bool) {
+	var global *config.RateRule
+	for i, rule := range l.rules {
+		if len(rule.Groups) == 0 {
+			if global == nil {
+				global = &l.rules[i]
+			}
+			continue
+		}
+		if rule.AppliesTo(group) {
+			return rule, true
+		}
+	}
+	if global == nil {
+		return config.RateRule{}, false
+	}
+	return *global, true
+}
+
+// Allow takes a token from the bucket of addr in the route group. When the
+// bucket is empty it returns false and how long until a token is available.
+func (l *Limits) Allow(group string, addr netip.Addr) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rule, ok := l.rule(group)
+	if !ok {
+		return true, 0
+	}
+
+	now := time.Now()
+	l.sweep(now)
+
+	key := bucketKey{group: group, addr: addr}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(rule.Burst), last: now}
+		l.buckets[key] = b
+	}
+
+	// Refill for the time since the last request
+	rate := rule.PerSecond()
+	b.tokens = math.Min(float64(rule.Burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep drops buckets that have been idle long enough to be full again, as
+// they behave exactly like a new bucket
+func (l *Limits) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		rule, ok := l.rule(key.group)
+		refill := time.Duration((float64(rule.Burst) - b.tokens) / rule.PerSecond() * float64(time.Second))
+		if !ok || now.Sub(b.last) >= refill {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// AcquireUpload reserves a slot for an upload. It returns false when the
+// concurrent upload cap is reached; otherwise release must be called once
+// the upload is done.
+func (l *Limits) AcquireUpload() (release func(), ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.maxUploads > 0 && l.uploads >= l.maxUploads {
+		return nil, false
+	}
+	l.uploads++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			l.uploads--
+			l.mu.Unlock()
+		})
+	}, true
+}
+
+// DownloadRate returns the bandwidth limit for each download in bytes per
+// second, 0 when unlimited
+func (l *Limits) DownloadRate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.downloadRate
+}
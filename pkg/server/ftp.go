@@ -0,0 +1,53 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/m1kkY8/ctfserver/pkg/ftp"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/util"
+)
+
+// startFTP serves FTP in the background, reporting a failed listener on
+// serveErrors
+func (s *Server) startFTP(serveErrors chan<- error) {
+	addr := s.config.FTPListen
+	host, port, _ := net.SplitHostPort(addr)
+
+	// An interface name binds its first address, passive replies can only name one
+	if util.IsInterfaceName(host) {
+		ips := util.InterfaceIPs(host)
+		if len(ips) == 0 {
+			serveErrors <- fmt.Errorf("ftp listener %s: interface has no addresses", addr)
+			return
+		}
+		addr = net.JoinHostPort(ips[0].String(), port)
+	}
+
+	logger.Logger.WithFields(map[string]interface{}{
+		"addr":          addr,
+		"passive_ports": s.config.FTPPassivePorts,
+	}).Info("Starting FTP server")
+	go func() {
+		if err := s.ftpServer.ListenAndServe(addr); err != nil && err != ftp.ErrServerClosed {
+			serveErrors <- fmt.Errorf("ftp listener %s: %w", addr, err)
+		}
+	}()
+
+	printFTPBanner(advertiseHost(host), port)
+}
+
+// printFTPBanner prints how busybox and Windows targets fetch and store files
+func printFTPBanner(host, port string) {
+	busybox, windows := host, "ftp -A "+host
+	if port != "21" {
+		busybox = "-P " + port + " " + host
+		windows = fmt.Sprintf("ftp -A, then: open %s %s", host, port)
+	}
+	fmt.Fprintln(os.Stdout, "FTP server:")
+	fmt.Fprintf(os.Stdout, "  download: busybox ftpget %s FILE\n", busybox)
+	fmt.Fprintf(os.Stdout, "  upload:   busybox ftpput %s FILE\n", busybox)
+	fmt.Fprintf(os.Stdout, "  windows:  %s (binary, get FILE, put FILE)\n", windows)
+}
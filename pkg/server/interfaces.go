@@ -0,0 +1,210 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/m1kkY8/ctfserver/pkg/config"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/models"
+	"github.com/m1kkY8/ctfserver/pkg/util"
+)
+
+// interfaceCheckInterval is how often interface addresses are re-resolved
+const interfaceCheckInterval = 5 * time.Second
+
+// interfaceBinder keeps one socket per address of a network interface,
+// rebinding when the interface goes down and comes back with new addresses
+type interfaceBinder struct {
+	iface      string
+	port       string
+	tls        bool
+	httpServer *http.Server
+
+	mu      sync.Mutex
+	sockets map[string]net.Listener // Keyed by host:port
+	waiting bool                    // No address to bind, logged once
+	done    chan struct{}
+}
+
+func newInterfaceBinder(iface, port string, tls bool, httpServer *http.Server) *interfaceBinder {
+	return &interfaceBinder{
+		iface:      iface,
+		port:       port,
+		tls:        tls,
+		httpServer: httpServer,
+		sockets:    make(map[string]net.Listener),
+		done:       make(chan struct{}),
+	}
+}
+
+// run keeps the bound addresses in sync with the interface until stop is called
+func (b *interfaceBinder) run() {
+	ticker := time.NewTicker(interfaceCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+			b.sync()
+		}
+	}
+}
+
+// stop ends address polling; sockets are closed by shutting down the HTTP server
+func (b *interfaceBinder) stop() {
+	close(b.done)
+}
+
+// sync binds new interface addresses and releases ones that went away
+func (b *interfaceBinder) sync() {
+	want := make(map[string]bool)
+	for _, ip := range util.InterfaceIPs(b.iface) {
+		want[net.JoinHostPort(ip.String(), b.port)] = true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(want) == 0 && !b.waiting {
+		logger.Logger.WithField("interface", b.iface).Warn("Interface is down or missing, waiting for it to come up")
+	}
+	b.waiting = len(want) == 0
+
+	for addr, socket := range b.sockets {
+		if !want[addr] {
+			socket.Close()
+			delete(b.sockets, addr)
+			logger.Logger.WithFields(map[string]interface{}{
+				"interface": b.iface,
+				"addr":      addr,
+			}).Warn("Interface address gone, stopped listening")
+		}
+	}
+
+	for addr := range want {
+		if _, ok := b.sockets[addr]; ok {
+			continue
+		}
+		socket, err := net.Listen("tcp", addr)
+		if err != nil {
+			logger.Logger.WithError(err).WithField("addr", addr).Warn("Failed to bind interface address")
+			continue
+		}
+		b.sockets[addr] = socket
+		go b.serve(addr, socket)
+		logger.Logger.WithFields(map[string]interface{}{
+			"interface": b.iface,
+			"addr":      addr,
+			"tls":       b.tls,
+		}).Info("Listening on interface address")
+	}
+}
+
+// serve serves one socket, forgetting it when it fails so the next sync can rebind
+func (b *interfaceBinder) serve(addr string, socket net.Listener) {
+	var err error
+	if b.tls {
+		err = b.httpServer.ServeTLS(socket, "", "")
+	} else {
+		err = b.httpServer.Serve(socket)
+	}
+	if err == http.ErrServerClosed {
+		return
+	}
+
+	b.mu.Lock()
+	if b.sockets[addr] == socket {
+		delete(b.sockets, addr)
+	}
+	b.mu.Unlock()
+}
+
+// listenerInfo returns the URLs every listener is currently reachable at
+func (s *Server) listenerInfo() []models.ListenerInfo {
+	infos := make([]models.ListenerInfo, 0, len(s.config.Listeners))
+	for _, listener := range s.config.Listeners {
+		infos = append(infos, describeListener(listener))
+	}
+	return infos
+}
+
+// describeListener resolves the URLs a listener is reachable at
+func describeListener(listener config.ListenerConfig) models.ListenerInfo {
+	info := models.ListenerInfo{
+		Addr:   listener.Addr,
+		TLS:    listener.TLS,
+		Routes: listener.Routes,
+		URLs:   []models.ListenerURL{},
+	}
+
+	scheme := "http"
+	if listener.TLS {
+		scheme = "https"
+	}
+	host, port, err := net.SplitHostPort(listener.Addr)
+	if err != nil {
+		return info
+	}
+	addURL := func(iface string, host string) {
+		info.URLs = append(info.URLs, models.ListenerURL{
+			Interface: iface,
+			URL:       scheme + "://" + net.JoinHostPort(host, port),
+		})
+	}
+
+	switch ip := net.ParseIP(host); {
+	case host == "" || (ip != nil && ip.IsUnspecified()):
+		for _, addr := range util.LocalInterfaceAddrs() {
+			if ip != nil && ip.To4() != nil && addr.IP.To4() == nil {
+				continue // 0.0.0.0 only accepts IPv4
+			}
+			addURL(addr.Interface, addr.IP.String())
+		}
+	case util.MayBeInterfaceName(host):
+		info.Interface = host
+		for _, ifaceIP := range util.InterfaceIPs(host) {
+			addURL(host, ifaceIP.String())
+		}
+	default:
+		addURL("", host)
+	}
+
+	return info
+}
+
+// advertiseHost picks the address targets should use to reach a listener bound to bindHost
+func advertiseHost(bindHost string) string {
+	isInterface := util.MayBeInterfaceName(bindHost)
+	if isInterface {
+		if ips := util.InterfaceIPs(bindHost); len(ips) > 0 {
+			return ips[0].String()
+		}
+	}
+	if bindHost != "" && !isInterface {
+		if ip := net.ParseIP(bindHost); ip == nil || !ip.IsUnspecified() {
+			return bindHost
+		}
+	}
+
+	for _, addr := range util.LocalInterfaceAddrs() {
+		if addr.IP.To4() != nil {
+			return addr.IP.String()
+		}
+	}
+	return "127.0.0.1"
+}
+
+// splitListenerAddr splits a listener address into host and port
+func splitListenerAddr(addr string) (string, int) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, 0
+	}
+	port, _ := strconv.Atoi(portStr)
+	return host, port
+}
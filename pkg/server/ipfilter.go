@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/m1kkY8/ctfserver/pkg/config"
+	"github.com/m1kkY8/ctfserver/pkg/ipfilter"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+)
+
+// filterSource rejects requests whose source address may not use the route
+// group and records the client address of allowed ones in the request context
+func filterSource(filter *ipfilter.Filter, group string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr, ok := filter.ClientAddr(r)
+		if ok {
+			r = r.WithContext(ipfilter.NewContext(r.Context(), addr))
+		}
+		if !filter.Enabled() || (ok && filter.Allowed(group, addr)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		reject := filter.Reject()
+		logger.Logger.WithFields(map[string]interface{}{
+			"remote_addr": r.RemoteAddr,
+			"client_ip":   addr.String(),
+			"path":        r.URL.Path,
+			"group":       group,
+			"action":      reject,
+		}).Warn("Rejected request from disallowed address")
+
+		switch reject {
+		case config.IPRejectDrop:
+			// Close the connection without a response, falling back to 404
+			// where the connection can't be taken over (HTTP/2)
+			if conn, _, err := http.NewResponseController(w).Hijack(); err == nil {
+				conn.Close()
+				return
+			}
+			http.NotFound(w, r)
+		case config.IPRejectNotFound:
+			http.NotFound(w, r)
+		default:
+			writeError(w, "Forbidden", http.StatusForbidden)
+		}
+	})
+}
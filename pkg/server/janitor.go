@@ -0,0 +1,65 @@
+package server
+
+import (
+	"time"
+
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+)
+
+// janitorInterval is how often expired uploads are looked for
+const janitorInterval = time.Minute
+
+// janitor periodically deletes uploads selected by the retention policy
+type janitor struct {
+	fileService *service.FileService
+	done        chan struct{}
+	stopped     chan struct{}
+}
+
+func newJanitor(fileService *service.FileService) *janitor {
+	return &janitor{
+		fileService: fileService,
+		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+}
+
+// run sweeps right away and then every janitorInterval until stopped
+func (j *janitor) run() {
+	defer close(j.stopped)
+	ticker := time.NewTicker(janitorInterval)
+	defer ticker.Stop()
+	for {
+		j.sweep()
+		select {
+		case <-j.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// stop ends the sweeps, waiting for one in progress to finish
+func (j *janitor) stop() {
+	close(j.done)
+	<-j.stopped
+}
+
+// sweep deletes the uploads the retention policy selects now
+func (j *janitor) sweep() {
+	result, err := j.fileService.ApplyRetention(time.Now())
+	if err != nil {
+		logger.Logger.WithError(err).Warn("Failed to apply retention")
+	}
+	if result == nil {
+		return
+	}
+	for _, file := range result.Files {
+		logger.Logger.WithFields(map[string]interface{}{
+			"filename": file.Name,
+			"size":     file.Size,
+			"reason":   file.Reason,
+		}).Info("Deleted upload")
+	}
+}
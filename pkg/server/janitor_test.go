@@ -0,0 +1,40 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+)
+
+func TestJanitorSweepsOnStart(t *testing.T) {
+	logger.InitLogger("error")
+	fileService := service.NewFileService(fstest.MapFS{}, "root", t.TempDir(), 1<<20)
+	fileService.SetRetentionPolicy(service.RetentionPolicy{MaxAge: time.Hour})
+	for _, name := range []string{"stale.txt", "fresh.txt"} {
+		if _, err := fileService.SaveUpload(name, strings.NewReader(name), -1, "10.0.0.1", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stale := filepath.Join(fileService.UploadDir(), "stale.txt")
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	j := newJanitor(fileService)
+	go j.run()
+	j.stop()
+
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale upload kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(fileService.UploadDir(), "fresh.txt")); err != nil {
+		t.Errorf("fresh upload deleted: %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/m1kkY8/ctfserver/pkg/ipfilter"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/ratelimit"
+)
+
+// throttleChunk is the largest write passed on at once by a throttled download,
+// so bandwidth is spread evenly instead of in bursts
+const throttleChunk = 32 * 1024
+
+// rateLimit rejects requests once their source has used up its requests for the route group
+func rateLimit(limits *ratelimit.Limits, group string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr, ok := ipfilter.FromContext(r.Context())
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		allowed, retryAfter := limits.Allow(group, addr)
+		if allowed {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		logger.Logger.WithFields(map[string]interface{}{
+			"remote_addr": r.RemoteAddr,
+			"client_ip":   addr.String(),
+			"path":        r.URL.Path,
+			"group":       group,
+			"retry_after": retryAfter.String(),
+		}).Warn("Rate limited request")
+
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		writeError(w, "Too many requests", http.StatusTooManyRequests)
+	})
+}
+
+// limitUploads rejects uploads while the concurrent upload cap is reached
+func limitUploads(limits *ratelimit.Limits, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		release, ok := limits.AcquireUpload()
+		if !ok {
+			logger.Logger.WithFields(map[string]interface{}{
+				"remote_addr": r.RemoteAddr,
+				"path":        r.URL.Path,
+			}).Warn("Rejected upload over the concurrent upload cap")
+
+			w.Header().Set("Retry-After", "5")
+			writeError(w, "Too many concurrent uploads", http.StatusServiceUnavailable)
+			return
+		}
+		defer release()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// throttleDownloads limits the bandwidth of each response to the configured download rate
+func throttleDownloads(limits *ratelimit.Limits, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rate := limits.DownloadRate()
+		if rate <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(&throttledWriter{ResponseWriter: w, ctx: r.Context(), rate: rate, start: time.Now()}, r)
+	})
+}
+
+// throttledWriter paces writes so the response never exceeds rate bytes per
+// second, giving up once the request's context is done
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	rate    int64
+	start   time.Time
+	written int64
+}
+
+func (w *throttledWriter) Write(b []byte) (int, error) {
+	total := 0
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > throttleChunk {
+			chunk = chunk[:throttleChunk]
+		}
+
+		// Wait until the bytes written so far fit into the rate
+		due := w.start.Add(time.Duration(float64(w.written) / float64(w.rate) * float64(time.Second)))
+		if wait := time.Until(due); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-w.ctx.Done():
+				timer.Stop()
+				return total, w.ctx.Err()
+			}
+		}
+
+		n, err := w.ResponseWriter.Write(chunk)
+		total += n
+		w.written += int64(n)
+		if err != nil {
+			return total, err
+		}
+		b = b[n:]
+	}
+	return total, nil
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (w *throttledWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
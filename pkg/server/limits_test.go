@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestThrottledWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := &throttledWriter{ResponseWriter: rec, ctx: context.Background(), rate: 1 << 20, start: time.Now()}
+	start := time.Now()
+	if n, err := w.Write(make([]byte, 1<<19)); n != 1<<19 || err != nil {
+		t.Fatalf("Write() = %d, %v", n, err)
+	}
+	// The last chunk is due after half a second less one chunk
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("wrote 512KB at 1MB/s in %v", elapsed)
+	}
+}
+
+func TestThrottledWriterCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	rec := httptest.NewRecorder()
+	w := &throttledWriter{ResponseWriter: rec, ctx: ctx, rate: 1024, start: time.Now()}
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	n, err := w.Write(make([]byte, 1<<20))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Write() error %v, want %v", err, context.Canceled)
+	}
+	if n != throttleChunk || rec.Body.Len() != n {
+		t.Errorf("wrote %d bytes, %d passed on, want the first chunk of %d", n, rec.Body.Len(), throttleChunk)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("canceled write took %v", elapsed)
+	}
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/m1kkY8/ctfserver/pkg/config"
+)
+
+// listenerKey is the context key for the listener a request arrived on
+type listenerKey struct{}
+
+// withListener tags requests with the listener they arrived on
+func withListener(next http.Handler, listener config.ListenerConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), listenerKey{}, listener)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// routeGroup restricts a handler to listeners that serve the given route group
+func routeGroup(group string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		listener, ok := r.Context().Value(listenerKey{}).(config.ListenerConfig)
+		if ok && !listener.Serves(group) {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/m1kkY8/ctfserver/pkg/config"
+)
+
+func TestRouteGroup(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	mux := http.NewServeMux()
+	mux.Handle("/files/", routeGroup(config.RouteFiles, ok))
+	mux.Handle("/upload", routeGroup(config.RouteUpload, ok))
+
+	tests := []struct {
+		listener *config.ListenerConfig
+		path     string
+		wants    int
+	}{
+		{nil, "/files/a", http.StatusOK},
+		{nil, "/upload", http.StatusOK},
+		{&config.ListenerConfig{Addr: ":80"}, "/files/a", http.StatusOK},
+		{&config.ListenerConfig{Addr: ":80"}, "/upload", http.StatusOK},
+		{&config.ListenerConfig{Addr: ":80", Routes: []string{config.RouteUpload}}, "/files/a", http.StatusNotFound},
+		{&config.ListenerConfig{Addr: ":80", Routes: []string{config.RouteUpload}}, "/upload", http.StatusOK},
+	}
+	for _, tt := range tests {
+		var handler http.Handler = mux
+		if tt.listener != nil {
+			handler = withListener(mux, *tt.listener)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.wants {
+			t.Errorf("GET %s on %v = %d, want %d", tt.path, tt.listener, rec.Code, tt.wants)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"errors"
+	"path/filepath"
+
+	"github.com/m1kkY8/ctfserver/pkg/builtin"
+	"github.com/m1kkY8/ctfserver/pkg/config"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+	"github.com/m1kkY8/ctfserver/pkg/vfs"
+)
+
+// openRoot builds the served root file system for cfg
+func openRoot(cfg *config.Config) (*vfs.UnionFS, error) {
+	rootFS, err := vfs.Open(cfg.RootDirs)
+	if err != nil {
+		return nil, err
+	}
+
+	// The embedded toolkit sits below everything on disk so RootDirs can override it
+	if cfg.Builtin && builtin.FS() != nil {
+		rootFS.Append(builtin.FS())
+	}
+	return rootFS, nil
+}
+
+// Reload re-reads the configuration and applies the settings that can change
+// at runtime without dropping connections: roots, upload directory, limits,
+// retention, credentials, address filters, canned responses and log level.
+// It returns the settings changed since the last applied configuration that
+// only apply after a restart. On error the running configuration is left
+// untouched.
+func (s *Server) Reload() ([]string, error) {
+	if s.reload == nil {
+		return nil, errors.New("configuration reload is not available")
+	}
+
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	cfg, err := s.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	rootFS, err := openRoot(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Downloads in flight keep reading from the previous archives, which are
+	// closed when the last of them finishes
+	s.rootFS.Replace(rootFS)
+	s.fileService.Reconfigure(vfs.DisplayName(cfg.RootDirs), cfg.UploadDir, cfg.MaxUploadSize)
+	s.fileService.SetStorageLimits(storageLimits(cfg))
+	s.fileService.SetRetentionPolicy(retentionPolicy(cfg))
+	s.auth.Configure(cfg)
+	s.ipFilter.Configure(cfg)
+	s.limits.Configure(cfg)
+	s.responses.Configure(cfg.Responses)
+	s.recorder.SetLogLimits(cfg.CaptureLogSize, cfg.MinFreeSpace)
+	if err := logger.SetLevel(cfg.LogLevel); err != nil {
+		logger.Logger.WithError(err).Warn("Failed to set log level")
+	}
+
+	restart := s.applied.RestartRequired(cfg)
+	s.applied = cfg
+	entry := logger.Logger.WithField("root", cfg.RootDirs)
+	if len(restart) > 0 {
+		entry = entry.WithField("restart_required", restart)
+	}
+	entry.Info("Configuration reloaded")
+
+	return restart, nil
+}
+
+// storageLimits returns the upload storage guards of cfg
+func storageLimits(cfg *config.Config) service.StorageLimits {
+	return service.StorageLimits{
+		MinFreeSpace: cfg.MinFreeSpace,
+		UploadQuota:  cfg.UploadQuota,
+		SourceQuota:  cfg.SourceQuota,
+	}
+}
+
+// captureLogPath returns the capture log of cfg, relative paths being in the
+// upload directory
+func captureLogPath(cfg *config.Config) string {
+	if cfg.CaptureLog == "" || filepath.IsAbs(cfg.CaptureLog) {
+		return cfg.CaptureLog
+	}
+	return filepath.Join(cfg.UploadDir, cfg.CaptureLog)
+}
+
+// retentionPolicy returns the upload retention policy of cfg
+func retentionPolicy(cfg *config.Config) service.RetentionPolicy {
+	return service.RetentionPolicy{
+		MaxAge:  cfg.RetentionMaxAge,
+		MaxSize: cfg.RetentionMaxSize,
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/m1kkY8/ctfserver/pkg/config"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+)
+
+func TestReloadRestartRequired(t *testing.T) {
+	logger.InitLogger("error")
+
+	rootDir, uploadDir := t.TempDir(), t.TempDir()
+	newConfig := func(hashHeader bool) *config.Config {
+		cfg := config.Default()
+		cfg.RootDirs = []string{rootDir}
+		cfg.UploadDir = uploadDir
+		cfg.CaptureLog = ""
+		cfg.LogLevel = "error"
+		cfg.HashHeader = hashHeader
+		return cfg
+	}
+	startup := newConfig(false)
+
+	var next *config.Config
+	s, err := NewServer(startup, func() (*config.Config, error) { return next, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next = newConfig(true)
+	restart, err := s.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(restart, []string{"hash-header"}) {
+		t.Errorf("first reload requires restart of %v, want [hash-header]", restart)
+	}
+
+	// A second reload compares against the applied configuration
+	next = newConfig(true)
+	if restart, err = s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if len(restart) != 0 {
+		t.Errorf("unchanged reload requires restart of %v", restart)
+	}
+}
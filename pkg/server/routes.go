@@ -0,0 +1,90 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/m1kkY8/ctfserver/pkg/config"
+	"github.com/m1kkY8/ctfserver/pkg/dav"
+)
+
+// transferGroups are the route groups that move file contents and get
+// progress-based deadlines instead of absolute ones
+var transferGroups = map[string]bool{
+	config.RouteFiles:  true,
+	config.RouteUpload: true,
+}
+
+// groupRoles maps each route group to the role required to use it
+var groupRoles = map[string]string{
+	config.RouteFiles:   config.RoleDownload,
+	config.RouteAPI:     config.RoleDownload, // Tree, hashes and du reveal the root's contents
+	config.RouteUpload:  config.RoleUpload,
+	config.RouteLoot:    config.RoleLoot,
+	config.RouteAdmin:   config.RoleAdmin,
+	config.RouteCapture: "", // Targets calling back have no credentials
+}
+
+// davReads are the WebDAV methods that don't change anything
+var davReads = map[string]bool{
+	http.MethodOptions: true,
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	"PROPFIND":         true,
+}
+
+// route wraps a handler with the per-route policies of its route group
+func (s *Server) route(group string, handler http.Handler) http.Handler {
+	return s.routeAs(group, groupRoles[group], handler)
+}
+
+// publicRoute is like route but never requires credentials
+func (s *Server) publicRoute(group string, handler http.Handler) http.Handler {
+	return s.routeAs(group, "", handler)
+}
+
+// routeAs wraps a handler with the policies of its route group. From the
+// outside in: source address filtering, rate limiting, role when
+// authentication is enabled and role is not empty, the concurrent upload
+// cap, deadlines and download bandwidth.
+func (s *Server) routeAs(group, role string, handler http.Handler) http.Handler {
+	if group == config.RouteFiles {
+		handler = throttleDownloads(s.limits, handler)
+	}
+	if transferGroups[group] {
+		handler = progressDeadline(s.config.TransferIdleTimeout, handler)
+	} else {
+		handler = absoluteDeadline(s.config.APITimeout, handler)
+	}
+	if group == config.RouteUpload {
+		handler = limitUploads(s.limits, handler)
+	}
+	if role != "" {
+		handler = requireRole(s.auth, role, handler)
+	}
+	handler = rateLimit(s.limits, group, handler)
+	handler = filterSource(s.ipFilter, group, handler)
+	return routeGroup(group, handler)
+}
+
+// davRoute wraps the WebDAV share at prefix with the policies of the route
+// group each request belongs to: reads of the root are downloads, reads of
+// the uploads directory are loot and every change is an upload
+func (s *Server) davRoute(prefix string, handler http.Handler) http.Handler {
+	files := s.route(config.RouteFiles, handler)
+	upload := s.route(config.RouteUpload, handler)
+	// Loot is read like any other download, not within an API deadline
+	loot := s.route(config.RouteLoot, progressDeadline(s.config.TransferIdleTimeout, handler))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, inUploads := dav.UploadName(strings.TrimPrefix(r.URL.Path, prefix))
+		switch {
+		case !davReads[r.Method]:
+			upload.ServeHTTP(w, r)
+		case inUploads && r.Method != http.MethodOptions:
+			loot.ServeHTTP(w, r)
+		default:
+			files.ServeHTTP(w, r)
+		}
+	})
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/m1kkY8/ctfserver/pkg/config"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+)
+
+func TestDiskUsageRoles(t *testing.T) {
+	logger.InitLogger("error")
+
+	cfg := config.Default()
+	cfg.RootDirs = []string{t.TempDir()}
+	cfg.UploadDir = t.TempDir()
+	cfg.CaptureLog = ""
+	cfg.Users = []config.UserConfig{
+		{Name: "looter", Password: "secret", Roles: []string{config.RoleLoot}},
+	}
+	cfg.Anonymous = []string{config.RoleDownload}
+	if err := os.WriteFile(filepath.Join(cfg.UploadDir, "creds.txt"), []byte("loot"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewServer(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(s.setupRoutes())
+	defer server.Close()
+
+	tests := []struct {
+		path  string
+		loot  bool
+		wants int
+	}{
+		{"/api/v1/du", false, http.StatusOK},
+		{"/api/v1/du?dir=uploads", false, http.StatusUnauthorized},
+		{"/api/v1/du?dir=loot", false, http.StatusUnauthorized},
+		{"/api/v1/loot", false, http.StatusUnauthorized},
+		{"/api/v1/du?dir=uploads", true, http.StatusOK},
+		{"/api/v1/du?dir=loot", true, http.StatusOK},
+		{"/api/v1/loot", true, http.StatusOK},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+tt.path, nil)
+		if tt.loot {
+			req.SetBasicAuth("looter", "secret")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.wants {
+			t.Errorf("GET %s (loot %v) = %d, want %d", tt.path, tt.loot, resp.StatusCode, tt.wants)
+		}
+	}
+}
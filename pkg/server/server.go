@@ -2,84 +2,344 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/m1kkY8/ctfserver/pkg/auth"
+	"github.com/m1kkY8/ctfserver/pkg/canned"
+	"github.com/m1kkY8/ctfserver/pkg/capture"
+	"github.com/m1kkY8/ctfserver/pkg/certs"
 	"github.com/m1kkY8/ctfserver/pkg/config"
+	"github.com/m1kkY8/ctfserver/pkg/dnsexfil"
+	"github.com/m1kkY8/ctfserver/pkg/drop"
+	"github.com/m1kkY8/ctfserver/pkg/ftp"
 	"github.com/m1kkY8/ctfserver/pkg/handlers"
+	"github.com/m1kkY8/ctfserver/pkg/ipfilter"
 	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/ratelimit"
 	"github.com/m1kkY8/ctfserver/pkg/service"
+	"github.com/m1kkY8/ctfserver/pkg/smb"
+	"github.com/m1kkY8/ctfserver/pkg/sshd"
+	"github.com/m1kkY8/ctfserver/pkg/tftp"
+	"github.com/m1kkY8/ctfserver/pkg/util"
+	"github.com/m1kkY8/ctfserver/pkg/vfs"
 )
 
 const version = "1.0.0"
 
 // Server represents the HTTP server
 type Server struct {
-	config      *config.Config
-	httpServer  *http.Server
+	config      *config.Config // Configuration the server was started with
+	applied     *config.Config // Configuration last applied, guarded by reloadMu
+	reload      func() (*config.Config, error)
+	reloadMu    sync.Mutex
+	httpServers []*http.Server
+	binders     []*interfaceBinder
+	auth        *auth.Authenticator
+	ipFilter    *ipfilter.Filter
+	limits      *ratelimit.Limits
+	rootFS      *vfs.UnionFS
 	fileService *service.FileService
+	janitor     *janitor
+	recorder    *capture.Recorder
+	responses   *canned.Table
+	smbServer   *smb.Server      // Nil unless SMB shares are enabled
+	ftpServer   *ftp.Server      // Nil unless the FTP server is enabled
+	tftpServer  *tftp.Server     // Nil unless the TFTP server is enabled
+	sshServer   *sshd.Server     // Nil unless the SSH server is enabled
+	dnsServer   *dnsexfil.Server // Nil unless the DNS server is enabled
+	dropServer  *drop.Server     // Nil unless drop listeners are configured
 }
 
-// NewServer creates a new server instance
-func NewServer(cfg *config.Config) *Server {
-	fileService := service.NewFileService(cfg.RootDir, cfg.UploadDir, cfg.MaxUploadSize)
+// NewServer creates a new server instance; reload, if not nil, loads a fresh
+// configuration on SIGHUP or POST /api/v1/admin/reload
+func NewServer(cfg *config.Config, reload func() (*config.Config, error)) (*Server, error) {
+	rootFS, err := openRoot(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	fileService := service.NewFileService(rootFS, vfs.DisplayName(cfg.RootDirs), cfg.UploadDir, cfg.MaxUploadSize)
+	fileService.SetStorageLimits(storageLimits(cfg))
+	fileService.SetRetentionPolicy(retentionPolicy(cfg))
 
-	return &Server{
+	s := &Server{
 		config:      cfg,
+		applied:     cfg,
+		reload:      reload,
+		auth:        auth.New(cfg),
+		ipFilter:    ipfilter.New(cfg),
+		limits:      ratelimit.New(cfg),
+		rootFS:      rootFS,
 		fileService: fileService,
+		janitor:     newJanitor(fileService),
+		recorder:    capture.New(captureLogPath(cfg), cfg.CaptureHistory, cfg.CaptureBody),
+		responses:   canned.New(cfg.Responses),
+	}
+	s.recorder.SetLogLimits(cfg.CaptureLogSize, cfg.MinFreeSpace)
+	if cfg.SMBListen != "" {
+		s.smbServer = smb.New(fileService, s.auth, s.ipFilter)
+	}
+	if cfg.FTPListen != "" {
+		var minPort, maxPort int
+		if cfg.FTPPassivePorts != "" {
+			if minPort, maxPort, err = config.ParsePortRange(cfg.FTPPassivePorts); err != nil {
+				return nil, err
+			}
+		}
+		s.ftpServer = ftp.New(fileService, s.auth, s.ipFilter, minPort, maxPort)
+	}
+	if cfg.TFTPListen != "" {
+		s.tftpServer = tftp.New(fileService, s.auth, s.ipFilter)
+	}
+	if cfg.SSHListen != "" {
+		hostKey, err := sshd.LoadHostKey(cfg.SSHHostKey)
+		if err != nil {
+			return nil, err
+		}
+		s.sshServer = sshd.New(fileService, s.auth, s.ipFilter, hostKey, cfg.SSHAuthorizedKeys)
+	}
+	if cfg.DNSListen != "" {
+		s.dnsServer = dnsexfil.New(fileService, s.auth, cfg.DNSZone)
+	}
+	if len(cfg.Drops) > 0 {
+		s.dropServer = drop.New(fileService, s.auth, s.ipFilter, cfg.TransferIdleTimeout)
 	}
+	return s, nil
 }
 
-// Start starts the HTTP server
+// Start starts the HTTP server on every configured listener
 func (s *Server) Start() error {
 	// Initialize logger
 	logger.InitLogger(s.config.LogLevel)
 
-	// Create router
+	// Create router, shared by all listeners
 	router := s.setupRoutes()
 
-	// Create HTTP server
-	addr := fmt.Sprintf("%s:%d", s.config.Host, s.config.Port)
-	s.httpServer = &http.Server{
-		Addr:         addr,
-		Handler:      router,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+	if s.auth.Enabled() {
+		logger.Logger.WithFields(map[string]interface{}{
+			"users":     len(s.config.Users),
+			"tokens":    len(s.config.Tokens),
+			"anonymous": s.config.Anonymous,
+		}).Info("Authentication enabled")
+	}
+
+	// Load the certificate once for all TLS listeners
+	var tlsConfig *tls.Config
+	for _, listener := range s.config.Listeners {
+		if !listener.TLS {
+			continue
+		}
+		cert, err := s.loadCertificate()
+		if err != nil {
+			return err
+		}
+		tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+		logger.Logger.WithField("fingerprint", certs.Fingerprint(cert)).Info("TLS enabled")
+		host, port := splitListenerAddr(listener.Addr)
+		printTLSBanner(cert, advertiseHost(host), port)
+		break
+	}
+
+	// Create one HTTP server per listener
+	for _, listener := range s.config.Listeners {
+		httpServer := &http.Server{
+			Addr:              listener.Addr,
+			Handler:           withListener(router, listener),
+			ReadHeaderTimeout: s.config.ReadHeaderTimeout,
+			ReadTimeout:       s.config.ReadTimeout,
+			WriteTimeout:      s.config.WriteTimeout,
+			IdleTimeout:       s.config.IdleTimeout,
+		}
+		if listener.TLS {
+			httpServer.TLSConfig = tlsConfig
+		}
+		s.httpServers = append(s.httpServers, httpServer)
 	}
 
 	// Channel to listen for interrupt signal
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
-	// Start server in a goroutine
-	go func() {
-		logger.Logger.WithField("addr", addr).Info("Starting CTF file server")
-		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Logger.WithError(err).Fatal("Failed to start server")
+	// SIGHUP reloads the configuration
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	// Start servers in goroutines
+	serveErrors := make(chan error, len(s.httpServers)+len(s.config.Drops)+5)
+	for i, httpServer := range s.httpServers {
+		listener := s.config.Listeners[i]
+
+		// Listeners bound by interface name follow the interface's addresses,
+		// waiting for interfaces that don't exist yet
+		if host, port, err := net.SplitHostPort(listener.Addr); err == nil && util.MayBeInterfaceName(host) {
+			binder := newInterfaceBinder(host, port, listener.TLS, httpServer)
+			binder.sync()
+			go binder.run()
+			s.binders = append(s.binders, binder)
+			continue
 		}
-	}()
 
-	// Wait for interrupt signal
-	<-stop
+		go func() {
+			logger.Logger.WithFields(map[string]interface{}{
+				"addr":   listener.Addr,
+				"tls":    listener.TLS,
+				"routes": listener.Routes,
+			}).Info("Starting CTF file server")
+
+			var err error
+			if listener.TLS {
+				err = httpServer.ListenAndServeTLS("", "")
+			} else {
+				err = httpServer.ListenAndServe()
+			}
+			if err != nil && err != http.ErrServerClosed {
+				serveErrors <- fmt.Errorf("listener %s: %w", listener.Addr, err)
+			}
+		}()
+	}
+
+	printURLBanner(s.listenerInfo())
+
+	// SMB shares for Windows targets
+	if s.smbServer != nil {
+		s.startSMB(serveErrors)
+	}
+
+	// FTP for targets with only ftp.exe or busybox
+	if s.ftpServer != nil {
+		s.startFTP(serveErrors)
+	}
+
+	// TFTP for network devices and PXE-booted hosts
+	if s.tftpServer != nil {
+		s.startTFTP(serveErrors)
+	}
+
+	// SFTP and scp for targets with an OpenSSH client
+	if s.sshServer != nil {
+		s.startSSH(serveErrors)
+	}
+
+	// Raw TCP ports for targets with only netcat or /dev/tcp
+	if s.dropServer != nil {
+		s.startDrops(serveErrors)
+	}
+
+	// DNS for targets that can only resolve names
+	if s.dnsServer != nil {
+		s.startDNS(serveErrors)
+	}
+
+	// Delete expired uploads in the background
+	go s.janitor.run()
+
+	// Wait for interrupt signal or a failed listener, reloading on SIGHUP
+	var serveErr error
+wait:
+	for {
+		select {
+		case <-hangup:
+			if _, err := s.Reload(); err != nil {
+				logger.Logger.WithError(err).Error("Failed to reload configuration")
+			}
+		case <-stop:
+			break wait
+		case serveErr = <-serveErrors:
+			logger.Logger.WithError(serveErr).Error("Listener failed")
+			break wait
+		}
+	}
 	logger.Logger.Info("Shutting down server...")
 
-	// Graceful shutdown with timeout
+	for _, binder := range s.binders {
+		binder.stop()
+	}
+	s.janitor.stop()
+	if s.smbServer != nil {
+		if err := s.smbServer.Close(); err != nil {
+			logger.Logger.WithError(err).Warn("Failed to close SMB server")
+		}
+	}
+	if s.ftpServer != nil {
+		if err := s.ftpServer.Close(); err != nil {
+			logger.Logger.WithError(err).Warn("Failed to close FTP server")
+		}
+	}
+	if s.tftpServer != nil {
+		if err := s.tftpServer.Close(); err != nil {
+			logger.Logger.WithError(err).Warn("Failed to close TFTP server")
+		}
+	}
+	if s.sshServer != nil {
+		if err := s.sshServer.Close(); err != nil {
+			logger.Logger.WithError(err).Warn("Failed to close SSH server")
+		}
+	}
+	if s.dnsServer != nil {
+		if err := s.dnsServer.Close(); err != nil {
+			logger.Logger.WithError(err).Warn("Failed to close DNS server")
+		}
+	}
+	if s.dropServer != nil {
+		if err := s.dropServer.Close(); err != nil {
+			logger.Logger.WithError(err).Warn("Failed to close drop listeners")
+		}
+	}
+
+	// Graceful shutdown of all listeners with a shared timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	if err := s.httpServer.Shutdown(ctx); err != nil {
+	var wg sync.WaitGroup
+	shutdownErrors := make(chan error, len(s.httpServers))
+	for _, httpServer := range s.httpServers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := httpServer.Shutdown(ctx); err != nil {
+				shutdownErrors <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(shutdownErrors)
+
+	if err := <-shutdownErrors; err != nil {
 		logger.Logger.WithError(err).Error("Server forced to shutdown")
 		return err
 	}
 
+	if err := s.recorder.Close(); err != nil {
+		logger.Logger.WithError(err).Warn("Failed to close capture log")
+	}
+
+	if err := s.rootFS.Close(); err != nil {
+		logger.Logger.WithError(err).Warn("Failed to close root archives")
+	}
+
 	logger.Logger.Info("Server exited")
-	return nil
+	return serveErr
+}
+
+// loadCertificate returns the configured certificate or a self-signed one
+func (s *Server) loadCertificate() (tls.Certificate, error) {
+	if s.config.TLSCertFile != "" || s.config.TLSKeyFile != "" {
+		return certs.Load(s.config.TLSCertFile, s.config.TLSKeyFile)
+	}
+	return certs.SelfSigned(s.config.TLSCertDir)
 }
 
 // setupRoutes configures the HTTP routes
@@ -93,37 +353,121 @@ func (s *Server) setupRoutes() *mux.Router {
 	// API routes
 	apiRouter := router.PathPrefix("/api/v1").Subrouter()
 
-	// Health check
+	// Server info, including the URLs each listener is reachable at
+	infoHandler := handlers.NewInfoHandler(version, s.listenerInfo)
+	apiRouter.Handle("/info", s.route(config.RouteAPI, infoHandler)).Methods("GET")
+
+	// Health check, public so monitoring works without credentials
 	healthHandler := handlers.NewHealthHandler(version)
-	apiRouter.Handle("/health", healthHandler).Methods("GET")
+	apiRouter.Handle("/health", s.publicRoute(config.RouteAPI, healthHandler)).Methods("GET")
 
 	// File tree endpoint
 	fileTreeHandler := handlers.NewFileTreeHandler(s.fileService)
-	apiRouter.Handle("/filetree", fileTreeHandler).Methods("GET")
+	apiRouter.Handle("/filetree", s.route(config.RouteAPI, fileTreeHandler)).Methods("GET")
 
 	// Pretty file tree endpoint (human-readable, defaults to plain text)
 	prettyFileTreeHandler := handlers.NewPrettyFileTreeHandler(s.fileService)
-	apiRouter.Handle("/filetree/pretty", prettyFileTreeHandler).Methods("GET")
+	apiRouter.Handle("/filetree/pretty", s.route(config.RouteAPI, prettyFileTreeHandler)).Methods("GET")
 
 	// Shorter aliases for convenience
-	apiRouter.Handle("/tree", prettyFileTreeHandler).Methods("GET") // Short alias for pretty tree
-	apiRouter.Handle("/ls", prettyFileTreeHandler).Methods("GET")   // Unix-style alias
+	apiRouter.Handle("/tree", s.route(config.RouteAPI, prettyFileTreeHandler)).Methods("GET") // Short alias for pretty tree
+	apiRouter.Handle("/ls", s.route(config.RouteAPI, prettyFileTreeHandler)).Methods("GET")   // Unix-style alias
 
 	// Upload endpoint
 	uploadHandler := handlers.NewUploadHandler(s.fileService)
-	apiRouter.Handle("/upload", uploadHandler).Methods("POST")
+	apiRouter.Handle("/upload", s.route(config.RouteUpload, uploadHandler)).Methods("POST")
+
+	// Upload storage usage and remaining quota, for anyone allowed to upload
+	statsHandler := handlers.NewStatsHandler(s.fileService)
+	apiRouter.Handle("/stats", s.routeAs(config.RouteAPI, config.RoleUpload, statsHandler)).Methods("GET")
+
+	// Dry run of the retention policy
+	retentionPreviewHandler := handlers.NewRetentionPreviewHandler(s.fileService)
+	apiRouter.Handle("/retention/preview", s.route(config.RouteLoot, retentionPreviewHandler)).Methods("GET")
 
 	// Uploads list endpoint
 	uploadsListHandler := handlers.NewUploadsListHandler(s.fileService)
-	apiRouter.Handle("/uploads", uploadsListHandler).Methods("GET")
+	apiRouter.Handle("/uploads", s.route(config.RouteLoot, uploadsListHandler)).Methods("GET")
 
 	// Short alias for uploads list
-	apiRouter.Handle("/ul", uploadsListHandler).Methods("GET")   // Short alias for uploads list
-	apiRouter.Handle("/loot", uploadsListHandler).Methods("GET") // Short alias for uploads list
+	apiRouter.Handle("/ul", s.route(config.RouteLoot, uploadsListHandler)).Methods("GET")   // Short alias for uploads list
+	apiRouter.Handle("/loot", s.route(config.RouteLoot, uploadsListHandler)).Methods("GET") // Short alias for uploads list
+
+	// File hashes endpoint. Hashing or walking a large root on a cold cache
+	// writes nothing for a long time, so neither gets an API deadline.
+	hashesHandler := handlers.NewHashesHandler(s.fileService)
+	apiRouter.Handle("/hashes", s.route(config.RouteAPI, clearDeadlines(hashesHandler))).Methods("GET")
+
+	// Disk usage endpoint. Usage of the upload directory names every loot
+	// file, so it is guarded like the loot listing.
+	diskUsageHandler := clearDeadlines(handlers.NewDiskUsageHandler(s.fileService))
+	rootDiskUsage := s.route(config.RouteAPI, diskUsageHandler)
+	lootDiskUsage := s.route(config.RouteLoot, diskUsageHandler)
+	apiRouter.Handle("/du", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if service.IsUploadsDiskUsage(r.URL.Query().Get("dir")) {
+			lootDiskUsage.ServeHTTP(w, r)
+			return
+		}
+		rootDiskUsage.ServeHTTP(w, r)
+	})).Methods("GET")
+
+	// Embedded toolkit manifest
+	builtinHandler := handlers.NewBuiltinHandler()
+	apiRouter.Handle("/builtin", s.route(config.RouteAPI, builtinHandler)).Methods("GET")
+
+	// Requests captured below /c/
+	hitsHandler := handlers.NewHitsHandler(s.recorder)
+	apiRouter.Handle("/hits", s.route(config.RouteLoot, hitsHandler)).Methods("GET")
+
+	// Sender one-liners of the DNS exfiltration zone
+	onelinerHandler := handlers.NewOnelinerHandler(s.dnsZone())
+	apiRouter.Handle("/oneliner/dns", s.route(config.RouteAPI, onelinerHandler)).Methods("GET")
+
+	// Canned responses, changed at runtime
+	routesHandler := s.route(config.RouteAdmin, handlers.NewRoutesHandler(s.responses))
+	apiRouter.Handle("/routes", routesHandler).Methods("GET", "POST")
+	apiRouter.Handle("/routes/{id:[0-9]+}", routesHandler).Methods("PUT", "DELETE")
+
+	// Configuration reload
+	reloadHandler := handlers.NewReloadHandler(s.Reload)
+	apiRouter.Handle("/admin/reload", s.route(config.RouteAdmin, reloadHandler)).Methods("POST")
+
+	// WebDAV share, also answering the mini-redirector's OPTIONS probe of the server root
+	davHandler := s.davRoute("/dav", handlers.NewWebDAVHandler(s.fileService, "/dav"))
+	router.Handle("/dav", davHandler)
+	router.PathPrefix("/dav/").Handler(davHandler)
+	router.Handle("/", davHandler).Methods(http.MethodOptions)
 
 	// Static file server for downloads
-	fs := http.StripPrefix("/files/", http.FileServer(http.Dir(s.config.RootDir)))
-	router.PathPrefix("/files/").Handler(fs)
+	filesHandler := handlers.NewFilesHandler(s.fileService, s.config.HashHeader)
+	router.PathPrefix("/files/").Handler(s.route(config.RouteFiles, http.StripPrefix("/files/", filesHandler)))
+
+	// Callback capture for blind SSRF, XXE and XSS, any method
+	captureHandler := s.route(config.RouteCapture, handlers.NewCaptureHandler(s.recorder, http.StatusOK))
+	router.Handle("/c", captureHandler)
+	router.PathPrefix("/c/").Handler(captureHandler)
+
+	// Data leaked by XSS and blind injection payloads, saved as loot
+	exfilHandler := s.route(config.RouteCapture, handlers.NewExfilHandler(s.fileService, "/x", s.config.ExfilResponse))
+	router.Handle("/x", exfilHandler)
+	router.PathPrefix("/x/").Handler(exfilHandler)
+
+	// Canned responses for SSRF and open redirect testing, after the routes
+	// above so they can't shadow them. Answers get the idle deadline of
+	// transfers rather than the API deadline, and the handler lifts it while
+	// a delay holds the answer back.
+	cannedHandler := handlers.NewCannedHandler(s.responses, s.fileService, s.recorder)
+	router.MatcherFunc(func(r *http.Request, _ *mux.RouteMatch) bool {
+		_, ok := s.responses.Match(r.Method, r.URL.Path)
+		return ok
+	}).Handler(s.route(config.RouteCapture, progressDeadline(s.config.TransferIdleTimeout, cannedHandler)))
+
+	// Paths no route matches, still answered 404. Middleware only runs on
+	// matched routes, so the not found handler is wrapped itself.
+	if s.config.CaptureUnmatched {
+		unmatchedHandler := s.route(config.RouteCapture, handlers.NewCaptureHandler(s.recorder, http.StatusNotFound))
+		router.NotFoundHandler = logger.RecoveryMiddleware(logger.LoggingMiddleware(unmatchedHandler))
+	}
 
 	return router
 }
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/smb"
+	"github.com/m1kkY8/ctfserver/pkg/util"
+)
+
+// startSMB serves the SMB shares in the background, reporting a failed
+// listener on serveErrors
+func (s *Server) startSMB(serveErrors chan<- error) {
+	addr := s.config.SMBListen
+	host, port, _ := net.SplitHostPort(addr)
+
+	// An interface name binds its first address, SMB clients can't pick another port anyway
+	if util.IsInterfaceName(host) {
+		ips := util.InterfaceIPs(host)
+		if len(ips) == 0 {
+			serveErrors <- fmt.Errorf("smb listener %s: interface has no addresses", addr)
+			return
+		}
+		addr = net.JoinHostPort(ips[0].String(), port)
+	}
+
+	logger.Logger.WithField("addr", addr).Info("Starting SMB server")
+	go func() {
+		if err := s.smbServer.ListenAndServe(addr); err != nil && err != smb.ErrServerClosed {
+			serveErrors <- fmt.Errorf("smb listener %s: %w", addr, err)
+		}
+	}()
+
+	printSMBBanner(advertiseHost(host), port)
+}
+
+// printSMBBanner prints the shares and how Windows targets use them
+func printSMBBanner(host, port string) {
+	fmt.Fprintln(os.Stdout, "SMB shares:")
+	fmt.Fprintf(os.Stdout, "  download: copy \\\\%s\\%s\\FILE .\n", host, smb.FilesShare)
+	fmt.Fprintf(os.Stdout, "  upload:   copy FILE \\\\%s\\%s\\\n", host, smb.UploadsShare)
+	if port != "445" {
+		fmt.Fprintf(os.Stdout, "  (port %s: Windows only connects to port 445, forward it there)\n", port)
+	}
+}
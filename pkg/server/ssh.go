@@ -0,0 +1,54 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/sshd"
+	"github.com/m1kkY8/ctfserver/pkg/util"
+)
+
+// startSSH serves SFTP and scp in the background, reporting a failed
+// listener on serveErrors
+func (s *Server) startSSH(serveErrors chan<- error) {
+	addr := s.config.SSHListen
+	host, port, _ := net.SplitHostPort(addr)
+
+	// An interface name binds its first address
+	if util.IsInterfaceName(host) {
+		ips := util.InterfaceIPs(host)
+		if len(ips) == 0 {
+			serveErrors <- fmt.Errorf("ssh listener %s: interface has no addresses", addr)
+			return
+		}
+		addr = net.JoinHostPort(ips[0].String(), port)
+	}
+
+	logger.Logger.WithFields(map[string]interface{}{
+		"addr":        addr,
+		"fingerprint": s.sshServer.HostKeyFingerprint(),
+	}).Info("Starting SSH server")
+	go func() {
+		if err := s.sshServer.ListenAndServe(addr); err != nil && err != sshd.ErrServerClosed {
+			serveErrors <- fmt.Errorf("ssh listener %s: %w", addr, err)
+		}
+	}()
+
+	printSSHBanner(advertiseHost(host), port, s.sshServer.HostKeyFingerprint())
+}
+
+// printSSHBanner prints the host key and how OpenSSH clients, including
+// those of Windows, fetch and store files
+func printSSHBanner(host, port, fingerprint string) {
+	scp, sftp := "scp", "sftp"
+	if port != "22" {
+		scp = "scp -P " + port
+		sftp = "sftp -P " + port
+	}
+	fmt.Fprintf(os.Stdout, "SSH server (host key %s):\n", fingerprint)
+	fmt.Fprintf(os.Stdout, "  download: %s %s:FILE .\n", scp, host)
+	fmt.Fprintf(os.Stdout, "  upload:   %s FILE %s:\n", scp, host)
+	fmt.Fprintf(os.Stdout, "  browse:   %s %s\n", sftp, host)
+}
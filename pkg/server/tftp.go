@@ -0,0 +1,51 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/tftp"
+	"github.com/m1kkY8/ctfserver/pkg/util"
+)
+
+// startTFTP serves TFTP in the background, reporting a failed listener on
+// serveErrors
+func (s *Server) startTFTP(serveErrors chan<- error) {
+	addr := s.config.TFTPListen
+	host, port, _ := net.SplitHostPort(addr)
+
+	// An interface name binds its first address, so replies come from the address clients sent to
+	if util.IsInterfaceName(host) {
+		ips := util.InterfaceIPs(host)
+		if len(ips) == 0 {
+			serveErrors <- fmt.Errorf("tftp listener %s: interface has no addresses", addr)
+			return
+		}
+		addr = net.JoinHostPort(ips[0].String(), port)
+	}
+
+	logger.Logger.WithField("addr", addr).Info("Starting TFTP server")
+	go func() {
+		if err := s.tftpServer.ListenAndServe(addr); err != nil && err != tftp.ErrServerClosed {
+			serveErrors <- fmt.Errorf("tftp listener %s: %w", addr, err)
+		}
+	}()
+
+	printTFTPBanner(advertiseHost(host), port)
+}
+
+// printTFTPBanner prints how busybox and Windows targets fetch and store files
+func printTFTPBanner(host, port string) {
+	target := host
+	if port != "69" {
+		target += " " + port
+	}
+	fmt.Fprintln(os.Stdout, "TFTP server:")
+	fmt.Fprintf(os.Stdout, "  download: tftp -g -r FILE %s\n", target)
+	fmt.Fprintf(os.Stdout, "  upload:   tftp -p -l FILE %s\n", target)
+	if port == "69" {
+		fmt.Fprintf(os.Stdout, "  windows:  tftp -i %s GET FILE / tftp -i %s PUT FILE\n", host, host)
+	}
+}
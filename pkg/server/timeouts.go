@@ -0,0 +1,169 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+)
+
+// deadlineGranularity limits how often progress extends a deadline
+const deadlineGranularity = time.Second
+
+// readFromChunk is how much a progressWriter hands to sendfile at once
+// before extending its deadline
+const readFromChunk = 128 << 10
+
+// absoluteDeadline gives the whole request a fixed time budget
+func absoluteDeadline(timeout time.Duration, next http.Handler) http.Handler {
+	if timeout <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		deadline := time.Now().Add(timeout)
+		if err := rc.SetReadDeadline(deadline); err != nil {
+			logger.Logger.WithError(err).Debug("Failed to set read deadline")
+		}
+		if err := rc.SetWriteDeadline(deadline); err != nil {
+			logger.Logger.WithError(err).Debug("Failed to set write deadline")
+		}
+		defer resetWriteDeadline(rc)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// progressDeadline aborts transfers that stop making progress: every read of
+// the request body and every write of the response pushes the deadline back,
+// so slow but alive transfers of any size complete
+func progressDeadline(idle time.Duration, next http.Handler) http.Handler {
+	if idle <= 0 {
+		return clearDeadlines(next)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		deadlines := &idleDeadlines{rc: rc, idle: idle}
+		deadlines.extendWrite(true)
+		defer resetWriteDeadline(rc)
+
+		// Without a body, an expiring read deadline would only trip the
+		// server's background read and cancel a healthy download
+		if r.Body != nil && r.Body != http.NoBody {
+			deadlines.extendRead(true)
+			r.Body = &progressReader{ReadCloser: r.Body, deadlines: deadlines}
+		} else {
+			rc.SetReadDeadline(time.Time{})
+		}
+		next.ServeHTTP(&progressWriter{ResponseWriter: w, deadlines: deadlines}, r)
+	})
+}
+
+// clearDeadlines lifts any server-wide deadlines for routes that have none
+func clearDeadlines(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		rc.SetReadDeadline(time.Time{})
+		rc.SetWriteDeadline(time.Time{})
+		next.ServeHTTP(w, r)
+	})
+}
+
+// resetWriteDeadline keeps a per-route deadline from leaking into the next
+// request on a keep-alive connection; net/http only resets it when the
+// server-wide WriteTimeout is set
+func resetWriteDeadline(rc *http.ResponseController) {
+	rc.SetWriteDeadline(time.Time{})
+}
+
+// idleDeadlines tracks and extends the read and write deadlines of one request
+type idleDeadlines struct {
+	rc            *http.ResponseController
+	idle          time.Duration
+	lastReadMove  time.Time
+	lastWriteMove time.Time
+}
+
+func (d *idleDeadlines) extendRead(force bool) {
+	now := time.Now()
+	if !force && now.Sub(d.lastReadMove) < deadlineGranularity {
+		return
+	}
+	d.lastReadMove = now
+	d.rc.SetReadDeadline(now.Add(d.idle))
+}
+
+func (d *idleDeadlines) extendWrite(force bool) {
+	now := time.Now()
+	if !force && now.Sub(d.lastWriteMove) < deadlineGranularity {
+		return
+	}
+	d.lastWriteMove = now
+	d.rc.SetWriteDeadline(now.Add(d.idle))
+}
+
+// progressReader extends the read deadline as the request body is consumed
+type progressReader struct {
+	io.ReadCloser
+	deadlines *idleDeadlines
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	r.deadlines.extendRead(false)
+	n, err := r.ReadCloser.Read(p)
+	if err == io.EOF {
+		// The body is done, don't let the deadline expire while responding
+		r.deadlines.rc.SetReadDeadline(time.Time{})
+	}
+	return n, err
+}
+
+// progressWriter extends the write deadline as the response is written
+type progressWriter struct {
+	http.ResponseWriter
+	deadlines *idleDeadlines
+}
+
+func (w *progressWriter) Write(b []byte) (int, error) {
+	w.deadlines.extendWrite(false)
+	return w.ResponseWriter.Write(b)
+}
+
+// ReadFrom keeps the sendfile path of the underlying writer, extending the
+// deadline between chunks of readFromChunk bytes
+func (w *progressWriter) ReadFrom(src io.Reader) (int64, error) {
+	rf, ok := w.ResponseWriter.(io.ReaderFrom)
+	if !ok {
+		return io.Copy(struct{ io.Writer }{w}, src)
+	}
+
+	// sendfile only sees through one io.LimitedReader, so the limit of
+	// src is folded into each chunk rather than wrapped
+	limited, isLimited := src.(*io.LimitedReader)
+	var total int64
+	for {
+		chunk := &io.LimitedReader{R: src, N: readFromChunk}
+		if isLimited {
+			chunk.R, chunk.N = limited.R, min(limited.N, readFromChunk)
+		}
+		if chunk.N <= 0 {
+			return total, nil
+		}
+		want := chunk.N
+
+		w.deadlines.extendWrite(false)
+		n, err := rf.ReadFrom(chunk)
+		total += n
+		if isLimited {
+			limited.N -= n
+		}
+		if err != nil || n < want {
+			return total, err
+		}
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (w *progressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/m1kkY8/ctfserver/pkg/canned"
+	"github.com/m1kkY8/ctfserver/pkg/capture"
+	"github.com/m1kkY8/ctfserver/pkg/config"
+	"github.com/m1kkY8/ctfserver/pkg/handlers"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+)
+
+// readFromRecorder records whether the response was written through ReadFrom
+type readFromRecorder struct {
+	http.ResponseWriter
+	readFrom bool
+}
+
+func (w *readFromRecorder) ReadFrom(src io.Reader) (int64, error) {
+	w.readFrom = true
+	return io.Copy(struct{ io.Writer }{w.ResponseWriter}, src)
+}
+
+func TestProgressDeadlineKeepsReadFrom(t *testing.T) {
+	logger.InitLogger("error")
+
+	// Larger than a chunk so the deadline is extended between chunks
+	content := bytes.Repeat([]byte("0123456789abcdef"), readFromChunk/16*3+5)
+	name := filepath.Join(t.TempDir(), "payload.bin")
+	if err := os.WriteFile(name, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var recorder *readFromRecorder
+	handler := progressDeadline(time.Minute, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, name)
+	}))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder = &readFromRecorder{ResponseWriter: w}
+		logger.LoggingMiddleware(handler).ServeHTTP(recorder, r)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		rangeHeader string
+		want        []byte
+	}{
+		{"", content},
+		{"bytes=10-", content[10:]},
+		{fmt.Sprintf("bytes=5-%d", readFromChunk+6), content[5 : readFromChunk+7]},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		if tt.rangeHeader != "" {
+			req.Header.Set("Range", tt.rangeHeader)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("range %q: %v after %d bytes", tt.rangeHeader, err, len(body))
+		}
+		if !bytes.Equal(body, tt.want) {
+			t.Errorf("range %q: got %d bytes, want %d", tt.rangeHeader, len(body), len(tt.want))
+		}
+		if !recorder.readFrom {
+			t.Errorf("range %q: response wasn't written through ReadFrom", tt.rangeHeader)
+		}
+	}
+}
+
+func TestCannedDelayOutlastsIdleTimeout(t *testing.T) {
+	logger.InitLogger("error")
+	const idle = 200 * time.Millisecond
+	// Larger than the buffer of the connection, so it is written before the
+	// handler returns
+	content := strings.Repeat("late answer\n", 1<<14)
+	table := canned.New([]config.ResponseRule{{Path: "/slow", Body: content, Delay: 3 * idle}})
+	fileService := service.NewFileService(fstest.MapFS{}, "root", t.TempDir(), 1<<20)
+	recorder := capture.New("", 10, 1024)
+	defer recorder.Close()
+	server := httptest.NewServer(progressDeadline(idle, handlers.NewCannedHandler(table, fileService, recorder)))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != content {
+		t.Errorf("got %d bytes, %v, want the %d bytes of the delayed answer", len(body), err, len(content))
+	}
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	iofs "io/fs"
+	"net/http"
+	"path"
+	"sort"
+
+	"github.com/m1kkY8/ctfserver/pkg/models"
+	"github.com/m1kkY8/ctfserver/pkg/util"
+)
+
+// ErrNotDirectory is returned when a directory listing is requested for a file
+var ErrNotDirectory = errors.New("not a directory")
+
+// ListDirectory returns the entries of the directory at relPath below the root directory
+func (fs *FileService) ListDirectory(relPath string) (*models.DirectoryListing, error) {
+	target := util.CleanPath(relPath)
+	info, err := iofs.Stat(fs.rootFS, target)
+	if err != nil {
+		if errors.Is(err, iofs.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to stat path: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, ErrNotDirectory
+	}
+
+	entries, err := iofs.ReadDir(fs.rootFS, target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	dirPath := path.Join("/", target)
+	listing := &models.DirectoryListing{
+		Path:    dirPath,
+		Entries: make([]models.DirectoryEntry, 0, len(entries)),
+	}
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue // Skip files we can't read
+		}
+
+		item := models.DirectoryEntry{
+			Name:    entry.Name(),
+			Path:    path.Join(dirPath, entry.Name()),
+			IsDir:   info.IsDir(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		}
+		if !info.IsDir() {
+			item.MimeType = detectMimeType(fs.rootFS, path.Join(target, entry.Name()))
+		}
+		listing.Entries = append(listing.Entries, item)
+	}
+
+	// Directories first, then files, both alphabetically
+	sort.Slice(listing.Entries, func(i, j int) bool {
+		a, b := listing.Entries[i], listing.Entries[j]
+		if a.IsDir != b.IsDir {
+			return a.IsDir
+		}
+		return a.Name < b.Name
+	})
+
+	return listing, nil
+}
+
+// detectMimeType guesses the content type from the extension, falling back to content sniffing
+func detectMimeType(fsys iofs.FS, name string) string {
+	if mimeType := util.MimeTypeByExtension(name); mimeType != "" {
+		return mimeType
+	}
+
+	file, err := fsys.Open(name)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	buf := make([]byte, 512)
+	n, _ := file.Read(buf)
+	return http.DetectContentType(buf[:n])
+}
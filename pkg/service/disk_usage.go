@@ -0,0 +1,126 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	iofs "io/fs"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/m1kkY8/ctfserver/pkg/models"
+	"github.com/m1kkY8/ctfserver/pkg/util"
+)
+
+// Disk usage scopes accepted by GetDiskUsage
+const (
+	DiskUsageRoot    = "root"
+	DiskUsageUploads = "uploads"
+	DiskUsageLoot    = "loot" // Alias of DiskUsageUploads
+)
+
+// IsUploadsDiskUsage reports whether dir selects the upload directory, whose
+// usage lists the loot
+func IsUploadsDiskUsage(dir string) bool {
+	return dir == DiskUsageUploads || dir == DiskUsageLoot
+}
+
+// GetDiskUsage returns the largest files and directories below relPath in the root or upload directory
+func (fs *FileService) GetDiskUsage(dir, relPath string, top int) (*models.DiskUsageResponse, error) {
+	var fsys iofs.FS
+	switch dir {
+	case "", DiskUsageRoot:
+		dir = DiskUsageRoot
+		fsys = fs.rootFS
+	case DiskUsageUploads, DiskUsageLoot:
+		dir = DiskUsageUploads
+		fsys = os.DirFS(fs.UploadDir())
+	default:
+		return &models.DiskUsageResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Unknown directory %q (expected %q or %q)", dir, DiskUsageRoot, DiskUsageUploads),
+		}, nil
+	}
+
+	target := util.CleanPath(relPath)
+	if _, err := iofs.Stat(fsys, target); err != nil {
+		if errors.Is(err, iofs.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to stat path: %w", err)
+	}
+
+	tree, err := util.GenerateFileTree(fsys, target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate file tree: %w", err)
+	}
+
+	var entries []models.DiskUsageEntry
+	collectDiskUsage(tree.Children, &entries)
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Size != entries[j].Size {
+			return entries[i].Size > entries[j].Size
+		}
+		return entries[i].Path < entries[j].Path
+	})
+	if top > 0 && len(entries) > top {
+		entries = entries[:top]
+	}
+
+	totalSize, fileCount := tree.TotalSize, tree.FileCount
+	if !tree.IsDir {
+		totalSize, fileCount = tree.Size, 1
+	}
+
+	return &models.DiskUsageResponse{
+		Success:        true,
+		Dir:            dir,
+		Path:           target,
+		TotalSize:      totalSize,
+		TotalSizeHuman: util.FormatFileSize(totalSize),
+		FileCount:      fileCount,
+		Entries:        entries,
+	}, nil
+}
+
+// GetPrettyDiskUsage returns a du-style text listing of the disk usage
+func (fs *FileService) GetPrettyDiskUsage(dir, relPath string, top int) (string, *models.DiskUsageResponse, error) {
+	result, err := fs.GetDiskUsage(dir, relPath, top)
+	if err != nil || !result.Success {
+		return "", result, err
+	}
+
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("Disk usage of %s:%s - %s in %d files\n",
+		result.Dir, result.Path, result.TotalSizeHuman, result.FileCount))
+	for _, entry := range result.Entries {
+		name := entry.Path
+		if entry.IsDir {
+			name += "/"
+		}
+		builder.WriteString(fmt.Sprintf("%10s  %s\n", entry.SizeHuman, name))
+	}
+
+	return builder.String(), result, nil
+}
+
+// collectDiskUsage flattens a file tree into disk usage entries
+func collectDiskUsage(children []models.FileInfo, entries *[]models.DiskUsageEntry) {
+	for _, child := range children {
+		entry := models.DiskUsageEntry{
+			Path:      child.Path,
+			IsDir:     child.IsDir,
+			Size:      child.Size,
+			FileCount: 1,
+		}
+		if child.IsDir {
+			entry.Size = child.TotalSize
+			entry.FileCount = child.FileCount
+		}
+		entry.SizeHuman = util.FormatFileSize(entry.Size)
+		*entries = append(*entries, entry)
+
+		collectDiskUsage(child.Children, entries)
+	}
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestGetDiskUsage(t *testing.T) {
+	root := fstest.MapFS{
+		"tools/linux/linpeas.sh": {Data: make([]byte, 300)},
+		"tools/linux/pspy":       {Data: make([]byte, 500)},
+		"tools/win/nc.exe":       {Data: make([]byte, 100)},
+		"readme.txt":             {Data: make([]byte, 50)},
+		"empty":                  {Mode: iofs.ModeDir},
+	}
+	uploadDir := t.TempDir()
+	os.WriteFile(filepath.Join(uploadDir, "loot.txt"), make([]byte, 70), 0o644)
+	fs := NewFileService(root, "root", uploadDir, 1<<20)
+
+	tests := []struct {
+		name      string
+		dir, path string
+		top       int
+		wantTotal int64
+		wantFiles int
+		want      []string // Entries as path=size, largest first
+	}{
+		{
+			name: "root", dir: "", path: "/",
+			wantTotal: 950, wantFiles: 4,
+			want: []string{"tools=900", "tools/linux=800", "tools/linux/pspy=500", "tools/linux/linpeas.sh=300", "tools/win=100", "tools/win/nc.exe=100", "readme.txt=50", "empty=0"},
+		},
+		{
+			name: "top", dir: DiskUsageRoot, path: "/", top: 2,
+			wantTotal: 950, wantFiles: 4,
+			want: []string{"tools=900", "tools/linux=800"},
+		},
+		{
+			name: "subdirectory", dir: DiskUsageRoot, path: "/tools/win",
+			wantTotal: 100, wantFiles: 1,
+			want: []string{"tools/win/nc.exe=100"},
+		},
+		{
+			name: "single file", dir: DiskUsageRoot, path: "/readme.txt",
+			wantTotal: 50, wantFiles: 1,
+		},
+		{
+			name: "uploads", dir: DiskUsageLoot, path: "/",
+			wantTotal: 70, wantFiles: 1,
+			want: []string{"loot.txt=70"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := fs.GetDiskUsage(tt.dir, tt.path, tt.top)
+			if err != nil || !result.Success {
+				t.Fatalf("GetDiskUsage: %v, %+v", err, result)
+			}
+			if result.TotalSize != tt.wantTotal || result.FileCount != tt.wantFiles {
+				t.Errorf("total %d bytes in %d files, want %d in %d", result.TotalSize, result.FileCount, tt.wantTotal, tt.wantFiles)
+			}
+			var got []string
+			for _, entry := range result.Entries {
+				got = append(got, entry.Path+"="+fmt.Sprint(entry.Size))
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("entries %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if result, err := fs.GetDiskUsage("etc", "/", 0); err != nil || result.Success {
+		t.Errorf("unknown directory: %v, %+v", err, result)
+	}
+	if _, err := fs.GetDiskUsage(DiskUsageRoot, "/missing", 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing path: %v", err)
+	}
+
+	pretty, _, err := fs.GetPrettyDiskUsage(DiskUsageRoot, "/tools", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(pretty, "Disk usage of root:tools - 900 B in 3 files\n") || !strings.Contains(pretty, "  tools/linux/\n") {
+		t.Errorf("pretty listing:\n%s", pretty)
+	}
+}
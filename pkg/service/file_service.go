@@ -1,45 +1,107 @@
 package service
 
 import (
+	"errors"
 	"fmt"
 	"io"
-	"mime/multipart"
+	iofs "io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/m1kkY8/ctfserver/pkg/logger"
 	"github.com/m1kkY8/ctfserver/pkg/models"
 	"github.com/m1kkY8/ctfserver/pkg/util"
 )
 
 // FileService handles file operations
 type FileService struct {
-	rootDir   string
-	uploadDir string
-	maxSize   int64
+	rootFS    iofs.FS
+	hashCache *util.HashCache
+
+	mu            sync.RWMutex // Guards the settings below, which can change on reload
+	rootName      string
+	uploadDir     string
+	maxSize       int64
+	storageLimits StorageLimits
+	retention     RetentionPolicy
+
+	quotaMu          sync.Mutex // Serializes quota checks and the upload index
+	reserved         int64      // Bytes of uploads in progress
+	reservedBySource map[string]int64
+	writing          map[string]int // Paths of uploads in progress, left out of usage
+	usage            *uploadUsage   // Cached for quota checks, nil when stale
+	usageDir         string
+	usageTime        time.Time
 }
 
-// NewFileService creates a new file service
-func NewFileService(rootDir, uploadDir string, maxSize int64) *FileService {
+// NewFileService creates a new file service serving rootFS, displayed as rootName
+func NewFileService(rootFS iofs.FS, rootName, uploadDir string, maxSize int64) *FileService {
+	rootFS = hiddenFS{rootFS}
 	return &FileService{
-		rootDir:   rootDir,
+		rootFS:    rootFS,
+		rootName:  rootName,
 		uploadDir: uploadDir,
 		maxSize:   maxSize,
+		hashCache: util.NewHashCache(rootFS),
+
+		reservedBySource: make(map[string]int64),
+		writing:          make(map[string]int),
 	}
 }
 
+// RootFS returns the virtual file system served from the root
+func (fs *FileService) RootFS() iofs.FS {
+	return fs.rootFS
+}
+
 // MaxSize returns the maximum allowed file size
 func (fs *FileService) MaxSize() int64 {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
 	return fs.maxSize
 }
 
+// UploadDir returns the directory uploaded files are stored in
+func (fs *FileService) UploadDir() string {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.uploadDir
+}
+
+// Reconfigure applies reloaded settings; the root file system itself is
+// swapped in place by its owner, so hashes cached for the previous one are
+// dropped
+func (fs *FileService) Reconfigure(rootName, uploadDir string, maxSize int64) {
+	fs.hashCache.Reset()
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.rootName = rootName
+	fs.uploadDir = uploadDir
+	fs.maxSize = maxSize
+}
+
+func (fs *FileService) displayName() string {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.rootName
+}
+
 // GetFileTree returns the file tree for the root directory
 func (fs *FileService) GetFileTree() (*models.FileInfo, error) {
-	return util.GenerateFileTree(fs.rootDir)
+	fileTree, err := util.GenerateFileTree(fs.rootFS, ".")
+	if err != nil {
+		return nil, err
+	}
+	fileTree.Name = fs.displayName()
+	return fileTree, nil
 }
 
 // GetPrettyFileTree returns both structured and human-readable file tree
 func (fs *FileService) GetPrettyFileTree() (*models.PrettyFileTreeResponse, error) {
-	fileTree, err := util.GenerateFileTree(fs.rootDir)
+	fileTree, err := fs.GetFileTree()
 	if err != nil {
 		return &models.PrettyFileTreeResponse{
 			Success: false,
@@ -56,19 +118,30 @@ func (fs *FileService) GetPrettyFileTree() (*models.PrettyFileTreeResponse, erro
 	}, nil
 }
 
-// UploadFile saves an uploaded file to the upload directory
-func (fs *FileService) UploadFile(fileHeader *multipart.FileHeader, file multipart.File) (*models.UploadResponse, error) {
+// SaveUpload writes content from source to filename in the upload directory,
+// replacing any file of that name, and deletes it after expireAfter if that
+// is not zero. size is the expected length of content, or -1 if unknown.
+func (fs *FileService) SaveUpload(filename string, content io.Reader, size int64, source string, expireAfter time.Duration) (*models.UploadResponse, error) {
+	return fs.saveUpload(filename, content, size, source, expireAfter, false)
+}
+
+// SaveNewUpload is SaveUpload for generated names, which never replaces a
+// file: while filename is taken a counter is added before its extension,
+// e.g. loot-1.txt, and the result has the name that was used
+func (fs *FileService) SaveNewUpload(filename string, content io.Reader, size int64, source string, expireAfter time.Duration) (*models.UploadResponse, error) {
+	return fs.saveUpload(filename, content, size, source, expireAfter, true)
+}
+
+func (fs *FileService) saveUpload(filename string, content io.Reader, size int64, source string, expireAfter time.Duration, unique bool) (*models.UploadResponse, error) {
 	// Validate file size
-	if fileHeader.Size > fs.maxSize {
-		return &models.UploadResponse{
-			Success: false,
-			Error:   fmt.Sprintf("File size exceeds maximum allowed size of %d bytes", fs.maxSize),
-		}, nil
+	maxSize := fs.MaxSize()
+	if size > maxSize {
+		return sizeExceededResponse(maxSize), nil
 	}
 
 	// Validate filename
-	filename := filepath.Base(fileHeader.Filename)
-	if !util.IsValidFilename(filename) {
+	filename = filepath.Base(filename)
+	if !IsValidUploadName(filename) {
 		return &models.UploadResponse{
 			Success: false,
 			Error:   "Invalid filename",
@@ -76,36 +149,122 @@ func (fs *FileService) UploadFile(fileHeader *multipart.FileHeader, file multipa
 	}
 
 	// Ensure upload directory exists
-	if err := util.EnsureDir(fs.uploadDir); err != nil {
+	uploadDir := fs.UploadDir()
+	if err := util.EnsureDir(uploadDir); err != nil {
 		return nil, fmt.Errorf("failed to create upload directory: %w", err)
 	}
 
-	// Create destination file
-	dstPath := filepath.Join(fs.uploadDir, filename)
-	dst, err := os.Create(dstPath)
+	// Hold the space against the free space threshold and quotas while
+	// writing, growing the hold as the content turns out longer. A file
+	// that will be replaced stops counting, one that is kept doesn't.
+	dstPath := filepath.Join(uploadDir, filename)
+	reservedPath := dstPath
+	if unique {
+		reservedPath = ""
+	}
+	reservation, err := fs.reserveUpload(source, reservedPath, max(size, 0))
+	if err != nil {
+		return nil, err
+	}
+	defer reservation.release()
+
+	// Create destination file. A replaced file is written next to the old
+	// one and only moved over it once complete, so a failed upload keeps it.
+	var dst *os.File
+	if unique {
+		dst, dstPath, err = createNew(dstPath)
+		if err == nil {
+			filename = filepath.Base(dstPath)
+			reservation.rename(dstPath)
+		}
+	} else {
+		dst, _, err = createNew(filepath.Join(uploadDir, uploadTempPrefix+filename))
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to create destination file: %w", err)
 	}
 	defer dst.Close()
 
-	// Copy file content
-	written, err := io.Copy(dst, file)
+	// Copy file content, watching the free space in case something else fills the disk
+	var out io.Writer = &reservedWriter{Writer: dst, reservation: reservation}
+	if minFree := fs.getStorageLimits().MinFreeSpace; minFree > 0 {
+		out = &freeSpaceGuard{Writer: out, dir: uploadDir, minFree: minFree}
+	}
+	written, err := io.Copy(out, io.LimitReader(content, maxSize+1))
+	if err == nil && written > maxSize {
+		dst.Close()
+		os.Remove(dst.Name())
+		return sizeExceededResponse(maxSize), nil
+	}
+	if err == nil {
+		err = dst.Close()
+	}
+	if err == nil && dst.Name() != dstPath {
+		err = os.Rename(dst.Name(), dstPath)
+	}
 	if err != nil {
+		dst.Close()
+		os.Remove(dst.Name()) // Don't leave a partial file taking up space
+		if errors.Is(err, ErrInsufficientStorage) {
+			return nil, err
+		}
 		return nil, fmt.Errorf("failed to save file: %w", err)
 	}
 
-	return &models.UploadResponse{
+	record := uploadRecord{Source: source}
+	if expireAfter > 0 {
+		record.Expires = time.Now().Add(expireAfter)
+	}
+	if err := fs.recordUpload(uploadDir, filename, record); err != nil {
+		logger.Logger.WithError(err).Warn("Failed to record upload")
+	}
+
+	result := &models.UploadResponse{
 		Success:  true,
 		Filename: filename,
 		Size:     written,
 		Path:     dstPath,
-	}, nil
+	}
+	if !record.Expires.IsZero() {
+		result.Expires = &record.Expires
+	}
+	return result, nil
+}
+
+// maxNameAttempts bounds the names createNew tries
+const maxNameAttempts = 10000
+
+// createNew creates the file at path, or at the first free path made by
+// adding a counter before its extension. Creating exclusively makes the name
+// unique even among uploads saved at the same time.
+func createNew(path string) (*os.File, string, error) {
+	extension := filepath.Ext(path)
+	base := strings.TrimSuffix(path, extension)
+	for i := 0; i < maxNameAttempts; i++ {
+		candidate := path
+		if i > 0 {
+			candidate = fmt.Sprintf("%s-%d%s", base, i, extension)
+		}
+		file, err := os.OpenFile(candidate, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+		if !errors.Is(err, os.ErrExist) {
+			return file, candidate, err
+		}
+	}
+	return nil, "", fmt.Errorf("no free name for %s", filepath.Base(path))
+}
+
+func sizeExceededResponse(maxSize int64) *models.UploadResponse {
+	return &models.UploadResponse{
+		Success: false,
+		Error:   fmt.Sprintf("File size exceeds maximum allowed size of %d bytes", maxSize),
+	}
 }
 
 // ListUploads returns a list of all uploaded files
 func (fs *FileService) ListUploads() (*models.UploadsListResponse, error) {
 	// Ensure upload directory exists
-	if err := util.EnsureDir(fs.uploadDir); err != nil {
+	uploadDir := fs.UploadDir()
+	if err := util.EnsureDir(uploadDir); err != nil {
 		return &models.UploadsListResponse{
 			Success: false,
 			Error:   "Failed to access upload directory",
@@ -114,7 +273,7 @@ func (fs *FileService) ListUploads() (*models.UploadsListResponse, error) {
 	}
 
 	// Read directory contents
-	entries, err := os.ReadDir(fs.uploadDir)
+	entries, err := os.ReadDir(uploadDir)
 	if err != nil {
 		return &models.UploadsListResponse{
 			Success: false,
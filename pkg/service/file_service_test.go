@@ -0,0 +1,133 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"testing/iotest"
+
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+)
+
+func TestSaveNewUpload(t *testing.T) {
+	logger.InitLogger("error")
+	fs := NewFileService(fstest.MapFS{}, "root", t.TempDir(), 1<<20)
+
+	// Saved at the same time under one generated name, none is lost
+	const uploads = 40
+	names := make([]string, uploads)
+	var wg sync.WaitGroup
+	for i := range uploads {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := fs.SaveNewUpload("loot.txt", strings.NewReader(fmt.Sprint(i)), -1, "10.0.0.1", 0)
+			if err != nil || !result.Success {
+				t.Errorf("SaveNewUpload: %v, %+v", err, result)
+				return
+			}
+			names[i] = result.Filename
+		}()
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for i, name := range names {
+		data, err := os.ReadFile(filepath.Join(fs.UploadDir(), name))
+		if err != nil || string(data) != fmt.Sprint(i) {
+			t.Errorf("%s holds %q (%v), want %d", name, data, err, i)
+		}
+		seen[name] = true
+	}
+	if len(seen) != uploads || !seen["loot.txt"] || !seen["loot-1.txt"] || !seen[fmt.Sprintf("loot-%d.txt", uploads-1)] {
+		t.Errorf("saved as %v", names)
+	}
+
+	// SaveUpload still replaces
+	if _, err := fs.SaveUpload("loot.txt", strings.NewReader("new"), 3, "10.0.0.1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(fs.UploadDir(), "loot.txt")); string(data) != "new" {
+		t.Errorf("SaveUpload left %q", data)
+	}
+}
+
+func TestSaveUploadKeepsFileOnFailure(t *testing.T) {
+	logger.InitLogger("error")
+	fs := NewFileService(fstest.MapFS{}, "root", t.TempDir(), 16)
+	path := filepath.Join(fs.UploadDir(), "loot.txt")
+	if _, err := fs.SaveUpload("loot.txt", strings.NewReader("old"), 3, "10.0.0.1", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	broken := io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(errors.New("connection reset")))
+	if _, err := fs.SaveUpload("loot.txt", broken, -1, "10.0.0.1", 0); err == nil {
+		t.Error("broken upload succeeded")
+	}
+	if result, err := fs.SaveUpload("loot.txt", strings.NewReader(strings.Repeat("x", 17)), -1, "10.0.0.1", 0); err != nil || result.Success {
+		t.Errorf("oversized upload saved: %v, %+v", err, result)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "old" {
+		t.Errorf("failed uploads left %q, want the previous file", data)
+	}
+
+	if _, err := fs.SaveUpload("loot.txt", strings.NewReader("new"), -1, "10.0.0.1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "new" {
+		t.Errorf("replaced with %q, want new", data)
+	}
+	entries, _ := os.ReadDir(fs.UploadDir())
+	for _, entry := range entries {
+		if entry.Name() != "loot.txt" && entry.Name() != uploadIndexFile {
+			t.Errorf("left %s behind", entry.Name())
+		}
+	}
+}
+
+func TestReservedFilesHidden(t *testing.T) {
+	logger.InitLogger("error")
+	root := fstest.MapFS{
+		"uploads/loot.txt":      {Data: []byte("loot")},
+		"uploads/.uploads.json": {Data: []byte("{}")},
+		"uploads/.hits.jsonl":   {Data: []byte("{}\n")},
+		"uploads/.hits.jsonl.1": {Data: []byte("{}\n")},
+		"uploads/.env":          {Data: []byte("KEY=1")},
+	}
+	fs := NewFileService(root, "root", t.TempDir(), 1<<20)
+
+	// The wrapped root stays a consistent file system without them
+	if err := fstest.TestFS(fs.RootFS(), "uploads/loot.txt", "uploads/.env"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"uploads/.uploads.json", "uploads/.hits.jsonl", "uploads/.hits.jsonl.1"} {
+		if _, err := fs.RootFS().Open(name); !errors.Is(err, iofs.ErrNotExist) {
+			t.Errorf("Open(%q) = %v, want not found", name, err)
+		}
+	}
+
+	listing, err := fs.ListDirectory("uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, item := range listing.Entries {
+		names = append(names, item.Name)
+	}
+	if strings.Join(names, ",") != ".env,loot.txt" {
+		t.Errorf("listed %v, want .env and loot.txt", names)
+	}
+
+	for _, name := range []string{".uploads.json", ".hits.jsonl", ".hits.jsonl.1", ".uploading-loot.txt"} {
+		if IsValidUploadName(name) {
+			t.Errorf("%s is a valid upload name", name)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	iofs "io/fs"
+	"sort"
+	"strings"
+
+	"github.com/m1kkY8/ctfserver/pkg/models"
+	"github.com/m1kkY8/ctfserver/pkg/util"
+)
+
+// ErrNotFound is returned when a requested path does not exist
+var ErrNotFound = errors.New("path not found")
+
+// GetFileHashes returns hashes for the file or every file below the directory at relPath
+func (fs *FileService) GetFileHashes(relPath string, algos []string) (*models.HashesResponse, error) {
+	target := util.CleanPath(relPath)
+	info, err := iofs.Stat(fs.rootFS, target)
+	if err != nil {
+		if errors.Is(err, iofs.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to stat path: %w", err)
+	}
+
+	var files []models.FileHash
+	addFile := func(name string, info iofs.FileInfo) {
+		sums, err := fs.hashCache.Get(name, info, algos)
+		if err != nil {
+			return // Skip files we can't read
+		}
+		files = append(files, models.FileHash{
+			Path:    name,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			Hashes:  sums,
+		})
+	}
+
+	if info.IsDir() {
+		err = iofs.WalkDir(fs.rootFS, target, func(name string, d iofs.DirEntry, err error) error {
+			if err != nil {
+				if d != nil && d.IsDir() {
+					return skipUnreadableDir(name, target)
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			addFile(name, info)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to walk directory: %w", err)
+		}
+	} else if info.Mode().IsRegular() {
+		addFile(target, info)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+
+	return &models.HashesResponse{
+		Success:    true,
+		Algorithms: algos,
+		Files:      files,
+		Count:      len(files),
+	}, nil
+}
+
+// GetPrettyFileHashes returns a checksum manifest compatible with sha256sum -c and friends
+func (fs *FileService) GetPrettyFileHashes(relPath string, algos []string) (string, *models.HashesResponse, error) {
+	result, err := fs.GetFileHashes(relPath, algos)
+	if err != nil {
+		return "", result, err
+	}
+
+	// GNU coreutils format, "<hash>  <path>". Several algorithms get a section
+	// each under a comment line, which *sum -c skips.
+	var builder strings.Builder
+	for _, algo := range algos {
+		if len(algos) > 1 {
+			fmt.Fprintf(&builder, "# %s\n", strings.ToUpper(algo))
+		}
+		for _, file := range result.Files {
+			builder.WriteString(checksumLine(file.Hashes[algo], file.Path))
+		}
+	}
+
+	return builder.String(), result, nil
+}
+
+// checksumLine formats one line of a GNU checksum manifest. Like coreutils,
+// paths with a backslash, newline or carriage return have them escaped and
+// the line starts with a backslash.
+func checksumLine(sum, name string) string {
+	if !strings.ContainsAny(name, "\\\n\r") {
+		return sum + "  " + name + "\n"
+	}
+	escaped := strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r").Replace(name)
+	return "\\" + sum + "  " + escaped + "\n"
+}
+
+// FileSHA256 returns the cached SHA-256 of a regular file below the root
+func (fs *FileService) FileSHA256(relPath string) (string, bool) {
+	target := util.CleanPath(relPath)
+	info, err := iofs.Stat(fs.rootFS, target)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", false
+	}
+
+	sums, err := fs.hashCache.Get(target, info, []string{"sha256"})
+	if err != nil {
+		return "", false
+	}
+	return sums["sha256"], true
+}
+
+// skipUnreadableDir skips unreadable subdirectories but fails if the walk root itself is unreadable
+func skipUnreadableDir(name, root string) error {
+	if name == root {
+		return fmt.Errorf("failed to read directory %s", name)
+	}
+	return iofs.SkipDir
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestChecksumLine(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"tools/nc", "abc  tools/nc\n"},
+		{"with space", "abc  with space\n"},
+		{`back\slash`, `\abc  back\\slash` + "\n"},
+		{"new\nline\r", `\abc  new\nline\r` + "\n"},
+	}
+	for _, tt := range tests {
+		if got := checksumLine("abc", tt.name); got != tt.want {
+			t.Errorf("checksumLine(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGetPrettyFileHashes(t *testing.T) {
+	fsys := fstest.MapFS{
+		"tools/a": {Data: []byte("a")},
+		"tools/b": {Data: []byte("b")},
+	}
+	fs := NewFileService(fsys, "root", t.TempDir(), 1<<20)
+
+	single, _, err := fs.GetPrettyFileHashes("tools", []string{"md5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "0cc175b9c0f1b6a831c399e269772661  tools/a\n" +
+		"92eb5ffee6ae2fec3ad71c777531578f  tools/b\n"
+	if single != want {
+		t.Errorf("single algorithm manifest = %q, want %q", single, want)
+	}
+
+	multiple, _, err := fs.GetPrettyFileHashes("tools/a", []string{"md5", "sha1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "# MD5\n0cc175b9c0f1b6a831c399e269772661  tools/a\n" +
+		"# SHA1\n86f7e437faa5a7fce15d1ddcb9eaeaea377667b8  tools/a\n"
+	if multiple != want {
+		t.Errorf("multiple algorithm manifest = %q, want %q", multiple, want)
+	}
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	iofs "io/fs"
+	"path"
+	"slices"
+)
+
+// hiddenFS hides the upload index and capture log from the served root, which
+// often contains the upload directory
+type hiddenFS struct {
+	iofs.FS
+}
+
+// Open opens the named file unless it is reserved
+func (h hiddenFS) Open(name string) (iofs.File, error) {
+	if isReservedUploadName(path.Base(name)) {
+		return nil, &iofs.PathError{Op: "open", Path: name, Err: iofs.ErrNotExist}
+	}
+	file, err := h.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := file.Stat(); err == nil && info.IsDir() {
+		if dir, ok := file.(iofs.ReadDirFile); ok {
+			return hiddenDir{dir}, nil
+		}
+	}
+	return file, nil
+}
+
+// Stat returns file info of the named file unless it is reserved
+func (h hiddenFS) Stat(name string) (iofs.FileInfo, error) {
+	if isReservedUploadName(path.Base(name)) {
+		return nil, &iofs.PathError{Op: "stat", Path: name, Err: iofs.ErrNotExist}
+	}
+	return iofs.Stat(h.FS, name)
+}
+
+// ReadDir returns the entries of the named directory without reserved files
+func (h hiddenFS) ReadDir(name string) ([]iofs.DirEntry, error) {
+	entries, err := iofs.ReadDir(h.FS, name)
+	return withoutReserved(entries), err
+}
+
+// hiddenDir is an open directory listing no reserved files
+type hiddenDir struct {
+	iofs.ReadDirFile
+}
+
+// ReadDir reads the next n entries, skipping reserved files
+func (d hiddenDir) ReadDir(n int) ([]iofs.DirEntry, error) {
+	for {
+		entries, err := d.ReadDirFile.ReadDir(n)
+		visible := withoutReserved(entries)
+		// A batch of only reserved files mustn't look like the end
+		if n <= 0 || len(visible) > 0 || err != nil {
+			return visible, err
+		}
+	}
+}
+
+// withoutReserved drops the reserved files from entries
+func withoutReserved(entries []iofs.DirEntry) []iofs.DirEntry {
+	return slices.DeleteFunc(entries, func(entry iofs.DirEntry) bool {
+		return isReservedUploadName(entry.Name())
+	})
+}
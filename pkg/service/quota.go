@@ -0,0 +1,387 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/m1kkY8/ctfserver/pkg/models"
+	"github.com/m1kkY8/ctfserver/pkg/util"
+)
+
+// ErrInsufficientStorage is returned when an upload would exceed a quota or
+// leave less than the minimum free space
+var ErrInsufficientStorage = errors.New("insufficient storage")
+
+// freeSpaceCheckInterval is how many bytes are written between free space checks during an upload
+const freeSpaceCheckInterval = 8 * 1024 * 1024
+
+// reservationStep is how much more space an upload reserves each time it
+// outgrows what it holds, so uploads of unknown size are held to the quotas
+// while they stream without checking them on every write
+const reservationStep = 1024 * 1024
+
+// usageCacheTTL is how long quota checks reuse the usage of the upload
+// directory before reading it again, noticing files changed by others
+const usageCacheTTL = 2 * time.Second
+
+// StorageLimits guard the upload directory against filling up, zero disables a limit
+type StorageLimits struct {
+	MinFreeSpace int64 // Bytes that must stay free on the upload file system
+	UploadQuota  int64 // Total bytes allowed in the upload directory
+	SourceQuota  int64 // Bytes each source address may keep in the upload directory
+}
+
+// SetStorageLimits applies new storage limits, e.g. on reload
+func (fs *FileService) SetStorageLimits(limits StorageLimits) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.storageLimits = limits
+}
+
+func (fs *FileService) getStorageLimits() StorageLimits {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.storageLimits
+}
+
+// CheckUpload reports whether an upload of size bytes from source fits the
+// storage limits, so oversized uploads can be refused before reading them
+func (fs *FileService) CheckUpload(source string, size int64) error {
+	reservation, err := fs.reserveUpload(source, "", size)
+	if err != nil {
+		return err
+	}
+	reservation.release()
+	return nil
+}
+
+// reservation holds space for an upload in progress until it is released.
+// The file being written is left out of the usage of the upload directory
+// while it is held, the reservation counts it instead, so its bytes are
+// never counted twice.
+type reservation struct {
+	fs     *FileService
+	source string
+	path   string // File being written, empty for checks
+	size   int64  // Bytes held, guarded by fs.quotaMu
+}
+
+// reserveUpload checks size against the free space threshold and quotas,
+// counting uploads still in progress, and holds the space for the upload to
+// path until the reservation is released
+func (fs *FileService) reserveUpload(source, path string, size int64) (*reservation, error) {
+	limits := fs.getStorageLimits()
+	uploadDir := fs.UploadDir()
+
+	fs.quotaMu.Lock()
+	defer fs.quotaMu.Unlock()
+
+	r := &reservation{fs: fs, source: source, path: path}
+	if path != "" {
+		fs.writing[path]++
+		fs.usage = nil
+	}
+	if err := fs.checkLimits(limits, uploadDir, r, size); err != nil {
+		r.forget()
+		return nil, err
+	}
+	r.hold(size)
+	return r, nil
+}
+
+// ensure holds at least size bytes for the upload, reserving more in steps
+// of reservationStep and checking the limits again each time
+func (r *reservation) ensure(size int64) error {
+	fs := r.fs
+	limits := fs.getStorageLimits()
+	uploadDir := fs.UploadDir()
+
+	fs.quotaMu.Lock()
+	defer fs.quotaMu.Unlock()
+
+	need := size - r.size
+	if need <= 0 {
+		return nil
+	}
+	// Take a whole step if it fits, otherwise just what is needed
+	if need < reservationStep && fs.checkLimits(limits, uploadDir, r, reservationStep) == nil {
+		need = reservationStep
+	} else if err := fs.checkLimits(limits, uploadDir, r, need); err != nil {
+		return err
+	}
+	r.hold(need)
+	return nil
+}
+
+// rename moves the reservation to the file now at path
+func (r *reservation) rename(path string) {
+	r.fs.quotaMu.Lock()
+	defer r.fs.quotaMu.Unlock()
+	r.forget()
+	r.path = path
+	if path != "" {
+		r.fs.writing[path]++
+	}
+}
+
+// release gives the space back, counting the written file with the usage of
+// the upload directory again. Releasing twice does nothing.
+func (r *reservation) release() {
+	r.fs.quotaMu.Lock()
+	defer r.fs.quotaMu.Unlock()
+	r.hold(-r.size)
+	r.forget()
+	r.path = ""
+}
+
+// hold adds size bytes to the reservation, with fs.quotaMu held
+func (r *reservation) hold(size int64) {
+	fs := r.fs
+	r.size += size
+	fs.reserved += size
+	if fs.reservedBySource[r.source] += size; fs.reservedBySource[r.source] <= 0 {
+		delete(fs.reservedBySource, r.source)
+	}
+}
+
+// forget stops leaving the file of the reservation out of the usage, with
+// fs.quotaMu held
+func (r *reservation) forget() {
+	fs := r.fs
+	if r.path == "" {
+		return
+	}
+	if fs.writing[r.path]--; fs.writing[r.path] <= 0 {
+		delete(fs.writing, r.path)
+	}
+	fs.usage = nil
+}
+
+// checkLimits reports whether r may hold size more bytes, with fs.quotaMu
+// held. The free space on disk already reflects what r has written, so only
+// the other reservations are subtracted from it.
+func (fs *FileService) checkLimits(limits StorageLimits, uploadDir string, r *reservation, size int64) error {
+	if limits.MinFreeSpace > 0 {
+		if space, err := util.GetDiskSpace(existingParent(uploadDir)); err == nil {
+			free := space.Free - (fs.reserved - r.size)
+			if available := free - limits.MinFreeSpace; size > available {
+				return fmt.Errorf("%w: %s free on the upload disk, %s must stay free",
+					ErrInsufficientStorage, util.FormatFileSize(max(free, 0)), util.FormatFileSize(limits.MinFreeSpace))
+			}
+		}
+	}
+
+	if limits.UploadQuota > 0 || limits.SourceQuota > 0 {
+		usage, err := fs.cachedUploadUsage(uploadDir)
+		if err != nil {
+			return fmt.Errorf("failed to compute upload usage: %w", err)
+		}
+		if limits.UploadQuota > 0 && usage.total+fs.reserved+size > limits.UploadQuota {
+			return fmt.Errorf("%w: upload quota of %s exceeded (%s used)",
+				ErrInsufficientStorage, util.FormatFileSize(limits.UploadQuota), util.FormatFileSize(usage.total+fs.reserved))
+		}
+		if limits.SourceQuota > 0 {
+			used := usage.bySource[r.source] + fs.reservedBySource[r.source]
+			if used+size > limits.SourceQuota {
+				return fmt.Errorf("%w: quota of %s for %s exceeded (%s used)",
+					ErrInsufficientStorage, util.FormatFileSize(limits.SourceQuota), r.source, util.FormatFileSize(used))
+			}
+		}
+	}
+	return nil
+}
+
+// reservedWriter grows the reservation of an upload ahead of the bytes
+// written through it
+type reservedWriter struct {
+	io.Writer
+	reservation *reservation
+	written     int64
+}
+
+func (w *reservedWriter) Write(p []byte) (int, error) {
+	if err := w.reservation.ensure(w.written + int64(len(p))); err != nil {
+		return 0, err
+	}
+	n, err := w.Writer.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// uploadUsage is the space taken by uploaded files, in total and per source
+type uploadUsage struct {
+	total         int64
+	files         int
+	bySource      map[string]int64
+	filesBySource map[string]int
+}
+
+// cachedUploadUsage returns the usage of the upload directory without the
+// files being written, reading it again once it is older than usageCacheTTL
+// or uploads changed it. The caller holds fs.quotaMu.
+func (fs *FileService) cachedUploadUsage(uploadDir string) (*uploadUsage, error) {
+	if fs.usage != nil && fs.usageDir == uploadDir && time.Since(fs.usageTime) < usageCacheTTL {
+		return fs.usage, nil
+	}
+	usage, err := fs.uploadUsage(uploadDir, fs.writing)
+	if err != nil {
+		return nil, err
+	}
+	fs.usage, fs.usageDir, fs.usageTime = usage, uploadDir, time.Now()
+	return usage, nil
+}
+
+// uploadUsage sums the sizes of the uploaded files but those in skip,
+// attributing them to the sources recorded in the source index
+func (fs *FileService) uploadUsage(uploadDir string, skip map[string]int) (*uploadUsage, error) {
+	usage := &uploadUsage{
+		bySource:      make(map[string]int64),
+		filesBySource: make(map[string]int),
+	}
+
+	entries, err := os.ReadDir(uploadDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return usage, nil
+		}
+		return nil, err
+	}
+
+	index := loadUploadIndex(uploadDir)
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || isReservedUploadName(entry.Name()) {
+			continue
+		}
+		if skip[filepath.Join(uploadDir, entry.Name())] > 0 {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // Removed while we were looking
+		}
+		usage.total += info.Size()
+		usage.files++
+		if record, ok := index[entry.Name()]; ok && record.Source != "" {
+			usage.bySource[record.Source] += info.Size()
+			usage.filesBySource[record.Source]++
+		}
+	}
+	return usage, nil
+}
+
+// freeSpaceGuard aborts a write once the upload file system drops below the
+// minimum free space, catching disks filled by something else mid-upload
+type freeSpaceGuard struct {
+	io.Writer
+	dir       string
+	minFree   int64
+	sinceLast int64
+}
+
+func (g *freeSpaceGuard) Write(p []byte) (int, error) {
+	g.sinceLast += int64(len(p))
+	if g.sinceLast >= freeSpaceCheckInterval {
+		g.sinceLast = 0
+		if space, err := util.GetDiskSpace(g.dir); err == nil && space.Free-int64(len(p)) < g.minFree {
+			return 0, fmt.Errorf("%w: upload disk dropped below %s free", ErrInsufficientStorage, util.FormatFileSize(g.minFree))
+		}
+	}
+	return g.Writer.Write(p)
+}
+
+// GetStats returns the usage of the upload directory and the quotas of source
+func (fs *FileService) GetStats(source string) (*models.StatsResponse, error) {
+	limits := fs.getStorageLimits()
+	uploadDir := fs.UploadDir()
+
+	fs.quotaMu.Lock()
+	usage, err := fs.uploadUsage(uploadDir, nil)
+	fs.quotaMu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute upload usage: %w", err)
+	}
+
+	result := &models.StatsResponse{
+		Success:   true,
+		UploadDir: uploadDir,
+		Uploads:   newQuotaUsage("", usage.files, usage.total, limits.UploadQuota),
+		Source:    newQuotaUsage(source, usage.filesBySource[source], usage.bySource[source], limits.SourceQuota),
+	}
+
+	if space, err := util.GetDiskSpace(existingParent(uploadDir)); err == nil {
+		available := max(space.Free-limits.MinFreeSpace, 0)
+		result.Disk = &models.DiskStats{
+			Total:          space.Total,
+			Free:           space.Free,
+			FreeHuman:      util.FormatFileSize(space.Free),
+			MinFree:        limits.MinFreeSpace,
+			Available:      available,
+			AvailableHuman: util.FormatFileSize(available),
+		}
+	}
+
+	return result, nil
+}
+
+// GetPrettyStats returns a human-readable summary of upload storage
+func (fs *FileService) GetPrettyStats(source string) (string, *models.StatsResponse, error) {
+	result, err := fs.GetStats(source)
+	if err != nil {
+		return "", result, err
+	}
+
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("Upload directory: %s\n", result.UploadDir))
+	if disk := result.Disk; disk != nil {
+		builder.WriteString(fmt.Sprintf("Disk:    %s free, %s usable for uploads (keeping %s free)\n",
+			disk.FreeHuman, disk.AvailableHuman, util.FormatFileSize(disk.MinFree)))
+	}
+	for _, usage := range []*models.QuotaUsage{result.Uploads, result.Source} {
+		label := "Total:"
+		if usage.Address != "" {
+			label = usage.Address + ":"
+		}
+		line := fmt.Sprintf("%-8s %s in %d files", label, usage.UsedHuman, usage.Files)
+		if usage.Remaining != nil {
+			line += fmt.Sprintf(", %s of %s quota remaining", util.FormatFileSize(*usage.Remaining), util.FormatFileSize(usage.Quota))
+		}
+		builder.WriteString(line + "\n")
+	}
+
+	return builder.String(), result, nil
+}
+
+func newQuotaUsage(address string, files int, used, quota int64) *models.QuotaUsage {
+	usage := &models.QuotaUsage{
+		Address:   address,
+		Files:     files,
+		Used:      used,
+		UsedHuman: util.FormatFileSize(used),
+		Quota:     quota,
+	}
+	if quota > 0 {
+		remaining := max(quota-used, 0)
+		usage.Remaining = &remaining
+	}
+	return usage
+}
+
+// existingParent returns dir or its nearest existing parent, so free space
+// can be reported before the upload directory is created
+func existingParent(dir string) string {
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,192 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+)
+
+// unknownSize hides the length of a reader, like a chunked request body
+type unknownSize struct{ io.Reader }
+
+func newQuotaService(t *testing.T, limits StorageLimits) *FileService {
+	t.Helper()
+	logger.InitLogger("error")
+	fs := NewFileService(fstest.MapFS{}, "root", t.TempDir(), 1<<30)
+	fs.SetStorageLimits(limits)
+	return fs
+}
+
+func TestSaveUploadQuotas(t *testing.T) {
+	const mb = 1 << 20
+
+	type upload struct {
+		name    string
+		source  string
+		size    int  // Bytes written
+		known   bool // Size passed to SaveUpload
+		wantErr bool // Refused with ErrInsufficientStorage
+	}
+	tests := []struct {
+		name    string
+		limits  StorageLimits
+		uploads []upload
+		want    int64 // Bytes in the upload directory afterwards
+	}{
+		{
+			name:   "unknown size over the total quota",
+			limits: StorageLimits{UploadQuota: 3 * mb},
+			uploads: []upload{
+				{"a.bin", "10.0.0.1", 4 * mb, false, true},
+			},
+			want: 0,
+		},
+		{
+			name:   "unknown size just under the total quota",
+			limits: StorageLimits{UploadQuota: 3 * mb},
+			uploads: []upload{
+				{"a.bin", "10.0.0.1", 3*mb - 10, false, false},
+			},
+			want: 3*mb - 10,
+		},
+		{
+			name:   "finished uploads count",
+			limits: StorageLimits{UploadQuota: 3 * mb},
+			uploads: []upload{
+				{"a.bin", "10.0.0.1", 2 * mb, false, false},
+				{"b.bin", "10.0.0.2", 2 * mb, false, true},
+				{"c.bin", "10.0.0.2", mb, true, false},
+			},
+			want: 3 * mb,
+		},
+		{
+			name:   "known size refused up front",
+			limits: StorageLimits{UploadQuota: 3 * mb},
+			uploads: []upload{
+				{"a.bin", "10.0.0.1", 4 * mb, true, true},
+			},
+			want: 0,
+		},
+		{
+			name:   "source quota leaves other sources alone",
+			limits: StorageLimits{SourceQuota: 2 * mb},
+			uploads: []upload{
+				{"a.bin", "10.0.0.1", 2 * mb, false, false},
+				{"b.bin", "10.0.0.1", mb, false, true},
+				{"c.bin", "10.0.0.2", 2 * mb, false, false},
+			},
+			want: 4 * mb,
+		},
+		{
+			name:   "overwriting replaces the old size",
+			limits: StorageLimits{UploadQuota: 3 * mb},
+			uploads: []upload{
+				{"a.bin", "10.0.0.1", 2 * mb, false, false},
+				{"a.bin", "10.0.0.1", 3 * mb, false, false},
+			},
+			want: 3 * mb,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := newQuotaService(t, tt.limits)
+			for _, u := range tt.uploads {
+				var content io.Reader = bytes.NewReader(make([]byte, u.size))
+				size := int64(-1)
+				if u.known {
+					size = int64(u.size)
+				} else {
+					content = unknownSize{content}
+				}
+				_, err := fs.SaveUpload(u.name, content, size, u.source, 0)
+				if gotErr := errors.Is(err, ErrInsufficientStorage); gotErr != u.wantErr {
+					t.Fatalf("SaveUpload(%s, %d bytes) error = %v, want insufficient storage %v", u.name, u.size, err, u.wantErr)
+				}
+			}
+
+			usage, err := fs.uploadUsage(fs.UploadDir(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if usage.total != tt.want {
+				t.Errorf("upload directory holds %d bytes, want %d", usage.total, tt.want)
+			}
+			if fs.reserved != 0 || len(fs.reservedBySource) != 0 || len(fs.writing) != 0 {
+				t.Errorf("reservations left over: %d bytes, %v, %v", fs.reserved, fs.reservedBySource, fs.writing)
+			}
+		})
+	}
+}
+
+func TestUploadWriterQuota(t *testing.T) {
+	const mb = 1 << 20
+	fs := newQuotaService(t, StorageLimits{UploadQuota: 3 * mb})
+
+	w, err := fs.OpenUploadWriter("smb.bin", "10.0.0.1", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk := make([]byte, 64*1024)
+	for off := int64(0); off < 2*mb; off += int64(len(chunk)) {
+		if _, err := w.WriteAt(chunk, off); err != nil {
+			t.Fatalf("WriteAt(%d): %v", off, err)
+		}
+	}
+
+	// The open upload holds its space against other uploads
+	if err := fs.CheckUpload("10.0.0.2", 2*mb); !errors.Is(err, ErrInsufficientStorage) {
+		t.Errorf("CheckUpload beside an open upload = %v, want insufficient storage", err)
+	}
+	if _, err := w.WriteAt(chunk, 3*mb); !errors.Is(err, ErrInsufficientStorage) {
+		t.Errorf("WriteAt past the quota = %v, want insufficient storage", err)
+	}
+	if _, err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once closed the file counts by its size on disk
+	if err := fs.CheckUpload("10.0.0.2", mb); err != nil {
+		t.Errorf("CheckUpload(1MB) after close = %v", err)
+	}
+	if err := fs.CheckUpload("10.0.0.2", mb+1); !errors.Is(err, ErrInsufficientStorage) {
+		t.Errorf("CheckUpload(1MB+1) after close = %v, want insufficient storage", err)
+	}
+
+	// Reopening without truncating keeps the file counted once
+	w, err = fs.OpenUploadWriter("smb.bin", "10.0.0.1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.WriteAt(chunk, 2*mb); err != nil {
+		t.Errorf("WriteAt within the quota after reopening: %v", err)
+	}
+	if _, err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if fs.reserved != 0 || len(fs.writing) != 0 {
+		t.Errorf("reservations left over: %d bytes, %v", fs.reserved, fs.writing)
+	}
+}
+
+func TestUploadUsageNoticesOtherChanges(t *testing.T) {
+	fs := newQuotaService(t, StorageLimits{UploadQuota: 100})
+	if err := fs.CheckUpload("10.0.0.1", 100); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files dropped in by something else count once the cache is stale
+	if err := os.WriteFile(filepath.Join(fs.UploadDir(), "other.bin"), make([]byte, 60), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fs.usageTime = fs.usageTime.Add(-usageCacheTTL)
+	if err := fs.CheckUpload("10.0.0.1", 50); !errors.Is(err, ErrInsufficientStorage) {
+		t.Errorf("CheckUpload after another file appeared = %v, want insufficient storage", err)
+	}
+}
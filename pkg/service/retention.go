@@ -0,0 +1,197 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/m1kkY8/ctfserver/pkg/models"
+	"github.com/m1kkY8/ctfserver/pkg/util"
+)
+
+// Reasons an uploaded file is deleted by retention
+const (
+	RetentionExpired = "expired"  // Its X-Expire-After passed
+	RetentionMaxAge  = "max-age"  // Older than the maximum age
+	RetentionMaxSize = "max-size" // Evicted, oldest first, to fit the maximum total size
+)
+
+// RetentionPolicy decides which uploads are deleted, zero disables a limit.
+// Files uploaded with X-Expire-After are deleted when it passes regardless.
+type RetentionPolicy struct {
+	MaxAge  time.Duration // Delete uploads older than this
+	MaxSize int64         // Delete the oldest uploads while the total is larger
+}
+
+// SetRetentionPolicy applies a new retention policy, e.g. on reload
+func (fs *FileService) SetRetentionPolicy(policy RetentionPolicy) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.retention = policy
+}
+
+func (fs *FileService) getRetentionPolicy() RetentionPolicy {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.retention
+}
+
+// PlanRetention returns the uploads the retention policy would delete at now,
+// in the order they would be deleted
+func (fs *FileService) PlanRetention(now time.Time) (*models.RetentionResponse, error) {
+	policy := fs.getRetentionPolicy()
+	uploadDir := fs.UploadDir()
+
+	result := &models.RetentionResponse{
+		Success: true,
+		MaxSize: policy.MaxSize,
+		Files:   []models.RetentionEntry{},
+	}
+	if policy.MaxAge > 0 {
+		result.MaxAge = policy.MaxAge.String()
+	}
+
+	entries, err := os.ReadDir(uploadDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return result, nil
+		}
+		return nil, fmt.Errorf("failed to read upload directory: %w", err)
+	}
+
+	fs.quotaMu.Lock()
+	index := loadUploadIndex(uploadDir)
+	fs.quotaMu.Unlock()
+
+	var kept []models.RetentionEntry
+	var keptSize int64
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || isReservedUploadName(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // Removed while we were looking
+		}
+
+		file := models.RetentionEntry{
+			Name:      info.Name(),
+			Size:      info.Size(),
+			SizeHuman: util.FormatFileSize(info.Size()),
+			ModTime:   info.ModTime(),
+		}
+		switch expires := index[file.Name].Expires; {
+		case !expires.IsZero() && !expires.After(now):
+			file.Reason = RetentionExpired
+		case policy.MaxAge > 0 && now.Sub(file.ModTime) > policy.MaxAge:
+			file.Reason = RetentionMaxAge
+		default:
+			kept = append(kept, file)
+			keptSize += file.Size
+			continue
+		}
+		result.Files = append(result.Files, file)
+	}
+
+	// Evict the oldest of the remaining files until the rest fits
+	if policy.MaxSize > 0 && keptSize > policy.MaxSize {
+		sort.Slice(kept, func(i, j int) bool {
+			return kept[i].ModTime.Before(kept[j].ModTime)
+		})
+		for _, file := range kept {
+			if keptSize <= policy.MaxSize {
+				break
+			}
+			file.Reason = RetentionMaxSize
+			result.Files = append(result.Files, file)
+			keptSize -= file.Size
+		}
+	}
+
+	for _, file := range result.Files {
+		result.Freed += file.Size
+	}
+	result.Count = len(result.Files)
+	result.FreedHuman = util.FormatFileSize(result.Freed)
+	return result, nil
+}
+
+// ApplyRetention deletes the uploads the retention policy selects at now and
+// returns the ones that were deleted
+func (fs *FileService) ApplyRetention(now time.Time) (*models.RetentionResponse, error) {
+	plan, err := fs.PlanRetention(now)
+	if err != nil || plan.Count == 0 {
+		return plan, err
+	}
+
+	uploadDir := fs.UploadDir()
+	result := *plan
+	result.Files = make([]models.RetentionEntry, 0, len(plan.Files))
+	result.Freed = 0
+
+	var errs []error
+	for _, file := range plan.Files {
+		if err := os.Remove(filepath.Join(uploadDir, file.Name)); err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		result.Files = append(result.Files, file)
+		result.Freed += file.Size
+	}
+	result.Count = len(result.Files)
+	result.FreedHuman = util.FormatFileSize(result.Freed)
+
+	if err := fs.pruneUploadIndex(uploadDir); err != nil {
+		errs = append(errs, fmt.Errorf("failed to update upload index: %w", err))
+	}
+	return &result, errors.Join(errs...)
+}
+
+// GetPrettyRetentionPreview returns a human-readable list of the uploads the
+// retention policy would delete now
+func (fs *FileService) GetPrettyRetentionPreview() (string, *models.RetentionResponse, error) {
+	result, err := fs.PlanRetention(time.Now())
+	if err != nil {
+		return "", result, err
+	}
+
+	var builder strings.Builder
+	var limits []string
+	if result.MaxAge != "" {
+		limits = append(limits, "max age "+result.MaxAge)
+	}
+	if result.MaxSize > 0 {
+		limits = append(limits, "max size "+util.FormatFileSize(result.MaxSize))
+	}
+	if len(limits) == 0 {
+		limits = append(limits, "X-Expire-After only")
+	}
+	builder.WriteString(fmt.Sprintf("Retention (%s):\n", strings.Join(limits, ", ")))
+
+	if result.Count == 0 {
+		builder.WriteString("Nothing would be deleted.\n")
+		return builder.String(), result, nil
+	}
+
+	builder.WriteString(fmt.Sprintf("Would delete %d files, freeing %s:\n", result.Count, result.FreedHuman))
+	for i, file := range result.Files {
+		connector := "├── "
+		if i == len(result.Files)-1 {
+			connector = "└── "
+		}
+		builder.WriteString(fmt.Sprintf("%s%s (%s) - %s [%s]\n",
+			connector,
+			file.Name,
+			file.SizeHuman,
+			file.ModTime.Format("2006-01-02 15:04:05"),
+			file.Reason))
+	}
+
+	return builder.String(), result, nil
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+)
+
+// retentionFixture saves uploads of the given sizes and ages and one expiring
+// upload, returning the service and the current time
+func retentionFixture(t *testing.T, policy RetentionPolicy) (*FileService, time.Time) {
+	t.Helper()
+	logger.InitLogger("error")
+	fs := NewFileService(fstest.MapFS{}, "root", t.TempDir(), 1<<20)
+	fs.SetRetentionPolicy(policy)
+	now := time.Now()
+
+	files := []struct {
+		name string
+		size int
+		age  time.Duration
+	}{
+		{"ancient.txt", 10, 48 * time.Hour},
+		{"old.txt", 20, 3 * time.Hour},
+		{"older.txt", 30, 5 * time.Hour},
+		{"new.txt", 40, time.Minute},
+	}
+	for _, file := range files {
+		if _, err := fs.SaveUpload(file.name, strings.NewReader(strings.Repeat("x", file.size)), -1, "10.0.0.1", 0); err != nil {
+			t.Fatal(err)
+		}
+		modTime := now.Add(-file.age)
+		if err := os.Chtimes(filepath.Join(fs.UploadDir(), file.name), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := fs.SaveUpload("expiring.txt", strings.NewReader("x"), 1, "10.0.0.1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	return fs, now
+}
+
+func TestPlanRetention(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy RetentionPolicy
+		after  time.Duration
+		want   []string // name:reason in deletion order
+	}{
+		{"nothing", RetentionPolicy{}, 0, nil},
+		{"expire after", RetentionPolicy{}, 2 * time.Hour, []string{"expiring.txt:expired"}},
+		{"max age", RetentionPolicy{MaxAge: 24 * time.Hour}, 0, []string{"ancient.txt:max-age"}},
+		{"max size oldest first", RetentionPolicy{MaxSize: 50}, 0, []string{"ancient.txt:max-size", "older.txt:max-size", "old.txt:max-size"}},
+		{"max size after max age", RetentionPolicy{MaxAge: 24 * time.Hour, MaxSize: 75}, 0, []string{"ancient.txt:max-age", "older.txt:max-size"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, now := retentionFixture(t, tt.policy)
+			plan, err := fs.PlanRetention(now.Add(tt.after))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			var freed int64
+			for _, file := range plan.Files {
+				got = append(got, file.Name+":"+file.Reason)
+				freed += file.Size
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") || plan.Count != len(tt.want) || plan.Freed != freed {
+				t.Errorf("planned %v (count %d, freed %d), want %v", got, plan.Count, plan.Freed, tt.want)
+			}
+			// Planning deletes nothing
+			if entries, _ := os.ReadDir(fs.UploadDir()); len(entries) != 6 {
+				t.Errorf("%d entries left after planning, want 6", len(entries))
+			}
+		})
+	}
+}
+
+func TestApplyRetention(t *testing.T) {
+	fs, now := retentionFixture(t, RetentionPolicy{MaxAge: 24 * time.Hour})
+
+	result, err := fs.ApplyRetention(now.Add(2 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Count != 2 || result.Freed != 11 {
+		t.Errorf("deleted %d files, %d bytes, want 2 and 11", result.Count, result.Freed)
+	}
+	for _, name := range []string{"ancient.txt", "expiring.txt"} {
+		if _, err := os.Stat(filepath.Join(fs.UploadDir(), name)); !os.IsNotExist(err) {
+			t.Errorf("%s not deleted: %v", name, err)
+		}
+	}
+	index := loadUploadIndex(fs.UploadDir())
+	if _, ok := index["ancient.txt"]; ok || len(index) != 3 {
+		t.Errorf("index holds %v, want the 3 remaining files", index)
+	}
+
+	// A second sweep finds nothing left to delete
+	if result, err := fs.ApplyRetention(now.Add(2 * time.Hour)); err != nil || result.Count != 0 {
+		t.Errorf("second sweep deleted %+v, %v", result, err)
+	}
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// uploadIndexFile records the source and expiry of each uploaded file, hidden
+// from the uploads list by its leading dot
+const uploadIndexFile = ".uploads.json"
+
+// captureLogFile is the default log of captured requests in the upload
+// directory, rotated to .hits.jsonl.1
+const captureLogFile = ".hits.jsonl"
+
+// uploadTempPrefix starts the names of uploads written next to the file
+// they replace
+const uploadTempPrefix = ".uploading-"
+
+// uploadRecord is what the index knows about an uploaded file
+type uploadRecord struct {
+	Source  string    `json:"source,omitempty"` // Address the file was uploaded from
+	Expires time.Time `json:"expires,omitzero"` // Deletion time requested at upload
+}
+
+// loadUploadIndex reads the filename to record index of the upload directory
+func loadUploadIndex(uploadDir string) map[string]uploadRecord {
+	index := make(map[string]uploadRecord)
+	data, err := os.ReadFile(filepath.Join(uploadDir, uploadIndexFile))
+	if err != nil {
+		return index
+	}
+	json.Unmarshal(data, &index)
+	return index
+}
+
+// recordUpload remembers record for filename, replacing what was known about
+// an earlier file of the same name
+func (fs *FileService) recordUpload(uploadDir, filename string, record uploadRecord) error {
+	fs.quotaMu.Lock()
+	defer fs.quotaMu.Unlock()
+	fs.usage = nil // Sizes or sources changed
+
+	index := loadUploadIndex(uploadDir)
+	index[filename] = record
+	return saveUploadIndex(uploadDir, index)
+}
+
+// moveUploadRecord moves the record of a renamed file to its new name
+func (fs *FileService) moveUploadRecord(uploadDir, oldName, newName string) error {
+	fs.quotaMu.Lock()
+	defer fs.quotaMu.Unlock()
+	fs.usage = nil // Sizes or sources changed
+
+	index := loadUploadIndex(uploadDir)
+	if record, ok := index[oldName]; ok {
+		index[newName] = record
+	} else {
+		delete(index, newName)
+	}
+	delete(index, oldName)
+	return saveUploadIndex(uploadDir, index)
+}
+
+// pruneUploadIndex drops the records of files that were deleted
+func (fs *FileService) pruneUploadIndex(uploadDir string) error {
+	fs.quotaMu.Lock()
+	defer fs.quotaMu.Unlock()
+	fs.usage = nil // Sizes or sources changed
+	return saveUploadIndex(uploadDir, loadUploadIndex(uploadDir))
+}
+
+// saveUploadIndex writes index, dropping entries of files that no longer exist
+func saveUploadIndex(uploadDir string, index map[string]uploadRecord) error {
+	for name := range index {
+		if _, err := os.Stat(filepath.Join(uploadDir, name)); err != nil {
+			delete(index, name)
+		}
+	}
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write and rename so a crash never leaves a truncated index
+	tmp := filepath.Join(uploadDir, uploadIndexFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(uploadDir, uploadIndexFile))
+}
+
+// isReservedUploadName reports whether name belongs to the upload index, the
+// capture log or an upload still being written, which clients must not be
+// able to read or overwrite
+func isReservedUploadName(name string) bool {
+	return strings.HasPrefix(name, uploadIndexFile) || strings.HasPrefix(name, captureLogFile) ||
+		strings.HasPrefix(name, uploadTempPrefix)
+}
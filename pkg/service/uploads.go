@@ -0,0 +1,238 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/models"
+	"github.com/m1kkY8/ctfserver/pkg/util"
+)
+
+// ErrInvalidFilename is returned for upload names that are unsafe or reserved
+var ErrInvalidFilename = errors.New("invalid filename")
+
+// UploadPath returns the path of the uploaded file filename
+func (fs *FileService) UploadPath(filename string) (string, error) {
+	if !IsValidUploadName(filename) {
+		return "", ErrInvalidFilename
+	}
+	return filepath.Join(fs.UploadDir(), filename), nil
+}
+
+// DeleteUpload removes the uploaded file filename
+func (fs *FileService) DeleteUpload(filename string) error {
+	path, err := fs.UploadPath(filename)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	if err := fs.pruneUploadIndex(fs.UploadDir()); err != nil {
+		return fmt.Errorf("failed to update upload index: %w", err)
+	}
+	return nil
+}
+
+// RenameUpload renames the uploaded file oldName to newName, replacing any
+// file of that name, and keeps its source and expiry
+func (fs *FileService) RenameUpload(oldName, newName string) error {
+	oldPath, err := fs.UploadPath(oldName)
+	if err != nil {
+		return err
+	}
+	newPath, err := fs.UploadPath(newName)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+	if err := fs.moveUploadRecord(fs.UploadDir(), oldName, newName); err != nil {
+		return fmt.Errorf("failed to update upload index: %w", err)
+	}
+	return nil
+}
+
+// IsValidUploadName reports whether name is a plain file name clients may upload to
+func IsValidUploadName(name string) bool {
+	return util.IsValidFilename(name) && filepath.Base(name) == name && !isReservedUploadName(name)
+}
+
+// ErrFileTooLarge is returned when a write would grow an upload past the maximum upload size
+var ErrFileTooLarge = errors.New("file too large")
+
+// UploadWriter is an upload written at arbitrary offsets, e.g. over SMB. Growth
+// is checked against the maximum upload size and held against the storage
+// limits as it happens, and Close records the upload like SaveUpload does. It
+// is safe for concurrent use, as by SFTP clients that pipeline writes.
+type UploadWriter struct {
+	fs          *FileService
+	file        *os.File
+	source      string
+	reservation *reservation
+
+	mu       sync.Mutex
+	name     string
+	size     int64
+	modified bool
+}
+
+// OpenUploadWriter opens filename in the upload directory for writing by
+// source, creating it if needed and emptying it when truncate is set
+func (fs *FileService) OpenUploadWriter(filename, source string, truncate bool) (*UploadWriter, error) {
+	filePath, err := fs.UploadPath(filename)
+	if err != nil {
+		return nil, err
+	}
+	if err := util.EnsureDir(fs.UploadDir()); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+
+	// The reservation takes over counting what the file already holds
+	var existing int64
+	if info, err := os.Stat(filePath); err == nil && !truncate {
+		existing = info.Size()
+	}
+	reservation, err := fs.reserveUpload(source, filePath, existing)
+	if err != nil {
+		return nil, err
+	}
+
+	flag := os.O_RDWR | os.O_CREATE
+	if truncate {
+		flag |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(filePath, flag, 0644)
+	if err != nil {
+		reservation.release()
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		reservation.release()
+		return nil, err
+	}
+
+	return &UploadWriter{
+		fs:          fs,
+		file:        file,
+		name:        filename,
+		source:      source,
+		reservation: reservation,
+		size:        info.Size(),
+		modified:    truncate,
+	}, nil
+}
+
+// Name returns the file name of the upload
+func (w *UploadWriter) Name() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.name
+}
+
+// Stat returns the file info of the upload
+func (w *UploadWriter) Stat() (os.FileInfo, error) {
+	return w.file.Stat()
+}
+
+// ReadAt reads back what was written
+func (w *UploadWriter) ReadAt(p []byte, off int64) (int, error) {
+	return w.file.ReadAt(p, off)
+}
+
+// WriteAt writes p at offset off
+func (w *UploadWriter) WriteAt(p []byte, off int64) (int, error) {
+	w.mu.Lock()
+	if err := w.grow(off + int64(len(p))); err != nil {
+		w.mu.Unlock()
+		return 0, err
+	}
+	w.modified = true
+	w.mu.Unlock()
+	return w.file.WriteAt(p, off)
+}
+
+// Truncate changes the size of the upload
+func (w *UploadWriter) Truncate(size int64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.grow(size); err != nil {
+		return err
+	}
+	if err := w.file.Truncate(size); err != nil {
+		return err
+	}
+	w.size = size
+	w.modified = true
+	return nil
+}
+
+// Sync commits the written data to disk
+func (w *UploadWriter) Sync() error {
+	return w.file.Sync()
+}
+
+// Rename renames the upload while it is open
+func (w *UploadWriter) Rename(newName string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.fs.RenameUpload(w.name, newName); err != nil {
+		return err
+	}
+	newPath, _ := w.fs.UploadPath(newName)
+	w.reservation.rename(newPath)
+	w.name = newName
+	return nil
+}
+
+// grow checks that the upload may grow to end bytes, with w.mu held
+func (w *UploadWriter) grow(end int64) error {
+	if end <= w.size {
+		return nil
+	}
+	if maxSize := w.fs.MaxSize(); end > maxSize {
+		return fmt.Errorf("%w: maximum upload size is %d bytes", ErrFileTooLarge, maxSize)
+	}
+	if err := w.reservation.ensure(end); err != nil {
+		return err
+	}
+	w.size = end
+	return nil
+}
+
+// Close closes the upload, recording its source if it was written to. The
+// result is nil if nothing was written.
+func (w *UploadWriter) Close() (*models.UploadResponse, error) {
+	defer w.reservation.release()
+	info, statErr := w.file.Stat()
+	if err := w.file.Close(); err != nil {
+		return nil, err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.modified {
+		return nil, nil
+	}
+	if statErr != nil {
+		return nil, statErr
+	}
+
+	uploadDir := w.fs.UploadDir()
+	if err := w.fs.recordUpload(uploadDir, w.name, uploadRecord{Source: w.source}); err != nil {
+		logger.Logger.WithError(err).Warn("Failed to record upload")
+	}
+
+	return &models.UploadResponse{
+		Success:  true,
+		Filename: w.name,
+		Size:     info.Size(),
+		Path:     filepath.Join(uploadDir, w.name),
+	}, nil
+}
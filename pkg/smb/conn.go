@@ -0,0 +1,359 @@
+package smb
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+)
+
+// maxMessageSize bounds the messages a client may send, well above the
+// largest write the server negotiates
+const maxMessageSize = 16 << 20
+
+// maxCredits is the most credits granted with a single response
+const maxCredits = 512
+
+// conn is one client connection. Its requests are handled one at a time, so
+// its state needs no locking.
+type conn struct {
+	server  *Server
+	netConn net.Conn
+	addr    netip.Addr
+
+	dialect            uint16
+	clientGUID         [16]byte
+	clientSecurityMode uint16
+	clientCapabilities uint32
+	preauth            []byte // 3.1.1 pre-authentication integrity hash of the connection
+
+	sessions      map[uint64]*session
+	files         map[uint64]*openFile
+	lastSessionID uint64
+	lastTreeID    uint32
+	lastFileID    uint64
+
+	compoundFileID uint64 // File created by the previous command of a compound request
+}
+
+// request is one command of a message
+type request struct {
+	msg          []byte // Header and body
+	command      uint16
+	creditCharge uint16
+	credits      uint16
+	flags        uint32
+	messageID    uint64
+	treeID       uint32
+	sessionID    uint64
+
+	session *session
+	tree    *tree
+	sign    bool    // Sign the response with the session's key
+	preauth *[]byte // Hash the response into this pre-authentication hash
+}
+
+// body returns the command's body following the header
+func (r *request) body() []byte {
+	return r.msg[headerSize:]
+}
+
+// Scopes of commands
+const (
+	scopeNone    = iota // Allowed before a logon
+	scopeSession        // Needs a session
+	scopeTree           // Needs a session and a tree
+)
+
+// commandFunc handles a command, returning the status and body of the
+// response; a nil body with an error status sends an error response
+type commandFunc func(c *conn, req *request) (status uint32, body []byte)
+
+var commands = map[uint16]struct {
+	handle commandFunc
+	scope  int
+}{
+	cmdNegotiate:      {(*conn).negotiate, scopeNone},
+	cmdSessionSetup:   {(*conn).sessionSetup, scopeNone},
+	cmdLogoff:         {(*conn).logoff, scopeSession},
+	cmdTreeConnect:    {(*conn).treeConnect, scopeSession},
+	cmdTreeDisconnect: {(*conn).treeDisconnect, scopeTree},
+	cmdCreate:         {(*conn).create, scopeTree},
+	cmdClose:          {(*conn).close, scopeTree},
+	cmdFlush:          {(*conn).flush, scopeTree},
+	cmdRead:           {(*conn).read, scopeTree},
+	cmdWrite:          {(*conn).write, scopeTree},
+	cmdLock:           {(*conn).lock, scopeTree},
+	cmdIoctl:          {(*conn).ioctl, scopeTree},
+	cmdEcho:           {(*conn).echo, scopeNone},
+	cmdQueryDirectory: {(*conn).queryDirectory, scopeTree},
+	cmdChangeNotify:   {(*conn).changeNotify, scopeTree},
+	cmdQueryInfo:      {(*conn).queryInfo, scopeTree},
+	cmdSetInfo:        {(*conn).setInfo, scopeTree},
+}
+
+func newConn(server *Server, netConn net.Conn) *conn {
+	c := &conn{
+		server:   server,
+		netConn:  netConn,
+		preauth:  make([]byte, sha512.Size),
+		sessions: make(map[uint64]*session),
+		files:    make(map[uint64]*openFile),
+	}
+	if addrPort, err := netip.ParseAddrPort(netConn.RemoteAddr().String()); err == nil {
+		c.addr = addrPort.Addr().Unmap()
+	}
+	return c
+}
+
+// source returns the client address as recorded with uploads
+func (c *conn) source() string {
+	if !c.addr.IsValid() {
+		return ""
+	}
+	return c.addr.String()
+}
+
+// serve handles the connection's messages until it is closed
+func (c *conn) serve() {
+	defer c.closeAll()
+	logger.Logger.WithField("remote_addr", c.netConn.RemoteAddr().String()).Debug("SMB connection opened")
+
+	for {
+		msg, err := c.readMessage()
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				logger.Logger.WithError(err).WithField("remote_addr", c.netConn.RemoteAddr().String()).Debug("SMB connection failed")
+			}
+			return
+		}
+
+		var reply []byte
+		switch {
+		case bytes.HasPrefix(msg, protocolID):
+			reply, err = c.handleMessage(msg)
+		case bytes.HasPrefix(msg, smb1ProtocolID):
+			reply, err = c.negotiateSMB1(msg)
+		default:
+			err = errors.New("unsupported protocol, encryption is not supported")
+		}
+		if err == nil && reply != nil {
+			err = c.writeMessage(reply)
+		}
+		if err != nil {
+			logger.Logger.WithError(err).WithField("remote_addr", c.netConn.RemoteAddr().String()).Debug("Closing SMB connection")
+			return
+		}
+	}
+}
+
+// readMessage reads the next message framed by a direct TCP transport header
+func (c *conn) readMessage() ([]byte, error) {
+	for {
+		var frame [4]byte
+		if _, err := io.ReadFull(c.netConn, frame[:]); err != nil {
+			return nil, err
+		}
+		length := int(frame[1])<<16 | int(frame[2])<<8 | int(frame[3])
+		if frame[0] != 0 || length > maxMessageSize {
+			return nil, fmt.Errorf("invalid message frame")
+		}
+		if length == 0 {
+			continue // Keep-alive
+		}
+		msg := make([]byte, length)
+		if _, err := io.ReadFull(c.netConn, msg); err != nil {
+			return nil, err
+		}
+		return msg, nil
+	}
+}
+
+// writeMessage sends msg with a direct TCP transport header
+func (c *conn) writeMessage(msg []byte) error {
+	frame := []byte{0, byte(len(msg) >> 16), byte(len(msg) >> 8), byte(len(msg))}
+	_, err := c.netConn.Write(append(frame, msg...))
+	return err
+}
+
+// handleMessage handles the commands of an SMB2 message, returning the reply
+func (c *conn) handleMessage(msg []byte) ([]byte, error) {
+	type response struct {
+		msg []byte
+		key []byte
+	}
+	var responses []response
+	var previous *request
+	var previousStatus uint32
+	c.compoundFileID = 0
+
+	for len(msg) > 0 {
+		if len(msg) < headerSize || !bytes.HasPrefix(msg, protocolID) {
+			return nil, errors.New("malformed SMB2 message")
+		}
+		next := int(le.Uint32(msg[20:]))
+		if next != 0 && (next < headerSize || next > len(msg)) {
+			return nil, errors.New("malformed compound message")
+		}
+		current := msg
+		if next != 0 {
+			current = msg[:next]
+		}
+		msg = msg[len(current):]
+
+		req := &request{
+			msg:          current,
+			command:      le.Uint16(current[12:]),
+			creditCharge: le.Uint16(current[6:]),
+			credits:      le.Uint16(current[14:]),
+			flags:        le.Uint32(current[16:]),
+			messageID:    le.Uint64(current[24:]),
+			treeID:       le.Uint32(current[36:]),
+			sessionID:    le.Uint64(current[40:]),
+		}
+		related := req.flags&flagRelated != 0 && previous != nil
+		if related {
+			req.sessionID = previous.sessionID
+			req.treeID = previous.treeID
+		}
+
+		var status uint32
+		var body []byte
+		if related && isError(previousStatus) {
+			status = previousStatus
+		} else {
+			status, body = c.dispatch(req)
+		}
+		if req.command == cmdCancel {
+			continue // Never answered
+		}
+		previous, previousStatus = req, status
+
+		reply := c.response(req, status, body)
+		if req.preauth != nil {
+			*req.preauth = preauthHash(*req.preauth, reply)
+		}
+		var key []byte
+		if req.sign && req.session != nil {
+			key = req.session.signingKey
+		}
+		responses = append(responses, response{reply, key})
+	}
+
+	// Chain the responses, each padded to 8 bytes and signed on its own
+	var out []byte
+	for i, resp := range responses {
+		if i < len(responses)-1 {
+			for len(resp.msg)%8 != 0 {
+				resp.msg = append(resp.msg, 0)
+			}
+			le.PutUint32(resp.msg[20:], uint32(len(resp.msg)))
+		}
+		if resp.key != nil {
+			sign(c.dialect, resp.key, resp.msg)
+		}
+		out = append(out, resp.msg...)
+	}
+	return out, nil
+}
+
+// dispatch checks the session, signature and tree of a request and handles it
+func (c *conn) dispatch(req *request) (uint32, []byte) {
+	command, ok := commands[req.command]
+	if !ok {
+		return statusNotSupported, nil
+	}
+	if c.dialect == 0 && req.command != cmdNegotiate {
+		return statusInvalidParameter, nil
+	}
+
+	// Session setup finds its session itself, echo is answered with or without one
+	if command.scope != scopeNone || req.command == cmdEcho {
+		req.session = c.sessions[req.sessionID]
+		if req.session == nil || !req.session.established {
+			if command.scope != scopeNone {
+				return statusUserSessionDeleted, nil
+			}
+			req.session = nil
+		}
+	}
+	if req.session != nil && req.session.signingKey != nil && req.flags&flagSigned != 0 {
+		if !verify(c.dialect, req.session.signingKey, req.msg) {
+			logger.Logger.WithField("remote_addr", c.netConn.RemoteAddr().String()).Warn("Rejected SMB request with a bad signature")
+			return statusAccessDenied, nil
+		}
+		req.sign = true
+	}
+
+	if command.scope == scopeTree {
+		req.tree = req.session.trees[req.treeID]
+		if req.tree == nil {
+			return statusNetworkNameDeleted, nil
+		}
+	}
+	return command.handle(c, req)
+}
+
+// response builds the response to req
+func (c *conn) response(req *request, status uint32, body []byte) []byte {
+	if body == nil {
+		body = errorBody()
+	}
+	credits := min(max(req.credits, 1), maxCredits)
+	flags := uint32(flagServerToRedir)
+	if req.flags&flagRelated != 0 {
+		flags |= flagRelated
+	}
+
+	var b builder
+	b.bytes(protocolID)
+	b.u16(headerSize)
+	b.u16(req.creditCharge)
+	b.u32(status)
+	b.u16(req.command)
+	b.u16(credits)
+	b.u32(flags)
+	b.u32(0) // NextCommand
+	b.u64(req.messageID)
+	b.u32(0) // Reserved
+	b.u32(req.treeID)
+	b.u64(req.sessionID)
+	b.zeros(16) // Signature
+	b.bytes(body)
+	return b.b
+}
+
+// errorBody is the body of an error response
+func errorBody() []byte {
+	var b builder
+	b.u16(9)   // StructureSize
+	b.zeros(7) // No error contexts or data
+	return b.b
+}
+
+// isError reports whether status is an error rather than success or a warning
+func isError(status uint32) bool {
+	return status>>30 == 3
+}
+
+// preauthHash extends a pre-authentication integrity hash with msg
+func preauthHash(previous, msg []byte) []byte {
+	hash := sha512.New()
+	hash.Write(previous)
+	hash.Write(msg)
+	return hash.Sum(nil)
+}
+
+// closeAll closes the files and sessions left open when the connection ends
+func (c *conn) closeAll() {
+	for _, f := range c.files {
+		c.closeFile(f)
+	}
+	c.netConn.Close()
+	logger.Logger.WithField("remote_addr", c.netConn.RemoteAddr().String()).Debug("SMB connection closed")
+}
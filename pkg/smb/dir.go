@@ -0,0 +1,204 @@
+package smb
+
+import (
+	iofs "io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/m1kkY8/ctfserver/pkg/service"
+	"github.com/m1kkY8/ctfserver/pkg/util"
+)
+
+// QUERY_DIRECTORY flags
+const (
+	queryRestartScans      = 0x01
+	queryReturnSingleEntry = 0x02
+	queryReopen            = 0x10
+)
+
+// Directory information classes
+const (
+	classDirectory       = 1
+	classFullDirectory   = 2
+	classBothDirectory   = 3
+	classNames           = 12
+	classIDBothDirectory = 37
+	classIDFullDirectory = 38
+)
+
+// dirEntry is an entry of a directory listing
+type dirEntry struct {
+	name string
+	info iofs.FileInfo
+}
+
+// queryDirectory lists the entries of a directory matching a pattern,
+// continuing where the previous query of the same handle stopped
+func (c *conn) queryDirectory(req *request) (uint32, []byte) {
+	body := req.body()
+	if len(body) < 32 {
+		return statusInvalidParameter, nil
+	}
+	f, status := c.file(req, 8)
+	if f == nil {
+		return status, nil
+	}
+	if !f.dir {
+		return statusInvalidParameter, nil
+	}
+	if !f.canRead {
+		return statusAccessDenied, nil
+	}
+	class, flags := body[2], body[3]
+	if !validDirectoryClass(class) {
+		return statusInvalidInfoClass, nil
+	}
+
+	if !f.listed || flags&(queryRestartScans|queryReopen) != 0 {
+		pattern := decodeString(field(req.msg, int(le.Uint16(body[24:])), int(le.Uint16(body[26:]))))
+		entries, err := c.listDirectory(f)
+		if err != nil {
+			return fileStatus(err), nil
+		}
+		f.listing = nil
+		for _, entry := range entries {
+			if matchPattern(pattern, entry.name) {
+				f.listing = append(f.listing, entry)
+			}
+		}
+		if !f.listed && len(f.listing) == 0 {
+			f.listed = true
+			return statusNoSuchFile, nil
+		}
+		f.listed = true
+	}
+	if len(f.listing) == 0 {
+		return statusNoMoreFiles, nil
+	}
+
+	// Fill the output buffer with entries aligned to 8 bytes
+	maxLength := int(le.Uint32(body[28:]))
+	var out builder
+	last := 0
+	for len(f.listing) > 0 {
+		entry := directoryEntry(class, f.listing[0], f)
+		start := (out.len() + 7) &^ 7
+		if start+len(entry) > maxLength {
+			break
+		}
+		if out.len() > 0 {
+			out.align(8)
+			le.PutUint32(out.b[last:], uint32(start-last))
+		}
+		last = start
+		out.bytes(entry)
+		f.listing = f.listing[1:]
+		if flags&queryReturnSingleEntry != 0 {
+			break
+		}
+	}
+	if out.len() == 0 {
+		return statusInfoLengthMismatch, nil
+	}
+
+	var b builder
+	b.u16(9) // StructureSize
+	b.u16(headerSize + 8)
+	b.u32(uint32(out.len()))
+	b.bytes(out.b)
+	return statusSuccess, b.b
+}
+
+// listDirectory returns the entries of a directory, "." and ".." first
+func (c *conn) listDirectory(f *openFile) ([]dirEntry, error) {
+	self, err := c.stat(f)
+	if err != nil {
+		return nil, err
+	}
+	entries := []dirEntry{{".", self}, {"..", self}}
+
+	if f.tree.share == UploadsShare {
+		files, err := os.ReadDir(c.server.fileService.UploadDir())
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if !service.IsValidUploadName(file.Name()) || file.IsDir() {
+				continue
+			}
+			if info, err := file.Info(); err == nil {
+				entries = append(entries, dirEntry{file.Name(), info})
+			}
+		}
+		return entries, nil
+	}
+
+	files, err := iofs.ReadDir(c.server.fileService.RootFS(), util.CleanPath(f.name))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if info, err := file.Info(); err == nil {
+			entries = append(entries, dirEntry{file.Name(), info})
+		}
+	}
+	return entries, nil
+}
+
+// matchPattern matches a name against a Windows search pattern, case-insensitively
+func matchPattern(pattern, name string) bool {
+	if pattern == "" || pattern == "*" || pattern == "*.*" {
+		return true
+	}
+	// DOS wildcards: < and > as * and ?, " as a dot
+	pattern = strings.NewReplacer(`\`, `\\`, "[", `\[`, "<", "*", ">", "?", `"`, ".").Replace(pattern)
+	matched, err := path.Match(strings.ToLower(pattern), strings.ToLower(name))
+	return err == nil && matched
+}
+
+func validDirectoryClass(class byte) bool {
+	switch class {
+	case classDirectory, classFullDirectory, classBothDirectory, classNames, classIDBothDirectory, classIDFullDirectory:
+		return true
+	}
+	return false
+}
+
+// directoryEntry encodes an entry in a directory information class, with
+// NextEntryOffset left zero
+func directoryEntry(class byte, entry dirEntry, dir *openFile) []byte {
+	name := encodeString(entry.name)
+	var b builder
+	b.u32(0) // NextEntryOffset
+	b.u32(0) // FileIndex
+	if class == classNames {
+		b.u32(uint32(len(name)))
+		b.bytes(name)
+		return b.b
+	}
+
+	fileTimes(&b, entry.info)
+	b.u64(uint64(max(entry.info.Size(), 0)))
+	b.u64(allocationSize(entry.info))
+	b.u32(attributes(entry.info))
+	b.u32(uint32(len(name)))
+	if class != classDirectory {
+		b.u32(0) // EaSize
+	}
+	switch class {
+	case classBothDirectory, classIDBothDirectory:
+		b.u8(0) // ShortNameLength
+		b.u8(0)
+		b.zeros(24) // ShortName
+		if class == classIDBothDirectory {
+			b.u16(0)
+			b.u64(fileID(dir.tree.share, path.Join(dir.name, entry.name)))
+		}
+	case classIDFullDirectory:
+		b.u32(0)
+		b.u64(fileID(dir.tree.share, path.Join(dir.name, entry.name)))
+	}
+	b.bytes(name)
+	return b.b
+}
@@ -0,0 +1,620 @@
+package smb
+
+import (
+	"errors"
+	"io"
+	iofs "io/fs"
+	"os"
+	"strings"
+
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+	"github.com/m1kkY8/ctfserver/pkg/util"
+)
+
+// Create dispositions
+const (
+	fileSupersede   = 0
+	fileOpen        = 1
+	fileCreate      = 2
+	fileOpenIf      = 3
+	fileOverwrite   = 4
+	fileOverwriteIf = 5
+)
+
+// Create actions
+const (
+	fileSuperseded  = 0
+	fileOpened      = 1
+	fileCreated     = 2
+	fileOverwritten = 3
+)
+
+// Create options
+const (
+	optionDirectory     = 0x00000001
+	optionNonDirectory  = 0x00000040
+	optionDeleteOnClose = 0x00001000
+)
+
+// accessWriteData are the access rights asking to change or delete a file:
+// FILE_WRITE_DATA, FILE_APPEND_DATA, DELETE, GENERIC_ALL and GENERIC_WRITE
+const accessWriteData = 0x00000002 | 0x00000004 | 0x00010000 | 0x10000000 | 0x40000000
+
+// closePostQuery asks CLOSE to return the file's attributes
+const closePostQuery = 0x0001
+
+// Control codes answered by IOCTL
+const (
+	fsctlDFSGetReferrals       = 0x00060194
+	fsctlDFSGetReferralsEx     = 0x000601B0
+	fsctlValidateNegotiateInfo = 0x00140204
+)
+
+// openFile is a file or directory opened by CREATE
+type openFile struct {
+	id      uint64
+	tree    *tree
+	session *session
+	name    string // Path within the share with forward slashes, "" for its top directory
+	dir     bool
+	access  uint32
+
+	root   iofs.File             // Files share
+	upload *os.File              // Uploads share, opened for reading
+	writer *service.UploadWriter // Uploads share, opened for writing
+
+	canRead       bool // File data may be read
+	deleteOnClose bool
+	listing       []dirEntry // Directory entries left to return
+	listed        bool
+	served        int64 // Bytes read, logged on close
+}
+
+// stat returns the current info of the file
+func (c *conn) stat(f *openFile) (iofs.FileInfo, error) {
+	switch {
+	case f.writer != nil:
+		return f.writer.Stat()
+	case f.upload != nil:
+		return f.upload.Stat()
+	case f.root != nil:
+		return f.root.Stat()
+	case f.tree.share == UploadsShare:
+		return os.Stat(c.server.fileService.UploadDir())
+	}
+	return iofs.Stat(c.server.fileService.RootFS(), util.CleanPath(f.name))
+}
+
+// readAt reads the file's data at off
+func (f *openFile) readAt(p []byte, off int64) (int, error) {
+	switch {
+	case f.writer != nil:
+		return f.writer.ReadAt(p, off)
+	case f.upload != nil:
+		return f.upload.ReadAt(p, off)
+	}
+
+	if readerAt, ok := f.root.(io.ReaderAt); ok {
+		return readerAt.ReadAt(p, off)
+	}
+	seeker, ok := f.root.(io.Seeker)
+	if !ok {
+		return 0, errors.New("file does not support seeking")
+	}
+	if _, err := seeker.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(f.root, p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// file returns the open file whose ID is at offset of the request body
+func (c *conn) file(req *request, offset int) (*openFile, uint32) {
+	id := field(req.body(), offset, 16)
+	if id == nil {
+		return nil, statusInvalidParameter
+	}
+	volatile := le.Uint64(id[8:])
+	if req.flags&flagRelated != 0 && le.Uint64(id) == ^uint64(0) && volatile == ^uint64(0) {
+		volatile = c.compoundFileID
+	}
+	f := c.files[volatile]
+	if f == nil || f.tree != req.tree {
+		return nil, statusFileClosed
+	}
+	return f, statusSuccess
+}
+
+// create opens or creates a file or directory
+func (c *conn) create(req *request) (uint32, []byte) {
+	body := req.body()
+	if len(body) < 56 {
+		return statusInvalidParameter, nil
+	}
+	if req.tree.share == ipcShare {
+		return statusObjectNameNotFound, nil // No named pipes
+	}
+
+	name := decodeString(field(req.msg, int(le.Uint16(body[44:])), int(le.Uint16(body[46:]))))
+	name = strings.TrimSuffix(strings.ReplaceAll(name, `\`, "/"), "::$DATA")
+	name = strings.Trim(name, "/")
+	if strings.Contains(name, ":") {
+		return statusObjectNameNotFound, nil // No alternate data streams
+	}
+
+	f := &openFile{
+		tree:    req.tree,
+		session: req.session,
+		access:  le.Uint32(body[24:]),
+	}
+	disposition := le.Uint32(body[36:])
+	options := le.Uint32(body[40:])
+
+	var status, action uint32
+	if req.tree.share == FilesShare {
+		status, action = c.openRoot(f, name, disposition, options)
+	} else {
+		status, action = c.openUpload(f, name, disposition, options)
+	}
+	if status != statusSuccess {
+		return status, nil
+	}
+
+	info, err := c.stat(f)
+	if err != nil {
+		c.closeFile(f)
+		return fileStatus(err), nil
+	}
+
+	c.lastFileID++
+	f.id = c.lastFileID
+	c.files[f.id] = f
+	c.compoundFileID = f.id
+
+	contexts := c.createContexts(req, f, info)
+	var b builder
+	b.u16(89) // StructureSize
+	b.u8(0)   // OplockLevel: none
+	b.u8(0)   // Flags
+	b.u32(action)
+	fileTimes(&b, info)
+	b.u64(allocationSize(info))
+	b.u64(uint64(max(info.Size(), 0)))
+	b.u32(attributes(info))
+	b.u32(0) // Reserved2
+	b.u64(f.id)
+	b.u64(f.id)
+	if contexts != nil {
+		b.u32(headerSize + 88)
+	} else {
+		b.u32(0)
+	}
+	b.u32(uint32(len(contexts)))
+	b.bytes(contexts)
+	return statusSuccess, b.b
+}
+
+// openRoot opens a file or directory of the read-only files share
+func (c *conn) openRoot(f *openFile, name string, disposition, options uint32) (uint32, uint32) {
+	relPath := util.CleanPath(name)
+	rootFS := c.server.fileService.RootFS()
+	info, err := iofs.Stat(rootFS, relPath)
+	if err != nil {
+		if errors.Is(err, iofs.ErrNotExist) && (disposition == fileOpen || disposition == fileOverwrite) {
+			return statusObjectNameNotFound, 0
+		}
+		if errors.Is(err, iofs.ErrNotExist) {
+			return statusAccessDenied, 0
+		}
+		return fileStatus(err), 0
+	}
+	if f.access&accessWriteData != 0 || disposition != fileOpen && disposition != fileOpenIf || options&optionDeleteOnClose != 0 {
+		return statusAccessDenied, 0
+	}
+	if status := checkKind(info.IsDir(), options); status != statusSuccess {
+		return status, 0
+	}
+
+	if relPath != "." {
+		f.name = relPath
+	}
+	f.dir = info.IsDir()
+	f.canRead = f.tree.read
+	if !f.dir {
+		if f.root, err = rootFS.Open(relPath); err != nil {
+			return fileStatus(err), 0
+		}
+	}
+	return statusSuccess, fileOpened
+}
+
+// openUpload opens or creates a file of the uploads share, or the share's
+// top directory, the only one as the share is flat
+func (c *conn) openUpload(f *openFile, name string, disposition, options uint32) (uint32, uint32) {
+	fileService := c.server.fileService
+	if name == "" {
+		if disposition != fileOpen && disposition != fileOpenIf {
+			return statusAccessDenied, 0
+		}
+		if status := checkKind(true, options); status != statusSuccess {
+			return status, 0
+		}
+		if err := util.EnsureDir(fileService.UploadDir()); err != nil {
+			return fileStatus(err), 0
+		}
+		f.dir = true
+		f.canRead = f.tree.read
+		return statusSuccess, fileOpened
+	}
+	if strings.Contains(name, "/") {
+		return statusObjectPathNotFound, 0
+	}
+
+	filePath, err := fileService.UploadPath(name)
+	if err != nil {
+		if disposition == fileOpen || disposition == fileOverwrite {
+			return statusObjectNameNotFound, 0
+		}
+		return statusObjectNameInvalid, 0
+	}
+	info, statErr := os.Stat(filePath)
+	exists := statErr == nil
+	if exists && info.IsDir() {
+		return statusAccessDenied, 0
+	}
+	switch {
+	case !exists && (disposition == fileOpen || disposition == fileOverwrite):
+		return statusObjectNameNotFound, 0
+	case exists && disposition == fileCreate:
+		return statusObjectNameCollision, 0
+	case options&optionDirectory != 0:
+		if exists {
+			return statusNotADirectory, 0
+		}
+		return statusAccessDenied, 0 // No subdirectories
+	}
+
+	creating := !exists
+	truncating := exists && (disposition == fileSupersede || disposition == fileOverwrite || disposition == fileOverwriteIf)
+	writing := f.access&accessWriteData != 0 || creating || truncating || options&optionDeleteOnClose != 0
+	if writing && !f.tree.write {
+		return statusAccessDenied, 0
+	}
+
+	f.name = name
+	f.deleteOnClose = options&optionDeleteOnClose != 0
+	// Uploaders may read back what they write but not the rest of the loot
+	f.canRead = f.tree.read || creating || truncating
+
+	if writing {
+		if f.writer, err = fileService.OpenUploadWriter(name, c.source(), truncating); err != nil {
+			return fileStatus(err), 0
+		}
+	} else if f.upload, err = os.Open(filePath); err != nil {
+		return fileStatus(err), 0
+	}
+
+	switch {
+	case creating:
+		return statusSuccess, fileCreated
+	case truncating && disposition == fileSupersede:
+		return statusSuccess, fileSuperseded
+	case truncating:
+		return statusSuccess, fileOverwritten
+	}
+	return statusSuccess, fileOpened
+}
+
+// checkKind checks the directory and non-directory create options
+func checkKind(dir bool, options uint32) uint32 {
+	switch {
+	case dir && options&optionNonDirectory != 0:
+		return statusFileIsADirectory
+	case !dir && options&optionDirectory != 0:
+		return statusNotADirectory
+	}
+	return statusSuccess
+}
+
+// createContexts answers the maximal access and file ID create contexts
+func (c *conn) createContexts(req *request, f *openFile, info iofs.FileInfo) []byte {
+	body := req.body()
+	offset, length := int(le.Uint32(body[48:])), int(le.Uint32(body[52:]))
+	contexts := field(req.msg, offset, length)
+
+	var responses [][]byte
+	for len(contexts) >= 16 {
+		next := int(le.Uint32(contexts))
+		name := field(contexts, int(le.Uint16(contexts[4:])), int(le.Uint16(contexts[6:])))
+
+		var data builder
+		switch string(name) {
+		case "MxAc":
+			data.u32(statusSuccess)
+			data.u32(f.tree.maximalAccess())
+		case "QFid":
+			data.u64(fileID(f.tree.share, f.name))
+			data.zeros(8)
+			data.bytes(c.server.guid[:8]) // VolumeId
+			data.zeros(8)
+		}
+		if data.len() > 0 {
+			var ctx builder
+			ctx.u32(0) // Next, set below
+			ctx.u16(16)
+			ctx.u16(uint16(len(name)))
+			ctx.u16(0)
+			ctx.u16(24)
+			ctx.u32(uint32(data.len()))
+			ctx.bytes(name)
+			ctx.align(8)
+			ctx.bytes(data.b)
+			responses = append(responses, ctx.b)
+		}
+
+		if next == 0 || next > len(contexts) {
+			break
+		}
+		contexts = contexts[next:]
+	}
+
+	var b builder
+	for i, ctx := range responses {
+		if i < len(responses)-1 {
+			for len(ctx)%8 != 0 {
+				ctx = append(ctx, 0)
+			}
+			le.PutUint32(ctx, uint32(len(ctx)))
+		}
+		b.bytes(ctx)
+	}
+	return b.b
+}
+
+// close closes a file
+func (c *conn) close(req *request) (uint32, []byte) {
+	f, status := c.file(req, 8)
+	if f == nil {
+		return status, nil
+	}
+	postQuery := le.Uint16(req.body()[2:])&closePostQuery != 0
+
+	var info iofs.FileInfo
+	if postQuery {
+		info, _ = c.stat(f)
+	}
+	c.closeFile(f)
+
+	var b builder
+	b.u16(60) // StructureSize
+	if info == nil {
+		b.u16(0)
+		b.zeros(56)
+		return statusSuccess, b.b
+	}
+	b.u16(closePostQuery)
+	b.u32(0) // Reserved
+	fileTimes(&b, info)
+	b.u64(allocationSize(info))
+	b.u64(uint64(max(info.Size(), 0)))
+	b.u32(attributes(info))
+	return statusSuccess, b.b
+}
+
+// closeFile closes f, recording and logging what was done with it
+func (c *conn) closeFile(f *openFile) {
+	delete(c.files, f.id)
+	fields := map[string]interface{}{
+		"filename": f.name,
+		"share":    f.tree.share,
+		"source":   c.source(),
+		"user":     f.session.user,
+	}
+
+	switch {
+	case f.writer != nil:
+		result, err := f.writer.Close()
+		if err != nil {
+			logger.Logger.WithError(err).WithFields(fields).Error("Failed to close upload")
+		} else if result != nil && !f.deleteOnClose {
+			logger.Logger.WithFields(map[string]interface{}{
+				"filename": result.Filename,
+				"size":     result.Size,
+				"path":     result.Path,
+				"source":   c.source(),
+				"user":     f.session.user,
+				"via":      "smb",
+			}).Info("File uploaded successfully")
+		}
+	case f.upload != nil:
+		f.upload.Close()
+	case f.root != nil:
+		f.root.Close()
+	}
+
+	if f.served > 0 {
+		fields["bytes"] = f.served
+		logger.Logger.WithFields(fields).Info("Served file over SMB")
+	}
+
+	if f.deleteOnClose {
+		if err := c.server.fileService.DeleteUpload(f.name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.Logger.WithError(err).WithFields(fields).Error("Failed to delete upload")
+			return
+		}
+		logger.Logger.WithFields(fields).Info("Deleted upload over SMB")
+	}
+}
+
+// flush commits written data to disk
+func (c *conn) flush(req *request) (uint32, []byte) {
+	f, status := c.file(req, 8)
+	if f == nil {
+		return status, nil
+	}
+	if f.writer != nil {
+		if err := f.writer.Sync(); err != nil {
+			return fileStatus(err), nil
+		}
+	}
+	return statusSuccess, emptyBody()
+}
+
+// read reads file data
+func (c *conn) read(req *request) (uint32, []byte) {
+	f, status := c.file(req, 16)
+	if f == nil {
+		return status, nil
+	}
+	if f.dir {
+		return statusInvalidDeviceRequest, nil
+	}
+	if !f.canRead {
+		return statusAccessDenied, nil
+	}
+
+	body := req.body()
+	length := min(le.Uint32(body[4:]), maxTransferSize)
+	offset := le.Uint64(body[8:])
+	data := make([]byte, length)
+	n, err := f.readAt(data, int64(offset))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fileStatus(err), nil
+	}
+	if n == 0 && length > 0 {
+		return statusEndOfFile, nil
+	}
+	f.served += int64(n)
+
+	var b builder
+	b.u16(17)             // StructureSize
+	b.u8(headerSize + 16) // DataOffset
+	b.u8(0)
+	b.u32(uint32(n))
+	b.u32(0) // DataRemaining
+	b.u32(0) // Reserved2
+	b.bytes(data[:n])
+	return statusSuccess, b.b
+}
+
+// write writes file data to an upload
+func (c *conn) write(req *request) (uint32, []byte) {
+	f, status := c.file(req, 16)
+	if f == nil {
+		return status, nil
+	}
+	if f.writer == nil {
+		return statusAccessDenied, nil
+	}
+
+	body := req.body()
+	data := field(req.msg, int(le.Uint16(body[2:])), int(le.Uint32(body[4:])))
+	if data == nil {
+		return statusInvalidParameter, nil
+	}
+	offset := int64(le.Uint64(body[8:]))
+	if offset < 0 { // Append
+		info, err := f.writer.Stat()
+		if err != nil {
+			return fileStatus(err), nil
+		}
+		offset = info.Size()
+	}
+	n, err := f.writer.WriteAt(data, offset)
+	if err != nil {
+		return c.storageStatus(err, f), nil
+	}
+
+	var b builder
+	b.u16(17) // StructureSize
+	b.u16(0)  // Reserved
+	b.u32(uint32(n))
+	b.u32(0) // Remaining
+	b.u16(0) // WriteChannelInfoOffset
+	b.u16(0) // WriteChannelInfoLength
+	return statusSuccess, b.b
+}
+
+// lock grants byte range locks; nothing else writes the files concurrently
+func (c *conn) lock(req *request) (uint32, []byte) {
+	if f, status := c.file(req, 8); f == nil {
+		return status, nil
+	}
+	return statusSuccess, emptyBody()
+}
+
+// changeNotify is not supported, clients fall back to polling
+func (c *conn) changeNotify(req *request) (uint32, []byte) {
+	return statusNotSupported, nil
+}
+
+// ioctl answers negotiate validation and declines DFS referrals
+func (c *conn) ioctl(req *request) (uint32, []byte) {
+	body := req.body()
+	if len(body) < 56 {
+		return statusInvalidParameter, nil
+	}
+	code := le.Uint32(body[4:])
+
+	var out builder
+	switch code {
+	case fsctlValidateNegotiateInfo:
+		out.u32(capabilities(c.dialect))
+		out.bytes(c.server.guid[:])
+		out.u16(signingEnabled)
+		out.u16(c.dialect)
+	case fsctlDFSGetReferrals, fsctlDFSGetReferralsEx:
+		return statusNotFound, nil
+	default:
+		return statusInvalidDeviceRequest, nil
+	}
+
+	var b builder
+	b.u16(49) // StructureSize
+	b.u16(0)  // Reserved
+	b.u32(code)
+	b.bytes(body[8:24]) // FileId
+	b.u32(headerSize + 48)
+	b.u32(0) // InputCount
+	b.u32(headerSize + 48)
+	b.u32(uint32(out.len()))
+	b.u32(0) // Flags
+	b.u32(0) // Reserved2
+	b.bytes(out.b)
+	return statusSuccess, b.b
+}
+
+// storageStatus maps an error writing an upload to a status, logging
+// uploads refused by the storage limits like the upload API does
+func (c *conn) storageStatus(err error, f *openFile) uint32 {
+	if errors.Is(err, service.ErrInsufficientStorage) || errors.Is(err, service.ErrFileTooLarge) {
+		logger.Logger.WithError(err).WithFields(map[string]interface{}{
+			"filename": f.name,
+			"source":   c.source(),
+			"user":     f.session.user,
+		}).Warn("Rejected upload over storage limits")
+	}
+	return fileStatus(err)
+}
+
+// fileStatus maps a file system or file service error to a status
+func fileStatus(err error) uint32 {
+	switch {
+	case errors.Is(err, service.ErrInsufficientStorage):
+		return statusDiskFull
+	case errors.Is(err, service.ErrFileTooLarge):
+		return statusFileTooLarge
+	case errors.Is(err, service.ErrInvalidFilename):
+		return statusObjectNameInvalid
+	case errors.Is(err, iofs.ErrNotExist):
+		return statusObjectNameNotFound
+	case errors.Is(err, iofs.ErrPermission):
+		return statusAccessDenied
+	}
+	logger.Logger.WithError(err).Error("SMB file operation failed")
+	return statusUnexpectedIOError
+}
@@ -0,0 +1,418 @@
+package smb
+
+import (
+	"errors"
+	"hash/fnv"
+	iofs "io/fs"
+	"os"
+	"strings"
+
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/util"
+)
+
+// Info types of QUERY_INFO and SET_INFO
+const (
+	infoFile       = 1
+	infoFilesystem = 2
+	infoSecurity   = 3
+)
+
+// File information classes
+const (
+	classBasic         = 4
+	classStandard      = 5
+	classInternal      = 6
+	classEa            = 7
+	classAccess        = 8
+	classRename        = 10
+	classDisposition   = 13
+	classPosition      = 14
+	classMode          = 16
+	classAlignment     = 17
+	classAll           = 18
+	classAllocation    = 19
+	classEndOfFile     = 20
+	classStream        = 22
+	classCompression   = 28
+	classNetworkOpen   = 34
+	classAttributeTag  = 35
+	classDispositionEx = 64
+)
+
+// File system information classes
+const (
+	fsVolume     = 1
+	fsSize       = 3
+	fsDevice     = 4
+	fsAttribute  = 5
+	fsFullSize   = 7
+	fsObjectID   = 8
+	fsSectorSize = 11
+)
+
+// File attributes
+const (
+	attributeDirectory = 0x00000010
+	attributeArchive   = 0x00000020
+)
+
+// Disk geometry reported to clients: 4 KiB clusters of 512-byte sectors
+const (
+	sectorSize        = 512
+	sectorsPerCluster = 8
+	clusterSize       = sectorSize * sectorsPerCluster
+)
+
+// queryInfo returns information about a file, its file system or its security
+func (c *conn) queryInfo(req *request) (uint32, []byte) {
+	body := req.body()
+	if len(body) < 40 {
+		return statusInvalidParameter, nil
+	}
+	f, status := c.file(req, 24)
+	if f == nil {
+		return status, nil
+	}
+	info, err := c.stat(f)
+	if err != nil {
+		return fileStatus(err), nil
+	}
+
+	var data []byte
+	status = statusSuccess
+	switch body[2] {
+	case infoFile:
+		data, status = fileInfo(f, info, body[3])
+	case infoFilesystem:
+		data, status = c.filesystemInfo(f, body[3])
+	case infoSecurity:
+		data = securityDescriptor()
+	default:
+		status = statusNotSupported
+	}
+	if status != statusSuccess {
+		return status, nil
+	}
+
+	if maxLength := int(le.Uint32(body[4:])); len(data) > maxLength {
+		data, status = data[:maxLength], statusBufferOverflow
+	}
+	var b builder
+	b.u16(9) // StructureSize
+	b.u16(headerSize + 8)
+	b.u32(uint32(len(data)))
+	b.bytes(data)
+	return status, b.b
+}
+
+// fileInfo encodes a file information class
+func fileInfo(f *openFile, info iofs.FileInfo, class byte) ([]byte, uint32) {
+	var b builder
+	switch class {
+	case classBasic:
+		basicInfo(&b, info)
+	case classStandard:
+		standardInfo(&b, f, info)
+	case classInternal:
+		b.u64(fileID(f.tree.share, f.name))
+	case classEa:
+		b.u32(0)
+	case classAccess:
+		b.u32(f.access)
+	case classPosition:
+		b.u64(0)
+	case classMode, classAlignment:
+		b.u32(0)
+	case classAll:
+		basicInfo(&b, info)
+		standardInfo(&b, f, info)
+		b.u64(fileID(f.tree.share, f.name))
+		b.u32(0) // EaSize
+		b.u32(f.access)
+		b.u64(0) // CurrentByteOffset
+		b.u32(0) // Mode
+		b.u32(0) // AlignmentRequirement
+		name := encodeString(`\` + strings.ReplaceAll(f.name, "/", `\`))
+		b.u32(uint32(len(name)))
+		b.bytes(name)
+	case classStream:
+		if !info.IsDir() {
+			name := encodeString("::$DATA")
+			b.u32(0) // NextEntryOffset
+			b.u32(uint32(len(name)))
+			b.u64(uint64(max(info.Size(), 0)))
+			b.u64(allocationSize(info))
+			b.bytes(name)
+		}
+	case classCompression:
+		b.u64(uint64(max(info.Size(), 0)))
+		b.zeros(8) // Not compressed
+	case classNetworkOpen:
+		fileTimes(&b, info)
+		b.u64(allocationSize(info))
+		b.u64(uint64(max(info.Size(), 0)))
+		b.u32(attributes(info))
+		b.u32(0)
+	case classAttributeTag:
+		b.u32(attributes(info))
+		b.u32(0) // ReparseTag
+	default:
+		return nil, statusInvalidInfoClass
+	}
+	return b.b, statusSuccess
+}
+
+func basicInfo(b *builder, info iofs.FileInfo) {
+	fileTimes(b, info)
+	b.u32(attributes(info))
+	b.u32(0)
+}
+
+func standardInfo(b *builder, f *openFile, info iofs.FileInfo) {
+	b.u64(allocationSize(info))
+	b.u64(uint64(max(info.Size(), 0)))
+	b.u32(1) // NumberOfLinks
+	if f.deleteOnClose {
+		b.u8(1)
+	} else {
+		b.u8(0)
+	}
+	if info.IsDir() {
+		b.u8(1)
+	} else {
+		b.u8(0)
+	}
+	b.u16(0)
+}
+
+// filesystemInfo encodes a file system information class. Sizes are those
+// of the disk holding the upload directory, with no space free on the
+// read-only files share.
+func (c *conn) filesystemInfo(f *openFile, class byte) ([]byte, uint32) {
+	var total, free uint64
+	if space, err := util.GetDiskSpace(c.server.fileService.UploadDir()); err == nil {
+		total = uint64(space.Total) / clusterSize
+		if f.tree.share == UploadsShare {
+			free = uint64(space.Free) / clusterSize
+		}
+	}
+
+	var b builder
+	switch class {
+	case fsVolume:
+		label := encodeString(f.tree.share)
+		b.u64(fileTime(c.server.started))
+		b.u32(le.Uint32(c.server.guid[:])) // VolumeSerialNumber
+		b.u32(uint32(len(label)))
+		b.u8(0) // SupportsObjects
+		b.u8(0)
+		b.bytes(label)
+	case fsSize:
+		b.u64(total)
+		b.u64(free)
+		b.u32(sectorsPerCluster)
+		b.u32(sectorSize)
+	case fsDevice:
+		b.u32(0x07) // FILE_DEVICE_DISK
+		b.u32(0x20) // FILE_DEVICE_IS_MOUNTED
+	case fsAttribute:
+		attributes := uint32(0x02 | 0x04) // Case preserved and Unicode names
+		if f.tree.share == FilesShare {
+			attributes |= 0x00080000 // Read-only volume
+		}
+		name := encodeString("NTFS")
+		b.u32(attributes)
+		b.u32(255) // MaximumComponentNameLength
+		b.u32(uint32(len(name)))
+		b.bytes(name)
+	case fsFullSize:
+		b.u64(total)
+		b.u64(free) // CallerAvailableAllocationUnits
+		b.u64(free) // ActualAvailableAllocationUnits
+		b.u32(sectorsPerCluster)
+		b.u32(sectorSize)
+	case fsObjectID:
+		b.zeros(64)
+	case fsSectorSize:
+		for range 4 {
+			b.u32(sectorSize)
+		}
+		b.zeros(12) // Flags and alignment offsets
+	default:
+		return nil, statusInvalidInfoClass
+	}
+	return b.b, statusSuccess
+}
+
+// securityDescriptor is a self-relative security descriptor owned by
+// Everyone and granting Everyone full access; the shares enforce access
+func securityDescriptor() []byte {
+	everyone := []byte{1, 1, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0} // S-1-1-0
+
+	var acl builder
+	acl.u8(2) // AclRevision
+	acl.u8(0)
+	acl.u16(uint16(8 + 8 + len(everyone)))
+	acl.u16(1) // AceCount
+	acl.u16(0)
+	acl.u8(0) // ACCESS_ALLOWED_ACE_TYPE
+	acl.u8(0)
+	acl.u16(uint16(8 + len(everyone)))
+	acl.u32(accessFull)
+	acl.bytes(everyone)
+
+	const headerLength = 20
+	var b builder
+	b.u8(1) // Revision
+	b.u8(0)
+	b.u16(0x8004) // Self-relative, DACL present
+	b.u32(headerLength)
+	b.u32(uint32(headerLength + len(everyone)))
+	b.u32(0) // No SACL
+	b.u32(uint32(headerLength + 2*len(everyone)))
+	b.bytes(everyone) // Owner
+	b.bytes(everyone) // Group
+	b.bytes(acl.b)
+	return b.b
+}
+
+// setInfo renames, deletes and resizes uploads. Times, attributes and
+// security are accepted and ignored.
+func (c *conn) setInfo(req *request) (uint32, []byte) {
+	body := req.body()
+	if len(body) < 32 {
+		return statusInvalidParameter, nil
+	}
+	f, status := c.file(req, 16)
+	if f == nil {
+		return status, nil
+	}
+	if f.tree.share != UploadsShare || !f.tree.write {
+		return statusAccessDenied, nil
+	}
+	buffer := field(req.msg, int(le.Uint16(body[8:])), int(le.Uint32(body[4:])))
+	if buffer == nil {
+		return statusInvalidParameter, nil
+	}
+
+	switch body[2] {
+	case infoFile:
+	case infoSecurity:
+		return statusSuccess, setInfoResponse()
+	default:
+		return statusAccessDenied, nil
+	}
+
+	switch body[3] {
+	case classBasic, classAllocation:
+		return statusSuccess, setInfoResponse()
+
+	case classRename:
+		if len(buffer) < 20 {
+			return statusInvalidParameter, nil
+		}
+		name := decodeString(field(buffer, 20, int(le.Uint32(buffer[16:]))))
+		if status := c.rename(f, strings.Trim(strings.ReplaceAll(name, `\`, "/"), "/"), buffer[0] != 0); status != statusSuccess {
+			return status, nil
+		}
+
+	case classDisposition, classDispositionEx:
+		if len(buffer) < 1 {
+			return statusInvalidParameter, nil
+		}
+		remove := buffer[0]&0x01 != 0
+		if remove && f.dir {
+			return statusAccessDenied, nil
+		}
+		f.deleteOnClose = remove
+
+	case classEndOfFile:
+		if len(buffer) < 8 {
+			return statusInvalidParameter, nil
+		}
+		if f.writer == nil {
+			return statusAccessDenied, nil
+		}
+		if err := f.writer.Truncate(int64(le.Uint64(buffer))); err != nil {
+			return c.storageStatus(err, f), nil
+		}
+
+	default:
+		return statusInvalidInfoClass, nil
+	}
+	return statusSuccess, setInfoResponse()
+}
+
+// rename renames an upload within the flat uploads share
+func (c *conn) rename(f *openFile, newName string, replace bool) uint32 {
+	if f.dir || strings.Contains(newName, "/") {
+		return statusAccessDenied
+	}
+	newPath, err := c.server.fileService.UploadPath(newName)
+	if err != nil {
+		return statusObjectNameInvalid
+	}
+	if _, err := os.Stat(newPath); err == nil && !replace && !strings.EqualFold(newName, f.name) {
+		return statusObjectNameCollision
+	}
+
+	if f.writer != nil {
+		err = f.writer.Rename(newName)
+	} else {
+		err = c.server.fileService.RenameUpload(f.name, newName)
+	}
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return statusObjectNameNotFound
+		}
+		return fileStatus(err)
+	}
+
+	logger.Logger.WithFields(map[string]interface{}{
+		"filename": newName,
+		"from":     f.name,
+		"source":   c.source(),
+		"user":     f.session.user,
+	}).Info("Renamed upload over SMB")
+	f.name = newName
+	return statusSuccess
+}
+
+func setInfoResponse() []byte {
+	var b builder
+	b.u16(2) // StructureSize
+	return b.b
+}
+
+// fileTimes writes the creation, last access, last write and change times,
+// all the modification time as that is all file systems reliably keep
+func fileTimes(b *builder, info iofs.FileInfo) {
+	modified := fileTime(info.ModTime())
+	for range 4 {
+		b.u64(modified)
+	}
+}
+
+// attributes returns the file attributes of info
+func attributes(info iofs.FileInfo) uint32 {
+	if info.IsDir() {
+		return attributeDirectory
+	}
+	return attributeArchive
+}
+
+// allocationSize returns the size of info rounded up to whole clusters
+func allocationSize(info iofs.FileInfo) uint64 {
+	if info.IsDir() {
+		return 0
+	}
+	return (uint64(max(info.Size(), 0)) + clusterSize - 1) &^ (clusterSize - 1)
+}
+
+// fileID derives a stable file ID from a share path
+func fileID(share, name string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(share + "/" + name))
+	return hash.Sum64()
+}
@@ -0,0 +1,184 @@
+package smb
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"time"
+)
+
+// smb1Negotiate is the SMB1 NEGOTIATE command clients may still open with
+const smb1Negotiate = 0x72
+
+// Negotiate context types and the pre-authentication hash algorithm
+const (
+	contextPreauthIntegrity = 0x0001
+	hashSHA512              = 0x0001
+)
+
+// Largest reads, writes and transactions, smaller for SMB 2.0.2 which can't
+// use multi-credit requests
+const (
+	maxTransferSize      = 1 << 20
+	maxTransferSizeSMB20 = 64 << 10
+)
+
+// negotiate picks the highest dialect both sides support
+func (c *conn) negotiate(req *request) (uint32, []byte) {
+	if c.dialect != 0 {
+		return statusAccessDenied, nil // Only once per connection
+	}
+	body := req.body()
+	if len(body) < 36 {
+		return statusInvalidParameter, nil
+	}
+	count := int(le.Uint16(body[2:]))
+	offered := field(body, 36, count*2)
+	if count == 0 || offered == nil {
+		return statusInvalidParameter, nil
+	}
+
+	var dialect uint16
+	for _, supported := range supportedDialects {
+		for i := 0; i < count && dialect == 0; i++ {
+			if le.Uint16(offered[i*2:]) == supported {
+				dialect = supported
+			}
+		}
+	}
+	if dialect == 0 {
+		return statusNotSupported, nil
+	}
+
+	if dialect == dialect311 {
+		offset, contexts := int(le.Uint32(body[28:])), int(le.Uint16(body[32:]))
+		if !offersSHA512(req.msg, offset, contexts) {
+			return statusInvalidParameter, nil
+		}
+		c.preauth = preauthHash(c.preauth, req.msg)
+		req.preauth = &c.preauth
+	}
+
+	c.dialect = dialect
+	c.clientSecurityMode = le.Uint16(body[4:])
+	c.clientCapabilities = le.Uint32(body[8:])
+	copy(c.clientGUID[:], body[12:28])
+	return statusSuccess, c.negotiateResponse(dialect)
+}
+
+// negotiateSMB1 answers an SMB1 NEGOTIATE offering SMB2, as sent by clients
+// that don't know whether the server speaks SMB2
+func (c *conn) negotiateSMB1(msg []byte) ([]byte, error) {
+	if len(msg) < 32 || msg[4] != smb1Negotiate || c.dialect != 0 {
+		return nil, errors.New("SMB1 is not supported")
+	}
+
+	// The wildcard asks the client to negotiate again with SMB2
+	var dialect uint16
+	switch {
+	case bytes.Contains(msg[32:], []byte("SMB 2.???\x00")):
+		dialect = dialectWildcard
+	case bytes.Contains(msg[32:], []byte("SMB 2.002\x00")):
+		dialect = dialect202
+		c.dialect = dialect202
+	default:
+		return nil, errors.New("SMB1 is not supported")
+	}
+
+	req := &request{command: cmdNegotiate}
+	return c.response(req, statusSuccess, c.negotiateResponse(dialect)), nil
+}
+
+// negotiateResponse is the body of the NEGOTIATE response for dialect
+func (c *conn) negotiateResponse(dialect uint16) []byte {
+	maxSize := uint32(maxTransferSize)
+	if dialect == dialect202 || dialect == dialectWildcard {
+		maxSize = maxTransferSizeSMB20
+	}
+
+	const bufferOffset = headerSize + 64
+	token := negTokenInit()
+	var contexts []byte
+	contextOffset := 0
+	if dialect == dialect311 {
+		contexts = preauthContext()
+		contextOffset = (bufferOffset + len(token) + 7) &^ 7
+	}
+
+	var b builder
+	b.u16(65) // StructureSize
+	b.u16(signingEnabled)
+	b.u16(dialect)
+	if contexts != nil {
+		b.u16(1) // NegotiateContextCount
+	} else {
+		b.u16(0)
+	}
+	b.bytes(c.server.guid[:])
+	b.u32(capabilities(dialect))
+	b.u32(maxSize) // MaxTransactSize
+	b.u32(maxSize) // MaxReadSize
+	b.u32(maxSize) // MaxWriteSize
+	b.u64(fileTime(time.Now()))
+	b.u64(fileTime(c.server.started))
+	b.u16(bufferOffset)
+	b.u16(uint16(len(token)))
+	b.u32(uint32(contextOffset))
+	b.bytes(token)
+	if contexts != nil {
+		b.zeros(contextOffset - headerSize - b.len())
+		b.bytes(contexts)
+	}
+	return b.b
+}
+
+// offersSHA512 reports whether the negotiate contexts of a 3.1.1 NEGOTIATE
+// request offer SHA-512 for the pre-authentication hash
+func offersSHA512(msg []byte, offset, count int) bool {
+	for ; count > 0; count-- {
+		header := field(msg, offset, 8)
+		if header == nil {
+			return false
+		}
+		length := int(le.Uint16(header[2:]))
+		data := field(msg, offset+8, length)
+		if data == nil {
+			return false
+		}
+		if le.Uint16(header) == contextPreauthIntegrity && len(data) >= 2 {
+			algorithms := field(data, 4, int(le.Uint16(data))*2)
+			for i := 0; i+1 < len(algorithms); i += 2 {
+				if le.Uint16(algorithms[i:]) == hashSHA512 {
+					return true
+				}
+			}
+		}
+		offset = (offset + 8 + length + 7) &^ 7
+	}
+	return false
+}
+
+// preauthContext is the negotiate context choosing SHA-512 with a random salt
+func preauthContext() []byte {
+	salt := make([]byte, 32)
+	rand.Read(salt)
+
+	var b builder
+	b.u16(contextPreauthIntegrity)
+	b.u16(uint16(6 + len(salt))) // DataLength
+	b.u32(0)                     // Reserved
+	b.u16(1)                     // HashAlgorithmCount
+	b.u16(uint16(len(salt)))
+	b.u16(hashSHA512)
+	b.bytes(salt)
+	return b.b
+}
+
+// capabilities returns the server capabilities for dialect: multi-credit
+// requests from SMB 2.1 on, nothing optional beyond that
+func capabilities(dialect uint16) uint32 {
+	if dialect == dialect202 || dialect == dialectWildcard {
+		return 0
+	}
+	return capLargeMTU
+}
@@ -0,0 +1,212 @@
+package smb
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rc4"
+	"errors"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/md4"
+)
+
+// ntlmSignature starts every NTLMSSP message
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+// NTLMSSP message types
+const (
+	ntlmNegotiate    = 1
+	ntlmChallenge    = 2
+	ntlmAuthenticate = 3
+)
+
+// NTLMSSP negotiate flags
+const (
+	ntlmFlagUnicode                 = 0x00000001
+	ntlmFlagRequestTarget           = 0x00000004
+	ntlmFlagSign                    = 0x00000010
+	ntlmFlagNTLM                    = 0x00000200
+	ntlmFlagAlwaysSign              = 0x00008000
+	ntlmFlagTargetTypeServer        = 0x00020000
+	ntlmFlagExtendedSessionSecurity = 0x00080000
+	ntlmFlagTargetInfo              = 0x00800000
+	ntlmFlagVersion                 = 0x02000000
+	ntlmFlag128                     = 0x20000000
+	ntlmFlagKeyExchange             = 0x40000000
+	ntlmFlag56                      = 0x80000000
+)
+
+// ntlmServerFlags are offered in the challenge; the sign, key exchange and
+// 56-bit flags only if the client asked for them
+const (
+	ntlmServerFlags = ntlmFlagUnicode | ntlmFlagRequestTarget | ntlmFlagNTLM | ntlmFlagAlwaysSign |
+		ntlmFlagTargetTypeServer | ntlmFlagExtendedSessionSecurity | ntlmFlagTargetInfo | ntlmFlagVersion | ntlmFlag128
+	ntlmOptionalFlags = ntlmFlagSign | ntlmFlagKeyExchange | ntlmFlag56
+)
+
+// ntlmVersion claims Windows 10 and NTLM revision 15
+var ntlmVersion = []byte{10, 0, 0x61, 0x4A, 0, 0, 0, 15}
+
+// ntlmServer is the server side of one NTLM authentication
+type ntlmServer struct {
+	name      string // NetBIOS name of the server
+	challenge [8]byte
+	flags     uint32
+}
+
+// challengeMessage answers a client's NEGOTIATE message
+func (n *ntlmServer) challengeMessage(negotiate []byte) ([]byte, error) {
+	if len(negotiate) < 16 || !bytes.Equal(negotiate[:8], ntlmSignature) || le.Uint32(negotiate[8:]) != ntlmNegotiate {
+		return nil, errors.New("not an NTLM negotiate message")
+	}
+	n.flags = ntlmServerFlags | le.Uint32(negotiate[12:])&ntlmOptionalFlags
+	if _, err := rand.Read(n.challenge[:]); err != nil {
+		return nil, err
+	}
+
+	targetName := encodeString(n.name)
+	var info builder
+	for _, pair := range []struct {
+		id    uint16
+		value string
+	}{{2, n.name}, {1, n.name}, {4, strings.ToLower(n.name)}, {3, strings.ToLower(n.name)}} {
+		value := encodeString(pair.value)
+		info.u16(pair.id)
+		info.u16(uint16(len(value)))
+		info.bytes(value)
+	}
+	info.u16(7) // Timestamp
+	info.u16(8)
+	info.u64(fileTime(time.Now()))
+	info.u32(0) // End of list
+
+	const payloadOffset = 56
+	var msg builder
+	msg.bytes(ntlmSignature)
+	msg.u32(ntlmChallenge)
+	msg.u16(uint16(len(targetName)))
+	msg.u16(uint16(len(targetName)))
+	msg.u32(payloadOffset)
+	msg.u32(n.flags)
+	msg.bytes(n.challenge[:])
+	msg.zeros(8)
+	msg.u16(uint16(info.len()))
+	msg.u16(uint16(info.len()))
+	msg.u32(uint32(payloadOffset + len(targetName)))
+	msg.bytes(ntlmVersion)
+	msg.bytes(targetName)
+	msg.bytes(info.b)
+	return msg.b, nil
+}
+
+// ntlmAuthentication is what a client's AUTHENTICATE message claims
+type ntlmAuthentication struct {
+	user          string
+	domain        string
+	workstation   string
+	ntResponse    []byte
+	encryptedKey  []byte
+	flags         uint32
+	anonymous     bool
+	exportedKey   []byte // Session key, set once verified
+	serverSignKey []byte
+	serverSealKey []byte
+}
+
+// parseAuthenticate parses a client's AUTHENTICATE message
+func (n *ntlmServer) parseAuthenticate(msg []byte) (*ntlmAuthentication, error) {
+	if len(msg) < 64 || !bytes.Equal(msg[:8], ntlmSignature) || le.Uint32(msg[8:]) != ntlmAuthenticate {
+		return nil, errors.New("not an NTLM authenticate message")
+	}
+	payload := func(offset int) []byte {
+		length, start := int(le.Uint16(msg[offset:])), int(le.Uint32(msg[offset+4:]))
+		if length == 0 || start+length > len(msg) {
+			return nil
+		}
+		return msg[start : start+length]
+	}
+
+	auth := &ntlmAuthentication{
+		ntResponse:   payload(20),
+		encryptedKey: payload(52),
+		flags:        le.Uint32(msg[60:]),
+	}
+	text := func(b []byte) string {
+		if auth.flags&ntlmFlagUnicode != 0 {
+			return decodeString(b)
+		}
+		return string(b)
+	}
+	auth.domain = text(payload(28))
+	auth.user = text(payload(36))
+	auth.workstation = text(payload(44))
+	auth.anonymous = auth.user == "" && len(auth.ntResponse) == 0
+	return auth, nil
+}
+
+// verify checks the NTLMv2 response against password and derives the session key
+func (n *ntlmServer) verify(auth *ntlmAuthentication, password string) bool {
+	if len(auth.ntResponse) <= 16 {
+		return false // NTLMv1 is not accepted
+	}
+	proof, blob := auth.ntResponse[:16], auth.ntResponse[16:]
+
+	hash := md4.New()
+	hash.Write(encodeString(password))
+	responseKey := hmacMD5(hash.Sum(nil), encodeString(strings.ToUpper(auth.user)+auth.domain))
+	if !hmac.Equal(proof, hmacMD5(responseKey, n.challenge[:], blob)) {
+		return false
+	}
+
+	// With extended session security the key exchange key is the session base key
+	sessionKey := hmacMD5(responseKey, proof)
+	if n.flags&ntlmFlagKeyExchange != 0 && len(auth.encryptedKey) == 16 {
+		cipher, err := rc4.NewCipher(sessionKey)
+		if err != nil {
+			return false
+		}
+		exported := make([]byte, 16)
+		cipher.XORKeyStream(exported, auth.encryptedKey)
+		sessionKey = exported
+	}
+	auth.exportedKey = sessionKey
+	auth.serverSignKey = md5Sum(sessionKey, []byte("session key to server-to-client signing key magic constant\x00"))
+	auth.serverSealKey = md5Sum(sessionKey, []byte("session key to server-to-client sealing key magic constant\x00"))
+	return true
+}
+
+// mechListMIC signs the SPNEGO mechanism list with the server's first NTLM
+// message signature, proving the list wasn't downgraded
+func (auth *ntlmAuthentication) mechListMIC(mechTypes []byte) []byte {
+	var seq [4]byte
+	checksum := hmacMD5(auth.serverSignKey, seq[:], mechTypes)[:8]
+	if auth.flags&ntlmFlagKeyExchange != 0 {
+		if cipher, err := rc4.NewCipher(auth.serverSealKey); err == nil {
+			cipher.XORKeyStream(checksum, checksum)
+		}
+	}
+	var mic builder
+	mic.u32(1)
+	mic.bytes(checksum)
+	mic.bytes(seq[:])
+	return mic.b
+}
+
+func hmacMD5(key []byte, data ...[]byte) []byte {
+	mac := hmac.New(md5.New, key)
+	for _, d := range data {
+		mac.Write(d)
+	}
+	return mac.Sum(nil)
+}
+
+func md5Sum(data ...[]byte) []byte {
+	hash := md5.New()
+	for _, d := range data {
+		hash.Write(d)
+	}
+	return hash.Sum(nil)
+}
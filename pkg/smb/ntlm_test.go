@@ -0,0 +1,183 @@
+package smb
+
+import (
+	"bytes"
+	"crypto/rc4"
+	"encoding/hex"
+	"testing"
+)
+
+// Values of the NTLMv2 example in MS-NLMP 4.2.4
+var (
+	vectorUser            = "User"
+	vectorDomain          = "Domain"
+	vectorPassword        = "Password"
+	vectorServerChallenge = unhex("0123456789abcdef")
+	vectorRandomKey       = bytes.Repeat([]byte{0x55}, 16)
+	vectorNTProof         = unhex("68cd0ab851e51c96aabc927bebef6a1c")
+	vectorSessionBaseKey  = unhex("8de40ccadbc14a82f15cb0ad0de95ca3")
+	vectorEncryptedKey    = unhex("c5dad2544fc9799094ce1ce90bc9d03e")
+)
+
+// vectorBlob is the client's NTLMv2 blob of the example: time zero, client
+// challenge aaaa... and the domain and server names of the challenge
+var vectorBlob = unhex("0101000000000000" + "0000000000000000" + "aaaaaaaaaaaaaaaa" + "00000000" +
+	"02000c0044006f006d00610069006e00" + "01000c005300650072007600650072000000" + "0000" + "00000000")
+
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// authenticateMessage builds an AUTHENTICATE message like a client would
+func authenticateMessage(flags uint32, domain, user, workstation string, ntResponse, encryptedKey []byte) []byte {
+	text := func(s string) []byte {
+		if flags&ntlmFlagUnicode != 0 {
+			return encodeString(s)
+		}
+		return []byte(s)
+	}
+	fields := [][]byte{nil, ntResponse, text(domain), text(user), text(workstation), encryptedKey}
+
+	const payloadOffset = 64
+	var msg builder
+	msg.bytes(ntlmSignature)
+	msg.u32(ntlmAuthenticate)
+	offset := payloadOffset
+	for _, f := range fields {
+		msg.u16(uint16(len(f)))
+		msg.u16(uint16(len(f)))
+		msg.u32(uint32(offset))
+		offset += len(f)
+	}
+	msg.u32(flags)
+	for _, f := range fields {
+		msg.bytes(f)
+	}
+	return msg.b
+}
+
+func TestNTLMv2Vector(t *testing.T) {
+	flags := uint32(ntlmServerFlags | ntlmFlagKeyExchange | ntlmFlagSign)
+	n := &ntlmServer{name: "Server", flags: flags}
+	copy(n.challenge[:], vectorServerChallenge)
+
+	// The example's session base key, checked against the expected
+	// encryption of the random session key
+	cipher, _ := rc4.NewCipher(vectorSessionBaseKey)
+	encrypted := make([]byte, 16)
+	cipher.XORKeyStream(encrypted, vectorRandomKey)
+	if !bytes.Equal(encrypted, vectorEncryptedKey) {
+		t.Fatalf("encrypted random session key = %x, want %x", encrypted, vectorEncryptedKey)
+	}
+
+	ntResponse := append(bytes.Clone(vectorNTProof), vectorBlob...)
+	msg := authenticateMessage(flags, vectorDomain, vectorUser, "COMPUTER", ntResponse, vectorEncryptedKey)
+	auth, err := n.parseAuthenticate(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.user != vectorUser || auth.domain != vectorDomain || auth.workstation != "COMPUTER" || auth.anonymous {
+		t.Fatalf("parsed user %q, domain %q, workstation %q, anonymous %v", auth.user, auth.domain, auth.workstation, auth.anonymous)
+	}
+
+	if !n.verify(auth, vectorPassword) {
+		t.Fatal("the example's response didn't verify")
+	}
+	if !bytes.Equal(auth.exportedKey, vectorRandomKey) {
+		t.Errorf("exported session key = %x, want %x", auth.exportedKey, vectorRandomKey)
+	}
+
+	// Without key exchange the session base key is used as is
+	plain := &ntlmServer{name: "Server", flags: ntlmServerFlags}
+	copy(plain.challenge[:], vectorServerChallenge)
+	auth, _ = plain.parseAuthenticate(authenticateMessage(ntlmServerFlags, vectorDomain, vectorUser, "", ntResponse, nil))
+	if !plain.verify(auth, vectorPassword) || !bytes.Equal(auth.exportedKey, vectorSessionBaseKey) {
+		t.Errorf("session base key = %x, want %x", auth.exportedKey, vectorSessionBaseKey)
+	}
+}
+
+func TestNTLMv2Rejects(t *testing.T) {
+	ntResponse := append(bytes.Clone(vectorNTProof), vectorBlob...)
+	tampered := bytes.Clone(ntResponse)
+	tampered[len(tampered)-5] ^= 1
+
+	tests := []struct {
+		name       string
+		user       string
+		domain     string
+		password   string
+		challenge  []byte
+		ntResponse []byte
+	}{
+		{"wrong password", vectorUser, vectorDomain, "password", vectorServerChallenge, ntResponse},
+		{"other user", "Admin", vectorDomain, vectorPassword, vectorServerChallenge, ntResponse},
+		{"other domain", vectorUser, "WORKGROUP", vectorPassword, vectorServerChallenge, ntResponse},
+		{"replayed to another challenge", vectorUser, vectorDomain, vectorPassword, unhex("fedcba9876543210"), ntResponse},
+		{"tampered blob", vectorUser, vectorDomain, vectorPassword, vectorServerChallenge, tampered},
+		{"NTLMv1 response", vectorUser, vectorDomain, vectorPassword, vectorServerChallenge, ntResponse[:16]},
+	}
+	for _, tt := range tests {
+		n := &ntlmServer{name: "Server", flags: ntlmServerFlags}
+		copy(n.challenge[:], tt.challenge)
+		auth, err := n.parseAuthenticate(authenticateMessage(ntlmServerFlags, tt.domain, tt.user, "", tt.ntResponse, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n.verify(auth, tt.password) {
+			t.Errorf("%s: verified", tt.name)
+		}
+	}
+}
+
+func TestParseAuthenticate(t *testing.T) {
+	n := &ntlmServer{}
+
+	// OEM strings without the Unicode flag
+	auth, err := n.parseAuthenticate(authenticateMessage(0, "CORP", "bob", "WS01", nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.user != "bob" || auth.domain != "CORP" || auth.workstation != "WS01" {
+		t.Errorf("OEM strings parsed as %q, %q, %q", auth.user, auth.domain, auth.workstation)
+	}
+
+	// Anonymous logons have neither a user nor a response
+	auth, err = n.parseAuthenticate(authenticateMessage(ntlmFlagUnicode, "", "", "", nil, nil))
+	if err != nil || !auth.anonymous {
+		t.Errorf("anonymous logon parsed as %+v, %v", auth, err)
+	}
+
+	// Fields pointing past the message are dropped
+	msg := authenticateMessage(ntlmFlagUnicode, "D", "user", "", nil, nil)
+	le.PutUint32(msg[40:], 1<<20)
+	if auth, err := n.parseAuthenticate(msg); err != nil || auth.user != "" {
+		t.Errorf("out of bounds user parsed as %q, %v", auth.user, err)
+	}
+
+	for _, bad := range [][]byte{nil, []byte("NTLMSSP\x00"), authenticateMessage(0, "", "", "", nil, nil)[:63]} {
+		if _, err := n.parseAuthenticate(bad); err == nil {
+			t.Errorf("parseAuthenticate(%x) succeeded", bad)
+		}
+	}
+}
+
+func FuzzParseAuthenticate(f *testing.F) {
+	ntResponse := append(bytes.Clone(vectorNTProof), vectorBlob...)
+	f.Add(authenticateMessage(ntlmServerFlags|ntlmFlagKeyExchange, vectorDomain, vectorUser, "COMPUTER", ntResponse, vectorEncryptedKey))
+	f.Add(authenticateMessage(0, "", "", "", nil, nil))
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		n := &ntlmServer{name: serverName, flags: ntlmServerFlags | ntlmFlagKeyExchange}
+		copy(n.challenge[:], vectorServerChallenge)
+		auth, err := n.parseAuthenticate(msg)
+		if err != nil {
+			return
+		}
+		if n.verify(auth, vectorPassword) {
+			auth.mechListMIC([]byte{0x30, 0x00})
+		}
+	})
+}
@@ -0,0 +1,202 @@
+package smb
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/m1kkY8/ctfserver/pkg/auth"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+)
+
+// Session flags of the SESSION_SETUP response
+const (
+	sessionFlagGuest = 0x0001
+	sessionFlagNull  = 0x0002
+)
+
+// session is a logon on a connection
+type session struct {
+	id          uint64
+	ntlm        *ntlmServer
+	rawNTLM     bool   // The client sends NTLMSSP without SPNEGO
+	mechTypes   []byte // Mechanism list the client offered, signed by the MIC
+	preauth     []byte // 3.1.1 pre-authentication integrity hash of the logon
+	established bool
+
+	user       string         // Name for logs
+	identity   *auth.Identity // Nil for guests while authentication is disabled
+	signingKey []byte         // Nil for anonymous and guest sessions
+	trees      map[uint32]*tree
+}
+
+// sessionSetup runs the NTLM exchange, in two rounds: the client's
+// NEGOTIATE is answered with a CHALLENGE, its AUTHENTICATE with the outcome
+func (c *conn) sessionSetup(req *request) (uint32, []byte) {
+	body := req.body()
+	if len(body) < 24 {
+		return statusInvalidParameter, nil
+	}
+	blob := field(req.msg, int(le.Uint16(body[12:])), int(le.Uint16(body[14:])))
+
+	sess := c.sessions[req.sessionID]
+	switch {
+	case req.sessionID == 0:
+		c.lastSessionID++
+		sess = &session{
+			id:      c.lastSessionID,
+			ntlm:    &ntlmServer{name: serverName},
+			preauth: bytes.Clone(c.preauth),
+			trees:   make(map[uint32]*tree),
+		}
+		c.sessions[sess.id] = sess
+		req.sessionID = sess.id
+	case sess == nil:
+		return statusUserSessionDeleted, nil
+	case sess.established:
+		return statusNotSupported, nil // No reauthentication
+	}
+
+	token, err := parseNegToken(blob)
+	if err != nil {
+		delete(c.sessions, sess.id)
+		return statusInvalidParameter, nil
+	}
+	if token.mechTypes != nil {
+		sess.mechTypes = token.mechTypes
+	}
+	if c.dialect == dialect311 {
+		sess.preauth = preauthHash(sess.preauth, req.msg)
+	}
+
+	ntlmMsg := token.mechToken
+	if len(ntlmMsg) < 12 || !bytes.HasPrefix(ntlmMsg, ntlmSignature) {
+		// Another mechanism, e.g. Kerberos, was tried first: ask for NTLM
+		if c.dialect == dialect311 {
+			req.preauth = &sess.preauth
+		}
+		return statusMoreProcessingRequired, sessionSetupResponse(0, negTokenResp(negAcceptIncomplete, nil, nil))
+	}
+
+	switch le.Uint32(ntlmMsg[8:]) {
+	case ntlmNegotiate:
+		challenge, err := sess.ntlm.challengeMessage(ntlmMsg)
+		if err != nil {
+			delete(c.sessions, sess.id)
+			return statusInvalidParameter, nil
+		}
+		sess.rawNTLM = bytes.HasPrefix(blob, ntlmSignature)
+		if !sess.rawNTLM {
+			challenge = negTokenResp(negAcceptIncomplete, challenge, nil)
+		}
+		if c.dialect == dialect311 {
+			req.preauth = &sess.preauth
+		}
+		return statusMoreProcessingRequired, sessionSetupResponse(0, challenge)
+
+	case ntlmAuthenticate:
+		return c.authenticate(req, sess, token)
+	}
+
+	delete(c.sessions, sess.id)
+	return statusInvalidParameter, nil
+}
+
+// authenticate checks the client's AUTHENTICATE message and establishes the session
+func (c *conn) authenticate(req *request, sess *session, token *negToken) (uint32, []byte) {
+	authn, err := sess.ntlm.parseAuthenticate(token.mechToken)
+	if err != nil {
+		delete(c.sessions, sess.id)
+		return statusInvalidParameter, nil
+	}
+
+	var flags uint16
+	switch {
+	case authn.anonymous:
+		sess.user = "anonymous"
+		if c.server.auth.Enabled() {
+			sess.identity = c.server.auth.Anonymous()
+		}
+		flags = sessionFlagNull
+
+	case c.server.auth.Enabled():
+		identity, ok := c.server.auth.AuthenticateSecret(authn.user, func(secret string) bool {
+			return sess.ntlm.verify(authn, secret)
+		})
+		if !ok {
+			logger.Logger.WithFields(map[string]interface{}{
+				"remote_addr": c.netConn.RemoteAddr().String(),
+				"user":        authn.user,
+				"domain":      authn.domain,
+			}).Warn("Rejected SMB logon")
+			delete(c.sessions, sess.id)
+			return statusLogonFailure, nil
+		}
+		sess.user = identity.Name
+		sess.identity = identity
+
+	default:
+		// Without configured credentials any logon is a guest
+		sess.user = "guest"
+		flags = sessionFlagGuest
+	}
+
+	sess.established = true
+	req.session = sess
+
+	var mic []byte
+	if authn.exportedKey != nil {
+		sess.signingKey = signingKey(c.dialect, authn.exportedKey, sess.preauth)
+		req.sign = true
+		if token.mechListMIC != nil && sess.mechTypes != nil {
+			mic = authn.mechListMIC(sess.mechTypes)
+		}
+	}
+
+	var response []byte
+	if !sess.rawNTLM {
+		response = negTokenResp(negAcceptCompleted, nil, mic)
+	}
+
+	logger.Logger.WithFields(map[string]interface{}{
+		"remote_addr": c.netConn.RemoteAddr().String(),
+		"user":        sess.user,
+		"dialect":     fmt.Sprintf("%d.%d.%d", c.dialect>>8, c.dialect>>4&0xF, c.dialect&0xF),
+		"signed":      sess.signingKey != nil,
+	}).Info("SMB session established")
+	return statusSuccess, sessionSetupResponse(flags, response)
+}
+
+// sessionSetupResponse is the body of a SESSION_SETUP response
+func sessionSetupResponse(flags uint16, token []byte) []byte {
+	var b builder
+	b.u16(9) // StructureSize
+	b.u16(flags)
+	b.u16(headerSize + 8)
+	b.u16(uint16(len(token)))
+	b.bytes(token)
+	return b.b
+}
+
+// logoff ends the session, closing its files
+func (c *conn) logoff(req *request) (uint32, []byte) {
+	for _, f := range c.files {
+		if f.session == req.session {
+			c.closeFile(f)
+		}
+	}
+	delete(c.sessions, req.session.id)
+	return statusSuccess, emptyBody()
+}
+
+// echo answers a keep-alive
+func (c *conn) echo(req *request) (uint32, []byte) {
+	return statusSuccess, emptyBody()
+}
+
+// emptyBody is the body of responses that carry nothing
+func emptyBody() []byte {
+	var b builder
+	b.u16(4) // StructureSize
+	b.u16(0) // Reserved
+	return b.b
+}
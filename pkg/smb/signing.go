@@ -0,0 +1,115 @@
+package smb
+
+import (
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+)
+
+// signatureOffset is where the 16-byte signature sits in the SMB2 header
+const signatureOffset = 48
+
+// signingKey derives the key messages of a session are signed with from its
+// session key. preauth is the 3.1.1 pre-authentication integrity hash.
+func signingKey(dialect uint16, sessionKey, preauth []byte) []byte {
+	switch {
+	case dialect >= dialect311:
+		return kdf(sessionKey, []byte("SMBSigningKey\x00"), preauth)
+	case dialect >= dialect300:
+		return kdf(sessionKey, []byte("SMB2AESCMAC\x00"), []byte("SmbSign\x00"))
+	default:
+		return sessionKey
+	}
+}
+
+// kdf is the SP800-108 counter mode KDF with HMAC-SHA256 deriving a 128-bit key
+func kdf(key, label, context []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte{0, 0, 0, 1})
+	mac.Write(label)
+	mac.Write([]byte{0})
+	mac.Write(context)
+	mac.Write([]byte{0, 0, 0, 128})
+	return mac.Sum(nil)[:16]
+}
+
+// signature computes the signature of msg, whose signature field must be zero
+func signature(dialect uint16, key, msg []byte) []byte {
+	if dialect >= dialect300 {
+		return cmac(key, msg)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(msg)
+	return mac.Sum(nil)[:16]
+}
+
+// sign sets the signed flag and signature of msg
+func sign(dialect uint16, key, msg []byte) {
+	le.PutUint32(msg[16:], le.Uint32(msg[16:])|flagSigned)
+	clear(msg[signatureOffset : signatureOffset+16])
+	copy(msg[signatureOffset:], signature(dialect, key, msg))
+}
+
+// verify checks the signature of msg, restoring it afterwards
+func verify(dialect uint16, key, msg []byte) bool {
+	var given [16]byte
+	copy(given[:], msg[signatureOffset:])
+	clear(msg[signatureOffset : signatureOffset+16])
+	expected := signature(dialect, key, msg)
+	copy(msg[signatureOffset:], given[:])
+	return subtle.ConstantTimeCompare(given[:], expected) == 1
+}
+
+// cmac computes the AES-CMAC of msg (RFC 4493)
+func cmac(key, msg []byte) []byte {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return make([]byte, 16)
+	}
+
+	// Subkeys K1 and K2
+	k1 := make([]byte, 16)
+	block.Encrypt(k1, k1)
+	k1 = cmacShift(k1)
+	k2 := cmacShift(k1)
+
+	blocks := (len(msg) + 15) / 16
+	complete := blocks > 0 && len(msg)%16 == 0
+	if blocks == 0 {
+		blocks = 1
+	}
+
+	// Mask the last block, padding it if incomplete
+	last := make([]byte, 16)
+	copy(last, msg[(blocks-1)*16:])
+	if complete {
+		subtle.XORBytes(last, last, k1)
+	} else {
+		last[len(msg)-(blocks-1)*16] = 0x80
+		subtle.XORBytes(last, last, k2)
+	}
+
+	x := make([]byte, 16)
+	for i := 0; i < blocks-1; i++ {
+		subtle.XORBytes(x, x, msg[i*16:(i+1)*16])
+		block.Encrypt(x, x)
+	}
+	subtle.XORBytes(x, x, last)
+	block.Encrypt(x, x)
+	return x
+}
+
+// cmacShift doubles a subkey in GF(2^128)
+func cmacShift(in []byte) []byte {
+	hi := binary.BigEndian.Uint64(in)
+	lo := binary.BigEndian.Uint64(in[8:])
+	out := make([]byte, 16)
+	binary.BigEndian.PutUint64(out, hi<<1|lo>>63)
+	binary.BigEndian.PutUint64(out[8:], lo<<1)
+	if hi>>63 != 0 {
+		out[15] ^= 0x87
+	}
+	return out
+}
@@ -0,0 +1,141 @@
+// Package smb serves the root directory and the upload directory as SMB2/3
+// shares, for Windows targets where copy and net use are more reliable than
+// HTTP downloads.
+//
+// The files share is the root, read-only. The uploads share is the upload
+// directory: flat, writable by the upload role and readable by the loot
+// role. Logons are NTLM, checked against the configured users and tokens;
+// without any configured, every logon is accepted as a guest.
+package smb
+
+import (
+	"crypto/rand"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/m1kkY8/ctfserver/pkg/auth"
+	"github.com/m1kkY8/ctfserver/pkg/ipfilter"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+)
+
+// Share names
+const (
+	FilesShare   = "files"
+	UploadsShare = "uploads"
+	ipcShare     = "IPC$"
+)
+
+// serverName is the NetBIOS name the server gives in NTLM challenges
+const serverName = "CTFSERVER"
+
+// ErrServerClosed is returned by Serve after Close
+var ErrServerClosed = errors.New("smb: server closed")
+
+// Server is an SMB2/3 file server
+type Server struct {
+	fileService *service.FileService
+	auth        *auth.Authenticator
+	ipFilter    *ipfilter.Filter
+	guid        [16]byte
+	started     time.Time
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[*conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// New creates an SMB server for fileService, checking logons with
+// authenticator and client addresses with filter
+func New(fileService *service.FileService, authenticator *auth.Authenticator, filter *ipfilter.Filter) *Server {
+	s := &Server{
+		fileService: fileService,
+		auth:        authenticator,
+		ipFilter:    filter,
+		started:     time.Now(),
+		conns:       make(map[*conn]struct{}),
+	}
+	rand.Read(s.guid[:])
+	return s
+}
+
+// ListenAndServe listens on the TCP address addr and serves connections
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve serves connections accepted from l until Close
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listener = l
+	s.mu.Unlock()
+
+	for {
+		netConn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		c := newConn(s, netConn)
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			netConn.Close()
+			return ErrServerClosed
+		}
+		s.conns[c] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+			c.serve()
+			s.mu.Lock()
+			delete(s.conns, c)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// Close stops accepting connections and closes the open ones, waiting for
+// their open files to be closed
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for c := range s.conns {
+		c.netConn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	logger.Logger.Debug("SMB server closed")
+	return err
+}
@@ -0,0 +1,137 @@
+package smb
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hirochachacha/go-smb2"
+	"github.com/m1kkY8/ctfserver/pkg/auth"
+	"github.com/m1kkY8/ctfserver/pkg/config"
+	"github.com/m1kkY8/ctfserver/pkg/ipfilter"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+)
+
+// newTestServer serves root and uploadDir over SMB on a local port, with
+// the users of cfg
+func newTestServer(t *testing.T, cfg *config.Config, root, uploadDir string) string {
+	t.Helper()
+	logger.InitLogger("error")
+	fileService := service.NewFileService(os.DirFS(root), "root", uploadDir, 1<<20)
+	server := New(fileService, auth.New(cfg), ipfilter.New(cfg))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(l)
+	t.Cleanup(func() { server.Close() })
+	return l.Addr().String()
+}
+
+// dial logs on to addr as user with password
+func dial(t *testing.T, addr, user, password string) *smb2.Session {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	dialer := &smb2.Dialer{
+		Initiator: &smb2.NTLMInitiator{User: user, Password: password},
+	}
+	session, err := dialer.Dial(conn)
+	if err != nil {
+		t.Fatalf("logon as %q: %v", user, err)
+	}
+	t.Cleanup(func() { session.Logoff() })
+	return session
+}
+
+func TestRoundTrip(t *testing.T) {
+	root, uploadDir := t.TempDir(), t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "tools"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	payload := bytes.Repeat([]byte("winPEAS "), 20000) // Larger than one read
+	if err := os.WriteFile(filepath.Join(root, "tools", "winPEAS.exe"), payload, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{Users: []config.UserConfig{
+		{Name: "op", Password: "Passw0rd!", Roles: []string{config.RoleDownload, config.RoleUpload, config.RoleLoot}},
+		{Name: "reader", Password: "reader", Roles: []string{config.RoleDownload}},
+	}}
+	addr := newTestServer(t, cfg, root, uploadDir)
+
+	session := dial(t, addr, "op", "Passw0rd!")
+
+	files, err := session.Mount(FilesShare)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer files.Umount()
+	got, err := files.ReadFile(`tools\winPEAS.exe`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("read %d bytes from the files share, want %d", len(got), len(payload))
+	}
+	entries, err := files.ReadDir("tools")
+	if err != nil || len(entries) != 1 || entries[0].Name() != "winPEAS.exe" {
+		t.Errorf("ReadDir(tools) = %v, %v", entries, err)
+	}
+	if err := files.WriteFile("new.txt", []byte("x"), 0644); err == nil {
+		t.Error("wrote to the read-only files share")
+	}
+
+	uploads, err := session.Mount(UploadsShare)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uploads.Umount()
+	loot := []byte("Administrator:500:aad3b435b51404eeaad3b435b51404ee:31d6cfe0d16ae931b73c59d7e0c089c0:::\n")
+	f, err := uploads.Create("sam.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(loot); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if onDisk, err := os.ReadFile(filepath.Join(uploadDir, "sam.txt")); err != nil || !bytes.Equal(onDisk, loot) {
+		t.Errorf("uploaded file on disk = %q, %v", onDisk, err)
+	}
+	f, err = uploads.Open("sam.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	readBack, err := io.ReadAll(f)
+	f.Close()
+	if err != nil || !bytes.Equal(readBack, loot) {
+		t.Errorf("read back %q, %v", readBack, err)
+	}
+
+	// Roles decide the shares
+	reader := dial(t, addr, "reader", "reader")
+	if _, err := reader.Mount(UploadsShare); err == nil {
+		t.Error("download role mounted the uploads share")
+	}
+
+	// Wrong passwords are refused
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	dialer := &smb2.Dialer{Initiator: &smb2.NTLMInitiator{User: "op", Password: "wrong"}}
+	if _, err := dialer.Dial(conn); err == nil {
+		t.Error("logon with a wrong password succeeded")
+	}
+}
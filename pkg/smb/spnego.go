@@ -0,0 +1,154 @@
+package smb
+
+import (
+	"bytes"
+	"errors"
+)
+
+// DER tags of the SPNEGO tokens
+const (
+	tagApplication0 = 0x60
+	tagSequence     = 0x30
+	tagOID          = 0x06
+	tagOctetString  = 0x04
+	tagEnumerated   = 0x0A
+	tagInit         = 0xA0 // NegTokenInit, and the first field of a sequence
+	tagResp         = 0xA1 // NegTokenResp, and the second field of a sequence
+)
+
+// Object identifiers of SPNEGO and NTLMSSP, DER encoded with their tags
+var (
+	oidSPNEGO = []byte{tagOID, 0x06, 0x2B, 0x06, 0x01, 0x05, 0x05, 0x02}
+	oidNTLM   = []byte{tagOID, 0x0A, 0x2B, 0x06, 0x01, 0x04, 0x01, 0x82, 0x37, 0x02, 0x02, 0x0A}
+)
+
+// SPNEGO negotiation states
+const (
+	negAcceptCompleted  = 0
+	negAcceptIncomplete = 1
+)
+
+// negToken is what a client's SPNEGO token carries
+type negToken struct {
+	mechTypes   []byte // DER encoded mechanism list of a NegTokenInit
+	mechToken   []byte
+	mechListMIC []byte
+}
+
+// parseNegToken parses a NegTokenInit or NegTokenResp. Bare NTLMSSP
+// messages, which some clients send without SPNEGO, are accepted as is.
+func parseNegToken(b []byte) (*negToken, error) {
+	if bytes.HasPrefix(b, ntlmSignature) {
+		return &negToken{mechToken: b}, nil
+	}
+
+	tag, content, _, ok := derNext(b)
+	if ok && tag == tagApplication0 {
+		if !bytes.HasPrefix(content, oidSPNEGO) {
+			return nil, errors.New("not an SPNEGO token")
+		}
+		tag, content, _, ok = derNext(content[len(oidSPNEGO):])
+	}
+	if !ok || tag != tagInit && tag != tagResp {
+		return nil, errors.New("malformed SPNEGO token")
+	}
+	init := tag == tagInit
+
+	tag, fields, _, ok := derNext(content)
+	if !ok || tag != tagSequence {
+		return nil, errors.New("malformed SPNEGO token")
+	}
+
+	token := &negToken{}
+	for len(fields) > 0 {
+		tag, value, rest, ok := derNext(fields)
+		if !ok {
+			return nil, errors.New("malformed SPNEGO token")
+		}
+		fields = rest
+
+		switch {
+		case tag == 0xA0 && init:
+			token.mechTypes = value
+		case tag == 0xA2:
+			if _, octets, _, ok := derNext(value); ok {
+				token.mechToken = octets
+			}
+		case tag == 0xA3:
+			if _, octets, _, ok := derNext(value); ok {
+				token.mechListMIC = octets
+			}
+		}
+	}
+	return token, nil
+}
+
+// negTokenInit is the token of the NEGOTIATE response, offering NTLMSSP only
+func negTokenInit() []byte {
+	mechTypes := der(tagSequence, oidNTLM)
+	init := der(tagInit, der(tagSequence, der(0xA0, mechTypes)))
+	return der(tagApplication0, oidSPNEGO, init)
+}
+
+// negTokenResp is a server NegTokenResp. The token and MIC are left out when empty.
+func negTokenResp(state byte, responseToken, mechListMIC []byte) []byte {
+	fields := [][]byte{der(0xA0, der(tagEnumerated, []byte{state}))}
+	if state == negAcceptIncomplete {
+		fields = append(fields, der(0xA1, oidNTLM))
+	}
+	if responseToken != nil {
+		fields = append(fields, der(0xA2, der(tagOctetString, responseToken)))
+	}
+	if mechListMIC != nil {
+		fields = append(fields, der(0xA3, der(tagOctetString, mechListMIC)))
+	}
+	return der(tagResp, der(tagSequence, fields...))
+}
+
+// der encodes a TLV of tag with the concatenated contents
+func der(tag byte, contents ...[]byte) []byte {
+	length := 0
+	for _, content := range contents {
+		length += len(content)
+	}
+
+	out := []byte{tag}
+	switch {
+	case length < 0x80:
+		out = append(out, byte(length))
+	case length < 0x100:
+		out = append(out, 0x81, byte(length))
+	case length < 0x10000:
+		out = append(out, 0x82, byte(length>>8), byte(length))
+	default:
+		out = append(out, 0x83, byte(length>>16), byte(length>>8), byte(length))
+	}
+	for _, content := range contents {
+		out = append(out, content...)
+	}
+	return out
+}
+
+// derNext splits the first TLV off b, returning its tag and contents
+func derNext(b []byte) (tag byte, value, rest []byte, ok bool) {
+	if len(b) < 2 {
+		return 0, nil, nil, false
+	}
+	tag = b[0]
+	length, header := int(b[1]), 2
+	if length&0x80 != 0 {
+		n := length & 0x7F
+		if n == 0 || n > 3 || len(b) < 2+n {
+			return 0, nil, nil, false
+		}
+		length = 0
+		for _, octet := range b[2 : 2+n] {
+			length = length<<8 | int(octet)
+		}
+		header += n
+	}
+	if len(b) < header+length {
+		return 0, nil, nil, false
+	}
+	return tag, b[header : header+length], b[header+length:], true
+}
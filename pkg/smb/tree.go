@@ -0,0 +1,106 @@
+package smb
+
+import (
+	"strings"
+
+	"github.com/m1kkY8/ctfserver/pkg/config"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+)
+
+// Share types of the TREE_CONNECT response
+const (
+	shareTypeDisk = 0x01
+	shareTypePipe = 0x02
+)
+
+// Access masks granted on the shares
+const (
+	accessReadOnly = 0x001200A9 // Read data, attributes, EAs and security; execute; synchronize
+	accessFull     = 0x001F01FF
+)
+
+// tree is a connection to a share
+type tree struct {
+	id    uint32
+	share string
+	read  bool // Reading and listing files
+	write bool // Creating, changing and deleting files
+}
+
+// maximalAccess returns the access mask granted on the tree
+func (t *tree) maximalAccess() uint32 {
+	if t.write {
+		return accessFull
+	}
+	return accessReadOnly
+}
+
+// treeConnect connects to a share, granting what the session's roles and
+// the client's address allow
+func (c *conn) treeConnect(req *request) (uint32, []byte) {
+	body := req.body()
+	if len(body) < 8 {
+		return statusInvalidParameter, nil
+	}
+	path := decodeString(field(req.msg, int(le.Uint16(body[4:])), int(le.Uint16(body[6:]))))
+	share := path[strings.LastIndex(path, `\`)+1:]
+
+	t := &tree{}
+	shareType := byte(shareTypeDisk)
+	switch {
+	case strings.EqualFold(share, FilesShare):
+		t.share = FilesShare
+		t.read = c.allowed(req.session, config.RouteFiles, config.RoleDownload)
+	case strings.EqualFold(share, UploadsShare):
+		t.share = UploadsShare
+		t.read = c.allowed(req.session, config.RouteLoot, config.RoleLoot)
+		t.write = c.allowed(req.session, config.RouteUpload, config.RoleUpload)
+	case strings.EqualFold(share, ipcShare):
+		t.share = ipcShare
+		t.read = true
+		shareType = shareTypePipe
+	default:
+		return statusBadNetworkName, nil
+	}
+
+	if !t.read && !t.write {
+		logger.Logger.WithFields(map[string]interface{}{
+			"remote_addr": c.netConn.RemoteAddr().String(),
+			"user":        req.session.user,
+			"share":       t.share,
+		}).Warn("Rejected SMB share access")
+		return statusAccessDenied, nil
+	}
+
+	c.lastTreeID++
+	t.id = c.lastTreeID
+	req.session.trees[t.id] = t
+	req.treeID = t.id
+
+	var b builder
+	b.u16(16) // StructureSize
+	b.u8(shareType)
+	b.u8(0)  // Reserved
+	b.u32(0) // ShareFlags: manual caching
+	b.u32(0) // Capabilities
+	b.u32(t.maximalAccess())
+	return statusSuccess, b.b
+}
+
+// treeDisconnect disconnects from a share, closing its files
+func (c *conn) treeDisconnect(req *request) (uint32, []byte) {
+	for _, f := range c.files {
+		if f.tree == req.tree {
+			c.closeFile(f)
+		}
+	}
+	delete(req.session.trees, req.tree.id)
+	return statusSuccess, emptyBody()
+}
+
+// allowed reports whether the session may use what the route group covers
+// over HTTP: the client address must pass the filter and the session must be
+// permitted role
+func (c *conn) allowed(sess *session, group, role string) bool {
+	return c.server.ipFilter.Allowed(group, c.addr) && c.server.auth.Permits(sess.identity, role)
+}
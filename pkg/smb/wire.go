@@ -0,0 +1,175 @@
+package smb
+
+import (
+	"encoding/binary"
+	"time"
+	"unicode/utf16"
+)
+
+var le = binary.LittleEndian
+
+// headerSize is the size of the SMB2 header preceding every command
+const headerSize = 64
+
+// Protocol identifiers at the start of SMB2 and SMB1 messages
+var (
+	protocolID     = []byte{0xFE, 'S', 'M', 'B'}
+	smb1ProtocolID = []byte{0xFF, 'S', 'M', 'B'}
+)
+
+// Commands
+const (
+	cmdNegotiate      = 0x00
+	cmdSessionSetup   = 0x01
+	cmdLogoff         = 0x02
+	cmdTreeConnect    = 0x03
+	cmdTreeDisconnect = 0x04
+	cmdCreate         = 0x05
+	cmdClose          = 0x06
+	cmdFlush          = 0x07
+	cmdRead           = 0x08
+	cmdWrite          = 0x09
+	cmdLock           = 0x0A
+	cmdIoctl          = 0x0B
+	cmdCancel         = 0x0C
+	cmdEcho           = 0x0D
+	cmdQueryDirectory = 0x0E
+	cmdChangeNotify   = 0x0F
+	cmdQueryInfo      = 0x10
+	cmdSetInfo        = 0x11
+)
+
+// Header flags
+const (
+	flagServerToRedir = 0x00000001
+	flagRelated       = 0x00000004
+	flagSigned        = 0x00000008
+)
+
+// Dialects
+const (
+	dialect202      = 0x0202
+	dialect210      = 0x0210
+	dialect300      = 0x0300
+	dialect302      = 0x0302
+	dialect311      = 0x0311
+	dialectWildcard = 0x02FF // Answer to an SMB1 negotiate offering SMB2 and later
+)
+
+// supportedDialects in order of preference
+var supportedDialects = []uint16{dialect311, dialect302, dialect300, dialect210, dialect202}
+
+// Security modes and capabilities
+const (
+	signingEnabled  = 0x0001
+	signingRequired = 0x0002
+
+	capLargeMTU = 0x00000004
+)
+
+// NT status codes
+const (
+	statusSuccess                = 0x00000000
+	statusBufferOverflow         = 0x80000005
+	statusNoMoreFiles            = 0x80000006
+	statusInvalidInfoClass       = 0xC0000003
+	statusInfoLengthMismatch     = 0xC0000004
+	statusInvalidParameter       = 0xC000000D
+	statusNoSuchFile             = 0xC000000F
+	statusInvalidDeviceRequest   = 0xC0000010
+	statusEndOfFile              = 0xC0000011
+	statusMoreProcessingRequired = 0xC0000016
+	statusAccessDenied           = 0xC0000022
+	statusObjectNameInvalid      = 0xC0000033
+	statusObjectNameNotFound     = 0xC0000034
+	statusObjectNameCollision    = 0xC0000035
+	statusObjectPathNotFound     = 0xC000003A
+	statusLogonFailure           = 0xC000006D
+	statusDiskFull               = 0xC000007F
+	statusFileIsADirectory       = 0xC00000BA
+	statusNotSupported           = 0xC00000BB
+	statusNetworkNameDeleted     = 0xC00000C9
+	statusBadNetworkName         = 0xC00000CC
+	statusUnexpectedIOError      = 0xC00000E9
+	statusNotADirectory          = 0xC0000103
+	statusFileClosed             = 0xC0000128
+	statusUserSessionDeleted     = 0xC0000203
+	statusNotFound               = 0xC0000225
+	statusFileTooLarge           = 0xC0000904
+)
+
+// fileTime converts t to a Windows FILETIME, 100ns intervals since 1601
+func fileTime(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixNano()/100) + 116444736000000000
+}
+
+// encodeString encodes s as UTF-16LE
+func encodeString(s string) []byte {
+	units := utf16.Encode([]rune(s))
+	b := make([]byte, len(units)*2)
+	for i, unit := range units {
+		le.PutUint16(b[i*2:], unit)
+	}
+	return b
+}
+
+// decodeString decodes UTF-16LE bytes
+func decodeString(b []byte) string {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = le.Uint16(b[i*2:])
+	}
+	return string(utf16.Decode(units))
+}
+
+// field returns the length bytes at offset of msg, where offset counts from
+// the start of the SMB2 header, or nil if they are out of bounds
+func field(msg []byte, offset, length int) []byte {
+	if offset < 0 || length < 0 || offset+length > len(msg) {
+		return nil
+	}
+	return msg[offset : offset+length]
+}
+
+// builder appends little-endian fields to a message
+type builder struct {
+	b []byte
+}
+
+func (w *builder) u8(v uint8) {
+	w.b = append(w.b, v)
+}
+
+func (w *builder) u16(v uint16) {
+	w.b = le.AppendUint16(w.b, v)
+}
+
+func (w *builder) u32(v uint32) {
+	w.b = le.AppendUint32(w.b, v)
+}
+
+func (w *builder) u64(v uint64) {
+	w.b = le.AppendUint64(w.b, v)
+}
+
+func (w *builder) bytes(v []byte) {
+	w.b = append(w.b, v...)
+}
+
+func (w *builder) zeros(n int) {
+	w.b = append(w.b, make([]byte, n)...)
+}
+
+// align pads to a multiple of n bytes
+func (w *builder) align(n int) {
+	if rem := len(w.b) % n; rem != 0 {
+		w.zeros(n - rem)
+	}
+}
+
+func (w *builder) len() int {
+	return len(w.b)
+}
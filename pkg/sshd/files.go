@@ -0,0 +1,170 @@
+package sshd
+
+import (
+	"errors"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/m1kkY8/ctfserver/pkg/config"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+	"github.com/m1kkY8/ctfserver/pkg/util"
+)
+
+// cleanPath returns name as a clean absolute path. Relative paths and "~"
+// start at the top, the only home directory there is.
+func cleanPath(name string) string {
+	name = strings.TrimPrefix(strings.ReplaceAll(name, `\`, "/"), "~")
+	return path.Clean("/" + name)
+}
+
+// uploadName returns the upload file name of a clean absolute path, ok is
+// false for paths outside the uploads directory
+func uploadName(name string) (upload string, ok bool) {
+	if name == "/"+UploadsDir {
+		return "", true
+	}
+	return strings.CutPrefix(name, "/"+UploadsDir+"/")
+}
+
+// canRead reports whether the client may read or list a path: the root
+// needs the download role, the uploads directory the loot role
+func (sess *session) canRead(name string) bool {
+	if _, ok := uploadName(name); ok {
+		return sess.allowed(config.RouteLoot, config.RoleLoot)
+	}
+	return sess.allowed(config.RouteFiles, config.RoleDownload)
+}
+
+// canWrite reports whether the client may store, rename and delete uploads
+func (sess *session) canWrite() bool {
+	return sess.allowed(config.RouteUpload, config.RoleUpload)
+}
+
+// stat returns the info of a clean absolute path
+func (sess *session) stat(name string) (iofs.FileInfo, error) {
+	upload, inUploads := uploadName(name)
+	switch {
+	case !inUploads:
+		return iofs.Stat(sess.server.fileService.RootFS(), util.CleanPath(name))
+	case upload == "":
+		return sess.uploadsDirInfo()
+	}
+
+	filePath, err := sess.server.fileService.UploadPath(upload)
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	return os.Stat(filePath)
+}
+
+// uploadsDirInfo returns the info of the upload directory, named UploadsDir
+func (sess *session) uploadsDirInfo() (iofs.FileInfo, error) {
+	uploadDir := sess.server.fileService.UploadDir()
+	if err := util.EnsureDir(uploadDir); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(uploadDir)
+	if err != nil {
+		return nil, err
+	}
+	return namedInfo{info, UploadsDir}, nil
+}
+
+// readDir lists a directory. The uploads directory hides the files
+// reserved by the file service, the top directory lists it in place of any
+// root entry of that name.
+func (sess *session) readDir(name string) ([]iofs.FileInfo, error) {
+	var infos []iofs.FileInfo
+	if _, inUploads := uploadName(name); inUploads {
+		entries, err := os.ReadDir(sess.server.fileService.UploadDir())
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !service.IsValidUploadName(entry.Name()) {
+				continue
+			}
+			if info, err := entry.Info(); err == nil {
+				infos = append(infos, info)
+			}
+		}
+		return infos, nil
+	}
+
+	entries, err := iofs.ReadDir(sess.server.fileService.RootFS(), util.CleanPath(name))
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if name == "/" && entry.Name() == UploadsDir {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			infos = append(infos, info)
+		}
+	}
+	if name == "/" {
+		if info, err := sess.uploadsDirInfo(); err == nil {
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+// open opens a file of the root or the uploads directory for reading
+func (sess *session) open(name string) (iofs.File, error) {
+	upload, inUploads := uploadName(name)
+	if !inUploads {
+		return sess.server.fileService.RootFS().Open(util.CleanPath(name))
+	}
+	filePath, err := sess.server.fileService.UploadPath(upload)
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	return os.Open(filePath)
+}
+
+// writableUpload returns the upload a path in the uploads directory names,
+// ok is false for other paths
+func writableUpload(name string) (string, bool) {
+	upload, inUploads := uploadName(name)
+	if !inUploads || upload == "" || strings.Contains(upload, "/") {
+		return "", false
+	}
+	return upload, true
+}
+
+// readAt reads file at off. Files of the root can all seek, uploads are
+// plain files.
+func readAt(file iofs.File, p []byte, off int64) (int, error) {
+	if readerAt, ok := file.(io.ReaderAt); ok {
+		return readerAt.ReadAt(p, off)
+	}
+	seeker, ok := file.(io.Seeker)
+	if !ok {
+		return 0, errors.New("file does not support seeking")
+	}
+	if _, err := seeker.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(file, p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// namedInfo is a directory's info under another name
+type namedInfo struct {
+	iofs.FileInfo
+	name string
+}
+
+func (i namedInfo) Name() string {
+	return i.name
+}
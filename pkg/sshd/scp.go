@@ -0,0 +1,367 @@
+package sshd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+)
+
+// scpCommand is the remote end of an scp run by a client: "scp -t" receives
+// files from it, "scp -f" sends files to it
+type scpCommand struct {
+	sink      bool     // -t, otherwise -f
+	recursive bool     // -r
+	dirTarget bool     // -d, the target must be a directory
+	paths     []string // Target of -t, sources of -f
+}
+
+// parseSCP parses the command of an exec request, ok is false for anything
+// but scp in sink or source mode
+func parseSCP(command string) (cmd *scpCommand, ok bool) {
+	args := strings.Fields(command)
+	if len(args) == 0 || path.Base(args[0]) != "scp" {
+		return nil, false
+	}
+
+	cmd = &scpCommand{}
+	var sink, source bool
+	i := 1
+	for ; i < len(args) && strings.HasPrefix(args[i], "-"); i++ {
+		if args[i] == "--" {
+			i++
+			break
+		}
+		for _, flag := range args[i][1:] {
+			switch flag {
+			case 't':
+				sink = true
+			case 'f':
+				source = true
+			case 'r':
+				cmd.recursive = true
+			case 'd':
+				cmd.dirTarget = true
+			case 'p', 'v', 'q':
+				// Times, verbosity and progress don't change what is sent
+			default:
+				return nil, false
+			}
+		}
+	}
+	if sink == source || i == len(args) {
+		return nil, false
+	}
+	cmd.sink = sink
+
+	// Paths are separate arguments in source mode only, a target may have spaces
+	if sink {
+		cmd.paths = []string{unquote(strings.Join(args[i:], " "))}
+	} else {
+		for _, arg := range args[i:] {
+			cmd.paths = append(cmd.paths, unquote(arg))
+		}
+	}
+	return cmd, true
+}
+
+// unquote removes the single quotes scp puts around paths with special
+// characters
+func unquote(arg string) string {
+	if len(arg) >= 2 && arg[0] == '\'' && arg[len(arg)-1] == '\'' {
+		return strings.ReplaceAll(arg[1:len(arg)-1], `'\''`, `'`)
+	}
+	return arg
+}
+
+// run runs the command on the session channel, returning the exit status
+func (cmd *scpCommand) run(sess *session) uint32 {
+	t := &scpTransfer{sess: sess, cmd: cmd, r: bufio.NewReader(sess.channel)}
+	var err error
+	if cmd.sink {
+		err = t.receive()
+	} else {
+		err = t.send()
+	}
+	if err != nil {
+		logger.Logger.WithError(err).WithField("source", sess.source.String()).Debug("SCP transfer failed")
+		return 1
+	}
+	if t.failed {
+		return 1
+	}
+	return 0
+}
+
+// scpTransfer runs the scp protocol: every message is a line answered with
+// a zero byte, or with 1 and a line of text to report an error
+type scpTransfer struct {
+	sess   *session
+	cmd    *scpCommand
+	r      *bufio.Reader
+	failed bool // An error was reported for some file
+}
+
+// ack accepts the last message
+func (t *scpTransfer) ack() error {
+	_, err := t.sess.channel.Write([]byte{0})
+	return err
+}
+
+// warn reports an error with one file, the transfer goes on
+func (t *scpTransfer) warn(format string, args ...interface{}) error {
+	t.failed = true
+	_, err := fmt.Fprintf(t.sess.channel, "\x01scp: "+format+"\n", args...)
+	return err
+}
+
+// fatal reports an error that ends the transfer
+func (t *scpTransfer) fatal(format string, args ...interface{}) error {
+	fmt.Fprintf(t.sess.channel, "\x02scp: "+format+"\n", args...)
+	return fmt.Errorf(format, args...)
+}
+
+// readAck waits for the client to accept the last message
+func (t *scpTransfer) readAck() error {
+	b, err := t.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if b == 0 {
+		return nil
+	}
+	message, _ := t.r.ReadString('\n')
+	return fmt.Errorf("client error: %s", strings.TrimSpace(message))
+}
+
+// receive stores the files the client sends in the upload directory. Into
+// a directory the files keep their names, otherwise the target names the
+// single file. Files of directories sent with -r are stored flat.
+func (t *scpTransfer) receive() error {
+	if !t.sess.canWrite() {
+		t.sess.refused("exec", "scp -t")
+		return t.fatal("permission denied")
+	}
+	target := cleanPath(t.cmd.paths[0])
+	rename := ""
+	if info, err := t.sess.stat(target); !t.cmd.dirTarget && (err != nil || !info.IsDir()) {
+		rename = path.Base(target)
+	}
+	if err := t.ack(); err != nil {
+		return err
+	}
+
+	depth := 0
+	for {
+		line, err := t.r.ReadString('\n')
+		if errors.Is(err, io.EOF) && line == "" {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+
+		switch line[0] {
+		case 'C':
+			mode, size, name, ok := parseEntry(line)
+			if !ok || mode == "" {
+				return t.fatal("invalid file message")
+			}
+			if rename != "" && depth == 0 {
+				name = rename
+			}
+			err = t.receiveFile(name, size)
+		case 'D':
+			if !t.cmd.recursive {
+				return t.fatal("received a directory without -r")
+			}
+			depth++
+			err = t.ack()
+		case 'E':
+			depth--
+			err = t.ack()
+		case 'T':
+			err = t.ack()
+		case '\x01', '\x02':
+			// The client reports an error with one of its files
+			logger.Logger.WithField("source", t.sess.source.String()).WithField("error", line[1:]).Debug("SCP client error")
+			if line[0] == '\x02' {
+				return errors.New(line[1:])
+			}
+		default:
+			return t.fatal("unexpected message")
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// receiveFile stores a file of size bytes through the file service. Files
+// that can't fit are refused before the client sends them.
+func (t *scpTransfer) receiveFile(filename string, size int64) error {
+	fs := t.sess.server.fileService
+	source := t.sess.source.String()
+	fields := t.sess.fields(filename)
+	if !service.IsValidUploadName(filename) {
+		return t.warn("%s: invalid filename", filename)
+	}
+	if maxSize := fs.MaxSize(); size > maxSize {
+		logger.Logger.WithFields(fields).WithField("size", size).Warn("Rejected SCP upload")
+		return t.warn("%s: file exceeds maximum size of %d bytes", filename, maxSize)
+	}
+	if err := fs.CheckUpload(source, size); err != nil {
+		logger.Logger.WithError(err).WithFields(fields).Warn("Rejected upload over storage limits")
+		return t.warn("%s: %v", filename, err)
+	}
+	if err := t.ack(); err != nil {
+		return err
+	}
+
+	content := io.LimitReader(t.r, size)
+	result, err := fs.SaveUpload(filename, content, size, source, 0)
+	// Stay in step with the client whatever was read, the data ends with a zero byte
+	if _, copyErr := io.Copy(io.Discard, content); copyErr != nil {
+		return copyErr
+	}
+	if ackErr := t.readAck(); ackErr != nil {
+		return ackErr
+	}
+
+	switch {
+	case errors.Is(err, service.ErrInsufficientStorage):
+		logger.Logger.WithError(err).WithFields(fields).Warn("Rejected upload over storage limits")
+		return t.warn("%s: %v", filename, err)
+	case err != nil:
+		logger.Logger.WithError(err).WithFields(fields).Error("Failed to save upload")
+		return t.warn("%s: failed to save file", filename)
+	case !result.Success:
+		logger.Logger.WithFields(fields).WithField("error", result.Error).Warn("Rejected SCP upload")
+		return t.warn("%s: %s", filename, result.Error)
+	}
+	logger.Logger.WithFields(map[string]interface{}{
+		"filename": result.Filename,
+		"size":     result.Size,
+		"path":     result.Path,
+		"source":   source,
+		"user":     t.sess.user,
+		"via":      "scp",
+	}).Info("File uploaded successfully")
+	return t.ack()
+}
+
+// parseEntry parses a "C" or "D" message: mode, size and name
+func parseEntry(line string) (mode string, size int64, name string, ok bool) {
+	parts := strings.SplitN(line[1:], " ", 3)
+	if len(parts) != 3 {
+		return "", 0, "", false
+	}
+	size, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || size < 0 {
+		return "", 0, "", false
+	}
+	return parts[0], size, parts[2], true
+}
+
+// send sends the requested files of the root or the uploads directory
+func (t *scpTransfer) send() error {
+	if err := t.readAck(); err != nil {
+		return err
+	}
+	for _, arg := range t.cmd.paths {
+		name := cleanPath(arg)
+		if !t.sess.canRead(name) {
+			if err := t.warn("%s: permission denied", arg); err != nil {
+				return err
+			}
+			continue
+		}
+		info, err := t.sess.stat(name)
+		switch {
+		case err != nil:
+			err = t.warn("%s: No such file or directory", arg)
+		case info.IsDir() && !t.cmd.recursive:
+			err = t.warn("%s: not a regular file", arg)
+		case info.IsDir():
+			err = t.sendDir(name)
+		default:
+			err = t.sendFile(name, info)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendDir sends a directory and everything below it
+func (t *scpTransfer) sendDir(name string) error {
+	infos, err := t.sess.readDir(name)
+	if err != nil {
+		return t.warn("%s: failed to read directory", name)
+	}
+	dirName := path.Base(name)
+	if name == "/" {
+		dirName = "root"
+	}
+	fmt.Fprintf(t.sess.channel, "D0755 0 %s\n", dirName)
+	if err := t.readAck(); err != nil {
+		return err
+	}
+
+	for _, info := range infos {
+		child := path.Join(name, info.Name())
+		if !t.sess.canRead(child) {
+			continue
+		}
+		if info.IsDir() {
+			err = t.sendDir(child)
+		} else {
+			err = t.sendFile(child, info)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	io.WriteString(t.sess.channel, "E\n")
+	return t.readAck()
+}
+
+// sendFile sends one file
+func (t *scpTransfer) sendFile(name string, info iofs.FileInfo) error {
+	file, err := t.sess.open(name)
+	if err != nil {
+		return t.warn("%s: No such file or directory", name)
+	}
+	defer file.Close()
+
+	size := info.Size()
+	fmt.Fprintf(t.sess.channel, "C0644 %d %s\n", size, path.Base(name))
+	if err := t.readAck(); err != nil {
+		return err
+	}
+	served, err := io.CopyN(t.sess.channel, file, size)
+	if err != nil {
+		return t.fatal("%s: read error", name)
+	}
+	if err := t.ack(); err != nil {
+		return err
+	}
+	if err := t.readAck(); err != nil {
+		return err
+	}
+
+	fields := t.sess.fields(strings.TrimPrefix(name, "/"))
+	fields["bytes"] = served
+	logger.Logger.WithFields(fields).Info("Served file over SCP")
+	return nil
+}
@@ -0,0 +1,99 @@
+package sshd
+
+import (
+	"net/netip"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/m1kkY8/ctfserver/pkg/auth"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+)
+
+// session is a session channel, running the sftp subsystem or scp
+type session struct {
+	server   *Server
+	channel  ssh.Channel
+	user     string
+	identity *auth.Identity // Nil with authentication disabled
+	source   netip.Addr
+}
+
+// serve answers the requests of the session. The first subsystem or exec
+// request it supports starts the transfer; shells, terminals and any other
+// command are refused.
+func (sess *session) serve(requests <-chan *ssh.Request) {
+	started := false
+	done := make(chan struct{})
+	for req := range requests {
+		var run func() uint32
+		switch req.Type {
+		case "subsystem":
+			if name := stringPayload(req.Payload); name == "sftp" {
+				run = sess.serveSFTP
+			} else {
+				sess.refused(req.Type, name)
+			}
+		case "exec":
+			command := stringPayload(req.Payload)
+			if scp, ok := parseSCP(command); ok {
+				run = func() uint32 { return scp.run(sess) }
+			} else {
+				sess.refused(req.Type, command)
+			}
+		case "shell":
+			sess.refused(req.Type, "")
+		}
+
+		if run == nil || started {
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+			continue
+		}
+		started = true
+		if req.WantReply {
+			req.Reply(true, nil)
+		}
+		go func() {
+			defer close(done)
+			status := run()
+			sess.channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+			sess.channel.Close()
+		}()
+	}
+
+	if started {
+		<-done
+	}
+	sess.channel.Close()
+}
+
+// refused logs a refused session request
+func (sess *session) refused(kind, command string) {
+	fields := map[string]interface{}{
+		"type":   kind,
+		"source": sess.source.String(),
+		"user":   sess.user,
+	}
+	if command != "" {
+		fields["command"] = command
+	}
+	logger.Logger.WithFields(fields).Warn("Rejected SSH request")
+}
+
+// allowed reports whether the client may use what the route group covers
+// over HTTP: its address must pass the filter and its identity must be
+// permitted role
+func (sess *session) allowed(group, role string) bool {
+	return sess.server.ipFilter.Allowed(group, sess.source) && sess.server.auth.Permits(sess.identity, role)
+}
+
+// stringPayload returns the string a subsystem or exec request carries
+func stringPayload(payload []byte) string {
+	var value struct{ Value string }
+	if err := ssh.Unmarshal(payload, &value); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(value.Value)
+}
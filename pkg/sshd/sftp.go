@@ -0,0 +1,267 @@
+package sshd
+
+import (
+	"errors"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/pkg/sftp"
+
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+)
+
+// sftpHandler answers the file requests of an SFTP session
+type sftpHandler struct {
+	sess *session
+}
+
+// serveSFTP runs the sftp subsystem until the client ends it, returning the
+// exit status. The channel stays open for the session to send it.
+func (sess *session) serveSFTP() uint32 {
+	h := &sftpHandler{sess: sess}
+	server := sftp.NewRequestServer(sess.channel, sftp.Handlers{
+		FileGet:  h,
+		FilePut:  h,
+		FileCmd:  h,
+		FileList: h,
+	}, sftp.WithStartDirectory("/"))
+
+	if err := server.Serve(); err != nil && !errors.Is(err, io.EOF) {
+		logger.Logger.WithError(err).WithField("source", sess.source.String()).Debug("SFTP session ended")
+		return 1
+	}
+	return 0
+}
+
+// Fileread opens a file of the root or the uploads directory for reading
+func (h *sftpHandler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	name := cleanPath(r.Filepath)
+	if !h.sess.canRead(name) {
+		return nil, sftp.ErrSSHFxPermissionDenied
+	}
+	file, err := h.sess.open(name)
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	if info, err := file.Stat(); err != nil || info.IsDir() {
+		file.Close()
+		return nil, errors.New("not a regular file")
+	}
+	return &download{sess: h.sess, file: file, name: name}, nil
+}
+
+// Filewrite opens an upload for writing. The file is named after the last
+// element of the path, whatever the directory.
+func (h *sftpHandler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	if !h.sess.canWrite() {
+		return nil, sftp.ErrSSHFxPermissionDenied
+	}
+	name := cleanPath(r.Filepath)
+	filename := path.Base(name)
+	if !service.IsValidUploadName(filename) {
+		return nil, service.ErrInvalidFilename
+	}
+
+	flags := r.Pflags()
+	if flags.Excl {
+		if _, err := h.sess.stat("/" + UploadsDir + "/" + filename); err == nil {
+			return nil, os.ErrExist
+		}
+	}
+	writer, err := h.sess.server.fileService.OpenUploadWriter(filename, h.sess.source.String(), flags.Trunc)
+	if err != nil {
+		fields := h.sess.fields(filename)
+		if errors.Is(err, service.ErrInsufficientStorage) {
+			logger.Logger.WithError(err).WithFields(fields).Warn("Rejected upload over storage limits")
+			return nil, err
+		}
+		logger.Logger.WithError(err).WithFields(fields).Error("Failed to open upload")
+		return nil, errors.New("failed to open file")
+	}
+	return &upload{sess: h.sess, writer: writer}, nil
+}
+
+// Filecmd changes uploads. Attribute changes, which clients send after
+// transfers, are accepted and ignored.
+func (h *sftpHandler) Filecmd(r *sftp.Request) error {
+	name := cleanPath(r.Filepath)
+	switch r.Method {
+	case "Setstat":
+		if !h.sess.canWrite() {
+			return sftp.ErrSSHFxPermissionDenied
+		}
+		return nil
+
+	case "Remove":
+		upload, ok := writableUpload(name)
+		if !ok || !h.sess.canWrite() {
+			return sftp.ErrSSHFxPermissionDenied
+		}
+		if err := h.sess.server.fileService.DeleteUpload(upload); err != nil {
+			return os.ErrNotExist
+		}
+		logger.Logger.WithFields(h.sess.fields(upload)).Info("Deleted upload over SFTP")
+		return nil
+
+	case "Rename":
+		from, fromOK := writableUpload(name)
+		to, toOK := writableUpload(cleanPath(r.Target))
+		if !fromOK || !toOK || !h.sess.canWrite() {
+			return sftp.ErrSSHFxPermissionDenied
+		}
+		if err := h.sess.server.fileService.RenameUpload(from, to); err != nil {
+			if errors.Is(err, service.ErrInvalidFilename) {
+				return err
+			}
+			return errors.New("rename failed")
+		}
+		fields := h.sess.fields(to)
+		fields["from"] = from
+		logger.Logger.WithFields(fields).Info("Renamed upload over SFTP")
+		return nil
+
+	case "Mkdir", "Rmdir", "Link", "Symlink":
+		// The root is read-only and the upload directory is flat
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+// Filelist lists directories and describes files. Clients that may only
+// upload can still see the top and uploads directories, so they find
+// somewhere to write to.
+func (h *sftpHandler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	name := cleanPath(r.Filepath)
+	switch r.Method {
+	case "List":
+		if !h.sess.canRead(name) {
+			return nil, sftp.ErrSSHFxPermissionDenied
+		}
+		info, err := h.sess.stat(name)
+		if err != nil {
+			return nil, os.ErrNotExist
+		}
+		if !info.IsDir() {
+			return listerAt{info}, nil
+		}
+		infos, err := h.sess.readDir(name)
+		if err != nil {
+			return nil, errors.New("failed to read directory")
+		}
+		return listerAt(infos), nil
+
+	case "Stat":
+		writerDir := name == "/" || name == "/"+UploadsDir
+		if !h.sess.canRead(name) && !(writerDir && h.sess.canWrite()) {
+			return nil, sftp.ErrSSHFxPermissionDenied
+		}
+		info, err := h.sess.stat(name)
+		if err != nil {
+			return nil, os.ErrNotExist
+		}
+		return listerAt{info}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+// fields returns the log fields of a transfer of filename
+func (sess *session) fields(filename string) map[string]interface{} {
+	return map[string]interface{}{
+		"filename": filename,
+		"source":   sess.source.String(),
+		"user":     sess.user,
+	}
+}
+
+// listerAt lists a fixed set of entries
+type listerAt []iofs.FileInfo
+
+func (l listerAt) ListAt(entries []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(entries, l[offset:])
+	if n < len(entries) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// download is a file open for reading, logged when the client closes it
+type download struct {
+	sess *session
+	file iofs.File
+	name string
+
+	mu     sync.Mutex // Seeking files can only serve one read at a time
+	served int64
+}
+
+func (d *download) ReadAt(p []byte, off int64) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n, err := readAt(d.file, p, off)
+	d.served += int64(n)
+	return n, err
+}
+
+func (d *download) Close() error {
+	err := d.file.Close()
+	fields := d.sess.fields(strings.TrimPrefix(d.name, "/"))
+	fields["bytes"] = d.served
+	logger.Logger.WithFields(fields).Info("Served file over SFTP")
+	return err
+}
+
+// upload is an upload open for writing, recorded when the client closes it
+type upload struct {
+	sess   *session
+	writer *service.UploadWriter
+
+	mu       sync.Mutex
+	rejected error // First write refused over the size or storage limits
+}
+
+func (u *upload) WriteAt(p []byte, off int64) (int, error) {
+	n, err := u.writer.WriteAt(p, off)
+	if errors.Is(err, service.ErrFileTooLarge) || errors.Is(err, service.ErrInsufficientStorage) {
+		u.mu.Lock()
+		if u.rejected == nil {
+			u.rejected = err
+		}
+		u.mu.Unlock()
+	}
+	return n, err
+}
+
+func (u *upload) Close() error {
+	name := u.writer.Name()
+	result, err := u.writer.Close()
+	fields := u.sess.fields(name)
+
+	u.mu.Lock()
+	rejected := u.rejected
+	u.mu.Unlock()
+	switch {
+	case rejected != nil:
+		logger.Logger.WithFields(fields).WithField("error", rejected.Error()).Warn("Rejected SFTP upload")
+	case err != nil:
+		logger.Logger.WithError(err).WithFields(fields).Error("Failed to save upload")
+		return err
+	case result != nil:
+		logger.Logger.WithFields(map[string]interface{}{
+			"filename": result.Filename,
+			"size":     result.Size,
+			"path":     result.Path,
+			"source":   u.sess.source.String(),
+			"user":     u.sess.user,
+			"via":      "sftp",
+		}).Info("File uploaded successfully")
+	}
+	return err
+}
@@ -0,0 +1,362 @@
+// Package sshd serves the root directory and the upload directory over SFTP
+// and scp, for targets with an OpenSSH client, which includes Windows 10 and
+// later. It is not a shell server: sessions only run the sftp subsystem or
+// "scp -t" and "scp -f", and port forwarding is refused.
+//
+// The layout is the same as over FTP: the root is served read-only at the
+// top, the upload directory below UploadsDir, and files written anywhere
+// land in the upload directory under their base name. Password logons are
+// checked against the configured users and tokens. Keys listed in the
+// authorized_keys file log on as the configured user the client names only
+// if the key's comment is that user, as NAME or NAME@HOST; other user names
+// get the anonymous roles. Without users, tokens or authorized keys, every
+// logon is accepted.
+package sshd
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/m1kkY8/ctfserver/pkg/auth"
+	"github.com/m1kkY8/ctfserver/pkg/ipfilter"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+)
+
+// UploadsDir is the directory at the top of the server backed by the upload
+// directory. It hides a root entry of the same name.
+const UploadsDir = "uploads"
+
+// handshakeTimeout bounds the key exchange and logon of a connection
+const handshakeTimeout = 30 * time.Second
+
+// ErrServerClosed is returned by Serve after Close
+var ErrServerClosed = errors.New("sshd: server closed")
+
+// Server is an SSH server offering only SFTP and scp
+type Server struct {
+	fileService    *service.FileService
+	auth           *auth.Authenticator
+	ipFilter       *ipfilter.Filter
+	hostKey        ssh.Signer
+	authorizedKeys string // Path of the authorized_keys file, empty for none
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// New creates an SSH server for fileService identified by hostKey, checking
+// passwords with authenticator, keys against the authorized_keys file at
+// authorizedKeys and client addresses with filter
+func New(fileService *service.FileService, authenticator *auth.Authenticator, filter *ipfilter.Filter, hostKey ssh.Signer, authorizedKeys string) *Server {
+	return &Server{
+		fileService:    fileService,
+		auth:           authenticator,
+		ipFilter:       filter,
+		hostKey:        hostKey,
+		authorizedKeys: authorizedKeys,
+		conns:          make(map[net.Conn]struct{}),
+	}
+}
+
+// LoadHostKey returns the host key saved at path, generating an Ed25519 key
+// and saving it there if there is none yet so clients see the same key on
+// every run. Without a path the generated key only lasts for this run.
+func LoadHostKey(path string) (ssh.Signer, error) {
+	if path != "" {
+		keyPEM, err := os.ReadFile(path)
+		if err == nil {
+			signer, err := ssh.ParsePrivateKey(keyPEM)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse host key %s: %w", path, err)
+			}
+			return signer, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read host key: %w", err)
+		}
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if path != "" {
+		block, err := ssh.MarshalPrivateKey(key, "")
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, fmt.Errorf("failed to create host key directory: %w", err)
+		}
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+			return nil, fmt.Errorf("failed to save host key: %w", err)
+		}
+	}
+	return signer, nil
+}
+
+// HostKeyFingerprint returns the SHA-256 fingerprint of the host key, as
+// ssh prints it
+func (s *Server) HostKeyFingerprint() string {
+	return ssh.FingerprintSHA256(s.hostKey.PublicKey())
+}
+
+// ListenAndServe listens on the TCP address addr and serves connections
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve serves connections accepted from l until Close
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listener = l
+	s.mu.Unlock()
+
+	for {
+		netConn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			netConn.Close()
+			return ErrServerClosed
+		}
+		s.conns[netConn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(netConn)
+			netConn.Close()
+			s.mu.Lock()
+			delete(s.conns, netConn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// serveConn runs the handshake on netConn and serves its sessions
+func (s *Server) serveConn(netConn net.Conn) {
+	var identity *auth.Identity
+	netConn.SetDeadline(time.Now().Add(handshakeTimeout))
+	sshConn, channels, requests, err := ssh.NewServerConn(netConn, s.serverConfig(&identity))
+	if err != nil {
+		logger.Logger.WithError(err).WithField("remote_addr", netConn.RemoteAddr().String()).Debug("SSH handshake failed")
+		return
+	}
+	defer sshConn.Close()
+	netConn.SetDeadline(time.Time{})
+
+	source := remoteAddr(sshConn)
+	logger.Logger.WithFields(map[string]interface{}{
+		"user":   sshConn.User(),
+		"source": source.String(),
+		"client": string(sshConn.ClientVersion()),
+	}).Info("SSH logon")
+
+	go refuseRequests(requests, source)
+
+	var sessions sync.WaitGroup
+	defer sessions.Wait()
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			logger.Logger.WithFields(map[string]interface{}{
+				"type":   newChannel.ChannelType(),
+				"source": source.String(),
+				"user":   sshConn.User(),
+			}).Warn("Rejected SSH channel")
+			newChannel.Reject(ssh.Prohibited, "only sftp and scp sessions are supported")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+
+		sess := &session{
+			server:   s,
+			channel:  channel,
+			user:     sshConn.User(),
+			identity: identity,
+			source:   source,
+		}
+		sessions.Add(1)
+		go func() {
+			defer sessions.Done()
+			sess.serve(channelRequests)
+		}()
+	}
+}
+
+// serverConfig returns the handshake configuration of a connection, storing
+// the identity the client logs on as in identity
+func (s *Server) serverConfig(identity **auth.Identity) *ssh.ServerConfig {
+	open := !s.auth.Enabled() && s.authorizedKeys == ""
+	cfg := &ssh.ServerConfig{
+		NoClientAuth: open,
+		PasswordCallback: func(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if open {
+				return nil, nil
+			}
+			if id, ok := s.auth.AuthenticatePassword(meta.User(), string(password)); ok {
+				*identity = id
+				return nil, nil
+			}
+			logRejected(meta, "password")
+			return nil, errors.New("invalid credentials")
+		},
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if open {
+				return nil, nil
+			}
+			if owner, ok := s.authorized(key); ok {
+				// A configured user's roles need a key issued to that user
+				id := s.auth.KeyIdentity(meta.User())
+				if id.Anonymous || keyOwner(owner) == id.Name {
+					*identity = id
+					return nil, nil
+				}
+			}
+			logRejected(meta, ssh.FingerprintSHA256(key))
+			return nil, errors.New("key not authorized")
+		},
+	}
+	cfg.AddHostKey(s.hostKey)
+	return cfg
+}
+
+// authorized reports whether key is listed in the authorized_keys file and
+// returns its comment, the user it was issued to. The file is read on every
+// logon, so keys can be added while serving.
+func (s *Server) authorized(key ssh.PublicKey) (owner string, ok bool) {
+	if s.authorizedKeys == "" {
+		return "", false
+	}
+	data, err := os.ReadFile(s.authorizedKeys)
+	if err != nil {
+		logger.Logger.WithError(err).Warn("Failed to read authorized keys")
+		return "", false
+	}
+	return findAuthorizedKey(data, key)
+}
+
+// keyOwner returns the user an authorized key was issued to from its
+// comment, NAME or NAME@HOST as ssh-keygen writes it
+func keyOwner(comment string) string {
+	owner, _, _ := strings.Cut(strings.TrimSpace(comment), "@")
+	return owner
+}
+
+// findAuthorizedKey looks key up in the authorized_keys content data
+func findAuthorizedKey(data []byte, key ssh.PublicKey) (owner string, ok bool) {
+	wanted := key.Marshal()
+	for len(data) > 0 {
+		authorizedKey, comment, _, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			return "", false
+		}
+		if bytes.Equal(authorizedKey.Marshal(), wanted) {
+			return comment, true
+		}
+		data = rest
+	}
+	return "", false
+}
+
+// logRejected logs a failed logon with credential, the method or key used
+func logRejected(meta ssh.ConnMetadata, credential string) {
+	logger.Logger.WithFields(map[string]interface{}{
+		"user":       meta.User(),
+		"source":     remoteAddr(meta).String(),
+		"credential": credential,
+	}).Warn("Rejected SSH logon")
+}
+
+// refuseRequests refuses the global requests of a connection, like the
+// tcpip-forward of remote port forwarding
+func refuseRequests(requests <-chan *ssh.Request, source netip.Addr) {
+	for req := range requests {
+		if req.Type != "keepalive@openssh.com" {
+			logger.Logger.WithFields(map[string]interface{}{
+				"type":   req.Type,
+				"source": source.String(),
+			}).Warn("Rejected SSH request")
+		}
+		if req.WantReply {
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// remoteAddr returns the client address of a connection
+func remoteAddr(meta ssh.ConnMetadata) netip.Addr {
+	if addrPort, err := netip.ParseAddrPort(meta.RemoteAddr().String()); err == nil {
+		return addrPort.Addr().Unmap()
+	}
+	return netip.Addr{}
+}
+
+// Close stops accepting connections and closes the open ones, waiting for
+// their transfers to end
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for netConn := range s.conns {
+		netConn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	logger.Logger.Debug("SSH server closed")
+	return err
+}
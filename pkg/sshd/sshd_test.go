@@ -0,0 +1,242 @@
+package sshd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/m1kkY8/ctfserver/pkg/auth"
+	"github.com/m1kkY8/ctfserver/pkg/config"
+	"github.com/m1kkY8/ctfserver/pkg/ipfilter"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+)
+
+// serve starts a server for cfg on a loopback port, authorizing the keys in
+// authorizedKeys. The root holds tools/nc and has secret.txt next to it,
+// outside the root.
+func serve(t *testing.T, cfg *config.Config, authorizedKeys string) (addr, uploadDir string) {
+	t.Helper()
+	logger.InitLogger("error")
+	dir := t.TempDir()
+	rootDir, uploadDir := filepath.Join(dir, "root"), filepath.Join(dir, "uploads")
+	if err := os.MkdirAll(filepath.Join(rootDir, "tools"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		filepath.Join(rootDir, "tools", "nc"): "nc",
+		filepath.Join(dir, "secret.txt"):      "secret",
+	} {
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keysPath := ""
+	if authorizedKeys != "" {
+		keysPath = filepath.Join(dir, "authorized_keys")
+		if err := os.WriteFile(keysPath, []byte(authorizedKeys), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hostKey, err := LoadHostKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileService := service.NewFileService(os.DirFS(rootDir), "root", uploadDir, 1<<20)
+	server := New(fileService, auth.New(cfg), ipfilter.New(cfg), hostKey, keysPath)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(l)
+	t.Cleanup(func() { server.Close() })
+	return l.Addr().String(), uploadDir
+}
+
+// newKey returns a client key and its authorized_keys line with comment
+func newKey(t *testing.T, comment string) (ssh.Signer, string) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))) + " " + comment + "\n"
+	return signer, line
+}
+
+// dial logs on to addr as user and starts an SFTP session
+func dial(t *testing.T, addr, user string, method ssh.AuthMethod) (*sftp.Client, error) {
+	t.Helper()
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{method},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() { conn.Close() })
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, nil
+}
+
+// readFile reads name over SFTP
+func readFile(client *sftp.Client, name string) (string, error) {
+	file, err := client.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	return string(data), err
+}
+
+// writeFile writes content to name over SFTP
+func writeFile(client *sftp.Client, name, content string) error {
+	file, err := client.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(file, content); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func TestTraversal(t *testing.T) {
+	addr, uploadDir := serve(t, &config.Config{}, "")
+	client, err := dial(t, addr, "anyone", ssh.Password("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Paths above the top resolve to it, so nothing outside the root is reachable
+	for _, name := range []string{"../secret.txt", "/../secret.txt", `..\secret.txt`, "~/../secret.txt", "/uploads/../../secret.txt"} {
+		if content, err := readFile(client, name); err == nil {
+			t.Errorf("read %s: %q", name, content)
+		}
+	}
+
+	// Uploads are named after the last element, reserved names are refused
+	if err := writeFile(client, "../../evil.txt", "evil"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(uploadDir, "evil.txt")); err != nil || string(data) != "evil" {
+		t.Errorf("upload holds %q: %v", data, err)
+	}
+	if err := writeFile(client, "/uploads/.uploads.json", "{}"); err == nil {
+		t.Error("wrote the upload index")
+	}
+	if err := client.Remove("/tools/nc"); err == nil {
+		t.Error("removed a file of the root")
+	}
+	if err := client.Rename("/uploads/evil.txt", "/tools/evil.txt"); err == nil {
+		t.Error("renamed an upload into the root")
+	}
+}
+
+func TestUploadsLandInUploadDir(t *testing.T) {
+	addr, uploadDir := serve(t, &config.Config{}, "")
+	client, err := dial(t, addr, "anyone", ssh.Password("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeFile(client, "/tools/loot.txt", "loot"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(uploadDir, "loot.txt")); err != nil || string(data) != "loot" {
+		t.Errorf("upload holds %q: %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(uploadDir), "root", "tools", "loot.txt")); !os.IsNotExist(err) {
+		t.Errorf("upload written to the root: %v", err)
+	}
+	if content, err := readFile(client, "/uploads/loot.txt"); err != nil || content != "loot" {
+		t.Errorf("read back %q: %v", content, err)
+	}
+	if content, err := readFile(client, "tools/nc"); err != nil || content != "nc" {
+		t.Errorf("read tools/nc %q: %v", content, err)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	aliceKey, aliceLine := newKey(t, "alice@kali")
+	otherKey, otherLine := newKey(t, "kali@box")
+	addr, _ := serve(t, &config.Config{
+		Users:     []config.UserConfig{{Name: "alice", Password: "secret", Roles: []string{config.RoleUpload, config.RoleLoot}}},
+		Anonymous: []string{config.RoleDownload},
+	}, aliceLine+otherLine)
+
+	if _, err := dial(t, addr, "alice", ssh.Password("wrong")); err == nil {
+		t.Error("logged on with a wrong password")
+	}
+	// A configured user only accepts keys issued to that user
+	if _, err := dial(t, addr, "alice", ssh.PublicKeys(otherKey)); err == nil {
+		t.Error("logged on as alice with another user's key")
+	}
+
+	tests := []struct {
+		name     string
+		user     string
+		method   ssh.AuthMethod
+		download bool
+		upload   bool
+	}{
+		{"password", "alice", ssh.Password("secret"), false, true},
+		{"own key", "alice", ssh.PublicKeys(aliceKey), false, true},
+		{"key as another name", "root", ssh.PublicKeys(aliceKey), true, false},
+		{"unnamed key", "kali", ssh.PublicKeys(otherKey), true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := dial(t, addr, tt.user, tt.method)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := readFile(client, "/tools/nc"); (err == nil) != tt.download {
+				t.Errorf("download error %v, want allowed %v", err, tt.download)
+			}
+			if err := writeFile(client, tt.user+".txt", "x"); (err == nil) != tt.upload {
+				t.Errorf("upload error %v, want allowed %v", err, tt.upload)
+			}
+			if err := writeFile(client, "denied.txt", "x"); err != nil && !tt.upload && !errors.Is(err, os.ErrPermission) {
+				t.Errorf("refused upload error %v, want permission denied", err)
+			}
+		})
+	}
+}
+
+func TestKeyOwner(t *testing.T) {
+	for comment, want := range map[string]string{
+		"alice":          "alice",
+		"alice@kali":     "alice",
+		" bob@host.lan ": "bob",
+		"":               "",
+		"@host":          "",
+	} {
+		if got := keyOwner(comment); got != want {
+			t.Errorf("keyOwner(%q) = %q, want %q", comment, got, want)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+// Package tftp serves the root directory read-only over TFTP (RFC 1350 with
+// the blksize, tsize and timeout options of RFC 2347-2349) and stores written
+// files in the upload directory, for network devices, PXE-booted hosts and
+// busybox systems that only have a TFTP client.
+//
+// TFTP has no logons, so every client gets the anonymous roles once
+// authentication is enabled.
+package tftp
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/m1kkY8/ctfserver/pkg/auth"
+	"github.com/m1kkY8/ctfserver/pkg/ipfilter"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+)
+
+// Opcodes
+const (
+	opRRQ   = 1
+	opWRQ   = 2
+	opData  = 3
+	opAck   = 4
+	opError = 5
+	opOACK  = 6
+)
+
+// Error codes
+const (
+	errNotDefined       = 0
+	errFileNotFound     = 1
+	errAccessViolation  = 2
+	errDiskFull         = 3
+	errIllegalOperation = 4
+	errUnknownTID       = 5
+	errOptionRefused    = 8
+)
+
+// Transfer parameters: the RFC 1350 block size and the bounds of the options
+const (
+	defaultBlockSize = 512
+	minBlockSize     = 8
+	maxBlockSize     = 65464
+	defaultTimeout   = 2 * time.Second
+	maxTimeout       = 255 * time.Second
+	maxRetries       = 5
+	maxPacketSize    = maxBlockSize + 4
+)
+
+// ErrServerClosed is returned by Serve after Close
+var ErrServerClosed = errors.New("tftp: server closed")
+
+// Server is a TFTP server
+type Server struct {
+	fileService *service.FileService
+	auth        *auth.Authenticator
+	ipFilter    *ipfilter.Filter
+
+	mu        sync.Mutex
+	conn      *net.UDPConn
+	transfers map[*transfer]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// New creates a TFTP server for fileService, checking what clients may do
+// against the anonymous roles of authenticator and the rules of filter
+func New(fileService *service.FileService, authenticator *auth.Authenticator, filter *ipfilter.Filter) *Server {
+	return &Server{
+		fileService: fileService,
+		auth:        authenticator,
+		ipFilter:    filter,
+		transfers:   make(map[*transfer]struct{}),
+	}
+}
+
+// ListenAndServe listens on the UDP address addr and serves requests
+func (s *Server) ListenAndServe(addr string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(conn)
+}
+
+// Serve answers requests received on conn until Close. Each transfer runs
+// from its own port, as RFC 1350 requires.
+func (s *Server) Serve(conn *net.UDPConn) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return ErrServerClosed
+	}
+	s.conn = conn
+	s.mu.Unlock()
+
+	localIP := conn.LocalAddr().(*net.UDPAddr).IP
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, peer, err := conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		req, err := parseRequest(buf[:n])
+		if err != nil {
+			logger.Logger.WithError(err).WithField("remote_addr", peer.String()).Debug("Ignoring TFTP packet")
+			continue
+		}
+		peer = netip.AddrPortFrom(peer.Addr().Unmap(), peer.Port())
+
+		t, err := s.newTransfer(localIP, peer)
+		if err != nil {
+			logger.Logger.WithError(err).WithField("remote_addr", peer.String()).Error("Failed to start TFTP transfer")
+			continue
+		}
+		go func() {
+			defer s.wg.Done()
+			defer s.endTransfer(t)
+			if req.opcode == opRRQ {
+				t.serveRead(req)
+			} else {
+				t.serveWrite(req)
+			}
+		}()
+	}
+}
+
+// newTransfer opens the port of a transfer with peer and registers it
+func (s *Server) newTransfer(localIP net.IP, peer netip.AddrPort) (*transfer, error) {
+	conn, err := net.DialUDP("udp", &net.UDPAddr{IP: localIP}, net.UDPAddrFromAddrPort(peer))
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		conn.Close()
+		return nil, ErrServerClosed
+	}
+	t := &transfer{
+		server:    s,
+		conn:      conn,
+		peer:      peer,
+		blockSize: defaultBlockSize,
+		timeout:   defaultTimeout,
+	}
+	s.transfers[t] = struct{}{}
+	s.wg.Add(1)
+	return t, nil
+}
+
+func (s *Server) endTransfer(t *transfer) {
+	t.conn.Close()
+	s.mu.Lock()
+	delete(s.transfers, t)
+	s.mu.Unlock()
+}
+
+// allowed reports whether a client at addr may use what the route group
+// covers over HTTP: its address must pass the filter and anonymous clients
+// must be permitted role
+func (s *Server) allowed(addr netip.Addr, group, role string) bool {
+	return s.ipFilter.Allowed(group, addr) && s.auth.Permits(s.auth.Anonymous(), role)
+}
+
+// Close stops answering requests and aborts the running transfers
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.conn != nil {
+		err = s.conn.Close()
+	}
+	for t := range s.transfers {
+		t.conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	logger.Logger.Debug("TFTP server closed")
+	return err
+}
+
+// request is a read or write request
+type request struct {
+	opcode   uint16
+	filename string
+	mode     string
+	options  map[string]string // Lower-cased option names
+}
+
+// parseRequest parses an RRQ or WRQ packet
+func parseRequest(packet []byte) (*request, error) {
+	if len(packet) < 4 {
+		return nil, errors.New("packet too short")
+	}
+	req := &request{opcode: be16(packet), options: make(map[string]string)}
+	if req.opcode != opRRQ && req.opcode != opWRQ {
+		return nil, errors.New("not a read or write request")
+	}
+
+	fields := bytes.Split(packet[2:], []byte{0})
+	if len(fields) < 3 || len(fields[len(fields)-1]) != 0 {
+		return nil, errors.New("malformed request")
+	}
+	fields = fields[:len(fields)-1]
+	req.filename = string(fields[0])
+	req.mode = strings.ToLower(string(fields[1]))
+	for i := 2; i+1 < len(fields); i += 2 {
+		req.options[strings.ToLower(string(fields[i]))] = string(fields[i+1])
+	}
+	return req, nil
+}
+
+// negotiate applies the options of req that the server supports to t,
+// returning the options to acknowledge. size is the transfer size to
+// answer tsize with in read requests.
+func (t *transfer) negotiate(req *request, size int64) map[string]string {
+	accepted := make(map[string]string)
+	if value, ok := req.options["blksize"]; ok {
+		if blockSize, err := strconv.Atoi(value); err == nil && blockSize >= minBlockSize {
+			t.blockSize = min(blockSize, maxBlockSize)
+			accepted["blksize"] = strconv.Itoa(t.blockSize)
+		}
+	}
+	if value, ok := req.options["timeout"]; ok {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds >= 1 && time.Duration(seconds)*time.Second <= maxTimeout {
+			t.timeout = time.Duration(seconds) * time.Second
+			accepted["timeout"] = value
+		}
+	}
+	if value, ok := req.options["tsize"]; ok {
+		if req.opcode == opRRQ {
+			accepted["tsize"] = strconv.FormatInt(size, 10)
+		} else if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+			accepted["tsize"] = value
+		}
+	}
+	return accepted
+}
+
+func be16(b []byte) uint16 {
+	return uint16(b[0])<<8 | uint16(b[1])
+}
+
+func packet(opcode uint16, block uint16, data []byte) []byte {
+	p := make([]byte, 4, 4+len(data))
+	p[0], p[1] = byte(opcode>>8), byte(opcode)
+	p[2], p[3] = byte(block>>8), byte(block)
+	return append(p, data...)
+}
+
+func errorPacket(code uint16, message string) []byte {
+	return append(packet(opError, code, []byte(message)), 0)
+}
+
+func oackPacket(options map[string]string) []byte {
+	p := []byte{0, opOACK}
+	for name, value := range options {
+		p = append(p, name...)
+		p = append(p, 0)
+		p = append(p, value...)
+		p = append(p, 0)
+	}
+	return p
+}
@@ -0,0 +1,191 @@
+package tftp
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m1kkY8/ctfserver/pkg/auth"
+	"github.com/m1kkY8/ctfserver/pkg/config"
+	"github.com/m1kkY8/ctfserver/pkg/ipfilter"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+)
+
+// serve starts a server for cfg on a loopback port. The root holds
+// tools/nc and has secret.txt next to it, outside the root.
+func serve(t *testing.T, cfg *config.Config) (*net.UDPAddr, string) {
+	t.Helper()
+	logger.InitLogger("error")
+	dir := t.TempDir()
+	rootDir, uploadDir := filepath.Join(dir, "root"), filepath.Join(dir, "uploads")
+	if err := os.MkdirAll(filepath.Join(rootDir, "tools"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		filepath.Join(rootDir, "tools", "nc"): strings.Repeat("n", 700),
+		filepath.Join(dir, "secret.txt"):      "secret",
+	} {
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fileService := service.NewFileService(os.DirFS(rootDir), "root", uploadDir, 1<<20)
+	server := New(fileService, auth.New(cfg), ipfilter.New(cfg))
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(conn)
+	t.Cleanup(func() { server.Close() })
+	return conn.LocalAddr().(*net.UDPAddr), uploadDir
+}
+
+// client sends a request to server and reads the replies of its transfer
+type client struct {
+	t        *testing.T
+	conn     *net.UDPConn
+	transfer *net.UDPAddr // Port of the transfer, known after the first reply
+}
+
+func sendRequest(t *testing.T, server *net.UDPAddr, opcode uint16, filename string) *client {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	p := append([]byte{0, byte(opcode)}, filename...)
+	p = append(append(p, 0), "octet\x00"...)
+	if _, err := conn.WriteToUDP(p, server); err != nil {
+		t.Fatal(err)
+	}
+	return &client{t: t, conn: conn}
+}
+
+// read returns the next packet of the transfer
+func (c *client) read() []byte {
+	c.t.Helper()
+	buf := make([]byte, maxPacketSize)
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, from, err := c.conn.ReadFromUDP(buf)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.transfer = from
+	return buf[:n]
+}
+
+func (c *client) send(p []byte) {
+	c.t.Helper()
+	if _, err := c.conn.WriteToUDP(p, c.transfer); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// download reads a file, returning its content or the error code
+func download(t *testing.T, server *net.UDPAddr, filename string) (string, uint16) {
+	c := sendRequest(t, server, opRRQ, filename)
+	var content []byte
+	for {
+		p := c.read()
+		if be16(p) == opError {
+			return "", be16(p[2:])
+		}
+		content = append(content, p[4:]...)
+		c.send(packet(opAck, be16(p[2:]), nil))
+		if len(p[4:]) < defaultBlockSize {
+			return string(content), 0
+		}
+	}
+}
+
+// upload writes content in one block, returning the error code if refused
+func upload(t *testing.T, server *net.UDPAddr, filename, content string) uint16 {
+	c := sendRequest(t, server, opWRQ, filename)
+	if p := c.read(); be16(p) == opError {
+		return be16(p[2:])
+	}
+	c.send(packet(opData, 1, []byte(content)))
+	p := c.read()
+	if be16(p) == opError {
+		return be16(p[2:])
+	}
+	if be16(p) != opAck || be16(p[2:]) != 1 {
+		t.Fatalf("unexpected reply %v", p)
+	}
+	return 0
+}
+
+func TestTraversal(t *testing.T) {
+	server, uploadDir := serve(t, &config.Config{})
+
+	for _, name := range []string{"../secret.txt", "/../secret.txt", `..\secret.txt`, "tools/../../secret.txt", "tools/../tools/nc"} {
+		if content, code := download(t, server, name); code != errAccessViolation {
+			t.Errorf("RRQ %s = %q, error %d, want access violation", name, content, code)
+		}
+	}
+	for _, name := range []string{"../evil.txt", "tools/../../evil.txt", ".uploads.json"} {
+		if code := upload(t, server, name, "evil"); code != errAccessViolation {
+			t.Errorf("WRQ %s = error %d, want access violation", name, code)
+		}
+	}
+	if entries, _ := os.ReadDir(uploadDir); len(entries) != 0 {
+		t.Errorf("refused uploads left %d files", len(entries))
+	}
+}
+
+func TestUploadsLandInUploadDir(t *testing.T) {
+	server, uploadDir := serve(t, &config.Config{})
+
+	if content, code := download(t, server, "/tools/nc"); code != 0 || content != strings.Repeat("n", 700) {
+		t.Errorf("RRQ /tools/nc = %d bytes, error %d", len(content), code)
+	}
+	if code := upload(t, server, "/tools/router.cfg", "hostname r1"); code != 0 {
+		t.Fatalf("WRQ = error %d", code)
+	}
+	data, err := os.ReadFile(filepath.Join(uploadDir, "router.cfg"))
+	if err != nil || !bytes.Equal(data, []byte("hostname r1")) {
+		t.Errorf("upload holds %q: %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(uploadDir), "root", "tools", "router.cfg")); !os.IsNotExist(err) {
+		t.Errorf("upload written to the root: %v", err)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	users := []config.UserConfig{{Name: "admin", Password: "secret", Roles: []string{config.RoleAdmin}}}
+	deny, err := config.ParseIPRule("files=127.0.0.1/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		cfg      *config.Config
+		download uint16
+		upload   uint16
+	}{
+		{"no authentication", &config.Config{}, 0, 0},
+		{"anonymous download", &config.Config{Users: users, Anonymous: []string{config.RoleDownload}}, 0, errAccessViolation},
+		{"anonymous upload", &config.Config{Users: users, Anonymous: []string{config.RoleUpload}}, errAccessViolation, 0},
+		{"no anonymous roles", &config.Config{Users: users}, errAccessViolation, errAccessViolation},
+		{"denied address", &config.Config{Deny: []config.IPRule{deny}}, errAccessViolation, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := serve(t, tt.cfg)
+			if _, code := download(t, server, "tools/nc"); code != tt.download {
+				t.Errorf("download error %d, want %d", code, tt.download)
+			}
+			if code := upload(t, server, "loot.txt", "loot"); code != tt.upload {
+				t.Errorf("upload error %d, want %d", code, tt.upload)
+			}
+		})
+	}
+}
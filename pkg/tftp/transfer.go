@@ -0,0 +1,331 @@
+package tftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"net"
+	"net/netip"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/m1kkY8/ctfserver/pkg/config"
+	"github.com/m1kkY8/ctfserver/pkg/logger"
+	"github.com/m1kkY8/ctfserver/pkg/models"
+	"github.com/m1kkY8/ctfserver/pkg/service"
+	"github.com/m1kkY8/ctfserver/pkg/util"
+)
+
+// transfer is one read or write, run from its own port
+type transfer struct {
+	server    *Server
+	conn      *net.UDPConn // Connected to the peer, so other senders are dropped
+	peer      netip.AddrPort
+	blockSize int
+	timeout   time.Duration
+}
+
+// peerError is an ERROR packet sent by the peer
+type peerError string
+
+func (e peerError) Error() string {
+	return "client aborted: " + string(e)
+}
+
+// replyAction is what exchange does with a received packet
+type replyAction int
+
+const (
+	replyIgnore replyAction = iota // Keep waiting
+	replyResend                    // Send the packet again right away
+	replyAccept                    // Return the packet
+)
+
+// exchange sends p until accept accepts a reply, retransmitting it when no
+// reply comes within the timeout
+func (t *transfer) exchange(p []byte, accept func(reply []byte) replyAction) ([]byte, error) {
+	buf := make([]byte, maxPacketSize)
+	for tries := 0; tries <= maxRetries; tries++ {
+		if _, err := t.conn.Write(p); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(t.timeout)
+
+	wait:
+		for {
+			t.conn.SetReadDeadline(deadline)
+			n, err := t.conn.Read(buf)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break wait
+				}
+				return nil, err
+			}
+			reply := buf[:n]
+			if len(reply) < 4 {
+				continue
+			}
+			if be16(reply) == opError {
+				return nil, peerError(strings.TrimRight(string(reply[4:]), "\x00"))
+			}
+			switch accept(reply) {
+			case replyAccept:
+				return reply, nil
+			case replyResend:
+				break wait
+			}
+		}
+	}
+	return nil, errors.New("timed out waiting for the client")
+}
+
+// fail sends an ERROR packet to the peer
+func (t *transfer) fail(code uint16, message string) {
+	t.conn.Write(errorPacket(code, message))
+}
+
+// fields returns the log fields of a transfer of filename
+func (t *transfer) fields(filename string) map[string]interface{} {
+	return map[string]interface{}{
+		"filename": filename,
+		"source":   t.peer.Addr().String(),
+		"blksize":  t.blockSize,
+	}
+}
+
+// reject refuses a request, logging why
+func (t *transfer) reject(req *request, code uint16, message string) {
+	logger.Logger.WithFields(map[string]interface{}{
+		"filename": req.filename,
+		"source":   t.peer.Addr().String(),
+		"write":    req.opcode == opWRQ,
+		"reason":   message,
+	}).Warn("Rejected TFTP request")
+	t.fail(code, message)
+}
+
+// requestPath validates the requested file name as a relative path of the
+// root. A leading slash is accepted, traversal attempts are not.
+func requestPath(filename string) (string, bool) {
+	name := strings.TrimPrefix(strings.ReplaceAll(filename, `\`, "/"), "/")
+	return name, util.IsValidFilename(name) && iofs.ValidPath(name)
+}
+
+// serveRead sends a file of the root
+func (t *transfer) serveRead(req *request) {
+	name, ok := requestPath(req.filename)
+	if !ok {
+		t.reject(req, errAccessViolation, "Invalid filename")
+		return
+	}
+	if req.mode != "octet" && req.mode != "netascii" {
+		t.reject(req, errIllegalOperation, "Unsupported transfer mode")
+		return
+	}
+	if !t.server.allowed(t.peer.Addr(), config.RouteFiles, config.RoleDownload) {
+		t.reject(req, errAccessViolation, "Access denied")
+		return
+	}
+
+	file, err := t.server.fileService.RootFS().Open(name)
+	if err != nil {
+		t.reject(req, errFileNotFound, "File not found")
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		t.reject(req, errFileNotFound, "File not found")
+		return
+	}
+
+	// With options the first block waits for the client to acknowledge them
+	if options := t.negotiate(req, info.Size()); len(options) > 0 {
+		_, err := t.exchange(oackPacket(options), func(reply []byte) replyAction {
+			if be16(reply) == opAck && be16(reply[2:]) == 0 {
+				return replyAccept
+			}
+			return replyIgnore
+		})
+		if err != nil {
+			t.failed(name, err)
+			return
+		}
+	}
+
+	sent, err := t.send(file)
+	if err != nil {
+		t.failed(name, err)
+		return
+	}
+	fields := t.fields(name)
+	fields["bytes"] = sent
+	logger.Logger.WithFields(fields).Info("Served file over TFTP")
+}
+
+// send sends content in blocks, each once the previous one was acknowledged.
+// Duplicate acknowledgements are ignored rather than answered, avoiding the
+// Sorcerer's Apprentice bug. Block numbers wrap around for large files.
+func (t *transfer) send(content io.Reader) (int64, error) {
+	buf := make([]byte, t.blockSize)
+	var sent int64
+	for block := uint16(1); ; block++ {
+		n, err := io.ReadFull(content, buf)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.fail(errNotDefined, "Read error")
+			return sent, err
+		}
+
+		_, err = t.exchange(packet(opData, block, buf[:n]), func(reply []byte) replyAction {
+			if be16(reply) == opAck && be16(reply[2:]) == block {
+				return replyAccept
+			}
+			return replyIgnore
+		})
+		if err != nil {
+			return sent, err
+		}
+		sent += int64(n)
+		if n < t.blockSize {
+			return sent, nil
+		}
+	}
+}
+
+// serveWrite stores a file in the upload directory through the file
+// service, named after the last element of the requested path
+func (t *transfer) serveWrite(req *request) {
+	name, ok := requestPath(req.filename)
+	filename := path.Base(name)
+	if !ok || !service.IsValidUploadName(filename) {
+		t.reject(req, errAccessViolation, "Invalid filename")
+		return
+	}
+	if req.mode != "octet" && req.mode != "netascii" {
+		t.reject(req, errIllegalOperation, "Unsupported transfer mode")
+		return
+	}
+	if !t.server.allowed(t.peer.Addr(), config.RouteUpload, config.RoleUpload) {
+		t.reject(req, errAccessViolation, "Access denied")
+		return
+	}
+
+	// Refuse announced uploads that can't fit before receiving them
+	size := int64(-1)
+	options := t.negotiate(req, 0)
+	if value, ok := options["tsize"]; ok {
+		size, _ = strconv.ParseInt(value, 10, 64)
+		if maxSize := t.server.fileService.MaxSize(); size > maxSize {
+			t.reject(req, errDiskFull, fmt.Sprintf("File exceeds maximum size of %d bytes", maxSize))
+			return
+		}
+		if err := t.server.fileService.CheckUpload(t.peer.Addr().String(), size); err != nil {
+			t.reject(req, errDiskFull, err.Error())
+			return
+		}
+	}
+
+	// The file service reads the blocks from a pipe while they arrive
+	reader, writer := io.Pipe()
+	type saved struct {
+		result *models.UploadResponse
+		err    error
+	}
+	done := make(chan saved, 1)
+	go func() {
+		result, err := t.server.fileService.SaveUpload(filename, reader, size, t.peer.Addr().String(), 0)
+		reader.CloseWithError(errUploadEnded)
+		done <- saved{result, err}
+	}()
+
+	first := packet(opAck, 0, nil)
+	if len(options) > 0 {
+		first = oackPacket(options)
+	}
+	final, err := t.receive(first, writer)
+	if err != nil {
+		writer.CloseWithError(err)
+	} else {
+		writer.Close()
+	}
+	outcome := <-done
+
+	fields := t.fields(filename)
+	switch {
+	case err != nil && !errors.Is(err, errUploadEnded):
+		t.failed(filename, err)
+	case outcome.err != nil:
+		if errors.Is(outcome.err, service.ErrInsufficientStorage) {
+			logger.Logger.WithError(outcome.err).WithFields(fields).Warn("Rejected upload over storage limits")
+			t.fail(errDiskFull, "Insufficient storage space")
+			return
+		}
+		logger.Logger.WithError(outcome.err).WithFields(fields).Error("Failed to save upload")
+		t.fail(errNotDefined, "Failed to save file")
+	case !outcome.result.Success:
+		logger.Logger.WithFields(fields).WithField("error", outcome.result.Error).Warn("Rejected TFTP upload")
+		t.fail(errDiskFull, outcome.result.Error)
+	default:
+		// The last block is only acknowledged once the upload is saved
+		t.conn.Write(final)
+		logger.Logger.WithFields(map[string]interface{}{
+			"filename": outcome.result.Filename,
+			"size":     outcome.result.Size,
+			"path":     outcome.result.Path,
+			"source":   t.peer.Addr().String(),
+			"via":      "tftp",
+		}).Info("File uploaded successfully")
+	}
+}
+
+// errUploadEnded stops a write once the file service stopped reading, e.g.
+// at the size limit
+var errUploadEnded = errors.New("upload ended early")
+
+// receive acknowledges blocks and writes them to w until the last one,
+// returning the acknowledgement of the last block still to be sent
+func (t *transfer) receive(first []byte, w io.Writer) ([]byte, error) {
+	ack := first
+	for block := uint16(1); ; block++ {
+		reply, err := t.exchange(ack, func(reply []byte) replyAction {
+			switch {
+			case be16(reply) != opData:
+				return replyIgnore
+			case be16(reply[2:]) == block:
+				return replyAccept
+			case be16(reply[2:]) == block-1:
+				return replyResend // Our acknowledgement was lost
+			}
+			return replyIgnore
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		data := reply[4:]
+		if len(data) > t.blockSize {
+			t.fail(errIllegalOperation, "Block larger than negotiated")
+			return nil, errors.New("block larger than negotiated")
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		ack = packet(opAck, block, nil)
+		if len(data) < t.blockSize {
+			return ack, nil
+		}
+	}
+}
+
+// failed logs a transfer that didn't complete
+func (t *transfer) failed(filename string, err error) {
+	var aborted peerError
+	if !errors.As(err, &aborted) && !errors.Is(err, net.ErrClosed) {
+		t.fail(errNotDefined, "Transfer failed")
+	}
+	logger.Logger.WithError(err).WithFields(t.fields(filename)).Warn("TFTP transfer failed")
+}
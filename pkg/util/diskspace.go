@@ -0,0 +1,12 @@
+package util
+
+import "errors"
+
+// ErrDiskSpaceUnsupported is returned where free space can't be queried
+var ErrDiskSpaceUnsupported = errors.New("disk space query not supported on this platform")
+
+// DiskSpace describes the file system holding a path
+type DiskSpace struct {
+	Total int64 // Size of the file system in bytes
+	Free  int64 // Bytes available to unprivileged users
+}
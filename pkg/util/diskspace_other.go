@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package util
+
+// GetDiskSpace is not supported on this platform
+func GetDiskSpace(path string) (DiskSpace, error) {
+	return DiskSpace{}, ErrDiskSpaceUnsupported
+}
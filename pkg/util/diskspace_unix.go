@@ -0,0 +1,17 @@
+//go:build linux || darwin || freebsd
+
+package util
+
+import "golang.org/x/sys/unix"
+
+// GetDiskSpace returns the size and free space of the file system holding path
+func GetDiskSpace(path string) (DiskSpace, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return DiskSpace{}, err
+	}
+	return DiskSpace{
+		Total: int64(uint64(stat.Blocks) * uint64(stat.Bsize)),
+		Free:  int64(uint64(stat.Bavail) * uint64(stat.Bsize)),
+	}, nil
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package util
+
+import "golang.org/x/sys/windows"
+
+// GetDiskSpace returns the size and free space of the volume holding path
+func GetDiskSpace(path string) (DiskSpace, error) {
+	dir, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return DiskSpace{}, err
+	}
+	var free, total, totalFree uint64
+	if err := windows.GetDiskFreeSpaceEx(dir, &free, &total, &totalFree); err != nil {
+		return DiskSpace{}, err
+	}
+	return DiskSpace{Total: int64(total), Free: int64(free)}, nil
+}
@@ -2,16 +2,18 @@ package util
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 
 	"github.com/m1kkY8/ctfserver/pkg/models"
 )
 
-// GenerateFileTree creates a file tree structure starting from the given root path
-func GenerateFileTree(root string) (*models.FileInfo, error) {
-	info, err := os.Stat(root)
+// GenerateFileTree creates a file tree structure starting from the given root path in fsys
+func GenerateFileTree(fsys fs.FS, root string) (*models.FileInfo, error) {
+	info, err := fs.Stat(fsys, root)
 	if err != nil {
 		return nil, err
 	}
@@ -25,18 +27,27 @@ func GenerateFileTree(root string) (*models.FileInfo, error) {
 	}
 
 	if info.IsDir() {
-		entries, err := os.ReadDir(root)
+		entries, err := fs.ReadDir(fsys, root)
 		if err != nil {
 			return fileInfo, nil // Return partial info even if can't read directory
 		}
 
 		for _, entry := range entries {
-			childPath := filepath.Join(root, entry.Name())
-			child, err := GenerateFileTree(childPath)
+			childPath := path.Join(root, entry.Name())
+			child, err := GenerateFileTree(fsys, childPath)
 			if err != nil {
 				continue // Skip files we can't read
 			}
 			fileInfo.Children = append(fileInfo.Children, *child)
+
+			// Aggregate recursive totals
+			if child.IsDir {
+				fileInfo.TotalSize += child.TotalSize
+				fileInfo.FileCount += child.FileCount
+			} else {
+				fileInfo.TotalSize += child.Size
+				fileInfo.FileCount++
+			}
 		}
 	}
 
@@ -49,6 +60,7 @@ func GeneratePrettyTree(root *models.FileInfo) string {
 	builder.WriteString(root.Name)
 	if root.IsDir {
 		builder.WriteString("/")
+		builder.WriteString(formatDirSummary(root))
 	}
 	builder.WriteString("\n")
 
@@ -75,6 +87,7 @@ func generatePrettyTreeRecursive(children []models.FileInfo, prefix string, buil
 		builder.WriteString(prefix + connector + child.Name)
 		if child.IsDir {
 			builder.WriteString("/")
+			builder.WriteString(formatDirSummary(&child))
 		} else {
 			// Add file size for regular files
 			builder.WriteString(fmt.Sprintf(" (%s)", formatFileSize(child.Size)))
@@ -88,6 +101,15 @@ func generatePrettyTreeRecursive(children []models.FileInfo, prefix string, buil
 	}
 }
 
+// formatDirSummary renders the recursive size and file count of a directory
+func formatDirSummary(dir *models.FileInfo) string {
+	noun := "files"
+	if dir.FileCount == 1 {
+		noun = "file"
+	}
+	return fmt.Sprintf(" (%s, %d %s)", formatFileSize(dir.TotalSize), dir.FileCount, noun)
+}
+
 // FormatFileSize converts bytes to human-readable format
 func FormatFileSize(bytes int64) string {
 	const unit = 1024
@@ -122,3 +144,12 @@ func IsValidFilename(filename string) bool {
 	clean := filepath.Clean(filename)
 	return clean == filename && !filepath.IsAbs(filename)
 }
+
+// CleanPath converts a client supplied slash-separated path into an fs.FS name that can't escape the root
+func CleanPath(relPath string) string {
+	clean := strings.TrimPrefix(path.Clean("/"+relPath), "/")
+	if clean == "" {
+		return "."
+	}
+	return clean
+}
@@ -0,0 +1,43 @@
+package util
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestGenerateFileTreeTotals(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a/b/c.txt": {Data: make([]byte, 2048)},
+		"a/b/d.txt": {Data: make([]byte, 10)},
+		"a/e.txt":   {Data: make([]byte, 1)},
+		"a/empty":   {Mode: fs.ModeDir},
+	}
+	tree, err := GenerateFileTree(fsys, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.TotalSize != 2059 || tree.FileCount != 3 {
+		t.Errorf("a holds %d bytes in %d files, want 2059 in 3", tree.TotalSize, tree.FileCount)
+	}
+	for _, child := range tree.Children {
+		switch child.Name {
+		case "b":
+			if child.TotalSize != 2058 || child.FileCount != 2 {
+				t.Errorf("b holds %d bytes in %d files, want 2058 in 2", child.TotalSize, child.FileCount)
+			}
+		case "empty":
+			if child.TotalSize != 0 || child.FileCount != 0 {
+				t.Errorf("empty holds %d bytes in %d files", child.TotalSize, child.FileCount)
+			}
+		}
+	}
+
+	pretty := GeneratePrettyTree(tree)
+	for _, want := range []string{"a/ (2.0 KB, 3 files)\n", "b/ (2.0 KB, 2 files)\n", "empty/ (0 B, 0 files)\n", "e.txt (1 B)\n"} {
+		if !strings.Contains(pretty, want) {
+			t.Errorf("tree lacks %q:\n%s", want, pretty)
+		}
+	}
+}
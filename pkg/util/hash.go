@@ -0,0 +1,193 @@
+package util
+
+import (
+	"container/list"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHashAlgorithm is used when no algorithm is requested
+const DefaultHashAlgorithm = "sha256"
+
+// hashConstructors maps supported algorithm names to their implementations
+var hashConstructors = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// ParseHashAlgorithms parses a comma separated list of hash algorithm names
+func ParseHashAlgorithms(list string) ([]string, error) {
+	if strings.TrimSpace(list) == "" {
+		return []string{DefaultHashAlgorithm}, nil
+	}
+
+	var algos []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		if _, ok := hashConstructors[name]; !ok {
+			return nil, fmt.Errorf("unsupported hash algorithm: %s", name)
+		}
+		seen[name] = true
+		algos = append(algos, name)
+	}
+
+	if len(algos) == 0 {
+		return []string{DefaultHashAlgorithm}, nil
+	}
+	return algos, nil
+}
+
+// HashReader computes all requested hashes over r in a single pass
+func HashReader(r io.Reader, algos []string) (map[string]string, error) {
+	hashers := make(map[string]hash.Hash, len(algos))
+	writers := make([]io.Writer, 0, len(algos))
+	for _, algo := range algos {
+		newHash, ok := hashConstructors[algo]
+		if !ok {
+			return nil, fmt.Errorf("unsupported hash algorithm: %s", algo)
+		}
+		h := newHash()
+		hashers[algo] = h
+		writers = append(writers, h)
+	}
+
+	if _, err := io.Copy(io.MultiWriter(writers...), r); err != nil {
+		return nil, err
+	}
+
+	sums := make(map[string]string, len(hashers))
+	for algo, h := range hashers {
+		sums[algo] = hex.EncodeToString(h.Sum(nil))
+	}
+	return sums, nil
+}
+
+// HashFile computes all requested hashes of the named file in fsys
+func HashFile(fsys fs.FS, name string, algos []string) (map[string]string, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return HashReader(file, algos)
+}
+
+// maxHashCacheEntries bounds the files a hash cache remembers, the least
+// recently used are dropped first
+const maxHashCacheEntries = 4096
+
+// hashCacheEntry holds the hashes computed for one version of a file
+type hashCacheEntry struct {
+	path    string
+	modTime time.Time
+	size    int64
+	sums    map[string]string
+}
+
+// HashCache caches hashes of files in fsys keyed by path, invalidated on mtime or size change
+type HashCache struct {
+	fsys       fs.FS
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // Values are *hashCacheEntry
+	recent  *list.List               // Most recently used first
+}
+
+// NewHashCache creates an empty hash cache for files in fsys
+func NewHashCache(fsys fs.FS) *HashCache {
+	return &HashCache{
+		fsys:       fsys,
+		maxEntries: maxHashCacheEntries,
+		entries:    make(map[string]*list.Element),
+		recent:     list.New(),
+	}
+}
+
+// Reset forgets every cached hash, for when the files behind fsys change
+// wholesale, like a reload swapping the root
+func (c *HashCache) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.recent.Init()
+}
+
+// Get returns the requested hashes for path, computing only the ones missing from the cache
+func (c *HashCache) Get(path string, info fs.FileInfo, algos []string) (map[string]string, error) {
+	c.mu.Lock()
+	entry := c.lookup(path, info)
+	result := make(map[string]string, len(algos))
+	var missing []string
+	for _, algo := range algos {
+		if sum, ok := entry.sums[algo]; ok {
+			result[algo] = sum
+		} else {
+			missing = append(missing, algo)
+		}
+	}
+	c.mu.Unlock()
+
+	if len(missing) == 0 {
+		return result, nil
+	}
+
+	sums, err := HashFile(c.fsys, path, missing)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	for algo, sum := range sums {
+		entry.sums[algo] = sum
+		result[algo] = sum
+	}
+	c.mu.Unlock()
+
+	return result, nil
+}
+
+// lookup returns the entry of the current version of path, creating it and
+// evicting the least recently used entry when needed. The caller holds c.mu.
+func (c *HashCache) lookup(path string, info fs.FileInfo) *hashCacheEntry {
+	if element, ok := c.entries[path]; ok {
+		entry := element.Value.(*hashCacheEntry)
+		if entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() {
+			c.recent.MoveToFront(element)
+			return entry
+		}
+		c.recent.Remove(element)
+		delete(c.entries, path)
+	}
+
+	entry := &hashCacheEntry{
+		path:    path,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		sums:    make(map[string]string),
+	}
+	c.entries[path] = c.recent.PushFront(entry)
+	for c.recent.Len() > c.maxEntries {
+		oldest := c.recent.Back()
+		c.recent.Remove(oldest)
+		delete(c.entries, oldest.Value.(*hashCacheEntry).path)
+	}
+	return entry
+}
@@ -0,0 +1,89 @@
+package util
+
+import (
+	"io/fs"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestParseHashAlgorithms(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    []string
+		wantErr bool
+	}{
+		{"", []string{"sha256"}, false},
+		{" , ", []string{"sha256"}, false},
+		{"MD5, sha1", []string{"md5", "sha1"}, false},
+		{"sha256,sha256,md5", []string{"sha256", "md5"}, false},
+		{"sha3", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseHashAlgorithms(tt.list)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseHashAlgorithms(%q) error = %v, want error %v", tt.list, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseHashAlgorithms(%q) = %v, want %v", tt.list, got, tt.want)
+		}
+	}
+}
+
+func TestHashCache(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a": {Data: []byte("hello\n"), ModTime: time.Unix(1, 0)},
+		"b": {Data: []byte("b"), ModTime: time.Unix(1, 0)},
+		"c": {Data: []byte("c"), ModTime: time.Unix(1, 0)},
+	}
+	cache := NewHashCache(fsys)
+	cache.maxEntries = 2
+
+	get := func(name string) map[string]string {
+		t.Helper()
+		info, err := fs.Stat(fsys, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sums, err := cache.Get(name, info, []string{"sha256", "md5"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sums
+	}
+
+	sums := get("a")
+	if want := "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03"; sums["sha256"] != want {
+		t.Errorf("sha256 = %s, want %s", sums["sha256"], want)
+	}
+	if want := "b1946ac92492d2347c6235b4d2611184"; sums["md5"] != want {
+		t.Errorf("md5 = %s, want %s", sums["md5"], want)
+	}
+
+	// A new version of the file is hashed again
+	fsys["a"] = &fstest.MapFile{Data: []byte("changed"), ModTime: time.Unix(2, 0)}
+	if changed := get("a"); changed["sha256"] == sums["sha256"] {
+		t.Error("hash of a changed file was served from the cache")
+	}
+
+	// The least recently used file is evicted past the limit
+	get("b")
+	get("a")
+	get("c")
+	if cache.recent.Len() != 2 {
+		t.Fatalf("cache holds %d entries, want 2", cache.recent.Len())
+	}
+	if _, ok := cache.entries["b"]; ok {
+		t.Error("least recently used entry b was kept")
+	}
+	if _, ok := cache.entries["a"]; !ok {
+		t.Error("recently used entry a was evicted")
+	}
+
+	cache.Reset()
+	if cache.recent.Len() != 0 || len(cache.entries) != 0 {
+		t.Error("Reset kept entries")
+	}
+}
@@ -0,0 +1,32 @@
+package util
+
+import (
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// extraMimeTypes covers common tool extensions missing from most system mime tables
+var extraMimeTypes = map[string]string{
+	".ps1":  "text/x-powershell",
+	".psm1": "text/x-powershell",
+	".sh":   "application/x-sh",
+	".py":   "text/x-python",
+	".exe":  "application/vnd.microsoft.portable-executable",
+	".dll":  "application/vnd.microsoft.portable-executable",
+	".elf":  "application/x-elf",
+	".bat":  "application/x-bat",
+	".hta":  "application/hta",
+}
+
+// MimeTypeByExtension returns the content type for a file name based on its extension
+func MimeTypeByExtension(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == "" {
+		return ""
+	}
+	if mimeType, ok := extraMimeTypes[ext]; ok {
+		return mimeType
+	}
+	return mime.TypeByExtension(ext)
+}
@@ -0,0 +1,87 @@
+package util
+
+import (
+	"net"
+	"sort"
+	"strings"
+)
+
+// InterfaceAddr is an address assigned to a named network interface
+type InterfaceAddr struct {
+	Interface string
+	IP        net.IP
+}
+
+// IsInterfaceName reports whether host names an existing network interface rather than an address
+func IsInterfaceName(host string) bool {
+	if host == "" || net.ParseIP(host) != nil {
+		return false
+	}
+	_, err := net.InterfaceByName(host)
+	return err == nil
+}
+
+// MayBeInterfaceName reports whether host names a network interface, even one
+// that doesn't exist yet like tun0 before the VPN connects: an existing
+// interface, or a single label without dots that doesn't resolve as a host
+func MayBeInterfaceName(host string) bool {
+	if IsInterfaceName(host) {
+		return true
+	}
+	if host == "" || net.ParseIP(host) != nil || strings.ContainsAny(host, ".:") {
+		return false
+	}
+	_, err := net.LookupHost(host)
+	return err != nil
+}
+
+// InterfaceIPs returns the usable addresses of the named interface, or none if it is down or missing
+func InterfaceIPs(name string) []net.IP {
+	iface, err := net.InterfaceByName(name)
+	if err != nil || iface.Flags&net.FlagUp == 0 {
+		return nil
+	}
+	return interfaceIPs(iface)
+}
+
+// LocalInterfaceAddrs returns the usable non-loopback addresses of every interface that is up
+func LocalInterfaceAddrs() []InterfaceAddr {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+
+	var addrs []InterfaceAddr
+	for i := range ifaces {
+		iface := &ifaces[i]
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		for _, ip := range interfaceIPs(iface) {
+			addrs = append(addrs, InterfaceAddr{Interface: iface.Name, IP: ip})
+		}
+	}
+	return addrs
+}
+
+// interfaceIPs returns the addresses of iface, IPv4 first, skipping IPv6 link-local ones
+func interfaceIPs(iface *net.Interface) []net.IP {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil
+	}
+
+	var ips []net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || (ipNet.IP.To4() == nil && ipNet.IP.IsLinkLocalUnicast()) {
+			continue
+		}
+		ips = append(ips, ipNet.IP)
+	}
+
+	sort.SliceStable(ips, func(i, j int) bool {
+		return ips[i].To4() != nil && ips[j].To4() == nil
+	})
+	return ips
+}
@@ -0,0 +1,35 @@
+package util
+
+import (
+	"net"
+	"testing"
+)
+
+func TestMayBeInterfaceName(t *testing.T) {
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"", false},
+		{"0.0.0.0", false},
+		{"10.10.14.2", false},
+		{"::1", false},
+		{"localhost", false},
+		{"ctf.example.com", false},
+		{"tunmissing9", true},
+	}
+	for _, tt := range tests {
+		if got := MayBeInterfaceName(tt.host); got != tt.want {
+			t.Errorf("MayBeInterfaceName(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+
+	// Existing interfaces are names whatever they resolve to
+	ifaces, err := net.Interfaces()
+	if err != nil || len(ifaces) == 0 {
+		t.Skip("no network interfaces")
+	}
+	if !MayBeInterfaceName(ifaces[0].Name) {
+		t.Errorf("MayBeInterfaceName(%q) = false for an existing interface", ifaces[0].Name)
+	}
+}
@@ -0,0 +1,90 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+)
+
+// seekableFile adds io.Seeker to files that only support sequential reads,
+// such as compressed archive members, so they can be served with range
+// requests. Seeking forward discards data; seeking backward reopens the file.
+type seekableFile struct {
+	fsys   fs.FS
+	name   string
+	file   fs.File
+	info   fs.FileInfo
+	pos    int64 // Position reported to the caller
+	offset int64 // Position of the underlying reader
+}
+
+// newSeekableFile returns file unchanged if it can already seek
+func newSeekableFile(fsys fs.FS, name string, file fs.File, info fs.FileInfo) fs.File {
+	if _, ok := file.(io.Seeker); ok {
+		return file
+	}
+	return &seekableFile{fsys: fsys, name: name, file: file, info: info}
+}
+
+func (f *seekableFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *seekableFile) Read(p []byte) (int, error) {
+	if err := f.sync(); err != nil {
+		return 0, err
+	}
+	n, err := f.file.Read(p)
+	f.offset += int64(n)
+	f.pos = f.offset
+	return n, err
+}
+
+func (f *seekableFile) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = f.pos + offset
+	case io.SeekEnd:
+		pos = f.info.Size() + offset
+	default:
+		return 0, errors.New("seek: invalid whence")
+	}
+	if pos < 0 {
+		return 0, errors.New("seek: negative position")
+	}
+
+	// Defer the actual repositioning until the next read
+	f.pos = pos
+	return pos, nil
+}
+
+func (f *seekableFile) Close() error {
+	return f.file.Close()
+}
+
+// sync moves the underlying reader to the requested position
+func (f *seekableFile) sync() error {
+	if f.pos == f.offset {
+		return nil
+	}
+
+	if f.pos < f.offset {
+		file, err := f.fsys.Open(f.name)
+		if err != nil {
+			return err
+		}
+		f.file.Close()
+		f.file = file
+		f.offset = 0
+	}
+
+	skipped, err := io.CopyN(io.Discard, f.file, f.pos-f.offset)
+	f.offset += skipped
+	if err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
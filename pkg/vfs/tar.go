@@ -0,0 +1,272 @@
+package vfs
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// tarEntry is an indexed member of a tar archive
+type tarEntry struct {
+	name     string
+	header   *tar.Header // nil for directories implied by member paths
+	offset   int64
+	children []string
+}
+
+// TarFS is a read-only fs.FS over a tar archive. Members are indexed once and
+// read directly from the archive on demand.
+type TarFS struct {
+	data    io.ReaderAt
+	closer  io.Closer
+	modTime time.Time
+	entries map[string]*tarEntry
+}
+
+// OpenTar indexes the tar archive at path. Gzip compressed archives are
+// decompressed to a temporary file since they can't be read at random
+// offsets; it is removed when the archive is closed.
+func OpenTar(path string) (*TarFS, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	lower := strings.ToLower(path)
+	if strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz") {
+		defer file.Close()
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+		spool, err := os.CreateTemp("", "ctfserver-*.tar")
+		if err != nil {
+			return nil, err
+		}
+		temp := &tempFile{File: spool}
+		size, err := io.Copy(spool, gz)
+		if err != nil {
+			temp.Close()
+			return nil, err
+		}
+		tfs, err := newTarFS(spool, size, temp, info.ModTime())
+		if err != nil {
+			temp.Close()
+			return nil, err
+		}
+		return tfs, nil
+	}
+
+	tfs, err := newTarFS(file, info.Size(), file, info.ModTime())
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return tfs, nil
+}
+
+func newTarFS(data io.ReaderAt, size int64, closer io.Closer, modTime time.Time) (*TarFS, error) {
+	tfs := &TarFS{
+		data:    data,
+		closer:  closer,
+		modTime: modTime,
+		entries: map[string]*tarEntry{".": {name: "."}},
+	}
+
+	// Track the read position so member data offsets can be recorded
+	counter := &countingReader{r: io.NewSectionReader(data, 0, size)}
+	reader := tar.NewReader(counter)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name := path.Clean(strings.TrimPrefix(header.Name, "/"))
+		if !fs.ValidPath(name) || name == "." {
+			continue
+		}
+
+		switch header.Typeflag {
+		case tar.TypeReg, tar.TypeDir:
+		default:
+			continue // Links and devices aren't served
+		}
+
+		tfs.addParents(name)
+		entry := tfs.entry(name)
+		entry.header = header
+		entry.offset = counter.n
+	}
+
+	// Link every entry to its parent directory
+	for name := range tfs.entries {
+		if name == "." {
+			continue
+		}
+		parent := tfs.entries[path.Dir(name)]
+		parent.children = append(parent.children, path.Base(name))
+	}
+	for _, entry := range tfs.entries {
+		sort.Strings(entry.children)
+	}
+	return tfs, nil
+}
+
+// entry returns the index entry for name, creating it if needed
+func (t *TarFS) entry(name string) *tarEntry {
+	entry, ok := t.entries[name]
+	if !ok {
+		entry = &tarEntry{name: name}
+		t.entries[name] = entry
+	}
+	return entry
+}
+
+// addParents registers name and all of its parent directories
+func (t *TarFS) addParents(name string) {
+	for ; name != "."; name = path.Dir(name) {
+		t.entry(name)
+	}
+}
+
+// Close releases the underlying archive file
+func (t *TarFS) Close() error {
+	if t.closer != nil {
+		return t.closer.Close()
+	}
+	return nil
+}
+
+// Open implements fs.FS
+func (t *TarFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	entry, ok := t.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	info := t.fileInfo(entry)
+	if info.IsDir() {
+		return &tarDir{tfs: t, entry: entry, info: info}, nil
+	}
+	return &tarFile{
+		SectionReader: io.NewSectionReader(t.data, entry.offset, entry.header.Size),
+		info:          info,
+	}, nil
+}
+
+func (t *TarFS) fileInfo(entry *tarEntry) fs.FileInfo {
+	if entry.header == nil || entry.header.Typeflag == tar.TypeDir {
+		return &tarDirInfo{name: path.Base(entry.name), header: entry.header, modTime: t.modTime}
+	}
+	return entry.header.FileInfo()
+}
+
+// tarFile is an open regular tar member
+type tarFile struct {
+	*io.SectionReader
+	info fs.FileInfo
+}
+
+func (f *tarFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *tarFile) Close() error               { return nil }
+
+// tarDir is an open tar directory
+type tarDir struct {
+	tfs    *TarFS
+	entry  *tarEntry
+	info   fs.FileInfo
+	offset int
+}
+
+func (d *tarDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *tarDir) Close() error               { return nil }
+
+func (d *tarDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.entry.name, Err: errors.New("is a directory")}
+}
+
+// ReadDir implements fs.ReadDirFile
+func (d *tarDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	children := d.entry.children[d.offset:]
+	if n > 0 {
+		if len(children) == 0 {
+			return nil, io.EOF
+		}
+		if n < len(children) {
+			children = children[:n]
+		}
+	}
+	d.offset += len(children)
+
+	entries := make([]fs.DirEntry, 0, len(children))
+	for _, child := range children {
+		entry := d.tfs.entries[path.Join(d.entry.name, child)]
+		entries = append(entries, fs.FileInfoToDirEntry(d.tfs.fileInfo(entry)))
+	}
+	return entries, nil
+}
+
+// tarDirInfo describes explicit and implied tar directories
+type tarDirInfo struct {
+	name    string
+	header  *tar.Header
+	modTime time.Time
+}
+
+func (i *tarDirInfo) Name() string      { return i.name }
+func (i *tarDirInfo) Size() int64       { return 0 }
+func (i *tarDirInfo) Mode() fs.FileMode { return fs.ModeDir | 0555 }
+func (i *tarDirInfo) IsDir() bool       { return true }
+func (i *tarDirInfo) Sys() interface{}  { return i.header }
+func (i *tarDirInfo) ModTime() time.Time {
+	if i.header != nil {
+		return i.header.ModTime
+	}
+	return i.modTime
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// tempFile is a temporary file removed once closed
+type tempFile struct {
+	*os.File
+}
+
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	if removeErr := os.Remove(f.Name()); err == nil {
+		err = removeErr
+	}
+	return err
+}
@@ -0,0 +1,72 @@
+package vfs
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestOpenTarGzip(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "tools.tar.gz")
+	file, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+	members := map[string]string{
+		"tools/nc":       "netcat",
+		"/abs/linpeas":   "peas",
+		"../escape":      "nope",
+		"windows/sharp/": "",
+	}
+	for name, content := range members {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if content == "" {
+			header.Typeflag = tar.TypeDir
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	gz.Close()
+	file.Close()
+
+	// The decompressed archive is spooled to a private temporary directory
+	t.Setenv("TMPDIR", t.TempDir())
+	tfs, err := OpenTar(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spooled, _ := filepath.Glob(filepath.Join(os.Getenv("TMPDIR"), "ctfserver-*.tar"))
+	if len(spooled) != 1 {
+		t.Fatalf("spooled files = %v, want one", spooled)
+	}
+
+	if data, err := fs.ReadFile(tfs, "tools/nc"); err != nil || string(data) != "netcat" {
+		t.Errorf("ReadFile(tools/nc) = %q, %v", data, err)
+	}
+	if data, err := fs.ReadFile(tfs, "abs/linpeas"); err != nil || string(data) != "peas" {
+		t.Errorf("ReadFile(abs/linpeas) = %q, %v", data, err)
+	}
+	if _, err := tfs.Open("escape"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("member outside the archive root was served: %v", err)
+	}
+	if err := fstest.TestFS(tfs, "tools/nc", "abs/linpeas", "windows/sharp"); err != nil {
+		t.Error(err)
+	}
+
+	if err := tfs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(spooled[0]); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("spooled archive left behind after Close: %v", err)
+	}
+}
@@ -0,0 +1,359 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"sync"
+)
+
+// UnionFS overlays several file systems. Layers listed first take precedence:
+// a file in an earlier layer hides any file or directory with the same path in
+// later layers, and everything below that path, while directories present in
+// several layers are merged.
+// The layers can be replaced while the union is in use.
+type UnionFS struct {
+	mu  sync.RWMutex
+	set *layerSet
+}
+
+// layerSet is one generation of layers. Files open from its archives keep it
+// alive after it is replaced; it is closed when the last of them is.
+type layerSet struct {
+	layers  []fs.FS
+	pinned  []bool      // Layers backed by archives, whose open files hold the set
+	closers []io.Closer // Archives closed with the set
+
+	mu      sync.Mutex
+	refs    int  // Open files of pinned layers
+	retired bool // Replaced, closed once refs drops to zero
+	closed  bool
+}
+
+// NewUnionFS creates a union of the given layers, highest precedence first
+func NewUnionFS(layers ...fs.FS) *UnionFS {
+	return &UnionFS{set: &layerSet{layers: layers, pinned: make([]bool, len(layers))}}
+}
+
+// add appends a layer, pinned when it holds closer open. Only used while
+// the union is built.
+func (u *UnionFS) add(layer fs.FS, closer io.Closer) {
+	u.set.layers = append(u.set.layers, layer)
+	u.set.pinned = append(u.set.pinned, closer != nil)
+	if closer != nil {
+		u.set.closers = append(u.set.closers, closer)
+	}
+}
+
+// Append adds a layer with lower precedence than all existing layers
+func (u *UnionFS) Append(layer fs.FS) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	set := u.set
+	// Never write into slices handed out by Layers
+	u.set = &layerSet{
+		layers:  append(set.layers[:len(set.layers):len(set.layers)], layer),
+		pinned:  append(set.pinned[:len(set.pinned):len(set.pinned)], false),
+		closers: set.closers,
+	}
+}
+
+// Layers returns the underlying layers, highest precedence first
+func (u *UnionFS) Layers() []fs.FS {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.set.layers
+}
+
+// Replace moves the layers of next into u. Files already open keep reading
+// from the previous layers, whose archives are closed once the last of those
+// files is.
+func (u *UnionFS) Replace(next *UnionFS) {
+	next.mu.Lock()
+	set := next.set
+	next.set = &layerSet{}
+	next.mu.Unlock()
+
+	u.mu.Lock()
+	previous := u.set
+	u.set = set
+	u.mu.Unlock()
+
+	previous.retire()
+}
+
+// Close releases any archives held open by the layers
+func (u *UnionFS) Close() error {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.set.close()
+}
+
+// acquire returns the current layers, counting a user that must release them
+func (u *UnionFS) acquire() *layerSet {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	u.set.mu.Lock()
+	u.set.refs++
+	u.set.mu.Unlock()
+	return u.set
+}
+
+// release ends a use of the set, closing it when it was the last of a
+// replaced set
+func (s *layerSet) release() {
+	s.mu.Lock()
+	s.refs--
+	closing := s.retired && s.refs == 0
+	s.mu.Unlock()
+	if closing {
+		s.close()
+	}
+}
+
+// retire marks the set as replaced, closing it unless it is still in use
+func (s *layerSet) retire() {
+	s.mu.Lock()
+	s.retired = true
+	closing := s.refs == 0
+	s.mu.Unlock()
+	if closing {
+		s.close()
+	}
+}
+
+// close closes the archives of the set, once
+func (s *layerSet) close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	var errs []error
+	for _, closer := range s.closers {
+		if err := closer.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Open opens the named file from the first layer that has it
+func (u *UnionFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	// Files of archives hold the set until they are closed, so a reload
+	// doesn't close archives under them
+	set := u.acquire()
+	for i, layer := range set.layers {
+		file, err := layer.Open(name)
+		if err != nil {
+			if shadows(layer, name) {
+				break
+			}
+			continue
+		}
+
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			continue
+		}
+
+		if !info.IsDir() {
+			file = newSeekableFile(layer, name, file, info)
+		}
+		if set.pinned[i] {
+			file = pin(file, set)
+		} else {
+			set.release()
+		}
+		if !info.IsDir() {
+			return file, nil
+		}
+
+		// Directory listings are merged across all layers
+		return &unionDir{union: u, name: name, file: file, info: info}, nil
+	}
+	set.release()
+
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// Stat returns file info from the first layer that has the named file
+func (u *UnionFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+
+	set := u.acquire()
+	defer set.release()
+	for _, layer := range set.layers {
+		if info, err := fs.Stat(layer, name); err == nil {
+			return info, nil
+		}
+		if shadows(layer, name) {
+			break
+		}
+	}
+
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+// ReadDir returns the merged, sorted entries of the named directory
+func (u *UnionFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	seen := make(map[string]bool)
+	var entries []fs.DirEntry
+	found := false
+
+	set := u.acquire()
+	defer set.release()
+	for _, layer := range set.layers {
+		info, err := fs.Stat(layer, name)
+		if err != nil {
+			if shadows(layer, name) {
+				break
+			}
+			continue
+		}
+		if !info.IsDir() {
+			if !found {
+				return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+			}
+			continue // A file in a lower layer can't contribute entries
+		}
+		found = true
+
+		layerEntries, err := fs.ReadDir(layer, name)
+		if err != nil {
+			continue
+		}
+		for _, entry := range layerEntries {
+			if seen[entry.Name()] {
+				continue
+			}
+			seen[entry.Name()] = true
+			entries = append(entries, entry)
+		}
+	}
+
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+// shadows reports whether layer has a file in place of one of the parent
+// directories of name, which hides name in every later layer
+func shadows(layer fs.FS, name string) bool {
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if info, err := fs.Stat(layer, dir); err == nil {
+			return !info.IsDir()
+		}
+	}
+	return false
+}
+
+// pin ties file to set, released when the file is closed. Files keep
+// io.ReaderAt when they have it, since callers probe for it.
+func pin(file fs.File, set *layerSet) fs.File {
+	pinned := &pinnedFile{File: file, set: set}
+	if _, ok := file.(io.ReaderAt); ok {
+		return &pinnedReaderAtFile{pinned}
+	}
+	return pinned
+}
+
+// pinnedFile is an open file of a layer set
+type pinnedFile struct {
+	fs.File
+	set  *layerSet
+	once sync.Once
+}
+
+func (f *pinnedFile) Close() error {
+	err := f.File.Close()
+	f.once.Do(f.set.release)
+	return err
+}
+
+func (f *pinnedFile) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := f.File.(io.Seeker)
+	if !ok {
+		return 0, errors.New("seek: not supported")
+	}
+	return seeker.Seek(offset, whence)
+}
+
+// pinnedReaderAtFile is a pinnedFile that reads at offsets
+type pinnedReaderAtFile struct {
+	*pinnedFile
+}
+
+func (f *pinnedReaderAtFile) ReadAt(p []byte, off int64) (int, error) {
+	return f.File.(io.ReaderAt).ReadAt(p, off)
+}
+
+// unionDir is an open directory whose entries are merged from all layers
+type unionDir struct {
+	union   *UnionFS
+	name    string
+	file    fs.File
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	loaded  bool
+	offset  int
+}
+
+func (d *unionDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *unionDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *unionDir) Close() error {
+	return d.file.Close()
+}
+
+// ReadDir implements fs.ReadDirFile
+func (d *unionDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.loaded {
+		entries, err := d.union.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries = entries
+		d.loaded = true
+	}
+
+	remaining := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(remaining) {
+		n = len(remaining)
+	}
+	d.offset += n
+	return remaining[:n], nil
+}
@@ -0,0 +1,189 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestUnionFSPrecedence(t *testing.T) {
+	upper := fstest.MapFS{
+		"tools/nc":      {Data: []byte("upper nc")},
+		"shadow":        {Data: []byte("a file")},
+		"upper-only.sh": {Data: []byte("upper")},
+	}
+	lower := fstest.MapFS{
+		"tools/nc":         {Data: []byte("lower nc")},
+		"tools/chisel":     {Data: []byte("lower chisel")},
+		"shadow/secret":    {Data: []byte("hidden")},
+		"shadow/dir/deep":  {Data: []byte("hidden")},
+		"lower-only/a.txt": {Data: []byte("lower")},
+	}
+	union := NewUnionFS(upper, lower)
+
+	reads := []struct {
+		name string
+		want string
+	}{
+		{"tools/nc", "upper nc"},
+		{"tools/chisel", "lower chisel"},
+		{"upper-only.sh", "upper"},
+		{"lower-only/a.txt", "lower"},
+		{"shadow", "a file"},
+	}
+	for _, tt := range reads {
+		data, err := fs.ReadFile(union, tt.name)
+		if err != nil {
+			t.Errorf("ReadFile(%q): %v", tt.name, err)
+			continue
+		}
+		if string(data) != tt.want {
+			t.Errorf("ReadFile(%q) = %q, want %q", tt.name, data, tt.want)
+		}
+	}
+
+	// A file in an upper layer hides everything below its path
+	for _, name := range []string{"shadow/secret", "shadow/dir/deep", "shadow/dir"} {
+		if _, err := union.Open(name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Open(%q) error = %v, want not exist", name, err)
+		}
+		if _, err := union.Stat(name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Stat(%q) error = %v, want not exist", name, err)
+		}
+		if _, err := union.ReadDir(name); err == nil {
+			t.Errorf("ReadDir(%q) succeeded below a file", name)
+		}
+	}
+
+	if _, err := union.Open("../etc/passwd"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Open of an invalid path error = %v, want invalid", err)
+	}
+}
+
+func TestUnionFSReadDir(t *testing.T) {
+	upper := fstest.MapFS{
+		"tools/nc":   {Data: []byte("upper")},
+		"tools/peas": {Data: []byte("upper")},
+	}
+	lower := fstest.MapFS{
+		"tools/nc":     {Data: []byte("lower")},
+		"tools/chisel": {Data: []byte("lower")},
+		"tools/win/x":  {Data: []byte("lower")},
+	}
+	union := NewUnionFS(upper, lower)
+
+	entries, err := fs.ReadDir(union, "tools")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if want := []string{"chisel", "nc", "peas", "win"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("ReadDir(tools) = %v, want %v", names, want)
+	}
+
+	// Open directories list the merged entries too
+	dir, err := union.Open("tools")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Close()
+	first, err := dir.(fs.ReadDirFile).ReadDir(3)
+	if err != nil || len(first) != 3 {
+		t.Fatalf("ReadDir(3) = %d entries, %v", len(first), err)
+	}
+	rest, err := dir.(fs.ReadDirFile).ReadDir(3)
+	if err != nil || len(rest) != 1 || rest[0].Name() != "win" {
+		t.Fatalf("second ReadDir(3) = %v, %v", rest, err)
+	}
+	if _, err := dir.(fs.ReadDirFile).ReadDir(1); err != io.EOF {
+		t.Errorf("ReadDir past the end error = %v, want EOF", err)
+	}
+
+	if err := fstest.TestFS(union, "tools/nc", "tools/chisel", "tools/peas", "tools/win/x"); err != nil {
+		t.Error(err)
+	}
+}
+
+// countingCloser counts how often an archive is closed
+type countingCloser struct {
+	closed int
+}
+
+func (c *countingCloser) Close() error {
+	c.closed++
+	return nil
+}
+
+func TestUnionFSReplace(t *testing.T) {
+	oldArchive := &countingCloser{}
+	union := NewUnionFS()
+	union.add(fstest.MapFS{"a": {Data: []byte("old")}}, oldArchive)
+
+	// A file open from the archive keeps it open across the replacement
+	file, err := union.Open("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := NewUnionFS()
+	next.add(fstest.MapFS{"a": {Data: []byte("new")}}, &countingCloser{})
+	union.Replace(next)
+
+	if data, _ := fs.ReadFile(union, "a"); string(data) != "new" {
+		t.Errorf("after Replace read %q, want new", data)
+	}
+	if oldArchive.closed != 0 {
+		t.Fatal("replaced archive closed while a file was open")
+	}
+	if data, err := io.ReadAll(file); err != nil || string(data) != "old" {
+		t.Errorf("open file read %q, %v, want old", data, err)
+	}
+	file.Close()
+	file.Close()
+	if oldArchive.closed != 1 {
+		t.Errorf("replaced archive closed %d times after its last file, want 1", oldArchive.closed)
+	}
+
+	// Without open files the previous archives are closed right away
+	current := union.set.closers[0].(*countingCloser)
+	union.Replace(NewUnionFS(fstest.MapFS{}))
+	if current.closed != 1 {
+		t.Errorf("unused replaced archive closed %d times, want 1", current.closed)
+	}
+}
+
+func TestUnionFSPinnedFiles(t *testing.T) {
+	union := NewUnionFS()
+	union.add(fstest.MapFS{"dir/a": {Data: []byte("a")}}, &countingCloser{})
+
+	file, err := union.Open("dir/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := file.(io.Seeker); !ok {
+		t.Error("archive member can't seek")
+	}
+	if _, ok := file.(fs.ReadDirFile); ok {
+		t.Error("regular file claims to be a directory")
+	}
+	file.Close()
+
+	dir, err := union.Open("dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := dir.(fs.ReadDirFile).ReadDir(-1)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("ReadDir = %v, %v", entries, err)
+	}
+	dir.Close()
+
+	if refs := union.set.refs; refs != 0 {
+		t.Errorf("%d references left after closing every file", refs)
+	}
+}
@@ -0,0 +1,71 @@
+// Package vfs builds the virtual root file system served by ctfserver from
+// directories and archives.
+package vfs
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Open builds a union file system from the given directories and archives.
+// Sources listed first take precedence over later ones. Supported archives
+// are .zip, .tar, .tar.gz and .tgz.
+func Open(sources []string) (*UnionFS, error) {
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no root sources configured")
+	}
+
+	union := NewUnionFS()
+	for _, source := range sources {
+		layer, closer, err := openLayer(source)
+		if err != nil {
+			union.Close()
+			return nil, fmt.Errorf("failed to open root %s: %w", source, err)
+		}
+		union.add(layer, closer)
+	}
+
+	return union, nil
+}
+
+// openLayer opens a single directory or archive as an fs.FS
+func openLayer(source string) (fs.FS, io.Closer, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.IsDir() {
+		return os.DirFS(source), nil, nil
+	}
+
+	lower := strings.ToLower(source)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		reader, err := zip.OpenReader(source)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &reader.Reader, reader, nil
+	case strings.HasSuffix(lower, ".tar"), strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		tfs, err := OpenTar(source)
+		if err != nil {
+			return nil, nil, err
+		}
+		return tfs, tfs, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported root type (expected directory, .zip, .tar, .tar.gz or .tgz)")
+	}
+}
+
+// DisplayName returns a human-readable name for the root made of sources
+func DisplayName(sources []string) string {
+	if len(sources) != 1 {
+		return "."
+	}
+	return filepath.Base(sources[0])
+}